@@ -90,6 +90,16 @@ private:
       return HttpResponse::ok("Key Not Found");
     }
 
+    // ?consistent=true reads through the Raft leader instead of local state
+    auto consistent = params.find("consistent");
+    if (consistent != params.end() && consistent->second == "true") {
+      ReadResult result = raft_client_.read(it->second, true);
+      if (!result.ok) {
+        return HttpResponse::error("error");
+      }
+      return HttpResponse::ok(result.value ? *result.value : "Key Not Found");
+    }
+
     auto value = store_.get(it->second);
     if (value) {
       return HttpResponse::ok(*value);
@@ -1,7 +1,9 @@
 #pragma once
 
 #include <chrono>
+#include <cstdlib>
 #include <memory>
+#include <optional>
 #include <string>
 
 #include "consensus.grpc.pb.h"
@@ -9,6 +11,14 @@
 
 namespace kvdb {
 
+/**
+ * @brief Result of a read served through the Raft sidecar.
+ */
+struct ReadResult {
+  bool ok = false;                  ///< The sidecar answered the read
+  std::optional<std::string> value; ///< The value, if the key exists
+};
+
 /**
  * @brief Abstract interface for Raft consensus client.
  *
@@ -26,13 +36,25 @@ public:
    * @return true if the proposal was accepted and committed
    */
   virtual bool propose(const std::string &payload) = 0;
+
+  /**
+   * @brief Read a key through the Raft cluster.
+   *
+   * @param key The key to look up
+   * @param linearizable Serve the read through the leader so it reflects
+   *        every write committed before the call
+   * @return The read result
+   */
+  virtual ReadResult read(const std::string &key, bool linearizable) = 0;
 };
 
 /**
  * @brief gRPC-based Raft client implementation.
  *
  * Communicates with the Go sidecar to propose commands
- * to the Raft cluster for consensus.
+ * to the Raft cluster for consensus. If RAFTKV_API_KEY is set, it is sent
+ * as the x-api-key metadata of every call, for sidecars that require
+ * authentication.
  */
 class GrpcRaftClient : public IRaftClient {
 public:
@@ -41,7 +63,11 @@ public:
    * @param channel Shared gRPC channel to the Raft sidecar
    */
   explicit GrpcRaftClient(std::shared_ptr<grpc::Channel> channel)
-      : stub_(consensus::RaftNode::NewStub(channel)) {}
+      : stub_(consensus::RaftNode::NewStub(channel)) {
+    if (const char *key = std::getenv("RAFTKV_API_KEY")) {
+      api_key_ = key;
+    }
+  }
 
   /**
    * @brief Create a Raft client connected to the specified address.
@@ -71,13 +97,54 @@ public:
     // Set deadline to prevent hanging
     auto deadline = std::chrono::system_clock::now() + kDefaultTimeout;
     context.set_deadline(deadline);
+    authenticate(context);
 
     grpc::Status status = stub_->Propose(&context, cmd, &reply);
     return status.ok() && reply.success();
   }
 
+  /**
+   * @brief Read a key through the sidecar.
+   *
+   * A follower's sidecar proxies linearizable reads to the leader.
+   *
+   * @param key The key to look up
+   * @param linearizable Whether the read must reflect all committed writes
+   * @return The read result; ok is false if the sidecar could not serve it
+   */
+  ReadResult read(const std::string &key, bool linearizable) override {
+    consensus::ReadRequest request;
+    request.set_key(key);
+    request.set_linearizable(linearizable);
+
+    consensus::ReadResponse reply;
+    grpc::ClientContext context;
+    context.set_deadline(std::chrono::system_clock::now() + kDefaultTimeout);
+    authenticate(context);
+
+    ReadResult result;
+    grpc::Status status = stub_->Read(&context, request, &reply);
+    if (!status.ok()) {
+      return result;
+    }
+
+    result.ok = true;
+    if (reply.found()) {
+      result.value = reply.value();
+    }
+    return result;
+  }
+
 private:
+  /// Attaches the API key, if any, to a call.
+  void authenticate(grpc::ClientContext &context) const {
+    if (!api_key_.empty()) {
+      context.AddMetadata("x-api-key", api_key_);
+    }
+  }
+
   std::unique_ptr<consensus::RaftNode::Stub> stub_;
+  std::string api_key_;
 
   static constexpr std::chrono::seconds kDefaultTimeout{5};
 };
@@ -1,11 +1,13 @@
 #pragma once
 
+#include <atomic>
 #include <iostream>
 #include <memory>
 #include <string>
 
 #include "consensus.grpc.pb.h"
 #include <grpcpp/grpcpp.h>
+#include <grpcpp/health_check_service_interface.h>
 
 #include "../commands/kv_command.hpp"
 #include "../storage/kv_store.hpp"
@@ -48,7 +50,8 @@ public:
                                               request->data().size());
 
       std::cout << "[StateMachine] Applied: " << cmd.op << " " << cmd.key
-                << std::endl;
+                << " (index " << request->index() << ", request_id "
+                << request->request_id() << ")" << std::endl;
 
       // Apply the operation to the store
       switch (cmd.operation_type()) {
@@ -65,18 +68,114 @@ public:
         return grpc::Status::OK;
       }
 
+      last_term_.store(request->term());
+      last_index_.store(request->index());
       reply->set_success(true);
       return grpc::Status::OK;
 
     } catch (const std::exception &e) {
-      std::cerr << "[StateMachine] Error: " << e.what() << std::endl;
+      std::cerr << "[StateMachine] Error: " << e.what() << " (index "
+                << request->index() << ", request_id "
+                << request->request_id() << ")" << std::endl;
       reply->set_success(false);
       return grpc::Status(grpc::StatusCode::INTERNAL, e.what());
     }
   }
 
+  /**
+   * @brief Stream a key range from the store.
+   *
+   * The Raft sidecar pauses applies while the stream is open, so every
+   * pair reflects the same point in the log.
+   *
+   * @param context gRPC server context
+   * @param request The key range and limit
+   * @param writer Stream the key-value pairs are written to
+   * @return gRPC status
+   */
+  grpc::Status Scan(grpc::ServerContext *context,
+                    const consensus::ScanRequest *request,
+                    grpc::ServerWriter<consensus::KeyValue> *writer) override {
+    auto entries =
+        store_.scan(request->start_key(), request->end_key(), request->limit());
+
+    for (const auto &[key, value] : entries) {
+      if (context->IsCancelled()) {
+        return grpc::Status::CANCELLED;
+      }
+
+      consensus::KeyValue kv;
+      kv.set_key(key);
+      kv.set_value(value);
+      if (!writer->Write(kv)) {
+        break;
+      }
+    }
+    return grpc::Status::OK;
+  }
+
+  /**
+   * @brief Look up a key in the store.
+   *
+   * Consistency is handled by the sidecar, which only calls this once the
+   * store reflects the required point in the log.
+   *
+   * @param context gRPC server context
+   * @param request The key to look up
+   * @param reply The value, if found
+   * @return gRPC status
+   */
+  grpc::Status Read(grpc::ServerContext *context,
+                    const consensus::ReadRequest *request,
+                    consensus::ReadResponse *reply) override {
+    auto value = store_.get(request->key());
+    reply->set_found(value.has_value());
+    if (value) {
+      reply->set_value(*value);
+    }
+    return grpc::Status::OK;
+  }
+
+  /**
+   * @brief Receive a leadership transition from the Raft sidecar.
+   *
+   * Leader-only background work (TTL sweeps, compactions) should check
+   * is_leader() before running.
+   *
+   * @param context gRPC server context
+   * @param request Whether this node is now the leader, and the term
+   * @param reply Empty acknowledgement
+   * @return gRPC status
+   */
+  grpc::Status OnLeadershipChange(grpc::ServerContext *context,
+                                  const consensus::LeadershipChange *request,
+                                  consensus::LeadershipChangeAck *reply) override {
+    is_leader_.store(request->is_leader());
+    std::cout << "[StateMachine] Leadership changed: "
+              << (request->is_leader() ? "leader" : "follower")
+              << " (term " << request->term() << ")" << std::endl;
+    return grpc::Status::OK;
+  }
+
+  /**
+   * @brief Whether the co-located sidecar is currently the Raft leader.
+   */
+  [[nodiscard]] bool is_leader() const { return is_leader_.load(); }
+
+  /**
+   * @brief Term and log index of the last applied command.
+   *
+   * Compare (term, index) against the fence carried by an external write
+   * to reject work issued by a deposed leader.
+   */
+  [[nodiscard]] uint64_t last_term() const { return last_term_.load(); }
+  [[nodiscard]] uint64_t last_index() const { return last_index_.load(); }
+
 private:
   IKVStore &store_;
+  std::atomic<bool> is_leader_{false};
+  std::atomic<uint64_t> last_term_{0};
+  std::atomic<uint64_t> last_index_{0};
 };
 
 /**
@@ -101,6 +200,9 @@ public:
    * This is non-blocking. Call wait() to block until shutdown.
    */
   void start() {
+    // Serve grpc.health.v1 so the sidecar can detect an unhealthy backend
+    grpc::EnableDefaultHealthCheckService(true);
+
     grpc::ServerBuilder builder;
     builder.AddListeningPort(address_, grpc::InsecureServerCredentials());
     builder.RegisterService(&service_);
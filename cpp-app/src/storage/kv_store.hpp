@@ -1,10 +1,14 @@
 #pragma once
 
+#include <algorithm>
+#include <cstddef>
 #include <fstream>
 #include <mutex>
 #include <optional>
 #include <string>
 #include <unordered_map>
+#include <utility>
+#include <vector>
 
 namespace kvdb {
 
@@ -22,6 +26,15 @@ public:
   virtual std::optional<std::string> get(const std::string &key) const = 0;
   virtual bool remove(const std::string &key) = 0;
   virtual bool contains(const std::string &key) const = 0;
+
+  /**
+   * @brief Return the key-value pairs in [start, end), ordered by key.
+   *
+   * An empty end means no upper bound; a limit of 0 means no limit.
+   */
+  virtual std::vector<std::pair<std::string, std::string>>
+  scan(const std::string &start, const std::string &end,
+       std::size_t limit) const = 0;
 };
 
 /**
@@ -81,6 +94,32 @@ public:
     return store_.count(key) > 0;
   }
 
+  /**
+   * @brief Return the key-value pairs in [start, end), ordered by key.
+   *
+   * The whole range is copied under the lock so the result is a
+   * consistent view of the store.
+   */
+  [[nodiscard]] std::vector<std::pair<std::string, std::string>>
+  scan(const std::string &start, const std::string &end,
+       std::size_t limit) const override {
+    std::vector<std::pair<std::string, std::string>> result;
+    {
+      std::lock_guard<std::mutex> lock(mutex_);
+      for (const auto &[key, value] : store_) {
+        if (key >= start && (end.empty() || key < end)) {
+          result.emplace_back(key, value);
+        }
+      }
+    }
+
+    std::sort(result.begin(), result.end());
+    if (limit > 0 && result.size() > limit) {
+      result.resize(limit);
+    }
+    return result;
+  }
+
 private:
   std::string db_path_;
   std::unordered_map<std::string, std::string> store_;
@@ -0,0 +1,455 @@
+// Package client provides a Go client for the Raft sidecar gRPC API.
+//
+// The client tracks the current leader, sends writes and linearizable reads
+// to it directly, and transparently retries requests rejected by followers
+// against the leader they point to. Given a zone, it sends stale reads to a
+// member in that zone instead.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"my-raft-sidecar/internal/requestid"
+	"my-raft-sidecar/internal/signing"
+	pb "my-raft-sidecar/pb"
+)
+
+// leaderAddrKey is the trailer metadata key the sidecar sets on NotLeader
+// errors.
+const leaderAddrKey = "x-raftkv-leader-addr"
+
+// ErrNoLeader is returned when no node could be found to serve as leader
+// within the retry budget.
+var ErrNoLeader = errors.New("no leader available")
+
+// Config holds configuration for the client.
+type Config struct {
+	// Endpoints are sidecar gRPC addresses used to discover the leader.
+	Endpoints []string
+	// Timeout bounds each individual RPC attempt.
+	Timeout time.Duration
+	// MaxRetries is the number of additional attempts after a failure.
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry; it doubles on
+	// every subsequent retry up to MaxBackoff. Retries that follow a leader
+	// hint are not delayed.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// DialOptions are passed to every connection. Insecure transport
+	// credentials are used if none are given.
+	DialOptions []grpc.DialOption
+	// Zone, if set, sends stale reads to a member that declares the same
+	// -zone, found through Admin.GetConfiguration and refreshed every
+	// ZoneRefresh. Reads fall back to the leader if none answers.
+	Zone        string
+	ZoneRefresh time.Duration
+	// SigningKey, if set, signs every proposed command, for sidecars
+	// started with -command-signing-keys. SigningKeyID names it as in
+	// their key file.
+	SigningKeyID string
+	SigningKey   []byte
+}
+
+// DefaultConfig returns default client configuration.
+func DefaultConfig(endpoints ...string) *Config {
+	return &Config{
+		Endpoints:      endpoints,
+		Timeout:        5 * time.Second,
+		MaxRetries:     5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		ZoneRefresh:    30 * time.Second,
+	}
+}
+
+// Client is a leader-tracking client for a RaftKV cluster. It is safe for
+// concurrent use.
+type Client struct {
+	config *Config
+
+	mu     sync.Mutex
+	conns  map[string]*grpc.ClientConn
+	leader string
+	next   int
+
+	// zoneMembers are the sidecar addresses of members in config.Zone,
+	// as of zoneFetched.
+	zoneMembers []string
+	zoneFetched time.Time
+	zoneNext    int
+}
+
+// New creates a client. Connections are established lazily.
+func New(cfg *Config) (*Client, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("client: at least one endpoint is required")
+	}
+	return &Client{
+		config: cfg,
+		conns:  make(map[string]*grpc.ClientConn),
+	}, nil
+}
+
+// Fence identifies a point in the replicated log. A fence from a later
+// leader always compares greater; see Less.
+type Fence struct {
+	Term  uint64
+	Token uint64
+}
+
+// Less reports whether f was issued before other.
+func (f Fence) Less(other Fence) bool {
+	if f.Term != other.Term {
+		return f.Term < other.Term
+	}
+	return f.Token < other.Token
+}
+
+// command wraps data for Propose, signed if a signing key is configured.
+func (c *Client) command(data []byte) *pb.Command {
+	cmd := &pb.Command{Data: data}
+	if len(c.config.SigningKey) > 0 {
+		cmd.KeyId = c.config.SigningKeyID
+		cmd.Signature = signing.Sign(c.config.SigningKey, data)
+	}
+	return cmd
+}
+
+// Propose replicates a command through the Raft log, returning the fence of
+// the committed entry once it has been committed and applied on the leader.
+// Attempts that may have reached the log (timeouts) are not retried, so a
+// command is never applied twice because of the client. Proposals the
+// leader could not enqueue in time (Aborted) never reached the log and are
+// retried after a backoff. Every attempt carries the same request ID: the
+// x-request-id set in ctx's outgoing metadata, or a new one.
+func (c *Client) Propose(ctx context.Context, data []byte) (Fence, error) {
+	if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get(requestid.MetadataKey)) == 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, requestid.MetadataKey, requestid.New())
+	}
+	var fence Fence
+	err := c.do(ctx, false, func(ctx context.Context, rc pb.RaftNodeClient, addr string) (string, error) {
+		var trailer metadata.MD
+		resp, err := rc.Propose(ctx, c.command(data), grpc.Trailer(&trailer))
+		if err != nil {
+			return hintFrom(trailer), err
+		}
+		if !resp.Success {
+			// Older sidecars reported a lost leadership in the response
+			if resp.LeaderAddr != "" && resp.LeaderAddr != addr {
+				return resp.LeaderAddr, status.Error(codes.FailedPrecondition, resp.Error)
+			}
+			return "", errors.New(resp.Error)
+		}
+		fence = Fence{Term: resp.Term, Token: resp.FencingToken}
+		return "", nil
+	})
+	return fence, err
+}
+
+// Fence returns the leader's current fence. Tag external writes with it so
+// that the receiving system can reject writes carrying an older fence.
+func (c *Client) Fence(ctx context.Context) (Fence, error) {
+	var fence Fence
+	err := c.do(ctx, true, func(ctx context.Context, rc pb.RaftNodeClient, addr string) (string, error) {
+		var trailer metadata.MD
+		resp, err := rc.GetFence(ctx, &pb.GetFenceRequest{}, grpc.Trailer(&trailer))
+		if err != nil {
+			return hintFrom(trailer), err
+		}
+		fence = Fence{Term: resp.Term, Token: resp.FencingToken}
+		return "", nil
+	})
+	return fence, err
+}
+
+// Get reads a key. A linearizable read reflects every write committed
+// before the call; otherwise the value may be stale.
+func (c *Client) Get(ctx context.Context, key string, linearizable bool) (value string, found bool, err error) {
+	if !linearizable && c.config.Zone != "" {
+		if addr := c.zoneTarget(ctx); addr != "" {
+			if value, found, err = c.getFrom(ctx, addr, key); err == nil {
+				return value, found, nil
+			}
+		}
+	}
+
+	err = c.do(ctx, true, func(ctx context.Context, rc pb.RaftNodeClient, addr string) (string, error) {
+		var trailer metadata.MD
+		resp, err := rc.Read(ctx, &pb.ReadRequest{Key: key, Linearizable: linearizable}, grpc.Trailer(&trailer))
+		if err != nil {
+			return hintFrom(trailer), err
+		}
+		value, found = resp.Value, resp.Found
+		return "", nil
+	})
+	return value, found, err
+}
+
+// Scan returns the keys from start (inclusive) to end (exclusive, or the
+// last key if empty) and the applied log index every value reflects. Served
+// by the leader, the result includes every write committed before the
+// call. An interrupted scan is retried from the start, so each attempt must
+// finish within Config.Timeout.
+func (c *Client) Scan(ctx context.Context, start, end string) (kvs []*pb.KeyValue, index uint64, err error) {
+	err = c.do(ctx, true, func(ctx context.Context, rc pb.RaftNodeClient, addr string) (string, error) {
+		kvs, index = nil, 0
+		stream, err := rc.Scan(ctx, &pb.ScanRequest{StartKey: start, EndKey: end})
+		if err != nil {
+			return "", err
+		}
+		for {
+			kv, err := stream.Recv()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			kvs = append(kvs, kv)
+			index = kv.Index
+		}
+	})
+	return kvs, index, err
+}
+
+// getFrom makes a single stale read against addr.
+func (c *Client) getFrom(ctx context.Context, addr, key string) (string, bool, error) {
+	rc, err := c.clientFor(addr)
+	if err != nil {
+		return "", false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	resp, err := rc.Read(ctx, &pb.ReadRequest{Key: key})
+	if err != nil {
+		return "", false, err
+	}
+	return resp.Value, resp.Found, nil
+}
+
+// zoneTarget returns the next member in config.Zone in round-robin order,
+// or "" if there is none. The member list is refreshed from the cluster
+// configuration once it is older than ZoneRefresh.
+func (c *Client) zoneTarget(ctx context.Context) string {
+	c.mu.Lock()
+	stale := time.Since(c.zoneFetched) >= c.config.ZoneRefresh
+	c.mu.Unlock()
+
+	if stale {
+		members, err := c.fetchZoneMembers(ctx)
+		c.mu.Lock()
+		c.zoneFetched = time.Now()
+		if err == nil {
+			c.zoneMembers = members
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.zoneMembers) == 0 {
+		return ""
+	}
+	c.zoneNext++
+	return c.zoneMembers[c.zoneNext%len(c.zoneMembers)]
+}
+
+// fetchZoneMembers asks any endpoint for the configuration and returns the
+// sidecar addresses of the members in config.Zone.
+func (c *Client) fetchZoneMembers(ctx context.Context) ([]string, error) {
+	conn, err := c.connFor(c.target())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	resp, err := pb.NewAdminClient(conn).GetConfiguration(ctx, &pb.GetConfigurationRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	var members []string
+	for _, server := range resp.Servers {
+		if server.Zone == c.config.Zone && server.SidecarAddr != "" {
+			members = append(members, server.SidecarAddr)
+		}
+	}
+	return members, nil
+}
+
+// Leader asks the cluster for the current leader and caches its address.
+func (c *Client) Leader(ctx context.Context) (*pb.GetLeaderResponse, error) {
+	var leader *pb.GetLeaderResponse
+	err := c.do(ctx, true, func(ctx context.Context, rc pb.RaftNodeClient, addr string) (string, error) {
+		resp, err := rc.GetLeader(ctx, &pb.GetLeaderRequest{})
+		if err != nil {
+			return "", err
+		}
+		if resp.SidecarAddr == "" {
+			return "", status.Error(codes.Unavailable, "no known leader")
+		}
+		leader = resp
+		return resp.SidecarAddr, nil
+	})
+	return leader, err
+}
+
+// Close closes all connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for addr, conn := range c.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.conns, addr)
+	}
+	return firstErr
+}
+
+// call performs a single attempt against addr. It returns a leader hint
+// (possibly empty) along with the attempt's error.
+type call func(ctx context.Context, rc pb.RaftNodeClient, addr string) (hint string, err error)
+
+// do runs fn against the cached leader, following leader hints and backing
+// off between attempts that fail without one. Timed-out attempts are only
+// retried for idempotent calls.
+func (c *Client) do(ctx context.Context, idempotent bool, fn call) error {
+	backoff := c.config.InitialBackoff
+	var lastErr error
+
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		addr := c.target()
+		rc, err := c.clientFor(addr)
+		if err != nil {
+			return err
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+		hint, err := fn(attemptCtx, rc, addr)
+		cancel()
+
+		if err == nil {
+			c.setLeader(addr)
+			if hint != "" {
+				c.setLeader(hint)
+			}
+			return nil
+		}
+		lastErr = err
+
+		switch status.Code(err) {
+		case codes.FailedPrecondition:
+			if hint != "" && hint != addr {
+				c.setLeader(hint)
+				continue // retry immediately against the hinted leader
+			}
+			c.rotate(addr)
+		case codes.Unavailable, codes.ResourceExhausted:
+			c.rotate(addr)
+		case codes.Aborted:
+			// The leader is overloaded; back off and try it again.
+		case codes.DeadlineExceeded:
+			if !idempotent || ctx.Err() != nil {
+				return err
+			}
+			c.rotate(addr)
+		default:
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, c.config.MaxBackoff)
+	}
+
+	return fmt.Errorf("%w: %v", ErrNoLeader, lastErr)
+}
+
+// target returns the address to send the next request to: the cached
+// leader if known, otherwise the next endpoint in round-robin order.
+func (c *Client) target() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.leader != "" {
+		return c.leader
+	}
+	return c.config.Endpoints[c.next%len(c.config.Endpoints)]
+}
+
+// setLeader caches addr as the current leader.
+func (c *Client) setLeader(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leader = addr
+}
+
+// rotate forgets addr as leader and moves on to the next endpoint.
+func (c *Client) rotate(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.leader == addr {
+		c.leader = ""
+	}
+	c.next++
+}
+
+// clientFor returns a RaftNode client for addr, dialing it on first use.
+func (c *Client) clientFor(addr string) (pb.RaftNodeClient, error) {
+	conn, err := c.connFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	return pb.NewRaftNodeClient(conn), nil
+}
+
+// connFor returns the connection to addr, dialing it on first use.
+func (c *Client) connFor(addr string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, ok := c.conns[addr]
+	if !ok {
+		opts := c.config.DialOptions
+		if len(opts) == 0 {
+			opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+		}
+
+		var err error
+		conn, err = grpc.NewClient(addr, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+		}
+		c.conns[addr] = conn
+	}
+	return conn, nil
+}
+
+// hintFrom extracts the leader address from NotLeader trailer metadata.
+func hintFrom(md metadata.MD) string {
+	if values := md.Get(leaderAddrKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/v2/codec"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"my-raft-sidecar/client"
+)
+
+// backupFormat is the version of the backup file format: a header line
+// followed by one JSON object per key.
+const backupFormat = 1
+
+// backupHeader is the first line of a backup file.
+type backupHeader struct {
+	Format int       `json:"raftkv_backup"`
+	Index  uint64    `json:"index"`
+	Keys   int       `json:"keys"`
+	Time   time.Time `json:"time"`
+}
+
+// backupEntry is a key and its value in a backup file.
+type backupEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// setCommand is the MsgPack command the backend applies to set a key.
+type setCommand struct {
+	Op    string `codec:"op"`
+	Key   string `codec:"key"`
+	Value string `codec:"value"`
+}
+
+// rpcFlags are the flags of the subcommands that call the sidecar gRPC API.
+type rpcFlags struct {
+	endpoints  *string
+	apiKeyFile *string
+	timeout    *time.Duration
+}
+
+// addRPCFlags defines the gRPC API flags on fs.
+func addRPCFlags(fs *flag.FlagSet, timeout time.Duration) *rpcFlags {
+	return &rpcFlags{
+		endpoints:  fs.String("rpc", "127.0.0.1:50052", "Comma-separated sidecar gRPC addresses of cluster members; requests go to the leader"),
+		apiKeyFile: fs.String("api-key-file", "", "File holding an API key for sidecars started with -grpc-api-keys-file"),
+		timeout:    fs.Duration("timeout", timeout, "Timeout of each request"),
+	}
+}
+
+// config returns the client configuration the flags describe.
+func (f *rpcFlags) config() *client.Config {
+	cfg := client.DefaultConfig(splitArgs(*f.endpoints)...)
+	cfg.Timeout = *f.timeout
+	if *f.apiKeyFile != "" {
+		key, err := os.ReadFile(*f.apiKeyFile)
+		if err != nil {
+			fatalf("Failed to read API key: %v", err)
+		}
+		cfg.DialOptions = []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithPerRPCCredentials(apiKey(strings.TrimSpace(string(key)))),
+		}
+	}
+	return cfg
+}
+
+// apiKey presents an API key on every call.
+type apiKey string
+
+func (k apiKey) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"x-api-key": string(k)}, nil
+}
+
+// The commands dial without TLS, which the gRPC API serves only on its Unix
+// socket when it uses SPIFFE or Vault certificates.
+func (k apiKey) RequireTransportSecurity() bool {
+	return false
+}
+
+// runBackup implements the backup subcommand, which copies every key of the
+// cluster, as of a single log index, to a file:
+//
+//	sidecar backup -rpc=10.0.0.1:50052 raftkv.backup
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	rpc := addRPCFlags(fs, 5*time.Minute)
+	fs.Usage = commandUsage(fs, "backup [flags] <file>",
+		"Copies every key of the cluster to a file, as of a single applied log index.",
+		"The keys are read from the leader and include every write committed before the call.")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+
+	c, err := client.New(rpc.config())
+	if err != nil {
+		fatalf("Failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	if _, err := c.Leader(ctx); err != nil {
+		fatalf("Failed to find the leader: %v", err)
+	}
+	kvs, index, err := c.Scan(ctx, "", "")
+	if err != nil {
+		fatalf("Failed to read keys: %v", err)
+	}
+
+	// Write to a temporary file first, so that a failed backup does not
+	// replace an earlier one.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		fatalf("Failed to create backup: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	encoder.Encode(backupHeader{Format: backupFormat, Index: index, Keys: len(kvs), Time: time.Now().UTC()})
+	for _, kv := range kvs {
+		encoder.Encode(backupEntry{Key: kv.Key, Value: kv.Value})
+	}
+	if err := w.Flush(); err != nil {
+		fatalf("Failed to write backup: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		fatalf("Failed to write backup: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		fatalf("Failed to write backup: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		fatalf("Failed to write backup: %v", err)
+	}
+	fmt.Printf("Backed up %d keys at index %d to %s\n", len(kvs), index, path)
+}
+
+// runRestore implements the restore subcommand, which sets every key of a
+// backup through Propose:
+//
+//	sidecar restore -rpc=10.0.0.1:50052 raftkv.backup
+//
+// Keys that are not in the backup are left as they are.
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	rpc := addRPCFlags(fs, 5*time.Second)
+	signingKeyID := fs.String("signing-key-id", "", "ID of the key commands are signed with, for sidecars started with -command-signing-keys")
+	signingKeyFile := fs.String("signing-key-file", "", "File holding the base64 key of -signing-key-id")
+	fs.Usage = commandUsage(fs, "restore [flags] <file>",
+		"Sets every key of a backup written by backup. Keys that are not in the backup are left as they are.")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+
+	cfg := rpc.config()
+	if *signingKeyID != "" {
+		data, err := os.ReadFile(*signingKeyFile)
+		if err != nil {
+			fatalf("Failed to read signing key: %v", err)
+		}
+		if cfg.SigningKey, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err != nil {
+			fatalf("Invalid signing key in %s: %v", *signingKeyFile, err)
+		}
+		cfg.SigningKeyID = *signingKeyID
+	}
+	c, err := client.New(cfg)
+	if err != nil {
+		fatalf("Failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		fatalf("Failed to open backup: %v", err)
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	var header backupHeader
+	if err := decoder.Decode(&header); err != nil || header.Format != backupFormat {
+		fatalf("%s is not a backup written by this version of the sidecar", path)
+	}
+
+	handle := &codec.MsgpackHandle{}
+	restored := 0
+	for {
+		var entry backupEntry
+		err := decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fatalf("Failed to read backup after %d keys: %v", restored, err)
+		}
+		var data []byte
+		if err := codec.NewEncoderBytes(&data, handle).Encode(setCommand{Op: "SET", Key: entry.Key, Value: entry.Value}); err != nil {
+			fatalf("Failed to encode %q: %v", entry.Key, err)
+		}
+		if _, err := c.Propose(context.Background(), data); err != nil {
+			fatalf("Failed to restore %q after %d keys: %v", entry.Key, restored, err)
+		}
+		restored++
+	}
+	if restored != header.Keys {
+		fatalf("Restored %d keys, but the backup should hold %d; it may be truncated", restored, header.Keys)
+	}
+	fmt.Printf("Restored %d keys from %s (backed up at index %d)\n", restored, path, header.Index)
+}
+
+// splitArgs splits a comma-separated flag value, dropping empty items.
+func splitArgs(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
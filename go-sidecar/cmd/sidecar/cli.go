@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"my-raft-sidecar/internal/cluster"
+	"my-raft-sidecar/internal/config"
+	"my-raft-sidecar/internal/tlsutil"
+)
+
+// apiFlags are the flags of the subcommands that call a node's management
+// API.
+type apiFlags struct {
+	addr      *string
+	tokenFile *string
+	https     *bool
+	ca        *string
+	cert      *string
+	key       *string
+	timeout   *time.Duration
+}
+
+// addAPIFlags defines the management API flags on fs.
+func addAPIFlags(fs *flag.FlagSet) *apiFlags {
+	return &apiFlags{
+		addr:      fs.String("addr", "127.0.0.1:6000", "Management API address of the node"),
+		tokenFile: fs.String("token-file", "", "File holding the management API bearer token (overrides "+config.MgmtTokenEnv+")"),
+		https:     fs.Bool("https", false, "Call the management API over HTTPS (implied by -tls-ca, -tls-cert and -tls-key)"),
+		ca:        fs.String("tls-ca", "", "PEM CA bundle the management API certificate is verified against (system roots if empty)"),
+		cert:      fs.String("tls-cert", "", "PEM client certificate, for management APIs started with -mgmt-mtls"),
+		key:       fs.String("tls-key", "", "PEM private key of -tls-cert"),
+		timeout:   fs.Duration("timeout", 30*time.Second, "Timeout of each request"),
+	}
+}
+
+// mgmtAPI calls a node's management API. Requests only the leader serves
+// follow the node's redirect to it.
+type mgmtAPI struct {
+	addr   string
+	client *http.Client
+}
+
+// api returns a client for the management API the flags name, exiting if
+// the token or TLS files cannot be loaded.
+func (f *apiFlags) api() *mgmtAPI {
+	token, err := (&config.Config{MgmtTokenFile: *f.tokenFile}).MgmtToken()
+	if err != nil {
+		fatalf("Failed to load management token: %v", err)
+	}
+	cluster.SetManagementToken(token)
+
+	switch {
+	case *f.cert != "" || *f.key != "":
+		source, err := tlsutil.NewSource("management API", tlsutil.Files{Cert: *f.cert, Key: *f.key, CA: *f.ca})
+		if err != nil {
+			fatalf("Failed to load TLS material: %v", err)
+		}
+		cluster.SetManagementTLSDialer(source.DialTLSContext(true, nil))
+	case *f.ca != "":
+		pool, err := tlsutil.LoadCA(*f.ca)
+		if err != nil {
+			fatalf("Failed to load -tls-ca: %v", err)
+		}
+		cluster.SetManagementTLS(&tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool})
+	case *f.https:
+		cluster.SetManagementTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	return &mgmtAPI{addr: *f.addr, client: cluster.NewManagementClient(*f.timeout)}
+}
+
+// call sends a request to path with params and decodes the JSON response
+// into out, unless out is nil. Responses other than 2xx are returned as
+// errors holding the response body.
+func (a *mgmtAPI) call(method, path string, params url.Values, out any) error {
+	target := cluster.ManagementURL(a.addr, path)
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: malformed response: %w", method, path, err)
+	}
+	return nil
+}
+
+// printJSON writes v to standard output as indented JSON.
+func printJSON(v any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// commandUsage returns a FlagSet usage function for the named subcommand,
+// printing its synopsis and description before its flags.
+func commandUsage(fs *flag.FlagSet, synopsis string, description ...string) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s\n\n", os.Args[0], synopsis)
+		for _, line := range description {
+			fmt.Fprintln(fs.Output(), line)
+		}
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"my-raft-sidecar/internal/config"
+)
+
+// runInit implements the init subcommand, which prints a configuration
+// file documenting every setting with its default:
+//
+//	sidecar init > sidecar.yaml
+//	sidecar init -format=toml > sidecar.toml
+//
+// The settings are commented out; uncomment the ones to change and pass
+// the file to -config.
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	format := fs.String("format", "yaml", "Format of the file: yaml or toml")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s init [flags] > sidecar.yaml\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Prints a configuration file for -config listing every setting with its")
+		fmt.Fprintln(fs.Output(), "usage and default, commented out.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := config.WriteTemplate(os.Stdout, *format); err != nil {
+		fatalf("init: %v", err)
+	}
+}
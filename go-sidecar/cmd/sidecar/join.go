@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+
+	"my-raft-sidecar/internal/cluster"
+	"my-raft-sidecar/internal/config"
+)
+
+// runJoin implements the join subcommand, which adds a node to a cluster
+// through its leader, as a sidecar started with -join does, retrying until
+// the leader accepts it:
+//
+//	sidecar join -addr=10.0.0.1:6000 -id=node4 -advertise=10.0.0.4
+//
+// The node's Raft, gRPC and management addresses are derived from
+// -advertise and its ports, or taken from -srv-advertise and
+// -mgmt-advertise, as the sidecar derives them.
+func runJoin(args []string) {
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
+	api := addAPIFlags(fs)
+	rpcAddr := fs.String("rpc", "", "Sidecar gRPC address of any member to join through the Admin service (instead of -addr)")
+	nodeID := fs.String("id", "", "ID of the joining node")
+	advertise := fs.String("advertise", "", "Address the joining node advertises to other nodes")
+	raftPort := fs.String("raft", "8088", "Raft port of the joining node")
+	sidecarPort := fs.String("srv", "50052", "Sidecar gRPC port of the joining node")
+	mgmtPort := fs.String("mgmt", "6000", "Management API port of the joining node")
+	sidecarAdvertise := fs.String("srv-advertise", "", "Sidecar gRPC address (host or host:port) of the joining node, if not -advertise and -srv")
+	mgmtAdvertise := fs.String("mgmt-advertise", "", "Management API address (host or host:port) of the joining node, if not -advertise and -mgmt")
+	nonvoter := fs.Bool("nonvoter", false, "Join as a non-voting learner")
+	readOnly := fs.Bool("nonvoter-readonly", false, "Join as a non-voting read-only replica")
+	standby := fs.Bool("standby", false, "Join as a hot spare")
+	priority := fs.Int("priority", 0, "Leadership priority of the joining node")
+	zone := fs.String("zone", "", "Zone of the joining node")
+	rack := fs.String("rack", "", "Rack of the joining node")
+	clusterTokenFile := fs.String("cluster-token-file", "", "File holding the cluster token (overrides "+config.ClusterTokenEnv+")")
+	joinTokenFile := fs.String("join-token-file", "", "File holding a join token to present instead of the cluster token (overrides "+config.JoinTokenEnv+")")
+	maxRetries := fs.Int("retries", 5, "Attempts before giving up (0 retries for up to five minutes)")
+	fs.Usage = commandUsage(fs, "join [flags]",
+		"Adds a node, with its addresses and placement, to a cluster through its leader.",
+		"Members other than the leader redirect the request to it.")
+	fs.Parse(args)
+
+	if *nodeID == "" || *advertise == "" {
+		fatalf("-id and -advertise are required")
+	}
+	node := &config.Config{
+		RaftAdvertise:    *advertise,
+		RaftPort:         *raftPort,
+		SidecarPort:      *sidecarPort,
+		MgmtPort:         *mgmtPort,
+		SidecarAdvertise: *sidecarAdvertise,
+		MgmtAdvertise:    *mgmtAdvertise,
+	}
+
+	// The joiner presents the token and TLS configuration of the flags.
+	api.api()
+	joinConfig := cluster.DefaultJoinConfig(*api.addr, *nodeID, node.AdvertiseAddr())
+	joinConfig.LeaderRPCAddr = *rpcAddr
+	joinConfig.SidecarAddr = node.SidecarAdvertiseAddr()
+	joinConfig.MgmtAddr = node.MgmtAdvertiseAddr()
+	joinConfig.Voter = !*nonvoter && !*readOnly && !*standby
+	joinConfig.ReadOnly = *readOnly
+	joinConfig.Standby = *standby
+	joinConfig.Priority = *priority
+	joinConfig.Zone = *zone
+	joinConfig.Rack = *rack
+	joinConfig.ClusterToken = joinToken(*clusterTokenFile, *joinTokenFile)
+	joinConfig.MaxRetries = *maxRetries
+	if err := cluster.NewJoiner(joinConfig).Join(); err != nil {
+		fatalf("Failed to join %s: %v", *nodeID, err)
+	}
+}
+
+// joinToken returns the join token or, without one, the cluster token, from
+// the named files or their environment variables.
+func joinToken(clusterTokenFile, joinTokenFile string) string {
+	secrets := &config.Config{ClusterTokenFile: clusterTokenFile, JoinTokenFile: joinTokenFile}
+	if err := secrets.LoadSecrets(); err != nil {
+		fatalf("Failed to load secrets: %v", err)
+	}
+	if secrets.JoinToken != "" {
+		return secrets.JoinToken
+	}
+	return secrets.ClusterToken
+}
@@ -1,87 +1,111 @@
 // Package main is the entry point for the Raft sidecar application.
+//
+// The sidecar runs with the serve subcommand, or without one. The other
+// subcommands operate a running node through its management or gRPC API,
+// or a stopped node's data directory:
+//
+//	sidecar [serve] [flags]         run the sidecar
+//	sidecar [serve] -version        print the version and build information
+//	sidecar init [flags]            print an annotated configuration file
+//	sidecar join [flags]            add a node with its metadata through the leader
+//	sidecar status [flags]          show the status of a node, or of every member
+//	sidecar members list|add|remove manage the cluster's members
+//	sidecar snapshot [flags]        take a Raft snapshot on a node
+//	sidecar backup [flags] <file>   copy every key to a file
+//	sidecar restore [flags] <file>  write the keys of a backup
+//	sidecar recover [flags]         replace the configuration of a stopped node
+//	sidecar verify-audit [flags]    check the hash chain of an audit log
 package main
 
 import (
-	"log"
+	"flag"
+	"fmt"
+	"io"
 	"os"
-	"os/signal"
-	"syscall"
+	"sort"
+	"strings"
 
-	"my-raft-sidecar/internal/backend"
-	"my-raft-sidecar/internal/cluster"
 	"my-raft-sidecar/internal/config"
-	"my-raft-sidecar/internal/fsm"
-	"my-raft-sidecar/internal/management"
-	"my-raft-sidecar/internal/raftnode"
-	"my-raft-sidecar/internal/rpc"
+	"my-raft-sidecar/internal/version"
 )
 
-func main() {
-	// Parse configuration
-	cfg := config.Parse()
-	log.Printf("Starting sidecar with config: %s", cfg)
-
-	// Connect to C++ backend
-	backendClient, err := backend.Connect(backend.DefaultConnectionConfig(cfg.AppAddr))
-	if err != nil {
-		log.Fatalf("Failed to connect to backend: %v", err)
-	}
-	defer backendClient.Close()
+// command is a subcommand of the sidecar binary.
+type command struct {
+	run     func(args []string)
+	summary string
+}
 
-	// Create FSM
-	stateMachineClient := fsm.NewStateMachineClient(backendClient.StateMachineClient)
-	raftFSM := fsm.NewCppFSM(stateMachineClient)
+// commands are the subcommands by name.
+var commands = map[string]command{
+	"serve":        {runServe, "Run the sidecar (the default without a subcommand)"},
+	"init":         {runInit, "Print a configuration file documenting every setting and its default"},
+	"join":         {runJoin, "Add a node, with its addresses and placement, to a cluster through its leader"},
+	"status":       {runStatus, "Show the status of a node, or watch every member's in a table"},
+	"members":      {runMembers, "List, add or remove the members of a cluster"},
+	"snapshot":     {runSnapshot, "Take a Raft snapshot on a node, compacting its log"},
+	"backup":       {runBackup, "Copy every key of the cluster to a file"},
+	"restore":      {runRestore, "Write the keys of a backup to the cluster"},
+	"recover":      {runRecover, "Replace the cluster configuration of a stopped node after quorum loss"},
+	"verify-audit": {runVerifyAudit, "Check that an audit log is unmodified and complete"},
+}
 
-	// Create Raft node
-	node, err := raftnode.New(cfg, raftFSM, nil)
-	if err != nil {
-		log.Fatalf("Failed to create Raft node: %v", err)
+func main() {
+	flag.Usage = serveUsage
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		runServe(os.Args[1:])
+		return
 	}
 
-	// Bootstrap if requested
-	if cfg.Bootstrap {
-		if err := node.Bootstrap(); err != nil {
-			log.Printf("Warning: Bootstrap failed (may already be bootstrapped): %v", err)
-		}
+	name := os.Args[1]
+	if name == "help" {
+		usage(os.Stdout)
+		return
 	}
-
-	// Start management server
-	mgmtServer := management.NewServer(node, cfg.MgmtPort)
-	mgmtServer.Start()
-
-	// Join cluster if requested
-	if cfg.JoinAddr != "" {
-		joiner := cluster.NewJoiner(cluster.DefaultJoinConfig(
-			cfg.JoinAddr,
-			cfg.NodeID,
-			cfg.AdvertiseAddr(),
-		))
-		joiner.JoinAsync()
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		usage(os.Stderr)
+		os.Exit(2)
 	}
+	cmd.run(os.Args[2:])
+}
 
-	// Start gRPC server
-	grpcServer := rpc.NewServer(node)
-
-	// Setup graceful shutdown
-	go func() {
-		sigCh := make(chan os.Signal, 1)
-		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-		<-sigCh
+// usage lists the subcommands.
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	// Keep serve, the default, first.
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "serve" || names[j] == "serve" {
+			return names[i] == "serve"
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-13s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
 
-		log.Println("Shutting down...")
-		grpcServer.Stop()
-	}()
+// fatalf prints an error to stderr and exits with status 1.
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
 
-	// Log startup info
-	log.Printf("Go Sidecar %s running (Bind: %s, Adv: %s). Mgmt: %s",
-		cfg.NodeID,
-		cfg.BindAddr(),
-		cfg.AdvertiseAddr(),
-		cfg.MgmtPort,
-	)
+// printVersion prints the version and build information.
+func printVersion() {
+	fmt.Printf("RaftKV sidecar %s\n", version.Get())
+}
 
-	// Start serving (blocks until shutdown)
-	if err := grpcServer.Start(cfg.SidecarPort); err != nil {
-		log.Fatalf("gRPC server failed: %v", err)
-	}
+// serveUsage prints the flags of the sidecar, followed by the other
+// commands.
+func serveUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [serve] [flags]\n\n", os.Args[0])
+	config.PrintDefaults(out)
+	fmt.Fprintf(out, "\nRun '%s help' for the other commands.\n", os.Args[0])
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"my-raft-sidecar/internal/config"
+)
+
+// member is one server in the /configuration response.
+type member struct {
+	ID          string  `json:"id"`
+	Address     string  `json:"address"`
+	Suffrage    string  `json:"suffrage"`
+	Leader      bool    `json:"leader"`
+	Zone        string  `json:"zone,omitempty"`
+	Rack        string  `json:"rack,omitempty"`
+	MgmtAddr    string  `json:"mgmt_addr,omitempty"`
+	MatchIndex  *uint64 `json:"match_index,omitempty"`
+	LastContact string  `json:"last_contact,omitempty"`
+}
+
+// runMembers implements the members subcommand:
+//
+//	sidecar members list [-json]
+//	sidecar members add -id=node4 -raft-addr=10.0.0.4:8088 [-voter=false]
+//	sidecar members remove -id=node4 [-force]
+func runMembers(args []string) {
+	if len(args) == 0 {
+		membersUsage()
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "list":
+		runMembersList(args[1:])
+	case "add":
+		runMembersAdd(args[1:])
+	case "remove":
+		runMembersRemove(args[1:])
+	default:
+		membersUsage()
+		os.Exit(2)
+	}
+}
+
+func membersUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s members list|add|remove [flags]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Run '%s members <list|add|remove> -h' for the flags of each.\n", os.Args[0])
+}
+
+// runMembersList prints the cluster configuration as seen by a node; on
+// the leader it includes each follower's replication progress.
+func runMembersList(args []string) {
+	fs := flag.NewFlagSet("members list", flag.ExitOnError)
+	api := addAPIFlags(fs)
+	asJSON := fs.Bool("json", false, "Print the configuration as JSON")
+	fs.Usage = commandUsage(fs, "members list [flags]", "Lists the members of the cluster.")
+	fs.Parse(args)
+
+	var configuration struct {
+		Index    uint64   `json:"index"`
+		LeaderID string   `json:"leader_id"`
+		Servers  []member `json:"servers"`
+	}
+	if err := api.api().call(http.MethodGet, "/configuration", nil, &configuration); err != nil {
+		fatalf("Failed to list members: %v", err)
+	}
+	if *asJSON {
+		printJSON(configuration)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tSUFFRAGE\tLEADER\tZONE\tRACK\tMATCH INDEX\tLAST CONTACT")
+	for _, m := range configuration.Servers {
+		match := "-"
+		if m.MatchIndex != nil {
+			match = strconv.FormatUint(*m.MatchIndex, 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%s\t%s\t%s\t%s\n",
+			m.ID, m.Address, m.Suffrage, m.Leader, dash(m.Zone), dash(m.Rack), match, dash(m.LastContact))
+	}
+	w.Flush()
+}
+
+// runMembersAdd adds a server by its Raft address alone, without the
+// metadata a joining sidecar publishes (see runJoin).
+func runMembersAdd(args []string) {
+	fs := flag.NewFlagSet("members add", flag.ExitOnError)
+	api := addAPIFlags(fs)
+	nodeID := fs.String("id", "", "ID of the server to add")
+	raftAddr := fs.String("raft-addr", "", "Raft address (host:port) of the server to add")
+	voter := fs.Bool("voter", true, "Add the server as a voter rather than a non-voting learner")
+	clusterTokenFile := fs.String("cluster-token-file", "", "File holding the cluster token (overrides "+config.ClusterTokenEnv+")")
+	joinTokenFile := fs.String("join-token-file", "", "File holding a join token to present instead of the cluster token (overrides "+config.JoinTokenEnv+")")
+	fs.Usage = commandUsage(fs, "members add [flags]", "Adds a server to the cluster through the leader.")
+	fs.Parse(args)
+
+	if *nodeID == "" || *raftAddr == "" {
+		fatalf("-id and -raft-addr are required")
+	}
+	params := url.Values{
+		"peerID":      {*nodeID},
+		"peerAddress": {*raftAddr},
+		"voter":       {strconv.FormatBool(*voter)},
+	}
+	if token := joinToken(*clusterTokenFile, *joinTokenFile); token != "" {
+		params.Set("token", token)
+	}
+	if err := api.api().call(http.MethodPost, "/join", params, nil); err != nil {
+		fatalf("Failed to add %s: %v", *nodeID, err)
+	}
+	fmt.Printf("Added %s at %s\n", *nodeID, *raftAddr)
+}
+
+// runMembersRemove removes a server through the leader.
+func runMembersRemove(args []string) {
+	fs := flag.NewFlagSet("members remove", flag.ExitOnError)
+	api := addAPIFlags(fs)
+	nodeID := fs.String("id", "", "ID of the server to remove")
+	force := fs.Bool("force", false, "Remove the server even if the remaining voters could not form a quorum")
+	fs.Usage = commandUsage(fs, "members remove [flags]", "Removes a server from the cluster through the leader.")
+	fs.Parse(args)
+
+	if *nodeID == "" {
+		fatalf("-id is required")
+	}
+	params := url.Values{"peerID": {*nodeID}}
+	if *force {
+		params.Set("force", "true")
+	}
+	if err := api.api().call(http.MethodDelete, "/remove", params, nil); err != nil {
+		fatalf("Failed to remove %s: %v", *nodeID, err)
+	}
+	fmt.Printf("Removed %s\n", *nodeID)
+}
+
+// dash returns s, or "-" if it is empty.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/backend"
+	"my-raft-sidecar/internal/config"
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// runRecover implements the recover subcommand, which rewrites the stored
+// configuration of a stopped node with raft.RecoverCluster:
+//
+//	sidecar recover -id=node1 -data=raft-data -servers=node1=10.0.0.1:8088
+//
+// The servers come from -servers ("id=host:port", comma-separated, all
+// voters) or from -peers-file in the peers.json format. The log is replayed
+// into the backend at -app before it is compacted.
+func runRecover(args []string) {
+	fs := flag.NewFlagSet("recover", flag.ExitOnError)
+	nodeID := fs.String("id", "node1", "ID of this node")
+	dataDir := fs.String("data", "raft-data", "Data directory of the stopped node")
+	appAddr := fs.String("app", "localhost:50051", "Address of C++ App gRPC")
+	servers := fs.String("servers", "", "Comma-separated id=host:port Raft addresses of the voters of the recovered cluster")
+	peersFile := fs.String("peers-file", "", "Read the recovered configuration from a peers.json file instead of -servers")
+	encryptionKeyFile := fs.String("encryption-key-file", "", "Keys of an encrypted log store, as given to the sidecar (overrides "+config.EncryptionKeyEnv+")")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s recover [flags]\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Replaces the cluster configuration stored in a stopped node's data directory.")
+		fmt.Fprintln(fs.Output(), "Only use it when a majority of voters is permanently lost.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var configuration raft.Configuration
+	switch {
+	case *peersFile != "" && *servers != "":
+		fatalf("-servers and -peers-file are mutually exclusive")
+	case *peersFile != "":
+		var err error
+		if configuration, err = raft.ReadConfigJSON(*peersFile); err != nil {
+			fatalf("Failed to read %s: %v", *peersFile, err)
+		}
+	case *servers != "":
+		for _, entry := range strings.Split(*servers, ",") {
+			id, addr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || id == "" || addr == "" {
+				fatalf("Invalid server %q, expected id=host:port", entry)
+			}
+			configuration.Servers = append(configuration.Servers, raft.Server{
+				Suffrage: raft.Voter,
+				ID:       raft.ServerID(id),
+				Address:  raft.ServerAddress(addr),
+			})
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if len(configuration.Servers) == 0 {
+		fatalf("The recovered configuration has no servers")
+	}
+
+	keys, err := config.LoadEncryptionKeys(*encryptionKeyFile)
+	if err != nil {
+		fatalf("Failed to load encryption keys: %v", err)
+	}
+
+	backendClient, err := backend.Connect(backend.DefaultConnectionConfig(*appAddr))
+	if err != nil {
+		fatalf("Failed to connect to backend: %v", err)
+	}
+	defer backendClient.Close()
+	stateMachine := fsm.NewCppFSM(fsm.NewStateMachineClient(backendClient.StateMachineClient))
+
+	fmt.Fprintf(os.Stderr, "WARNING: recovering %s in %s with %d servers\n", *nodeID, *dataDir, len(configuration.Servers))
+	if err := raftnode.Recover(*dataDir, *nodeID, stateMachine, configuration, keys); err != nil {
+		fatalf("Recovery failed: %v", err)
+	}
+	fmt.Fprintln(os.Stderr, "Recovery complete; start the sidecar normally")
+}
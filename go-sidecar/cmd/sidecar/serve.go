@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"my-raft-sidecar/internal/config"
+	"my-raft-sidecar/internal/logging"
+	"my-raft-sidecar/internal/version"
+	"my-raft-sidecar/sidecar"
+)
+
+// runServe implements the serve subcommand, which runs the sidecar with
+// the flags in args:
+//
+//	sidecar serve -id=node1 -advertise=10.0.0.1 -bootstrap
+//
+// It is also what the sidecar runs when no subcommand is given. With
+// -check-config it only checks the configuration, exiting with status 1 if
+// it is invalid.
+func runServe(args []string) {
+	// Parse configuration
+	cfg := config.Parse(args)
+	if cfg.Version {
+		printVersion()
+		return
+	}
+
+	// Log in the configured format, to the log file as well if one is
+	// given; an unknown format or level is left to the validation to
+	// report.
+	var logOutput io.Writer = os.Stderr
+	if cfg.LogFile != "" && !cfg.CheckConfig {
+		file, err := logging.OpenRotatingFile(cfg.LogFile, logging.RotationConfig{
+			MaxSize:    int64(cfg.LogMaxSize) << 20,
+			MaxAge:     cfg.LogMaxAge,
+			MaxBackups: cfg.LogMaxBackups,
+			Compress:   cfg.LogCompress,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		// The file comes first, as a failed write stops io.MultiWriter.
+		logOutput = io.MultiWriter(file, os.Stderr)
+	}
+	_ = logging.Setup(logOutput, cfg.LogFormat, cfg.LogLevel, slog.String("node_id", cfg.NodeID))
+	slog.Info("Starting sidecar", "version", version.Get(), "config", cfg.String())
+	if cfg.Env != "" {
+		slog.Info("Read configuration file with an environment overlay (flags and RAFTKV_ variables take precedence)", "file", cfg.ConfigFile, "env", cfg.Env)
+	} else if cfg.ConfigFile != "" {
+		slog.Info("Read configuration file (flags and RAFTKV_ variables take precedence)", "file", cfg.ConfigFile)
+	}
+	slog.Info("Raft tuning profile", "profile", cfg.Profile,
+		"heartbeat_timeout", cfg.HeartbeatTimeout.String(), "election_timeout", cfg.ElectionTimeout.String(),
+		"commit_timeout", cfg.CommitTimeout.String(), "log_sync", cfg.LogSync, "batch_apply", cfg.BatchApply,
+		"snapshot_threshold", cfg.SnapshotThreshold)
+	if overrides := cfg.Settings().Overrides; len(overrides) > 0 {
+		slog.Info("Applied settings changed at runtime, which take precedence over every other source",
+			"file", filepath.Join(cfg.DataDir, config.OverridesFile), "settings", overrides)
+	}
+
+	// Only check the configuration with -check-config
+	if cfg.CheckConfig {
+		if err := sidecar.New(cfg).Check(); err != nil {
+			for _, problem := range strings.Split(err.Error(), "\n") {
+				slog.Error("Invalid configuration", "problem", problem)
+			}
+			os.Exit(1)
+		}
+		slog.Info("Configuration is valid")
+		return
+	}
+
+	// Shut down gracefully on SIGINT and SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Apply changed tunables and reload TLS material on SIGHUP
+	s := sidecar.New(cfg)
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if _, err := s.Reload(); err != nil {
+				slog.Error("Failed to reload on SIGHUP, keeping the running configuration", "error", err)
+			}
+		}
+	}()
+
+	if err := s.Run(ctx); err != nil {
+		slog.Error("Sidecar failed", "error", err)
+		os.Exit(1)
+	}
+}
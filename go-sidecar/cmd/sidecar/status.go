@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// runStatus implements the status subcommand, which prints a node's
+// /status response, or with -watch a table of every member's status that
+// is refreshed until interrupted:
+//
+//	sidecar status -addr=10.0.0.1:6000 [-verify]
+//	sidecar status -addr=10.0.0.1:6000 -watch [-interval=2s] [-nodes=...]
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	api := addAPIFlags(fs)
+	verify := fs.Bool("verify", false, "Confirm leadership with a quorum before reporting the node as leader")
+	watch := fs.Bool("watch", false, "Poll every member and show their state, term, commit and applied index and lag in a table, refreshed every -interval")
+	interval := fs.Duration("interval", 2*time.Second, "How often -watch polls the members")
+	nodes := fs.String("nodes", "", "Comma-separated management API addresses for -watch to poll, instead of the members -addr lists")
+	fs.Usage = commandUsage(fs, "status [flags]", "Shows the status of a node, or with -watch of every member.")
+	fs.Parse(args)
+
+	var params url.Values
+	if *verify {
+		params = url.Values{"verify": {"true"}}
+	}
+	if *watch {
+		if *interval <= 0 {
+			fatalf("-interval must be positive")
+		}
+		// An unreachable node must not hold up the refresh
+		*api.timeout = min(*api.timeout, *interval)
+		watchStatus(api.api(), params, splitArgs(*nodes), *interval)
+		return
+	}
+
+	var status json.RawMessage
+	if err := api.api().call(http.MethodGet, "/status", params, &status); err != nil {
+		fatalf("Failed to get status: %v", err)
+	}
+	printJSON(status)
+}
+
+// memberStatus is the part of a node's /status response the watch table
+// shows.
+type memberStatus struct {
+	NodeID         string `json:"node_id"`
+	State          string `json:"state"`
+	Term           uint64 `json:"term"`
+	IsLeader       bool   `json:"is_leader"`
+	LeaderID       string `json:"leader_id"`
+	CommitIndex    uint64 `json:"commit_index"`
+	AppliedIndex   uint64 `json:"applied_index"`
+	BackendHealthy bool   `json:"backend_healthy"`
+	Draining       bool   `json:"draining"`
+	Standby        bool   `json:"standby"`
+}
+
+// watchTarget is a node the watch polls, and the result of the last poll.
+type watchTarget struct {
+	id     string
+	addr   string
+	status memberStatus
+	err    error
+}
+
+// watchStatus polls the /status of every member, or of the given nodes,
+// every interval, redrawing the table each time, until the process is
+// interrupted.
+func watchStatus(api *mgmtAPI, params url.Values, nodes []string, interval time.Duration) {
+	known := nodes
+	for {
+		targets, err := watchTargets(api, nodes, known)
+		if err == nil {
+			known = nil
+			for _, target := range targets {
+				known = append(known, target.addr)
+			}
+			pollStatus(api, params, targets)
+		}
+
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("Cluster status at %s, every %s (Ctrl-C to exit)\n\n", time.Now().Format(time.TimeOnly), interval)
+		if err != nil {
+			fmt.Printf("Failed to list members: %v\n", err)
+		} else {
+			printStatusTable(targets)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// watchTargets returns the nodes to poll: the given nodes, or the members
+// of the configuration, asked of -addr or, if it does not answer, of a
+// member found earlier. Members that have not published their management
+// address are assumed to serve it on -addr's port.
+func watchTargets(api *mgmtAPI, nodes, known []string) ([]*watchTarget, error) {
+	var targets []*watchTarget
+	if len(nodes) > 0 {
+		for _, addr := range nodes {
+			targets = append(targets, &watchTarget{addr: addr})
+		}
+		return targets, nil
+	}
+
+	var configuration struct {
+		Servers []member `json:"servers"`
+	}
+	var err error
+	for _, addr := range append([]string{api.addr}, known...) {
+		peer := &mgmtAPI{addr: addr, client: api.client}
+		if err = peer.call(http.MethodGet, "/configuration", nil, &configuration); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, port, _ := net.SplitHostPort(api.addr)
+	for _, m := range configuration.Servers {
+		addr := m.MgmtAddr
+		if addr == "" {
+			if host, _, err := net.SplitHostPort(m.Address); err == nil && port != "" {
+				addr = net.JoinHostPort(host, port)
+			}
+		}
+		targets = append(targets, &watchTarget{id: m.ID, addr: addr})
+	}
+	return targets, nil
+}
+
+// pollStatus fetches the /status of every target at once.
+func pollStatus(api *mgmtAPI, params url.Values, targets []*watchTarget) {
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		if target.addr == "" {
+			target.err = errors.New("unknown management address")
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peer := &mgmtAPI{addr: target.addr, client: api.client}
+			target.err = peer.call(http.MethodGet, "/status", params, &target.status)
+			if target.err == nil && target.id == "" {
+				target.id = target.status.NodeID
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// printStatusTable writes a row per target. Lag is how many entries a
+// node has yet to apply of those the leader has committed, or of the
+// highest commit index reported if no node leads.
+func printStatusTable(targets []*watchTarget) {
+	var commit uint64
+	leaderSeen := false
+	for _, target := range targets {
+		if target.err != nil {
+			continue
+		}
+		if target.status.IsLeader && (!leaderSeen || target.status.CommitIndex > commit) {
+			commit, leaderSeen = target.status.CommitIndex, true
+		} else if !leaderSeen {
+			commit = max(commit, target.status.CommitIndex)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tSTATE\tTERM\tLEADER\tCOMMIT\tAPPLIED\tLAG\tBACKEND\tERROR")
+	for _, target := range targets {
+		if target.err != nil {
+			fmt.Fprintf(w, "%s\t%s\tunreachable\t-\t-\t-\t-\t-\t-\t%v\n", dash(target.id), dash(target.addr), target.err)
+			continue
+		}
+		s := target.status
+		state := s.State
+		switch {
+		case s.Draining:
+			state += " (draining)"
+		case s.Standby:
+			state += " (standby)"
+		}
+		backend := "healthy"
+		if !s.BackendHealthy {
+			backend = "unhealthy"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%d\t%d\t%d\t%s\t-\n",
+			dash(target.id), target.addr, state, s.Term, dash(s.LeaderID), s.CommitIndex, s.AppliedIndex,
+			commit-min(s.AppliedIndex, commit), backend)
+	}
+	w.Flush()
+}
@@ -0,0 +1,185 @@
+// Package acl restricts which commands each client may propose.
+//
+// Commands are the MsgPack maps the backend applies ({"op", "key",
+// "value"}). The list is checked against the command actually entering the
+// log, so a client cannot get around it by describing its command
+// differently elsewhere in the request. Commands the backend could read
+// differently from the list are refused: MsgPack decoders disagree on
+// which of two values for the same field they keep, so a command must
+// hold no field but op, key and value, each at most once.
+package acl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/go-msgpack/v2/codec"
+)
+
+// ErrDenied is returned for commands no rule allows.
+var ErrDenied = errors.New("command not allowed")
+
+// Command is the part of a command that rules match.
+type Command struct {
+	Op  string `codec:"op"`
+	Key string `codec:"key"`
+}
+
+// Decode reads the op and key of a MsgPack-encoded command. It fails if
+// the command is not a map of op, key and value, if it repeats any of them
+// or if data holds anything after it.
+func Decode(data []byte) (Command, error) {
+	n, rest, err := mapHeader(data)
+	if err != nil {
+		return Command{}, err
+	}
+	handle := &codec.MsgpackHandle{}
+	handle.RawToString = true
+	decoder := codec.NewDecoderBytes(rest, handle)
+
+	var cmd Command
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		var field string
+		var value interface{}
+		if err := decoder.Decode(&field); err != nil {
+			return Command{}, fmt.Errorf("failed to decode command: %w", err)
+		}
+		if err := decoder.Decode(&value); err != nil {
+			return Command{}, fmt.Errorf("failed to decode command field %q: %w", field, err)
+		}
+		if seen[field] {
+			return Command{}, fmt.Errorf("command repeats field %q", field)
+		}
+		seen[field] = true
+		switch field {
+		case "op", "key":
+			str, ok := value.(string)
+			if !ok {
+				return Command{}, fmt.Errorf("command field %q is not a string", field)
+			}
+			if field == "op" {
+				cmd.Op = str
+			} else {
+				cmd.Key = str
+			}
+		case "value":
+		default:
+			return Command{}, fmt.Errorf("command has unknown field %q", field)
+		}
+	}
+	var extra interface{}
+	if err := decoder.Decode(&extra); !errors.Is(err, io.EOF) {
+		return Command{}, errors.New("command is followed by other data")
+	}
+	if cmd.Op == "" {
+		return Command{}, errors.New("command has no op")
+	}
+	return cmd, nil
+}
+
+// mapHeader returns the number of entries of the MsgPack map that data
+// starts with, and the data after its header.
+func mapHeader(data []byte) (n int, rest []byte, err error) {
+	switch {
+	case len(data) >= 1 && data[0]&0xf0 == 0x80:
+		return int(data[0] & 0x0f), data[1:], nil
+	case len(data) >= 3 && data[0] == 0xde:
+		return int(binary.BigEndian.Uint16(data[1:3])), data[3:], nil
+	case len(data) >= 5 && data[0] == 0xdf:
+		return int(binary.BigEndian.Uint32(data[1:5])), data[5:], nil
+	}
+	return 0, nil, errors.New("failed to decode command: not a MsgPack map")
+}
+
+// rule allows identities matching identity to propose ops on keys matching
+// key.
+type rule struct {
+	identity string
+	ops      []string
+	key      string
+}
+
+// match reports whether value matches pattern: "*" matches anything, a
+// trailing * matches by prefix, and anything else must be equal.
+func match(pattern, value string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(value, prefix)
+	}
+	return value == pattern
+}
+
+func (r rule) allows(identity string, cmd Command) bool {
+	if !match(r.identity, identity) || !match(r.key, cmd.Key) {
+		return false
+	}
+	for _, op := range r.ops {
+		if op == "*" || strings.EqualFold(op, cmd.Op) {
+			return true
+		}
+	}
+	return false
+}
+
+// List holds the rules of an ACL file. Commands no rule allows are denied.
+type List struct {
+	rules []rule
+}
+
+// Load reads an ACL file with one "<identity> <ops> <key>" rule per line:
+// identity is an API key name or JWT subject, ops a comma-separated list of
+// command ops such as SET,DELETE, and key a key or key prefix ending in *.
+// "*" matches any identity, op or key; anonymous callers match only "*".
+// Blank lines and lines starting with # are ignored.
+func Load(path string) (*List, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACL: %w", err)
+	}
+	defer file.Close()
+
+	list := &List{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"<identity> <ops> <key>\"", path, line)
+		}
+		list.rules = append(list.rules, rule{
+			identity: fields[0],
+			ops:      strings.Split(fields[1], ","),
+			key:      fields[2],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ACL: %w", err)
+	}
+	return list, nil
+}
+
+// Check returns nil if identity may propose the command encoded in data,
+// or an error wrapping ErrDenied.
+func (l *List) Check(identity string, data []byte) error {
+	cmd, err := Decode(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDenied, err)
+	}
+	for _, r := range l.rules {
+		if r.allows(identity, cmd) {
+			return nil
+		}
+	}
+	name := identity
+	if name == "" {
+		name = "anonymous caller"
+	}
+	return fmt.Errorf("%w: %s may not %s %q", ErrDenied, name, cmd.Op, cmd.Key)
+}
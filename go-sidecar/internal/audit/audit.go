@@ -0,0 +1,336 @@
+// Package audit records privileged operations to a durable, append-only
+// log. Each entry carries the hash of the one before it, so that editing,
+// removing or reordering entries breaks the chain and is caught by Verify.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Operations recorded in the log.
+const (
+	OpJoin               = "join"
+	OpAddVoter           = "add_voter"
+	OpAddNonvoter        = "add_nonvoter"
+	OpRemove             = "remove"
+	OpForceRemove        = "force_remove"
+	OpTransferLeadership = "transfer_leadership"
+	OpReplace            = "replace"
+	OpDrain              = "drain"
+	OpMintJoinToken      = "mint_join_token"
+	OpReload             = "reload"
+	OpSnapshot           = "snapshot"
+	OpPatchConfig        = "patch_config"
+)
+
+// Caller identifies the client behind an API call, as far as it is known.
+type Caller struct {
+	// Subject is the authenticated identity: an API key's name, a JWT's
+	// subject, or the kind of shared token presented.
+	Subject string `json:"subject,omitempty"`
+	// CertCN is the common name of the verified client certificate.
+	CertCN string `json:"cert_cn,omitempty"`
+	// SourceIP is the address the call came from.
+	SourceIP string `json:"source_ip,omitempty"`
+}
+
+// Entry is one record in the audit log.
+type Entry struct {
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"`
+	// Target is the ID of the server the operation applies to, if any,
+	// and Address its Raft address.
+	Target  string `json:"target,omitempty"`
+	Address string `json:"address,omitempty"`
+	// Initiator identifies who asked for the change: the client address
+	// of an API call, or the sidecar component that made it on its own.
+	Initiator string `json:"initiator"`
+	// Caller describes the client of an API call; it is nil for changes
+	// the sidecar makes on its own.
+	Caller *Caller `json:"caller,omitempty"`
+	// Params are the request's parameters, other than secrets.
+	Params map[string]string `json:"params,omitempty"`
+	// RequestID identifies the API call in the sidecar's logs.
+	RequestID string `json:"request_id,omitempty"`
+	// Outcome is "ok" or "error", with the error in Error.
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	// Prev is the Hash of the preceding entry, empty for the first one.
+	// Hash is the SHA-256 of this entry's JSON encoding without Hash, and
+	// must stay the last field.
+	Prev string `json:"prev"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// hashField precedes the hash at the end of an encoded entry.
+var hashField = []byte(`,"hash":"`)
+
+// seal sets entry's Prev to prev and returns its encoding, ending with its
+// hash, and the hash.
+func seal(entry Entry, prev string) ([]byte, string, error) {
+	entry.Prev, entry.Hash = prev, ""
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	line := append(body[:len(body)-1:len(body)-1], hashField...)
+	line = append(line, hash...)
+	return append(line, '"', '}'), hash, nil
+}
+
+// unseal returns the hash recorded at the end of line and the hash of the
+// rest of it, which match if the line is intact.
+func unseal(line []byte) (recorded, computed string, ok bool) {
+	i := bytes.LastIndex(line, hashField)
+	if i < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return "", "", false
+	}
+	recorded = string(line[i+len(hashField) : len(line)-2])
+	sum := sha256.Sum256(append(line[:i:i], '}'))
+	return recorded, hex.EncodeToString(sum[:]), true
+}
+
+// Log appends entries to a file, one JSON object per line. Every entry is
+// synced to disk before Record returns. A nil *Log discards entries.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	// head is the hash of the last entry.
+	head string
+}
+
+// Open opens or creates the audit log at path and continues its hash
+// chain. A torn final line, left by a crash while it was written, is cut
+// off.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	head, err := recoverHead(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Log{path: path, file: file, head: head}, nil
+}
+
+// recoverHead returns the hash of the last complete entry in file,
+// truncating any partial line after it.
+func recoverHead(file *os.File) (string, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read audit log: %w", err)
+	}
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if complete < len(data) {
+		fmt.Fprintf(os.Stderr, "audit: discarding %d bytes of a torn entry at the end of %s\n", len(data)-complete, file.Name())
+		if err := file.Truncate(int64(complete)); err != nil {
+			return "", fmt.Errorf("failed to truncate torn audit log entry: %w", err)
+		}
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data[:complete], []byte("\n")), []byte("\n"))
+	recorded, _, _ := unseal(lines[len(lines)-1])
+	return recorded, nil
+}
+
+// Head returns the hash of the last entry, which commits to every entry
+// before it. Recording it elsewhere lets a rewrite of the whole file be
+// detected too.
+func (l *Log) Head() string {
+	if l == nil {
+		return ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.head
+}
+
+// Record appends entry with the outcome of err. The time is filled in if
+// unset. Failures to write are logged to stderr rather than returned, so
+// that auditing never blocks the operation it records.
+func (l *Log) Record(entry Entry, err error) {
+	if l == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	entry.Outcome = "ok"
+	if err != nil {
+		entry.Outcome = "error"
+		entry.Error = err.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	data, hash, marshalErr := seal(entry, l.head)
+	if marshalErr != nil {
+		fmt.Fprintf(os.Stderr, "audit: failed to encode entry: %v\n", marshalErr)
+		return
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "audit: failed to write entry: %v\n", err)
+		return
+	}
+	l.head = hash
+	if err := l.file.Sync(); err != nil {
+		fmt.Fprintf(os.Stderr, "audit: failed to sync: %v\n", err)
+	}
+}
+
+// Filter selects entries returned by Query.
+type Filter struct {
+	// Since excludes entries recorded before it, if set.
+	Since time.Time
+	// Op and Target, if set, must match exactly.
+	Op     string
+	Target string
+	// Limit keeps only the most recent entries, if positive.
+	Limit int
+}
+
+// Query returns the entries matching filter, oldest first.
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn final line from a crash mid-write.
+			continue
+		}
+		if entry.Time.Before(filter.Since) ||
+			(filter.Op != "" && entry.Op != filter.Op) ||
+			(filter.Target != "" && entry.Target != filter.Target) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+	return entries, nil
+}
+
+// Verification is the result of checking a log's hash chain.
+type Verification struct {
+	// Entries is the number of entries checked and Head the hash of the
+	// last one.
+	Entries int    `json:"entries"`
+	Head    string `json:"head"`
+	// Unchained counts entries at the start of the log written before
+	// entries were hashed, which cannot be checked.
+	Unchained int `json:"unchained,omitempty"`
+	// Anchored reports whether the anchor passed to Verify is the hash of
+	// one of the entries.
+	Anchored bool `json:"anchored,omitempty"`
+}
+
+// ErrTampered is returned by Verify when the chain is broken.
+var ErrTampered = errors.New("audit log has been modified")
+
+// Verify checks the hash chain of the audit log at path, returning an
+// error wrapping ErrTampered at the first entry that was changed, removed,
+// inserted or reordered. anchor, if set, is a hash recorded elsewhere
+// earlier, such as an old Head; if no entry has it, the log was rewritten
+// from the start and an error wrapping ErrTampered is returned too.
+func Verify(path, anchor string) (Verification, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Verification{}, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var result Verification
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return result, fmt.Errorf("%w: line %d is not a valid entry", ErrTampered, line)
+		}
+		recorded, computed, ok := unseal(scanner.Bytes())
+		if !ok || entry.Hash == "" {
+			if result.Entries > 0 {
+				return result, fmt.Errorf("%w: line %d has no hash", ErrTampered, line)
+			}
+			result.Unchained++
+			continue
+		}
+		if entry.Prev != result.Head {
+			return result, fmt.Errorf("%w: line %d does not follow the entry before it", ErrTampered, line)
+		}
+		if recorded != computed {
+			return result, fmt.Errorf("%w: line %d does not match its hash", ErrTampered, line)
+		}
+		result.Entries++
+		result.Head = recorded
+		if recorded == anchor {
+			result.Anchored = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if anchor != "" && !result.Anchored {
+		return result, fmt.Errorf("%w: no entry has the hash %s", ErrTampered, anchor)
+	}
+	return result, nil
+}
+
+// Verify checks the hash chain of the log against anchor; see the Verify
+// function.
+func (l *Log) Verify(anchor string) (Verification, error) {
+	if l == nil {
+		return Verification{}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Verify(l.path, anchor)
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ProposeEntry is one record in the Propose audit log.
+type ProposeEntry struct {
+	Time   time.Time `json:"time"`
+	Caller *Caller   `json:"caller,omitempty"`
+	// RequestID identifies the call in the sidecars' and backend's logs.
+	RequestID string `json:"request_id,omitempty"`
+	// Size is the length of the command's data, and DedupKey its SHA-256,
+	// which is the same for retries and duplicates of a command.
+	Size     int    `json:"size"`
+	DedupKey string `json:"dedup_key"`
+	// Forwarded is set if this node forwarded the proposal to the leader.
+	Forwarded bool `json:"forwarded,omitempty"`
+	// Term and Index are where a successful proposal was committed.
+	Term  uint64 `json:"term,omitempty"`
+	Index uint64 `json:"index,omitempty"`
+	// Latency is how long the call took, from receipt to response.
+	Latency string `json:"latency"`
+	// Outcome is "ok" or "error", with the error in Error and its gRPC
+	// status code, if the call failed with one, in Code.
+	Outcome string `json:"outcome"`
+	Code    string `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ProposeLog appends a sample of Propose calls to a file, one JSON object
+// per line. Unlike Log it is written for throughput rather than
+// durability: entries are buffered and flushed every second (see Start),
+// and are not chained. A nil *ProposeLog records nothing.
+type ProposeLog struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	// sampleRate is the fraction of successful calls recorded.
+	sampleRate float64
+}
+
+// OpenProposeLog opens or creates the Propose audit log at path, recording
+// the given fraction of successful calls and every failed one.
+func OpenProposeLog(path string, sampleRate float64) (*ProposeLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create Propose audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Propose audit log: %w", err)
+	}
+	return &ProposeLog{file: file, w: bufio.NewWriterSize(file, 64<<10), sampleRate: sampleRate}, nil
+}
+
+// Sample reports whether a call should be recorded: always if it failed,
+// and at the log's sample rate otherwise.
+func (l *ProposeLog) Sample(failed bool) bool {
+	if l == nil {
+		return false
+	}
+	return failed || l.sampleRate >= 1 || rand.Float64() < l.sampleRate
+}
+
+// Record appends entry, with its outcome taken from err. Errors are
+// reported on stderr rather than failing the call.
+func (l *ProposeLog) Record(entry ProposeEntry, err error) {
+	if l == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	entry.Outcome = "ok"
+	if err != nil {
+		entry.Outcome = "error"
+		entry.Error = err.Error()
+	}
+	data, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		fmt.Fprintf(os.Stderr, "audit: failed to encode Propose entry: %v\n", marshalErr)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "audit: failed to write Propose entry: %v\n", err)
+	}
+}
+
+// Start flushes buffered entries to the file every second, in a goroutine
+// until ctx is cancelled.
+func (l *ProposeLog) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				l.flush()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// flush writes buffered entries to the file.
+func (l *ProposeLog) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "audit: failed to flush Propose audit log: %v\n", err)
+	}
+}
+
+// Close flushes buffered entries and closes the file.
+func (l *ProposeLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.Flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
@@ -2,21 +2,30 @@
 package backend
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
-	"log"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 
+	"my-raft-sidecar/internal/logging"
 	pb "my-raft-sidecar/pb"
 )
 
+// logger logs with component=backend.
+var logger = logging.Component("backend")
+
 // ConnectionConfig holds configuration for connecting to the backend.
 type ConnectionConfig struct {
 	Address    string
 	MaxRetries int
 	RetryDelay time.Duration
+	// TLS, if set, secures the connection; the backend is dialed in the
+	// clear otherwise.
+	TLS *tls.Config
 }
 
 // DefaultConnectionConfig returns default connection configuration.
@@ -39,21 +48,24 @@ func Connect(cfg *ConnectionConfig) (*Client, error) {
 	var conn *grpc.ClientConn
 	var err error
 
+	creds := insecure.NewCredentials()
+	if cfg.TLS != nil {
+		creds = credentials.NewTLS(cfg.TLS)
+	}
 	for i := 0; i < cfg.MaxRetries; i++ {
 		conn, err = grpc.Dial(
 			cfg.Address,
-			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithTransportCredentials(creds),
 		)
 		if err == nil {
-			log.Printf("Connected to C++ backend at %s", cfg.Address)
+			logger.Info("Connected to C++ backend", "address", cfg.Address, "tls", cfg.TLS != nil)
 			return &Client{
 				conn:               conn,
 				StateMachineClient: pb.NewStateMachineClient(conn),
 			}, nil
 		}
 
-		log.Printf("Waiting for C++ backend at %s (attempt %d/%d)",
-			cfg.Address, i+1, cfg.MaxRetries)
+		logger.Info("Waiting for C++ backend", "address", cfg.Address, "attempt", i+1, "max_attempts", cfg.MaxRetries)
 		time.Sleep(cfg.RetryDelay)
 	}
 
@@ -61,6 +73,44 @@ func Connect(cfg *ConnectionConfig) (*Client, error) {
 		cfg.Address, cfg.MaxRetries, err)
 }
 
+// WatchLeadership forwards leadership transitions received on leaderCh to
+// the backend's OnLeadershipChange RPC in a goroutine, so the backend can
+// start or stop leader-only work. A failed notification is retried until it
+// succeeds or is superseded by a newer transition.
+func (c *Client) WatchLeadership(leaderCh <-chan bool, currentTerm func() uint64) {
+	go func() {
+		for isLeader := range leaderCh {
+			for {
+				err := c.notifyLeadership(isLeader, currentTerm())
+				if err == nil {
+					break
+				}
+				logger.Error("Failed to notify backend of leadership change", "leader", isLeader, "error", err)
+
+				select {
+				case isLeader = <-leaderCh:
+				case <-time.After(time.Second):
+				}
+			}
+		}
+	}()
+}
+
+// notifyLeadership makes a single OnLeadershipChange call.
+func (c *Client) notifyLeadership(isLeader bool, term uint64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.StateMachineClient.OnLeadershipChange(ctx, &pb.LeadershipChange{
+		IsLeader: isLeader,
+		Term:     term,
+	})
+	if err == nil {
+		logger.Info("Notified backend of leadership change", "leader", isLeader, "term", term)
+	}
+	return err
+}
+
 // Close closes the connection to the backend.
 func (c *Client) Close() error {
 	if c.conn != nil {
@@ -0,0 +1,101 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthChecker periodically probes the backend with the standard gRPC
+// health checking protocol and tracks how long it has been unhealthy.
+type HealthChecker struct {
+	client   healthpb.HealthClient
+	interval time.Duration
+	timeout  time.Duration
+
+	mu             sync.RWMutex
+	healthy        bool
+	unhealthySince time.Time
+	lastErr        error
+}
+
+// NewHealthChecker creates a checker for the backend behind c. The backend
+// is assumed healthy until the first probe says otherwise.
+func (c *Client) NewHealthChecker(interval time.Duration) *HealthChecker {
+	return &HealthChecker{
+		client:   healthpb.NewHealthClient(c.conn),
+		interval: interval,
+		timeout:  interval,
+		healthy:  true,
+	}
+}
+
+// Start probes the backend in a goroutine until ctx is cancelled.
+func (h *HealthChecker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		for {
+			h.probe(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Healthy reports whether the last probe succeeded.
+func (h *HealthChecker) Healthy() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.healthy
+}
+
+// UnhealthyFor returns how long the backend has been failing probes, or
+// zero if it is healthy.
+func (h *HealthChecker) UnhealthyFor() time.Duration {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.healthy {
+		return 0
+	}
+	return time.Since(h.unhealthySince)
+}
+
+// LastError returns the error from the most recent failed probe.
+func (h *HealthChecker) LastError() error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.lastErr
+}
+
+// probe makes a single health check and records the result.
+func (h *HealthChecker) probe(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	resp, err := h.client.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err == nil && resp.Status != healthpb.HealthCheckResponse_SERVING {
+		err = fmt.Errorf("backend reports status %s", resp.Status)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case err != nil && h.healthy:
+		logger.Warn("Backend became unhealthy", "error", err)
+		h.healthy = false
+		h.unhealthySince = time.Now()
+	case err == nil && !h.healthy:
+		logger.Info("Backend is healthy again")
+		h.healthy = true
+	}
+	h.lastErr = err
+}
@@ -0,0 +1,221 @@
+// Package cdc exports applied Raft log entries to external message brokers.
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/logging"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// logger logs with component=cdc.
+var logger = logging.Component("cdc")
+
+// hwmFile is the name of the file, relative to the data directory, that
+// holds the index of the last entry acknowledged by the broker.
+const hwmFile = "cdc-hwm"
+
+// Event is the payload published for every applied entry.
+type Event struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data"`
+}
+
+// Publisher delivers events to a broker. Publish must only return nil once
+// the broker has durably accepted the event.
+type Publisher interface {
+	Publish(ctx context.Context, event *Event) error
+	Close() error
+}
+
+// Config holds configuration for the CDC exporter.
+type Config struct {
+	// Backend selects the broker: "nats" or "kafka".
+	Backend string
+	// URL is the broker address: nats://host:port for NATS JetStream or
+	// the base URL of a Kafka REST Proxy.
+	URL string
+	// Topic is the NATS subject or Kafka topic events are published to.
+	Topic string
+	// DataDir is where the high-water mark is persisted.
+	DataDir string
+	// RetryInterval is the delay between failed publish attempts.
+	RetryInterval time.Duration
+}
+
+// NewPublisher creates the Publisher selected by cfg.Backend.
+func NewPublisher(cfg *Config) (Publisher, error) {
+	switch cfg.Backend {
+	case "nats":
+		return NewNATSPublisher(cfg.URL, cfg.Topic), nil
+	case "kafka":
+		return NewKafkaPublisher(cfg.URL, cfg.Topic), nil
+	default:
+		return nil, fmt.Errorf("unknown CDC backend %q (want nats or kafka)", cfg.Backend)
+	}
+}
+
+// Exporter streams applied entries to a Publisher with at-least-once
+// delivery. The index of the last acknowledged entry is persisted so that
+// after a restart export resumes from the Raft log where it left off.
+type Exporter struct {
+	config    *Config
+	node      *raftnode.Node
+	fsm       *fsm.CppFSM
+	publisher Publisher
+	hwm       uint64
+}
+
+// NewExporter creates an exporter, loading the persisted high-water mark.
+func NewExporter(cfg *Config, node *raftnode.Node, stateMachine *fsm.CppFSM, publisher Publisher) (*Exporter, error) {
+	hwm, err := loadHWM(filepath.Join(cfg.DataDir, hwmFile))
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{
+		config:    cfg,
+		node:      node,
+		fsm:       stateMachine,
+		publisher: publisher,
+		hwm:       hwm,
+	}, nil
+}
+
+// Run exports entries until ctx is cancelled.
+func (e *Exporter) Run(ctx context.Context) {
+	logger.Info("CDC exporter started", "backend", e.config.Backend, "topic", e.config.Topic, "resume_after", e.hwm)
+	defer e.publisher.Close()
+
+	for ctx.Err() == nil {
+		if err := e.export(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("CDC export interrupted", "error", err)
+			sleep(ctx, e.config.RetryInterval)
+		}
+	}
+}
+
+// StartAsync runs the exporter in a goroutine.
+func (e *Exporter) StartAsync(ctx context.Context) {
+	go e.Run(ctx)
+}
+
+// export replays entries after the high-water mark from the Raft log, then
+// follows live applies until the subscription is dropped or ctx ends.
+func (e *Exporter) export(ctx context.Context) error {
+	sub, appliedIndex := e.fsm.Subscribe()
+	defer sub.Cancel()
+
+	if e.hwm < appliedIndex {
+		err := e.node.ReadLogs(e.hwm+1, appliedIndex, func(l *raft.Log) error {
+			return e.publish(ctx, &Event{Index: l.Index, Term: l.Term, Data: l.Data})
+		})
+		if errors.Is(err, raftnode.ErrLogCompacted) {
+			// The entries are gone for good; skip ahead rather than wedge.
+			logger.Warn("CDC gap: entries were compacted before export", "after", e.hwm, "error", err)
+			if err := e.advance(appliedIndex); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case entry, ok := <-sub.C:
+			if !ok {
+				return errors.New("subscription dropped, replaying from log")
+			}
+			if entry.Index <= e.hwm {
+				continue
+			}
+			if err := e.publish(ctx, &Event{Index: entry.Index, Term: entry.Term, Data: entry.Data}); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// publish delivers a single event, retrying until it is acknowledged, then
+// advances the high-water mark.
+func (e *Exporter) publish(ctx context.Context, event *Event) error {
+	for {
+		err := e.publisher.Publish(ctx, event)
+		if err == nil {
+			return e.advance(event.Index)
+		}
+		logger.Warn("CDC publish failed", "index", event.Index, "error", err)
+		if !sleep(ctx, e.config.RetryInterval) {
+			return ctx.Err()
+		}
+	}
+}
+
+// advance records index as the new high-water mark.
+func (e *Exporter) advance(index uint64) error {
+	if err := storeHWM(filepath.Join(e.config.DataDir, hwmFile), index); err != nil {
+		return err
+	}
+	e.hwm = index
+	return nil
+}
+
+// encodeEvent serializes an event as JSON.
+func encodeEvent(event *Event) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+// loadHWM reads the persisted high-water mark, returning 0 if none exists.
+func loadHWM(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read CDC high-water mark: %w", err)
+	}
+	hwm, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("corrupt CDC high-water mark in %s: %w", path, err)
+	}
+	return hwm, nil
+}
+
+// storeHWM atomically persists the high-water mark.
+func storeHWM(path string, index uint64) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(index, 10)), 0600); err != nil {
+		return fmt.Errorf("failed to write CDC high-water mark: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write CDC high-water mark: %w", err)
+	}
+	return nil
+}
+
+// sleep waits for d or until ctx is cancelled, reporting whether the full
+// duration elapsed.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
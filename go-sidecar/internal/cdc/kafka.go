@@ -0,0 +1,88 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KafkaPublisher publishes events to a Kafka topic through a Confluent
+// REST Proxy (v2 API). Records are keyed by log index so that consumers
+// can de-duplicate redeliveries.
+type KafkaPublisher struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewKafkaPublisher creates a publisher for the REST Proxy at baseURL.
+func NewKafkaPublisher(baseURL, topic string) *KafkaPublisher {
+	return &KafkaPublisher{
+		endpoint: strings.TrimRight(baseURL, "/") + "/topics/" + topic,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Publish produces the event and waits for the broker acknowledgement.
+func (p *KafkaPublisher) Publish(ctx context.Context, event *Event) error {
+	value, err := encodeEvent(event)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"records": []map[string]any{{
+			"key":   strconv.FormatUint(event.Index, 10),
+			"value": json.RawMessage(value),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kafka produce failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka produce failed with status %d: %s", resp.StatusCode, respBody)
+	}
+
+	var result struct {
+		Offsets []struct {
+			Error *string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("malformed kafka produce response: %w", err)
+	}
+	for _, offset := range result.Offsets {
+		if offset.Error != nil {
+			return fmt.Errorf("kafka rejected record: %s", *offset.Error)
+		}
+	}
+	return nil
+}
+
+// Close releases idle connections to the REST Proxy.
+func (p *KafkaPublisher) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,214 @@
+package cdc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// natsInbox is the subject JetStream publish acknowledgements are sent to.
+const natsInbox = "_INBOX.raftkv-cdc"
+
+// NATSPublisher publishes events to a NATS JetStream subject, speaking the
+// NATS client protocol directly. Each event carries a Nats-Msg-Id header
+// derived from its log index so that JetStream de-duplicates redeliveries.
+type NATSPublisher struct {
+	addr    string
+	subject string
+	timeout time.Duration
+
+	conn   net.Conn
+	reader *bufio.Reader
+	seq    uint64
+}
+
+// NewNATSPublisher creates a publisher for the given nats:// URL and subject.
+// The subject must be bound to a JetStream stream.
+func NewNATSPublisher(rawURL, subject string) *NATSPublisher {
+	addr := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		addr = u.Host
+	}
+	return &NATSPublisher{
+		addr:    addr,
+		subject: subject,
+		timeout: 10 * time.Second,
+	}
+}
+
+// Publish sends the event and waits for the JetStream acknowledgement.
+func (p *NATSPublisher) Publish(ctx context.Context, event *Event) error {
+	if p.conn == nil {
+		if err := p.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := p.publish(event); err != nil {
+		p.Close()
+		return err
+	}
+	return nil
+}
+
+// Close closes the connection to the NATS server.
+func (p *NATSPublisher) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	p.reader = nil
+	return err
+}
+
+// connect dials the server, performs the CONNECT handshake and subscribes
+// to the acknowledgement inbox.
+func (p *NATSPublisher) connect(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS at %s: %w", p.addr, err)
+	}
+	p.conn = conn
+	p.reader = bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(p.timeout))
+
+	// The server greets us with INFO before anything else.
+	line, err := p.reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		p.Close()
+		return fmt.Errorf("unexpected NATS greeting %q: %v", strings.TrimSpace(line), err)
+	}
+
+	handshake := `CONNECT {"verbose":false,"pedantic":false,"headers":true,"name":"raftkv-cdc"}` + "\r\n" +
+		"SUB " + natsInbox + ".* 1\r\n" +
+		"PING\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		p.Close()
+		return fmt.Errorf("NATS handshake failed: %w", err)
+	}
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			p.Close()
+			return fmt.Errorf("NATS handshake failed: %w", err)
+		}
+		if line == "PONG" {
+			return nil
+		}
+	}
+}
+
+// publish writes a single HPUB and waits for its acknowledgement.
+func (p *NATSPublisher) publish(event *Event) error {
+	payload, err := encodeEvent(event)
+	if err != nil {
+		return err
+	}
+
+	p.seq++
+	reply := natsInbox + "." + strconv.FormatUint(p.seq, 10)
+	headers := "NATS/1.0\r\nNats-Msg-Id: raftkv-" + strconv.FormatUint(event.Index, 10) + "\r\n\r\n"
+
+	p.conn.SetDeadline(time.Now().Add(p.timeout))
+	frame := fmt.Sprintf("HPUB %s %s %d %d\r\n%s%s\r\n",
+		p.subject, reply, len(headers), len(headers)+len(payload), headers, payload)
+	if _, err := p.conn.Write([]byte(frame)); err != nil {
+		return fmt.Errorf("NATS publish failed: %w", err)
+	}
+
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return fmt.Errorf("NATS publish failed: %w", err)
+		}
+
+		subject, body, err := p.readMessage(line)
+		if err != nil {
+			return err
+		}
+		if subject != reply {
+			continue // a late ack for an earlier, timed-out attempt
+		}
+		return parseAck(body)
+	}
+}
+
+// readLine reads a protocol line, answering server PINGs and surfacing
+// -ERR responses.
+func (p *NATSPublisher) readLine() (string, error) {
+	for {
+		line, err := p.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PING":
+			if _, err := p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return "", err
+			}
+		case line == "+OK", strings.HasPrefix(line, "INFO "):
+		case strings.HasPrefix(line, "-ERR"):
+			return "", fmt.Errorf("NATS server error: %s", strings.TrimPrefix(line, "-ERR "))
+		default:
+			return line, nil
+		}
+	}
+}
+
+// readMessage reads the payload of a MSG or HMSG frame whose header line
+// has already been consumed.
+func (p *NATSPublisher) readMessage(line string) (subject string, body []byte, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || (fields[0] != "MSG" && fields[0] != "HMSG") {
+		return "", nil, fmt.Errorf("unexpected NATS frame %q", line)
+	}
+
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return "", nil, fmt.Errorf("malformed NATS frame %q", line)
+	}
+	headerSize := 0
+	if fields[0] == "HMSG" {
+		if headerSize, err = strconv.Atoi(fields[len(fields)-2]); err != nil {
+			return "", nil, fmt.Errorf("malformed NATS frame %q", line)
+		}
+	}
+
+	buf := make([]byte, size+2) // payload followed by CRLF
+	if _, err := io.ReadFull(p.reader, buf); err != nil {
+		return "", nil, fmt.Errorf("NATS read failed: %w", err)
+	}
+	return fields[1], buf[headerSize:size], nil
+}
+
+// parseAck interprets a JetStream publish acknowledgement.
+func parseAck(body []byte) error {
+	var ack struct {
+		Stream string `json:"stream"`
+		Error  *struct {
+			Code        int    `json:"code"`
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if len(body) == 0 {
+		return errors.New("empty JetStream acknowledgement (is the subject bound to a stream?)")
+	}
+	if err := json.Unmarshal(body, &ack); err != nil {
+		return fmt.Errorf("malformed JetStream acknowledgement: %w", err)
+	}
+	if ack.Error != nil {
+		return fmt.Errorf("JetStream rejected message: %s (code %d)", ack.Error.Description, ack.Error.Code)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// Announcer keeps the replicated peer metadata current. Whenever this node
+// becomes leader it publishes the cluster ID, its signing keys if they
+// differ from the replicated ones, its own endpoints and those of every
+// known member, so that they survive log compaction.
+type Announcer struct {
+	node *raftnode.Node
+	fsm  *fsm.CppFSM
+	self *fsm.PeerMeta
+}
+
+// NewAnnouncer creates an Announcer publishing self on leadership.
+func NewAnnouncer(node *raftnode.Node, stateMachine *fsm.CppFSM, self *fsm.PeerMeta) *Announcer {
+	return &Announcer{
+		node: node,
+		fsm:  stateMachine,
+		self: self,
+	}
+}
+
+// Start watches for leadership in a goroutine.
+func (a *Announcer) Start() {
+	leaderCh := a.node.SubscribeLeadership()
+	go func() {
+		for isLeader := range leaderCh {
+			if isLeader {
+				a.announce()
+			}
+		}
+	}()
+}
+
+// announce publishes the metadata of this node and all peers that are
+// still members of the cluster.
+func (a *Announcer) announce() {
+	if err := a.node.PublishClusterID(); err != nil {
+		logger.Error("Failed to publish cluster ID", "error", err)
+		return
+	}
+	// The cluster ID entry is applied, and so is any key set before it.
+	if local := a.fsm.LocalSigningKeys(); !local.Equal(a.fsm.SigningKeys()) {
+		if err := a.node.PublishSigningKeys(local); err != nil {
+			logger.Error("Failed to publish signing keys", "error", err)
+			return
+		}
+		logger.Info("Published signing keys", "keys", len(local.Keys), "unsigned_allowed", local.AllowUnsigned)
+	}
+
+	// A standby that leads has been promoted.
+	self := *a.self
+	self.Standby = self.Standby && a.node.Standby()
+
+	peers := []*fsm.PeerMeta{&self}
+	for _, meta := range a.fsm.Peers() {
+		if meta.NodeID == a.self.NodeID {
+			continue
+		}
+		member, err := a.node.HasServer(meta.NodeID)
+		if err != nil {
+			logger.Warn("Failed to read configuration", "error", err)
+			return
+		}
+		if member {
+			peers = append(peers, &meta)
+		}
+	}
+
+	for _, meta := range peers {
+		if err := a.node.PublishPeerMeta(meta); err != nil {
+			logger.Error("Failed to publish metadata", "member", meta.NodeID, "error", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package cluster
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// imdsAddr is the EC2 instance metadata service.
+const imdsAddr = "http://169.254.169.254"
+
+// AWSDiscoverer finds members among the running EC2 instances carrying a
+// tag, in the spirit of Consul's cloud auto-join. Credentials come from
+// the arguments, the AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY/
+// AWS_SESSION_TOKEN environment variables or the instance profile, and
+// the region from the arguments, AWS_REGION or the instance metadata.
+type AWSDiscoverer struct {
+	TagKey   string
+	TagValue string
+	Region   string
+	// AddrType selects the address used for each instance: "private_v4"
+	// (the default) or "public_v4".
+	AddrType string
+	Port     string
+
+	accessKeyID     string
+	secretAccessKey string
+	client          *http.Client
+}
+
+// awsCredentials is a set of AWS credentials.
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+// NewAWSDiscoverer creates a discoverer for instances tagged
+// tagKey=tagValue.
+func NewAWSDiscoverer(tagKey, tagValue, region, addrType, accessKeyID, secretAccessKey, port string) (*AWSDiscoverer, error) {
+	if tagKey == "" || tagValue == "" {
+		return nil, fmt.Errorf("aws discovery requires tag_key= and tag_value=")
+	}
+	switch addrType {
+	case "":
+		addrType = "private_v4"
+	case "private_v4", "public_v4":
+	default:
+		return nil, fmt.Errorf("invalid addr_type %q, expected private_v4 or public_v4", addrType)
+	}
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	return &AWSDiscoverer{
+		TagKey:          tagKey,
+		TagValue:        tagValue,
+		Region:          region,
+		AddrType:        addrType,
+		Port:            port,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// Discover returns the management addresses of the running tagged
+// instances.
+func (a *AWSDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	if a.Region == "" {
+		region, err := a.metadata(ctx, "/latest/meta-data/placement/region")
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine AWS region: %w", err)
+		}
+		a.Region = region
+	}
+	creds, err := a.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	nextToken := ""
+	for {
+		params := url.Values{
+			"Action":           {"DescribeInstances"},
+			"Version":          {"2016-11-15"},
+			"Filter.1.Name":    {"tag:" + a.TagKey},
+			"Filter.1.Value.1": {a.TagValue},
+			"Filter.2.Name":    {"instance-state-name"},
+			"Filter.2.Value.1": {"running"},
+		}
+		if nextToken != "" {
+			params.Set("NextToken", nextToken)
+		}
+
+		var resp struct {
+			Reservations []struct {
+				Instances []struct {
+					PrivateIP string `xml:"privateIpAddress"`
+					PublicIP  string `xml:"ipAddress"`
+				} `xml:"instancesSet>item"`
+			} `xml:"reservationSet>item"`
+			NextToken string `xml:"nextToken"`
+		}
+		if err := a.ec2(ctx, creds, params, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, reservation := range resp.Reservations {
+			for _, instance := range reservation.Instances {
+				ip := instance.PrivateIP
+				if a.AddrType == "public_v4" {
+					ip = instance.PublicIP
+				}
+				if ip != "" {
+					addrs = append(addrs, net.JoinHostPort(ip, a.Port))
+				}
+			}
+		}
+		if resp.NextToken == "" {
+			return addrs, nil
+		}
+		nextToken = resp.NextToken
+	}
+}
+
+// credentials returns the configured credentials, falling back to the
+// environment and then to the instance profile.
+func (a *AWSDiscoverer) credentials(ctx context.Context) (*awsCredentials, error) {
+	if a.accessKeyID != "" {
+		return &awsCredentials{AccessKeyID: a.accessKeyID, SecretAccessKey: a.secretAccessKey}, nil
+	}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token:           os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	role, err := a.metadata(ctx, "/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials configured and no instance profile: %w", err)
+	}
+	role, _, _ = strings.Cut(role, "\n")
+	data, err := a.metadata(ctx, "/latest/meta-data/iam/security-credentials/"+role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch instance profile credentials: %w", err)
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal([]byte(data), &creds); err != nil {
+		return nil, fmt.Errorf("malformed instance profile credentials: %w", err)
+	}
+	return &creds, nil
+}
+
+// metadata reads a path from the instance metadata service using an
+// IMDSv2 session token.
+func (a *AWSDiscoverer) metadata(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imdsAddr+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := a.read(req)
+	if err != nil {
+		return "", err
+	}
+
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, imdsAddr+path, nil); err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	return a.read(req)
+}
+
+func (a *AWSDiscoverer) read(req *http.Request) (string, error) {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d for %s", resp.StatusCode, req.URL.Path)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// ec2 calls the EC2 query API with a SigV4-signed GET request and decodes
+// the XML response into out.
+func (a *AWSDiscoverer) ec2(ctx context.Context, creds *awsCredentials, params url.Values, out interface{}) error {
+	host := "ec2." + a.Region + ".amazonaws.com"
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	// url.Values.Encode sorts by key and escapes spaces as "+", which SigV4
+	// requires as "%20".
+	query := strings.ReplaceAll(params.Encode(), "+", "%20")
+
+	headers := map[string]string{"host": host, "x-amz-date": amzDate}
+	if creds.Token != "" {
+		headers["x-amz-security-token"] = creds.Token
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	emptyHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet, "/", query, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(emptyHash[:]),
+	}, "\n")
+	scope := date + "/" + a.Region + "/ec2/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{a.Region, "ec2", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+"/?"+query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("EC2 request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("EC2 returned status %d: %s", resp.StatusCode, msg)
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("malformed EC2 response: %w", err)
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,247 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// ConsulDiscoverer finds members through the Consul catalog and, if
+// Register is set, registers this node's endpoints there: the management
+// API as Service (checked over HTTP /health and tagged "leader" or
+// "follower"), and the Raft and gRPC ports as Service-raft and Service-grpc
+// (checked over TCP).
+type ConsulDiscoverer struct {
+	Addr     string
+	Service  string
+	Token    string
+	Register bool
+
+	client *http.Client
+}
+
+// NewConsulDiscoverer creates a discoverer for the Consul agent at addr. An
+// empty addr or token falls back to CONSUL_HTTP_ADDR and CONSUL_HTTP_TOKEN.
+func NewConsulDiscoverer(addr, service, token string, register bool) *ConsulDiscoverer {
+	if addr == "" {
+		addr = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if addr == "" {
+		addr = "http://127.0.0.1:8500"
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	if token == "" {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	if service == "" {
+		service = "raftkv"
+	}
+	return &ConsulDiscoverer{
+		Addr:     strings.TrimSuffix(addr, "/"),
+		Service:  service,
+		Token:    token,
+		Register: register,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Discover returns the management addresses of the passing instances of
+// the service.
+func (c *ConsulDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	var entries []struct {
+		Node struct {
+			Address string `json:"Address"`
+		} `json:"Node"`
+		Service struct {
+			Address string `json:"Address"`
+			Port    int    `json:"Port"`
+		} `json:"Service"`
+	}
+	path := "/v1/health/service/" + url.PathEscape(c.Service) + "?passing=true"
+	if err := c.do(ctx, http.MethodGet, path, nil, &entries); err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)))
+	}
+	return addrs, nil
+}
+
+// consulService is a service definition for the agent registration API.
+type consulService struct {
+	ID      string            `json:"ID"`
+	Name    string            `json:"Name"`
+	Tags    []string          `json:"Tags,omitempty"`
+	Address string            `json:"Address"`
+	Port    int               `json:"Port"`
+	Meta    map[string]string `json:"Meta,omitempty"`
+	Check   *consulCheck      `json:"Check,omitempty"`
+}
+
+type consulCheck struct {
+	HTTP                           string `json:"HTTP,omitempty"`
+	TCP                            string `json:"TCP,omitempty"`
+	Interval                       string `json:"Interval"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter"`
+}
+
+// StartRegistration registers this node's services and keeps the
+// management service's role tag current until ctx is cancelled, when the
+// services are deregistered.
+func (c *ConsulDiscoverer) StartRegistration(ctx context.Context, node *raftnode.Node, self *fsm.PeerMeta) {
+	if !c.Register {
+		return
+	}
+
+	services, err := c.services(node, self)
+	if err != nil {
+		logger.Error("Cannot register in Consul", "error", err)
+		return
+	}
+
+	leaderCh := node.SubscribeLeadership()
+	go func() {
+		isLeader := node.IsLeader()
+		for {
+			c.registerAll(ctx, services, isLeader)
+
+			select {
+			case isLeader = <-leaderCh:
+			case <-ctx.Done():
+				c.deregisterAll(services)
+				return
+			}
+		}
+	}()
+}
+
+// services builds the definitions of this node's three services.
+func (c *ConsulDiscoverer) services(node *raftnode.Node, self *fsm.PeerMeta) ([]*consulService, error) {
+	var services []*consulService
+	for _, endpoint := range []struct{ suffix, addr string }{
+		{"", self.MgmtAddr},
+		{"-raft", node.Addr()},
+		{"-grpc", self.SidecarAddr},
+	} {
+		host, portStr, err := net.SplitHostPort(endpoint.addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", endpoint.addr, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in %q: %w", endpoint.addr, err)
+		}
+
+		check := &consulCheck{
+			TCP:                            endpoint.addr,
+			Interval:                       "10s",
+			DeregisterCriticalServiceAfter: "1m",
+		}
+		// A TLS management API is checked over TCP, since Consul does not
+		// hold a client certificate.
+		if endpoint.suffix == "" && !managementHTTPS() {
+			check = &consulCheck{
+				HTTP:                           "http://" + endpoint.addr + "/health",
+				Interval:                       "10s",
+				DeregisterCriticalServiceAfter: "1m",
+			}
+		}
+		services = append(services, &consulService{
+			ID:      c.Service + endpoint.suffix + "-" + self.NodeID,
+			Name:    c.Service + endpoint.suffix,
+			Address: host,
+			Port:    port,
+			Meta:    map[string]string{"node_id": self.NodeID},
+			Check:   check,
+		})
+	}
+	return services, nil
+}
+
+// registerAll (re-)registers every service, tagging the management
+// service with the node's current role.
+func (c *ConsulDiscoverer) registerAll(ctx context.Context, services []*consulService, isLeader bool) {
+	role := "follower"
+	if isLeader {
+		role = "leader"
+	}
+	services[0].Tags = []string{role}
+
+	for _, service := range services {
+		if err := c.do(ctx, http.MethodPut, "/v1/agent/service/register", service, nil); err != nil {
+			logger.Warn("Failed to register Consul service", "service", service.ID, "error", err)
+		}
+	}
+}
+
+// deregisterAll removes this node's services from the agent.
+func (c *ConsulDiscoverer) deregisterAll(services []*consulService) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, service := range services {
+		if err := c.do(ctx, http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(service.ID), nil, nil); err != nil {
+			logger.Warn("Failed to deregister Consul service", "service", service.ID, "error", err)
+		}
+	}
+}
+
+// do calls the Consul HTTP API, encoding body and decoding the response
+// into out when they are non-nil.
+func (c *ConsulDiscoverer) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.Addr+path, reader)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("consul request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("consul returned status %d for %s: %s", resp.StatusCode, path, msg)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("malformed consul response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// Discoverer finds the management API addresses of other cluster members.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// ExpectProvider is implemented by discoverers that know how many servers
+// a new cluster should be bootstrapped with.
+type ExpectProvider interface {
+	BootstrapExpect(ctx context.Context) (int, error)
+}
+
+// BootstrapElector is implemented by discoverers that can elect a single
+// node to bootstrap a new cluster, which the others then join.
+type BootstrapElector interface {
+	ElectBootstrap(ctx context.Context, nodeID string) (bool, error)
+}
+
+// Registrar is implemented by discoverers that also advertise this node in
+// the registry they discover members from.
+type Registrar interface {
+	StartRegistration(ctx context.Context, node *raftnode.Node, self *fsm.PeerMeta)
+}
+
+// StaticDiscoverer returns a fixed list of management addresses.
+type StaticDiscoverer []string
+
+// Discover returns the configured addresses.
+func (d StaticDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	return d, nil
+}
+
+// ParseDiscoverer builds a Discoverer from a spec of the form
+// "<provider> key=value ...", such as "dns name=raftkv.internal port=6000".
+// mgmtPort is the default management port of discovered members; if it is
+// empty, providers that find hosts require a port in the spec.
+func ParseDiscoverer(spec, mgmtPort string) (Discoverer, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty discovery spec")
+	}
+	provider := strings.TrimPrefix(fields[0], "provider=")
+	args := make(map[string]string)
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid discovery argument %q, expected key=value", field)
+		}
+		args[key] = value
+	}
+	port := args["port"]
+	if port == "" {
+		port = mgmtPort
+	}
+	switch provider {
+	case "dns", "aws", "kubernetes", "k8s":
+		// These find hosts, not management addresses
+		if port == "" {
+			return nil, fmt.Errorf("%s discovery requires port= when the management API is not served on TCP", provider)
+		}
+	}
+
+	switch provider {
+	case "dns":
+		if args["name"] == "" {
+			return nil, fmt.Errorf("dns discovery requires name=")
+		}
+		return NewDNSDiscoverer(args["name"], port), nil
+	case "mdns":
+		return NewMDNSDiscoverer(args["service"]), nil
+	case "aws":
+		return NewAWSDiscoverer(args["tag_key"], args["tag_value"], args["region"], args["addr_type"],
+			args["access_key_id"], args["secret_access_key"], port)
+	case "consul":
+		return NewConsulDiscoverer(args["addr"], args["service"], args["token"], args["register"] != "false"), nil
+	case "etcd":
+		if args["endpoints"] == "" {
+			return nil, fmt.Errorf("etcd discovery requires endpoints=")
+		}
+		return NewEtcdDiscoverer(strings.Split(args["endpoints"], ","), args["prefix"], args["username"], args["password"]), nil
+	case "kubernetes", "k8s":
+		return NewKubernetesDiscoverer(args["namespace"], args["service"], args["label_selector"], port)
+	default:
+		return nil, fmt.Errorf("unknown discovery provider %q", provider)
+	}
+}
+
+// DiscoveryConfig holds configuration for discovery-based cluster
+// formation.
+type DiscoveryConfig struct {
+	Discoverer Discoverer
+	// BootstrapExpect is the number of voters that bootstrap a new cluster
+	// together once they have all found each other. Zero asks the
+	// Discoverer if it is an ExpectProvider, lets it elect a single
+	// bootstrapping node if it is a BootstrapElector, and otherwise only
+	// joins existing clusters.
+	BootstrapExpect int
+	// Join is used as a template when joining through a discovered member;
+	// its LeaderMgmtAddr is replaced by the member's address.
+	Join     *JoinConfig
+	Interval time.Duration
+}
+
+// Discovery forms or joins a cluster using the members found by a
+// Discoverer. Until this node is a member at its current address it
+// periodically queries every discovered member's /status: if any of them
+// already belongs to a cluster, this node joins through it; otherwise, once
+// exactly BootstrapExpect unbootstrapped nodes (including this one) are
+// known, they all bootstrap with the same configuration.
+type Discovery struct {
+	node   *raftnode.Node
+	config *DiscoveryConfig
+	status *statusClient
+}
+
+// NewDiscovery creates a Discovery for the given node.
+func NewDiscovery(node *raftnode.Node, config *DiscoveryConfig) *Discovery {
+	return &Discovery{
+		node:   node,
+		config: config,
+		status: newStatusClient(),
+	}
+}
+
+// Start runs discovery in a goroutine until this node belongs to a cluster
+// at its current address or ctx is cancelled.
+func (d *Discovery) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(d.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			if d.settled() {
+				logger.Info("Discovery done: node belongs to a cluster")
+				return
+			}
+			if err := d.attempt(ctx); err != nil {
+				logger.Warn("Discovery failed", "error", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// settled reports whether this node is a member of its configuration at
+// its current address. A node restarted with a new address is not, and
+// re-joins so that the leader updates its address.
+func (d *Discovery) settled() bool {
+	configuration, _, err := d.node.Configuration()
+	if err != nil {
+		return false
+	}
+	for _, server := range configuration.Servers {
+		if string(server.ID) == d.node.ID() {
+			return string(server.Address) == d.node.Addr()
+		}
+	}
+	return false
+}
+
+// attempt makes a single discovery round.
+func (d *Discovery) attempt(ctx context.Context) error {
+	addrs, err := d.config.Discoverer.Discover(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to discover peers: %w", err)
+	}
+
+	servers := map[string]raft.Server{
+		d.node.ID(): {ID: raft.ServerID(d.node.ID()), Address: raft.ServerAddress(d.node.Addr())},
+	}
+	for _, addr := range addrs {
+		status, err := d.status.fetch(addr)
+		if err != nil {
+			logger.Debug("Discovered peer unreachable", "address", addr, "error", err)
+			continue
+		}
+		if status.NodeID == d.node.ID() {
+			continue
+		}
+		if status.Bootstrapped {
+			return d.join(addr)
+		}
+		if status.Standby {
+			continue
+		}
+		servers[status.NodeID] = raft.Server{
+			ID:      raft.ServerID(status.NodeID),
+			Address: raft.ServerAddress(status.RaftAddr),
+		}
+	}
+
+	// Non-voters, including standbys, only ever join a cluster.
+	if !d.config.Join.Voter {
+		return fmt.Errorf("no member of an existing cluster found among %d peers", len(addrs))
+	}
+
+	expect := d.config.BootstrapExpect
+	if provider, ok := d.config.Discoverer.(ExpectProvider); ok && expect == 0 {
+		if expect, err = provider.BootstrapExpect(ctx); err != nil {
+			return fmt.Errorf("failed to determine expected servers: %w", err)
+		}
+	}
+	if elector, ok := d.config.Discoverer.(BootstrapElector); ok && expect == 0 {
+		won, err := elector.ElectBootstrap(ctx, d.node.ID())
+		if err != nil {
+			return fmt.Errorf("bootstrap election failed: %w", err)
+		}
+		if !won {
+			logger.Info("Another node is bootstrapping the cluster")
+			return nil
+		}
+		logger.Info("Won bootstrap election")
+		return d.node.BootstrapServers([]raft.Server{servers[d.node.ID()]})
+	}
+	if expect == 0 {
+		return fmt.Errorf("no member of an existing cluster found among %d peers", len(addrs))
+	}
+	if len(servers) < expect {
+		logger.Info("Waiting for expected servers", "found", len(servers), "expected", expect)
+		return nil
+	}
+	if len(servers) > expect {
+		return fmt.Errorf("found %d servers but expected %d, refusing to bootstrap", len(servers), expect)
+	}
+
+	configuration := make([]raft.Server, 0, len(servers))
+	for _, server := range servers {
+		configuration = append(configuration, server)
+	}
+	sort.Slice(configuration, func(i, j int) bool { return configuration[i].ID < configuration[j].ID })
+	return d.node.BootstrapServers(configuration)
+}
+
+// join joins the cluster through the member at mgmtAddr, which redirects
+// the request to its leader.
+func (d *Discovery) join(mgmtAddr string) error {
+	config := *d.config.Join
+	config.LeaderMgmtAddr = mgmtAddr
+	config.LeaderRPCAddr = ""
+	config.MaxRetries = 1
+	return NewJoiner(&config).Join()
+}
@@ -0,0 +1,54 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DNSDiscoverer finds members by resolving a DNS name. A name starting with
+// an underscore (such as _mgmt._tcp.raftkv.example.com) is looked up as an
+// SRV record, which supplies the management port of every target;
+// otherwise the A/AAAA records of the name are combined with Port.
+type DNSDiscoverer struct {
+	Name     string
+	Port     string
+	resolver *net.Resolver
+}
+
+// NewDNSDiscoverer creates a DNSDiscoverer using the system resolver.
+func NewDNSDiscoverer(name, port string) *DNSDiscoverer {
+	return &DNSDiscoverer{
+		Name:     name,
+		Port:     port,
+		resolver: net.DefaultResolver,
+	}
+}
+
+// Discover resolves the name to management addresses.
+func (d *DNSDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	if strings.HasPrefix(d.Name, "_") {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve SRV %s: %w", d.Name, err)
+		}
+		addrs := make([]string, 0, len(records))
+		for _, srv := range records {
+			target := strings.TrimSuffix(srv.Target, ".")
+			addrs = append(addrs, net.JoinHostPort(target, strconv.Itoa(int(srv.Port))))
+		}
+		return addrs, nil
+	}
+
+	hosts, err := d.resolver.LookupHost(ctx, d.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", d.Name, err)
+	}
+	addrs := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		addrs = append(addrs, net.JoinHostPort(host, d.Port))
+	}
+	return addrs, nil
+}
@@ -0,0 +1,166 @@
+package cluster
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/metrics"
+	"my-raft-sidecar/internal/raftnode"
+	"my-raft-sidecar/internal/version"
+)
+
+// driftCheckInterval is how often the leader compares members against the
+// configuration.
+const driftCheckInterval = 30 * time.Second
+
+// Drift is a member whose view of itself disagrees with the cluster.
+type Drift struct {
+	NodeID string `json:"node_id"`
+	// Field is what disagrees: "raft_addr", "node_id", "cluster_id" or
+	// "version".
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// DriftDetector catches misconfigured members early. While this node
+// leads, it periodically asks every member that has published its
+// management address for its /status, and records a drift when the member
+// advertises a Raft address or node ID other than the committed
+// configuration's, belongs to another cluster, or runs another version
+// than the leader. Unreachable members are left to the reaper.
+type DriftDetector struct {
+	node   *raftnode.Node
+	fsm    *fsm.CppFSM
+	status *statusClient
+
+	mu     sync.Mutex
+	drifts []Drift
+}
+
+// NewDriftDetector creates a DriftDetector.
+func NewDriftDetector(node *raftnode.Node, stateMachine *fsm.CppFSM) *DriftDetector {
+	return &DriftDetector{
+		node:   node,
+		fsm:    stateMachine,
+		status: newStatusClient(),
+	}
+}
+
+// Start runs the detector in a goroutine until ctx is cancelled.
+func (d *DriftDetector) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(driftCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Drifts returns the drifts found by the last check. It is empty unless
+// this node is the leader.
+func (d *DriftDetector) Drifts() []Drift {
+	if !d.node.IsLeader() {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Drift(nil), d.drifts...)
+}
+
+// Collect reports the drifts as metrics.
+func (d *DriftDetector) Collect(w *metrics.Writer) {
+	if !d.node.IsLeader() {
+		return
+	}
+	drifts := d.Drifts()
+	w.Gauge("raftkv_config_drifts", "Number of member settings that disagree with the cluster.", float64(len(drifts)))
+	for _, drift := range drifts {
+		w.Gauge("raftkv_config_drift", "Set for every member setting that disagrees with the cluster.", 1, "peer", drift.NodeID, "field", drift.Field)
+	}
+}
+
+// check compares every member against the configuration.
+func (d *DriftDetector) check() {
+	if !d.node.IsLeader() {
+		d.setDrifts(nil)
+		return
+	}
+
+	configuration, _, err := d.node.Configuration()
+	if err != nil {
+		logger.Warn("Drift check failed to read configuration", "error", err)
+		return
+	}
+	clusterID := d.node.ClusterID()
+
+	var drifts []Drift
+	for _, server := range configuration.Servers {
+		id, addr := string(server.ID), string(server.Address)
+		if id == d.node.ID() {
+			if d.node.Addr() != addr {
+				drifts = append(drifts, Drift{NodeID: id, Field: "raft_addr", Expected: addr, Actual: d.node.Addr()})
+			}
+			continue
+		}
+
+		meta, ok := d.fsm.Peer(id)
+		if !ok || meta.MgmtAddr == "" {
+			continue
+		}
+		status, err := d.status.fetch(meta.MgmtAddr)
+		if err != nil {
+			continue
+		}
+
+		if status.NodeID != id {
+			drifts = append(drifts, Drift{NodeID: id, Field: "node_id", Expected: id, Actual: status.NodeID})
+		}
+		if status.RaftAddr != addr {
+			drifts = append(drifts, Drift{NodeID: id, Field: "raft_addr", Expected: addr, Actual: status.RaftAddr})
+		}
+		if status.ClusterID != "" && clusterID != "" && status.ClusterID != clusterID {
+			drifts = append(drifts, Drift{NodeID: id, Field: "cluster_id", Expected: clusterID, Actual: status.ClusterID})
+		}
+		if status.Version != version.Version {
+			drifts = append(drifts, Drift{NodeID: id, Field: "version", Expected: version.Version, Actual: status.Version})
+		}
+	}
+	sort.SliceStable(drifts, func(i, j int) bool { return drifts[i].NodeID < drifts[j].NodeID })
+
+	for _, drift := range drifts {
+		if !d.known(drift) {
+			logger.Warn("Configuration drift", "member", drift.NodeID, "field", drift.Field, "actual", drift.Actual, "expected", drift.Expected)
+		}
+	}
+	d.setDrifts(drifts)
+}
+
+// known reports whether drift was already found by the previous check, so
+// that it is only logged once.
+func (d *DriftDetector) known(drift Drift) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, previous := range d.drifts {
+		if previous == drift {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DriftDetector) setDrifts(drifts []Drift) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.drifts = drifts
+}
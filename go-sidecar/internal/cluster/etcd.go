@@ -0,0 +1,319 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// etcdLeaseTTL is the TTL, in seconds, of the lease attached to a node's
+// etcd keys. Keys of a node that stops refreshing it disappear.
+const etcdLeaseTTL = 15
+
+// etcdMember is the value stored under <prefix>/members/<node ID>.
+type etcdMember struct {
+	NodeID   string `json:"node_id"`
+	MgmtAddr string `json:"mgmt_addr"`
+	RaftAddr string `json:"raft_addr"`
+}
+
+// EtcdDiscoverer keeps cluster membership under a key prefix in etcd and
+// coordinates the initial bootstrap through it. Every node registers
+// itself under <prefix>/members/ with a lease; the first node to create
+// <prefix>/bootstrap wins the bootstrap election, bootstraps a
+// single-server cluster and is joined by the others. Once the cluster has
+// a leader, <prefix>/formed is written without a lease, and no election is
+// won after that. It talks to etcd through the v3 JSON gateway.
+type EtcdDiscoverer struct {
+	Endpoints []string
+	Prefix    string
+	Username  string
+	Password  string
+
+	client *http.Client
+
+	mu      sync.Mutex
+	leaseID string
+	token   string
+}
+
+// NewEtcdDiscoverer creates a discoverer for the given etcd endpoints.
+func NewEtcdDiscoverer(endpoints []string, prefix, username, password string) *EtcdDiscoverer {
+	for i, endpoint := range endpoints {
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		endpoints[i] = strings.TrimSuffix(endpoint, "/")
+	}
+	if prefix == "" {
+		prefix = "/raftkv"
+	}
+	return &EtcdDiscoverer{
+		Endpoints: endpoints,
+		Prefix:    strings.TrimSuffix(prefix, "/"),
+		Username:  username,
+		Password:  password,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Discover returns the management addresses of the registered members.
+func (e *EtcdDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	prefix := e.Prefix + "/members/"
+	var resp struct {
+		Kvs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	req := map[string]string{
+		"key":       encode(prefix),
+		"range_end": encode(prefixEnd(prefix)),
+	}
+	if err := e.call(ctx, "/v3/kv/range", req, &resp); err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var member etcdMember
+		data, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil || json.Unmarshal(data, &member) != nil {
+			continue
+		}
+		addrs = append(addrs, member.MgmtAddr)
+	}
+	return addrs, nil
+}
+
+// ElectBootstrap reports whether this node won the bootstrap election.
+// The bootstrap key is bound to the node's lease, so the election reopens
+// if the winner dies before the cluster has formed; once the formed key
+// exists it is never won again, so that a node that cannot reach the
+// members of a running cluster does not bootstrap another.
+func (e *EtcdDiscoverer) ElectBootstrap(ctx context.Context, nodeID string) (bool, error) {
+	leaseID, err := e.lease(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	key := encode(e.Prefix + "/bootstrap")
+	txn := map[string]interface{}{
+		"compare": []map[string]string{
+			{"key": encode(e.Prefix + "/formed"), "result": "EQUAL", "target": "CREATE", "create_revision": "0"},
+			{"key": key, "result": "EQUAL", "target": "CREATE", "create_revision": "0"},
+		},
+		"success": []map[string]interface{}{
+			{"request_put": map[string]string{"key": key, "value": encode(nodeID), "lease": leaseID}},
+		},
+	}
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := e.call(ctx, "/v3/kv/txn", txn, &resp); err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// StartRegistration registers this node under the members prefix and keeps
+// its lease alive until ctx is cancelled. It also writes the formed key
+// once the node has seen a leader.
+func (e *EtcdDiscoverer) StartRegistration(ctx context.Context, node *raftnode.Node, self *fsm.PeerMeta) {
+	member, err := json.Marshal(etcdMember{NodeID: self.NodeID, MgmtAddr: self.MgmtAddr, RaftAddr: node.Addr()})
+	if err != nil {
+		logger.Error("Cannot register in etcd", "error", err)
+		return
+	}
+	key := encode(e.Prefix + "/members/" + self.NodeID)
+
+	go func() {
+		ticker := time.NewTicker(etcdLeaseTTL * time.Second / 3)
+		defer ticker.Stop()
+
+		registered, formed := false, false
+		for {
+			if err := e.keepAlive(ctx, key, encode(string(member)), registered); err != nil {
+				logger.Warn("etcd registration failed", "error", err)
+				registered = false
+			} else {
+				registered = true
+			}
+			if !formed && node.LeaderID() != "" {
+				if err := e.markFormed(ctx, self.NodeID); err != nil {
+					logger.Warn("Failed to record in etcd that the cluster has formed", "error", err)
+				} else {
+					formed = true
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// markFormed creates the formed key, without a lease, unless it exists.
+func (e *EtcdDiscoverer) markFormed(ctx context.Context, nodeID string) error {
+	key := encode(e.Prefix + "/formed")
+	txn := map[string]interface{}{
+		"compare": []map[string]string{
+			{"key": key, "result": "EQUAL", "target": "CREATE", "create_revision": "0"},
+		},
+		"success": []map[string]interface{}{
+			{"request_put": map[string]string{"key": key, "value": encode(nodeID)}},
+		},
+	}
+	return e.call(ctx, "/v3/kv/txn", txn, nil)
+}
+
+// keepAlive refreshes the node's lease, re-creating it and the member key
+// if it has expired or the key has not been written yet.
+func (e *EtcdDiscoverer) keepAlive(ctx context.Context, key, value string, registered bool) error {
+	leaseID, err := e.lease(ctx)
+	if err != nil {
+		return err
+	}
+
+	if registered {
+		var resp struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+		if err := e.call(ctx, "/v3/lease/keepalive", map[string]string{"ID": leaseID}, &resp); err != nil {
+			return err
+		}
+		if resp.Result.TTL != "" && resp.Result.TTL != "0" {
+			return nil
+		}
+		// The lease expired along with every key attached to it.
+		e.mu.Lock()
+		e.leaseID = ""
+		e.mu.Unlock()
+		if leaseID, err = e.lease(ctx); err != nil {
+			return err
+		}
+	}
+
+	return e.call(ctx, "/v3/kv/put", map[string]string{"key": key, "value": value, "lease": leaseID}, nil)
+}
+
+// lease returns the node's lease, granting it on first use.
+func (e *EtcdDiscoverer) lease(ctx context.Context) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.leaseID != "" {
+		return e.leaseID, nil
+	}
+	var resp struct {
+		ID string `json:"ID"`
+	}
+	if err := e.callLocked(ctx, "/v3/lease/grant", map[string]int{"TTL": etcdLeaseTTL}, &resp); err != nil {
+		return "", err
+	}
+	e.leaseID = resp.ID
+	return e.leaseID, nil
+}
+
+// call sends a request to the first etcd endpoint that answers.
+func (e *EtcdDiscoverer) call(ctx context.Context, path string, body, out interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.callLocked(ctx, path, body, out)
+}
+
+func (e *EtcdDiscoverer) callLocked(ctx context.Context, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, endpoint := range e.Endpoints {
+		if e.Username != "" && e.token == "" {
+			if err := e.authenticate(ctx, endpoint); err != nil {
+				lastErr = err
+				continue
+			}
+		}
+		if lastErr = e.post(ctx, endpoint+path, data, out); lastErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("etcd request %s failed: %w", path, lastErr)
+}
+
+// authenticate obtains an auth token for the configured user.
+func (e *EtcdDiscoverer) authenticate(ctx context.Context, endpoint string) error {
+	data, err := json.Marshal(map[string]string{"name": e.Username, "password": e.Password})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := e.post(ctx, endpoint+"/v3/auth/authenticate", data, &resp); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	e.token = resp.Token
+	return nil
+}
+
+func (e *EtcdDiscoverer) post(ctx context.Context, url string, data []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", e.token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusUnauthorized {
+			e.token = ""
+		}
+		return fmt.Errorf("status %d: %s", resp.StatusCode, msg)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("malformed response: %w", err)
+		}
+	}
+	return nil
+}
+
+// encode base64-encodes a key or value for the JSON gateway.
+func encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// prefixEnd returns the range end matching every key with the prefix.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	return string(end)
+}
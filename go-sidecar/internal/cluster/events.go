@@ -0,0 +1,158 @@
+package cluster
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/backend"
+	"my-raft-sidecar/internal/events"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// eventCheckInterval is how often the EventWatcher compares the
+// configuration, snapshots and backend health with what it saw last.
+const eventCheckInterval = time.Second
+
+// EventWatcher publishes what this node observes of the cluster as events:
+// leader elections as the Raft library reports them, and membership
+// changes, snapshots and backend health transitions as it finds them on
+// its periodic checks.
+type EventWatcher struct {
+	node   *raftnode.Node
+	health *backend.HealthChecker
+	bus    *events.Bus
+
+	// members, snapshotIndex and healthy are what the last check saw.
+	members       map[raft.ServerID]raft.Server
+	snapshotIndex uint64
+	healthy       bool
+}
+
+// NewEventWatcher creates an EventWatcher publishing to bus.
+func NewEventWatcher(node *raftnode.Node, health *backend.HealthChecker, bus *events.Bus) *EventWatcher {
+	return &EventWatcher{
+		node:   node,
+		health: health,
+		bus:    bus,
+	}
+}
+
+// Start runs the watcher in a goroutine until ctx is cancelled. The state
+// found on start is taken as known and raises no events.
+func (w *EventWatcher) Start(ctx context.Context) {
+	observations := make(chan raft.Observation, 16)
+	observer := raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		_, ok := o.Data.(raft.LeaderObservation)
+		return ok
+	})
+	w.node.Raft.RegisterObserver(observer)
+
+	w.members = w.configuration()
+	w.snapshotIndex, _ = w.lastSnapshot()
+	w.healthy = w.health.Healthy()
+
+	go func() {
+		defer w.node.Raft.DeregisterObserver(observer)
+		ticker := time.NewTicker(eventCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case o := <-observations:
+				w.leaderChanged(o.Data.(raft.LeaderObservation))
+			case <-ticker.C:
+				w.check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// leaderChanged publishes a leader election, or the loss of the leader.
+func (w *EventWatcher) leaderChanged(o raft.LeaderObservation) {
+	if o.LeaderID == "" {
+		w.bus.Publish(events.Event{Type: events.LeaderLost, NodeID: w.node.ID(), Term: w.node.Raft.CurrentTerm()})
+		return
+	}
+	w.bus.Publish(events.Event{
+		Type:    events.LeaderElected,
+		NodeID:  w.node.ID(),
+		Term:    w.node.Raft.CurrentTerm(),
+		Member:  string(o.LeaderID),
+		Address: string(o.LeaderAddr),
+	})
+}
+
+// check publishes the membership, snapshot and backend health changes
+// since the last check.
+func (w *EventWatcher) check() {
+	if members := w.configuration(); members != nil && w.members == nil {
+		w.members = members
+	} else if members != nil {
+		for id, server := range members {
+			if _, ok := w.members[id]; !ok {
+				w.publishMember(events.MemberJoined, server)
+			}
+		}
+		for id, server := range w.members {
+			if _, ok := members[id]; !ok {
+				w.publishMember(events.MemberRemoved, server)
+			}
+		}
+		w.members = members
+	}
+
+	if index, term := w.lastSnapshot(); index > w.snapshotIndex {
+		w.snapshotIndex = index
+		w.bus.Publish(events.Event{Type: events.SnapshotTaken, NodeID: w.node.ID(), Term: term, Index: index})
+	}
+
+	if healthy := w.health.Healthy(); healthy != w.healthy {
+		w.healthy = healthy
+		event := events.Event{Type: events.BackendHealthy, NodeID: w.node.ID()}
+		if !healthy {
+			event.Type = events.BackendUnhealthy
+			if err := w.health.LastError(); err != nil {
+				event.Error = err.Error()
+			}
+		}
+		w.bus.Publish(event)
+	}
+}
+
+// publishMember publishes a membership event about server.
+func (w *EventWatcher) publishMember(eventType string, server raft.Server) {
+	w.bus.Publish(events.Event{
+		Type:     eventType,
+		NodeID:   w.node.ID(),
+		Member:   string(server.ID),
+		Address:  string(server.Address),
+		Suffrage: server.Suffrage.String(),
+	})
+}
+
+// configuration returns the servers of the latest configuration by ID, or
+// nil if it cannot be read.
+func (w *EventWatcher) configuration() map[raft.ServerID]raft.Server {
+	configuration, _, err := w.node.Configuration()
+	if err != nil {
+		return nil
+	}
+	members := make(map[raft.ServerID]raft.Server)
+	for _, server := range configuration.Servers {
+		members[server.ID] = server
+	}
+	return members
+}
+
+// lastSnapshot returns the index and term of the latest snapshot.
+func (w *EventWatcher) lastSnapshot() (index, term uint64) {
+	stats := w.node.Raft.Stats()
+	index, _ = strconv.ParseUint(stats["last_snapshot_index"], 10, 64)
+	term, _ = strconv.ParseUint(stats["last_snapshot_term"], 10, 64)
+	return index, term
+}
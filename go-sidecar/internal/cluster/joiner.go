@@ -2,20 +2,72 @@
 package cluster
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
-	"log"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"my-raft-sidecar/internal/logging"
+	pb "my-raft-sidecar/pb"
 )
 
-// JoinConfig holds configuration for joining a cluster.
+// logger logs with component=cluster.
+var logger = logging.Component("cluster")
+
+// JoinConfig holds configuration for joining a cluster. The join is sent to
+// LeaderRPCAddr over gRPC if set, otherwise to LeaderMgmtAddr over HTTP.
 type JoinConfig struct {
 	LeaderMgmtAddr string
-	NodeID         string
-	RaftAddr       string
+	LeaderRPCAddr  string
+	// RPCTLS, if set, returns the configuration used to reach the sidecar
+	// at LeaderRPCAddr, whose host it is given, over TLS.
+	RPCTLS func(host string) *tls.Config
+	// ClusterToken is the cluster token or a join token minted with it.
+	ClusterToken string
+	// ClusterID is the ID of the cluster this node belongs to, if any; the
+	// leader refuses the join if it names another cluster.
+	ClusterID   string
+	NodeID      string
+	RaftAddr    string
+	SidecarAddr string
+	MgmtAddr    string
+	Voter       bool
+	Priority    int
+	ReadOnly    bool
+	Standby     bool
+	Zone        string
+	Rack        string
+	// Failed attempts are retried with exponential backoff: the delay
+	// starts at InitialBackoff and doubles up to MaxBackoff, with up to
+	// half of it randomized so that nodes started together spread out.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxElapsedTime stops retrying once this long has passed since the
+	// first attempt; zero retries indefinitely. MaxRetries, if positive,
+	// also limits the number of attempts.
+	MaxElapsedTime time.Duration
 	MaxRetries     int
-	RetryInterval  time.Duration
+	// ExitOnFailure makes JoinAsync exit the process with a non-zero
+	// status when joining fails, instead of leaving it running un-joined.
+	ExitOnFailure bool
+	// RemoveFirst removes any existing membership of NodeID before the
+	// first join, so that a node whose Raft state was wiped comes back as
+	// a new server instead of as a voter that no longer remembers its log
+	// or its votes.
+	RemoveFirst bool
 }
 
 // DefaultJoinConfig returns default join configuration.
@@ -24,8 +76,10 @@ func DefaultJoinConfig(leaderAddr, nodeID, raftAddr string) *JoinConfig {
 		LeaderMgmtAddr: leaderAddr,
 		NodeID:         nodeID,
 		RaftAddr:       raftAddr,
-		MaxRetries:     20,
-		RetryInterval:  2 * time.Second,
+		Voter:          true,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		MaxElapsedTime: 5 * time.Minute,
 	}
 }
 
@@ -39,52 +93,192 @@ type Joiner struct {
 func NewJoiner(config *JoinConfig) *Joiner {
 	return &Joiner{
 		config: config,
-		client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+		client: NewManagementClient(10 * time.Second),
 	}
 }
 
-// Join attempts to join the cluster, retrying on failure.
-// Returns an error if all attempts fail.
+// Join attempts to join the cluster, retrying on failure with jittered
+// exponential backoff. Returns an error once MaxElapsedTime or MaxRetries
+// is exhausted.
 func (j *Joiner) Join() error {
-	url := fmt.Sprintf(
-		"http://%s/join?peerID=%s&peerAddress=%s",
-		j.config.LeaderMgmtAddr,
-		j.config.NodeID,
-		j.config.RaftAddr,
-	)
-
-	var lastErr error
-	for i := 0; i < j.config.MaxRetries; i++ {
-		// Wait before retrying (but not on first attempt)
-		if i > 0 {
-			time.Sleep(j.config.RetryInterval)
+	target, attempt := j.httpAttempt()
+	remove := j.httpRemove
+	if j.config.LeaderRPCAddr != "" {
+		creds := insecure.NewCredentials()
+		if j.config.RPCTLS != nil {
+			host, _, _ := net.SplitHostPort(j.config.LeaderRPCAddr)
+			creds = credentials.NewTLS(j.config.RPCTLS(host))
+		}
+		conn, err := grpc.NewClient(j.config.LeaderRPCAddr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return fmt.Errorf("failed to create client for %s: %w", j.config.LeaderRPCAddr, err)
+		}
+		defer conn.Close()
+		client := pb.NewAdminClient(conn)
+		target, attempt = j.rpcAttempt(client)
+		remove = func() error { return j.rpcRemove(client) }
+	}
+	if j.config.RemoveFirst {
+		join, removed := attempt, false
+		attempt = func() error {
+			if !removed {
+				if err := remove(); err != nil {
+					return fmt.Errorf("failed to remove previous membership: %w", err)
+				}
+				logger.Info("Removed any previous membership of this node")
+				removed = true
+			}
+			return join()
 		}
+	}
+
+	start := time.Now()
+	backoff := j.config.InitialBackoff
+	for i := 1; ; i++ {
+		logger.Info("Attempting to join cluster", "via", target, "attempt", i)
 
-		log.Printf("Attempting to join cluster via %s (attempt %d/%d)...",
-			url, i+1, j.config.MaxRetries)
+		err := attempt()
+		if err == nil {
+			logger.Info("Joined the cluster")
+			return nil
+		}
 
-		if err := j.attemptJoin(url); err != nil {
-			lastErr = err
-			log.Printf("Join attempt %d failed: %v", i+1, err)
-			continue
+		if j.config.MaxRetries > 0 && i >= j.config.MaxRetries {
+			return fmt.Errorf("failed to join cluster after %d attempts: %w", i, err)
 		}
+		delay := jitter(backoff)
+		if j.config.MaxElapsedTime > 0 && time.Since(start)+delay > j.config.MaxElapsedTime {
+			return fmt.Errorf("failed to join cluster after %d attempts in %s: %w",
+				i, time.Since(start).Round(time.Second), err)
+		}
+
+		logger.Warn("Join attempt failed, retrying", "attempt", i, "retry_in", delay.Round(time.Millisecond).String(), "error", err)
+		time.Sleep(delay)
+		backoff = min(2*backoff, j.config.MaxBackoff)
+	}
+}
+
+// jitter returns a random delay between half of backoff and backoff.
+func jitter(backoff time.Duration) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+	half := backoff / 2
+	return half + rand.N(backoff-half+1)
+}
+
+// httpAttempt returns the join URL and a function making a single join
+// attempt through the management API.
+func (j *Joiner) httpAttempt() (string, func() error) {
+	params := url.Values{}
+	params.Set("peerID", j.config.NodeID)
+	params.Set("peerAddress", j.config.RaftAddr)
+	params.Set("voter", strconv.FormatBool(j.config.Voter))
+	if j.config.SidecarAddr != "" {
+		params.Set("sidecarAddr", j.config.SidecarAddr)
+	}
+	if j.config.MgmtAddr != "" {
+		params.Set("mgmtAddr", j.config.MgmtAddr)
+	}
+	if j.config.Priority != 0 {
+		params.Set("priority", strconv.Itoa(j.config.Priority))
+	}
+	if j.config.ReadOnly {
+		params.Set("readOnly", "true")
+	}
+	if j.config.Standby {
+		params.Set("standby", "true")
+	}
+	if j.config.Zone != "" {
+		params.Set("zone", j.config.Zone)
+	}
+	if j.config.Rack != "" {
+		params.Set("rack", j.config.Rack)
+	}
+	if j.config.ClusterID != "" {
+		params.Set("clusterID", j.config.ClusterID)
+	}
+	joinURL := ManagementURL(j.config.LeaderMgmtAddr, "/join?"+params.Encode())
+
+	// The token is left out of the returned URL, which is logged.
+	requestURL := joinURL
+	if j.config.ClusterToken != "" {
+		requestURL += "&token=" + url.QueryEscape(j.config.ClusterToken)
+	}
+	return joinURL, func() error { return j.attemptJoin(requestURL) }
+}
+
+// rpcAttempt returns the target address and a function making a single
+// join attempt through the Admin gRPC service.
+func (j *Joiner) rpcAttempt(client pb.AdminClient) (string, func() error) {
+	req := &pb.JoinRequest{
+		Id:           j.config.NodeID,
+		RaftAddr:     j.config.RaftAddr,
+		SidecarAddr:  j.config.SidecarAddr,
+		MgmtAddr:     j.config.MgmtAddr,
+		Voter:        j.config.Voter,
+		Priority:     int32(j.config.Priority),
+		ReadOnly:     j.config.ReadOnly,
+		Standby:      j.config.Standby,
+		Zone:         j.config.Zone,
+		Rack:         j.config.Rack,
+		ClusterToken: j.config.ClusterToken,
+		ClusterId:    j.config.ClusterID,
+	}
+	return j.config.LeaderRPCAddr, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), j.client.Timeout)
+		defer cancel()
+		_, err := client.Join(ctx, req)
+		return err
+	}
+}
 
-		log.Println("Successfully joined the cluster!")
+// httpRemove asks the leader, through the management API, to remove
+// NodeID. A node that is not a member counts as removed.
+func (j *Joiner) httpRemove() error {
+	params := url.Values{}
+	params.Set("peerID", j.config.NodeID)
+	req, err := http.NewRequest(http.MethodDelete, ManagementURL(j.config.LeaderMgmtAddr, "/remove?"+params.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("connection failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotFound:
 		return nil
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned status %d: %s", resp.StatusCode, body)
 	}
+}
 
-	return fmt.Errorf("failed to join cluster after %d attempts: %w",
-		j.config.MaxRetries, lastErr)
+// rpcRemove asks the leader, through the Admin gRPC service, to remove
+// NodeID. A node that is not a member counts as removed.
+func (j *Joiner) rpcRemove(client pb.AdminClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), j.client.Timeout)
+	defer cancel()
+	_, err := client.Remove(ctx, &pb.RemoveServerRequest{Id: j.config.NodeID})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
 }
 
 // JoinAsync attempts to join the cluster in a goroutine.
-// Logs a critical error if joining fails.
+// Logs a critical error if joining fails, and exits if ExitOnFailure is set.
 func (j *Joiner) JoinAsync() {
 	go func() {
 		if err := j.Join(); err != nil {
-			log.Printf("CRITICAL: %v", err)
+			if j.config.ExitOnFailure {
+				logger.Error("Failed to join the cluster, exiting", "error", err)
+				os.Exit(1)
+			}
+			logger.Error("Failed to join the cluster", "error", err)
 		}
 	}()
 }
@@ -0,0 +1,183 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into every pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubernetesDiscoverer finds members by listing the pods that back a
+// service or match a label selector through the Kubernetes API, using the
+// pod's service account. Only running pods with an IP are returned, so a
+// rescheduled pod is found at its new address.
+type KubernetesDiscoverer struct {
+	Namespace     string
+	Service       string
+	LabelSelector string
+	Port          string
+
+	podName string
+	apiURL  string
+	token   string
+	client  *http.Client
+}
+
+// NewKubernetesDiscoverer creates a discoverer for pods in namespace that
+// back service or, if service is empty, match labelSelector. An empty
+// namespace means the pod's own namespace.
+func NewKubernetesDiscoverer(namespace, service, labelSelector, port string) (*KubernetesDiscoverer, error) {
+	host, apiPort := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || apiPort == "" {
+		return nil, fmt.Errorf("not running inside Kubernetes (KUBERNETES_SERVICE_HOST is not set)")
+	}
+	if service == "" && labelSelector == "" {
+		return nil, fmt.Errorf("kubernetes discovery requires service= or label_selector=")
+	}
+
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid service account CA")
+	}
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	podName, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine pod name: %w", err)
+	}
+
+	return &KubernetesDiscoverer{
+		Namespace:     namespace,
+		Service:       service,
+		LabelSelector: labelSelector,
+		Port:          port,
+		podName:       podName,
+		apiURL:        "https://" + net.JoinHostPort(host, apiPort),
+		token:         strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// Discover returns the management addresses of the matching pods.
+func (k *KubernetesDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	selector := k.LabelSelector
+	if k.Service != "" {
+		var svc struct {
+			Spec struct {
+				Selector map[string]string `json:"selector"`
+			} `json:"spec"`
+		}
+		if err := k.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/services/%s", k.Namespace, k.Service), &svc); err != nil {
+			return nil, err
+		}
+		pairs := make([]string, 0, len(svc.Spec.Selector))
+		for key, value := range svc.Spec.Selector {
+			pairs = append(pairs, key+"="+value)
+		}
+		selector = strings.Join(pairs, ",")
+	}
+
+	var pods struct {
+		Items []struct {
+			Status struct {
+				Phase string `json:"phase"`
+				PodIP string `json:"podIP"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods?labelSelector=%s", k.Namespace, url.QueryEscape(selector))
+	if err := k.get(ctx, path, &pods); err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == "Running" && pod.Status.PodIP != "" {
+			addrs = append(addrs, net.JoinHostPort(pod.Status.PodIP, k.Port))
+		}
+	}
+	return addrs, nil
+}
+
+// BootstrapExpect returns the replica count of the StatefulSet that owns
+// this pod, so that a new cluster bootstraps with every replica.
+func (k *KubernetesDiscoverer) BootstrapExpect(ctx context.Context) (int, error) {
+	var pod struct {
+		Metadata struct {
+			OwnerReferences []struct {
+				Kind string `json:"kind"`
+				Name string `json:"name"`
+			} `json:"ownerReferences"`
+		} `json:"metadata"`
+	}
+	if err := k.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", k.Namespace, k.podName), &pod); err != nil {
+		return 0, err
+	}
+
+	for _, owner := range pod.Metadata.OwnerReferences {
+		if owner.Kind != "StatefulSet" {
+			continue
+		}
+		var sts struct {
+			Spec struct {
+				Replicas int `json:"replicas"`
+			} `json:"spec"`
+		}
+		if err := k.get(ctx, fmt.Sprintf("/apis/apps/v1/namespaces/%s/statefulsets/%s", k.Namespace, owner.Name), &sts); err != nil {
+			return 0, err
+		}
+		return sts.Spec.Replicas, nil
+	}
+	return 0, fmt.Errorf("pod %s is not owned by a StatefulSet", k.podName)
+}
+
+// get fetches an API object and decodes it into v.
+func (k *KubernetesDiscoverer) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kubernetes API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kubernetes API returned status %d for %s", resp.StatusCode, path)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("malformed kubernetes API response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// LeaveConfig holds configuration for leaving a cluster.
+type LeaveConfig struct {
+	// LocalMgmtAddr is this node's own management API. The remove request
+	// is sent there and follows the redirect to the leader.
+	LocalMgmtAddr string
+	NodeID        string
+	Timeout       time.Duration
+	RetryInterval time.Duration
+}
+
+// DefaultLeaveConfig returns default leave configuration.
+func DefaultLeaveConfig(localMgmtAddr, nodeID string) *LeaveConfig {
+	return &LeaveConfig{
+		LocalMgmtAddr: localMgmtAddr,
+		NodeID:        nodeID,
+		Timeout:       30 * time.Second,
+		RetryInterval: time.Second,
+	}
+}
+
+// Leaver removes this node from the cluster configuration so that a node
+// shut down for good does not linger as an unreachable member.
+type Leaver struct {
+	config *LeaveConfig
+	node   *raftnode.Node
+	client *http.Client
+}
+
+// NewLeaver creates a new Leaver with the given configuration.
+func NewLeaver(node *raftnode.Node, config *LeaveConfig) *Leaver {
+	return &Leaver{
+		config: config,
+		node:   node,
+		client: NewManagementClient(10 * time.Second),
+	}
+}
+
+// Leave hands off leadership if this node holds it, then asks the leader to
+// remove this node and waits until the removal has committed. A node that
+// is the only voter has nobody to hand off to and stays in the
+// configuration.
+func (l *Leaver) Leave() error {
+	voters, err := l.node.Voters()
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %w", err)
+	}
+	if len(voters) <= 1 && l.node.IsLeader() {
+		logger.Info("Last voter in the cluster, not leaving")
+		return nil
+	}
+
+	deadline := time.Now().Add(l.config.Timeout)
+
+	if l.node.IsLeader() {
+		logger.Info("Transferring leadership before leaving")
+		err := l.node.TransferLeadership()
+		l.node.AuditLog().Record(audit.Entry{Op: audit.OpTransferLeadership, Initiator: "leave on shutdown"}, err)
+		if err != nil {
+			return fmt.Errorf("failed to transfer leadership: %w", err)
+		}
+	}
+
+	params := url.Values{}
+	params.Set("peerID", l.config.NodeID)
+	removeURL := ManagementURL(l.config.LocalMgmtAddr, "/remove?"+params.Encode())
+
+	var lastErr error
+	for time.Now().Before(deadline) {
+		done, err := l.attemptRemove(removeURL)
+		if done {
+			logger.Info("Left the cluster")
+			return nil
+		}
+		lastErr = err
+		logger.Warn("Leave attempt failed", "error", err)
+		time.Sleep(l.config.RetryInterval)
+	}
+	return fmt.Errorf("failed to leave cluster within %s: %w", l.config.Timeout, lastErr)
+}
+
+// attemptRemove sends a single remove request. The leader only answers
+// once the configuration change has committed. An unknown peer means an
+// earlier attempt already succeeded.
+func (l *Leaver) attemptRemove(removeURL string) (bool, error) {
+	req, err := http.NewRequest(http.MethodDelete, removeURL, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotFound:
+		return true, nil
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("remove failed with status %d: %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,251 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// mdnsGroup is the IPv4 multicast address mDNS queries are sent to.
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// MDNSDiscoverer finds members on the local network through multicast DNS.
+// Every sidecar answers PTR queries for the service (by default
+// _raftkv._tcp.local.) with an instance named after its node ID, carrying
+// its management address in a TXT record. It is meant for development
+// clusters on a single LAN or Docker network.
+type MDNSDiscoverer struct {
+	Service string
+	Domain  string
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	nodes []string
+}
+
+// NewMDNSDiscoverer creates a discoverer for the given service name.
+func NewMDNSDiscoverer(service string) *MDNSDiscoverer {
+	if service == "" {
+		service = "_raftkv._tcp"
+	}
+	return &MDNSDiscoverer{
+		Service: service,
+		Domain:  "local.",
+		Timeout: time.Second,
+	}
+}
+
+// serviceName returns the fully qualified name that is browsed.
+func (m *MDNSDiscoverer) serviceName() string {
+	return m.Service + "." + m.Domain
+}
+
+// Discover sends a query and collects the answers that arrive within
+// Timeout.
+func (m *MDNSDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	name, err := dnsmessage.NewName(m.serviceName())
+	if err != nil {
+		return nil, fmt.Errorf("invalid mDNS service name: %w", err)
+	}
+	query, err := (&dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mDNS socket: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(m.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	if _, err := conn.WriteToUDP(query, mdnsGroup); err != nil {
+		return nil, fmt.Errorf("failed to send mDNS query: %w", err)
+	}
+
+	found := make(map[string]string)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		nodeID, mgmtAddr := parseMDNSAnswer(buf[:n], from.IP)
+		if nodeID != "" && mgmtAddr != "" {
+			found[nodeID] = mgmtAddr
+		}
+	}
+
+	nodes := make([]string, 0, len(found))
+	addrs := make([]string, 0, len(found))
+	for nodeID, addr := range found {
+		nodes = append(nodes, nodeID)
+		addrs = append(addrs, addr)
+	}
+	m.mu.Lock()
+	m.nodes = nodes
+	m.mu.Unlock()
+	return addrs, nil
+}
+
+// ElectBootstrap elects the node with the lowest ID among those that
+// answered the last query, so nodes started together bootstrap once.
+func (m *MDNSDiscoverer) ElectBootstrap(ctx context.Context, nodeID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.nodes) == 0 {
+		// Not even this node answered, so the network drops multicast.
+		return false, fmt.Errorf("no mDNS answers received")
+	}
+	for _, other := range m.nodes {
+		if other < nodeID {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// parseMDNSAnswer extracts the node ID and management address from a
+// response. An unspecified management host is replaced by the sender's
+// address.
+func parseMDNSAnswer(packet []byte, from net.IP) (string, string) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil || !msg.Header.Response {
+		return "", ""
+	}
+
+	var nodeID, mgmtAddr string
+	for _, answer := range append(msg.Answers, msg.Additionals...) {
+		txt, ok := answer.Body.(*dnsmessage.TXTResource)
+		if !ok {
+			continue
+		}
+		for _, entry := range txt.TXT {
+			key, value, _ := strings.Cut(entry, "=")
+			switch key {
+			case "id":
+				nodeID = value
+			case "mgmt":
+				mgmtAddr = value
+			}
+		}
+	}
+
+	host, port, err := net.SplitHostPort(mgmtAddr)
+	if err != nil {
+		return "", ""
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		mgmtAddr = net.JoinHostPort(from.String(), port)
+	}
+	return nodeID, mgmtAddr
+}
+
+// StartRegistration answers queries for the service until ctx is
+// cancelled.
+func (m *MDNSDiscoverer) StartRegistration(ctx context.Context, node *raftnode.Node, self *fsm.PeerMeta) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		logger.Error("Cannot listen for mDNS queries", "error", err)
+		return
+	}
+	response, err := m.response(self)
+	if err != nil {
+		conn.Close()
+		logger.Error("Cannot register with mDNS", "error", err)
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !m.isQuery(buf[:n]) {
+				continue
+			}
+			// Answer directly: the queries come from an ephemeral port
+			// (RFC 6762 section 6.7).
+			if _, err := conn.WriteToUDP(response, from); err != nil {
+				logger.Warn("Failed to answer mDNS query", "from", from.String(), "error", err)
+			}
+		}
+	}()
+}
+
+// isQuery reports whether packet asks for the service.
+func (m *MDNSDiscoverer) isQuery(packet []byte) bool {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil || msg.Header.Response {
+		return false
+	}
+	for _, question := range msg.Questions {
+		if question.Type == dnsmessage.TypePTR && strings.EqualFold(question.Name.String(), m.serviceName()) {
+			return true
+		}
+	}
+	return false
+}
+
+// response builds this node's answer: a PTR record for the service
+// pointing at the instance, and the instance's SRV and TXT records.
+func (m *MDNSDiscoverer) response(self *fsm.PeerMeta) ([]byte, error) {
+	_, portStr, err := net.SplitHostPort(self.MgmtAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid management address %q: %w", self.MgmtAddr, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q: %w", self.MgmtAddr, err)
+	}
+
+	service, err := dnsmessage.NewName(m.serviceName())
+	if err != nil {
+		return nil, err
+	}
+	instance, err := dnsmessage.NewName(self.NodeID + "." + m.serviceName())
+	if err != nil {
+		return nil, fmt.Errorf("node ID %q is not a valid mDNS label: %w", self.NodeID, err)
+	}
+	target, err := dnsmessage.NewName(self.NodeID + "." + m.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	header := func(name dnsmessage.Name, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: 120}
+	}
+	msg := &dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: []dnsmessage.Resource{
+			{Header: header(service, dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: instance}},
+		},
+		Additionals: []dnsmessage.Resource{
+			{Header: header(instance, dnsmessage.TypeSRV), Body: &dnsmessage.SRVResource{Target: target, Port: uint16(port)}},
+			{Header: header(instance, dnsmessage.TypeTXT), Body: &dnsmessage.TXTResource{TXT: []string{"id=" + self.NodeID, "mgmt=" + self.MgmtAddr}}},
+		},
+	}
+	return msg.Pack()
+}
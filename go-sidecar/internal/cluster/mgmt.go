@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	// mgmtTLS is the TLS configuration used to reach the management API of
+	// other members, or nil if it is served over plain HTTP.
+	mgmtTLS *tls.Config
+	// mgmtDialTLS, if set, opens the TLS connections to management APIs in
+	// place of mgmtTLS.
+	mgmtDialTLS func(ctx context.Context, network, addr string) (net.Conn, error)
+	// mgmtToken is the bearer token presented to management APIs, if any.
+	mgmtToken string
+)
+
+// SetManagementTLS makes the clients of this package reach management APIs
+// over HTTPS with config, which should present this node's certificate if
+// members require client certificates. It must be called before any of
+// them is created.
+func SetManagementTLS(config *tls.Config) {
+	mgmtTLS = config
+}
+
+// SetManagementTLSDialer makes the clients of this package reach
+// management APIs over HTTPS, opening each connection with dial, such as a
+// tlsutil.Source's DialTLSContext, which verifies the server against the
+// host it dials. It must be called before any of them is created.
+func SetManagementTLSDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	mgmtDialTLS = dial
+}
+
+// managementHTTPS reports whether management APIs are reached over HTTPS.
+func managementHTTPS() bool {
+	return mgmtTLS != nil || mgmtDialTLS != nil
+}
+
+// SetManagementToken makes the clients of this package authenticate to
+// management APIs with token. It must be called before any of them is
+// created.
+func SetManagementToken(token string) {
+	mgmtToken = token
+}
+
+// ManagementURL returns the URL of path on the management API at addr,
+// over HTTPS if SetManagementTLS or SetManagementTLSDialer was called.
+func ManagementURL(addr, path string) string {
+	if managementHTTPS() {
+		return "https://" + addr + path
+	}
+	return "http://" + addr + path
+}
+
+// NewManagementClient returns an HTTP client for management APIs, which
+// presents the TLS configuration or dialer and token set with
+// SetManagementTLS, SetManagementTLSDialer and SetManagementToken.
+func NewManagementClient(timeout time.Duration) *http.Client {
+	var transport http.RoundTripper = http.DefaultTransport
+	switch {
+	case mgmtDialTLS != nil:
+		transport = &http.Transport{DialTLSContext: mgmtDialTLS}
+	case mgmtTLS != nil:
+		transport = &http.Transport{TLSClientConfig: mgmtTLS}
+	}
+	if mgmtToken != "" {
+		transport = &bearerTransport{base: transport, token: mgmtToken}
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// bearerTransport adds the bearer token to every request, including those
+// that follow a redirect to the leader, from which http.Client strips the
+// Authorization header when the host changes.
+type bearerTransport struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
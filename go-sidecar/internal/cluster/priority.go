@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+const (
+	// priorityCheckInterval is how often the leader looks for a
+	// higher-priority voter to hand off to.
+	priorityCheckInterval = 5 * time.Second
+	// priorityMaxLag is how many entries a candidate may trail the leader's
+	// applied index by and still be considered caught up.
+	priorityMaxLag = 100
+)
+
+// PriorityMonitor pins leadership to the voters with the highest priority.
+// While this node leads, it periodically looks for a voter whose replicated
+// priority is higher than its own and, once that voter reports a healthy
+// backend and has caught up, transfers leadership to it. Among voters of
+// equal priority, those in the same zone as this node are tried first.
+type PriorityMonitor struct {
+	node     *raftnode.Node
+	fsm      *fsm.CppFSM
+	priority int
+	zone     string
+	status   *statusClient
+}
+
+// NewPriorityMonitor creates a monitor for a node with the given priority
+// in zone.
+func NewPriorityMonitor(node *raftnode.Node, stateMachine *fsm.CppFSM, priority int, zone string) *PriorityMonitor {
+	return &PriorityMonitor{
+		node:     node,
+		fsm:      stateMachine,
+		priority: priority,
+		zone:     zone,
+		status:   newStatusClient(),
+	}
+}
+
+// Start runs the monitor in a goroutine until ctx is cancelled.
+func (m *PriorityMonitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(priorityCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// check hands leadership to the highest-priority eligible voter, if any
+// outranks this node.
+func (m *PriorityMonitor) check() {
+	if !m.node.IsLeader() {
+		return
+	}
+	if draining, _ := m.node.Draining(); draining {
+		return
+	}
+
+	voters, err := m.node.Voters()
+	if err != nil {
+		logger.Warn("Priority check failed to read configuration", "error", err)
+		return
+	}
+
+	type candidate struct {
+		id, addr string
+		meta     fsm.PeerMeta
+	}
+	var candidates []candidate
+	for _, server := range voters {
+		id := string(server.ID)
+		meta, ok := m.fsm.Peer(id)
+		if !ok || meta.Priority <= m.priority || meta.MgmtAddr == "" {
+			continue
+		}
+		candidates = append(candidates, candidate{id: id, addr: string(server.Address), meta: meta})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].meta.Priority != candidates[j].meta.Priority {
+			return candidates[i].meta.Priority > candidates[j].meta.Priority
+		}
+		return m.sameZone(candidates[i].meta) && !m.sameZone(candidates[j].meta)
+	})
+
+	applied := m.node.Raft.AppliedIndex()
+	for _, c := range candidates {
+		status, err := m.status.fetch(c.meta.MgmtAddr)
+		if err != nil {
+			logger.Warn("Priority candidate unreachable", "member", c.id, "error", err)
+			continue
+		}
+		if !status.BackendHealthy || status.Draining || status.AppliedIndex+priorityMaxLag < applied {
+			continue
+		}
+
+		logger.Info("Transferring leadership to higher-priority voter", "member", c.id, "priority", c.meta.Priority, "own_priority", m.priority)
+		err = m.node.TransferLeadershipTo(c.id, c.addr)
+		m.node.AuditLog().Record(audit.Entry{Op: audit.OpTransferLeadership, Target: c.id, Address: c.addr, Initiator: "priority monitor"}, err)
+		if err != nil {
+			logger.Error("Leadership transfer failed", "member", c.id, "error", err)
+		}
+		return
+	}
+}
+
+// sameZone reports whether meta declares the same zone as this node.
+func (m *PriorityMonitor) sameZone(meta fsm.PeerMeta) bool {
+	return m.zone != "" && meta.Zone == m.zone
+}
@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+const (
+	// promoteCheckInterval is how often the leader checks learner progress.
+	promoteCheckInterval = 2 * time.Second
+	// promoteMaxLag is how many entries a learner may trail the leader's
+	// applied index by and still be considered caught up.
+	promoteMaxLag = 100
+	// promoteStableFor is how long a learner must stay caught up and
+	// healthy before it is promoted.
+	promoteStableFor = 10 * time.Second
+)
+
+// Promoter turns learners into voters once they have caught up, in the
+// manner of Consul's autopilot. While this node leads, it polls every
+// non-voter's management API and promotes those whose applied index has
+// stayed within promoteMaxLag of the leader's for promoteStableFor.
+// Read-only replicas are never promoted, and standbys only on request.
+type Promoter struct {
+	node   *raftnode.Node
+	fsm    *fsm.CppFSM
+	status *statusClient
+
+	// caughtUpSince records when each learner was first seen caught up.
+	caughtUpSince map[string]time.Time
+}
+
+// NewPromoter creates a Promoter.
+func NewPromoter(node *raftnode.Node, stateMachine *fsm.CppFSM) *Promoter {
+	return &Promoter{
+		node:          node,
+		fsm:           stateMachine,
+		status:        newStatusClient(),
+		caughtUpSince: make(map[string]time.Time),
+	}
+}
+
+// Start runs the promoter in a goroutine until ctx is cancelled.
+func (p *Promoter) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(promoteCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// check promotes every learner that has been caught up long enough.
+func (p *Promoter) check() {
+	if !p.node.IsLeader() {
+		clear(p.caughtUpSince)
+		return
+	}
+
+	learners, err := p.node.Nonvoters()
+	if err != nil {
+		logger.Warn("Promotion check failed to read configuration", "error", err)
+		return
+	}
+
+	applied := p.node.Raft.AppliedIndex()
+	seen := make(map[string]bool, len(learners))
+	for _, server := range learners {
+		id := string(server.ID)
+		meta, ok := p.fsm.Peer(id)
+		if !ok || meta.ReadOnly || meta.Standby || meta.MgmtAddr == "" {
+			continue
+		}
+		seen[id] = true
+
+		status, err := p.status.fetch(meta.MgmtAddr)
+		if err != nil || !status.BackendHealthy || status.AppliedIndex+promoteMaxLag < applied {
+			delete(p.caughtUpSince, id)
+			continue
+		}
+
+		since, ok := p.caughtUpSince[id]
+		if !ok {
+			p.caughtUpSince[id] = time.Now()
+			continue
+		}
+		if time.Since(since) < promoteStableFor {
+			continue
+		}
+
+		logger.Info("Promoting learner to voter", "member", id, "applied_index", status.AppliedIndex, "leader_applied_index", applied)
+		err = p.node.AddVoter(id, string(server.Address))
+		p.node.AuditLog().Record(audit.Entry{Op: audit.OpAddVoter, Target: id, Address: string(server.Address), Initiator: "promoter"}, err)
+		if err != nil {
+			logger.Error("Failed to promote learner", "member", id, "error", err)
+			continue
+		}
+		delete(p.caughtUpSince, id)
+	}
+
+	for id := range p.caughtUpSince {
+		if !seen[id] {
+			delete(p.caughtUpSince, id)
+		}
+	}
+}
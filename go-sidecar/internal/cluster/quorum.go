@@ -0,0 +1,238 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"my-raft-sidecar/internal/logging"
+	"my-raft-sidecar/internal/metrics"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// Cluster alert conditions.
+const (
+	// AlertNoLeader holds while this node has known no leader for longer
+	// than the monitor's threshold.
+	AlertNoLeader = "no_leader"
+	// AlertQuorumLost holds while this node leads but fewer voters than a
+	// quorum have answered it recently, so it is about to step down.
+	AlertQuorumLost = "quorum_lost"
+)
+
+// ClusterHealth is this node's view of whether the cluster can commit
+// writes.
+type ClusterHealth struct {
+	Healthy bool `json:"healthy"`
+	// Alerts are the alert conditions that hold, if any.
+	Alerts   []string `json:"alerts,omitempty"`
+	LeaderID string   `json:"leader_id,omitempty"`
+	// Leaderless is how long this node has known no leader.
+	Leaderless string `json:"leaderless,omitempty"`
+	// Voters is the number of voters in the configuration, and Quorum how
+	// many of them must be reachable. ReachableVoters, those that answered
+	// the leader within its lease timeout, is only known on the leader.
+	Voters          int  `json:"voters"`
+	Quorum          int  `json:"quorum"`
+	ReachableVoters *int `json:"reachable_voters,omitempty"`
+}
+
+// ClusterAlert is the JSON body posted to cluster alert webhooks.
+type ClusterAlert struct {
+	NodeID string    `json:"node_id"`
+	Status string    `json:"status"` // "firing" or "resolved"
+	Time   time.Time `json:"time"`
+	ClusterHealth
+}
+
+// QuorumMonitor watches for the outages that stop the cluster from
+// committing writes: no leader for longer than a threshold, and, on the
+// leader, fewer reachable voters than a quorum. It reports them on
+// /health?mode=cluster and in metrics, and posts them to webhooks when
+// they start, change and end.
+type QuorumMonitor struct {
+	node   *raftnode.Node
+	after  time.Duration
+	urls   []string
+	client *http.Client
+
+	mu              sync.Mutex
+	leaderlessSince time.Time
+	health          ClusterHealth
+	firing          bool
+	// alertChs hold the latest alert not yet taken by each URL's sender.
+	alertChs []chan ClusterAlert
+}
+
+// NewQuorumMonitor creates a monitor that raises AlertNoLeader once this
+// node has known no leader for the given duration, and posts alerts to
+// urls.
+func NewQuorumMonitor(node *raftnode.Node, after time.Duration, urls []string) *QuorumMonitor {
+	return &QuorumMonitor{
+		node:            node,
+		after:           after,
+		urls:            urls,
+		client:          &http.Client{Timeout: 5 * time.Second},
+		leaderlessSince: time.Now(),
+		health:          ClusterHealth{Healthy: true},
+	}
+}
+
+// Start runs the monitor, and a webhook sender per URL, in goroutines
+// until ctx is cancelled.
+func (m *QuorumMonitor) Start(ctx context.Context) {
+	for _, url := range m.urls {
+		alertCh := make(chan ClusterAlert, 1)
+		m.alertChs = append(m.alertChs, alertCh)
+		go m.send(ctx, url, alertCh)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Health returns the result of the last check.
+func (m *QuorumMonitor) Health() ClusterHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.health
+}
+
+// check evaluates the alert conditions, logging and posting changes.
+func (m *QuorumMonitor) check() {
+	health := ClusterHealth{LeaderID: m.node.LeaderID()}
+	reachable, voters, err := m.node.ReachableVoters()
+	if err == nil {
+		health.Voters, health.Quorum = voters, voters/2+1
+	}
+	if m.node.IsLeader() && err == nil {
+		health.ReachableVoters = &reachable
+		if reachable < health.Quorum {
+			health.Alerts = append(health.Alerts, AlertQuorumLost)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if health.LeaderID != "" {
+		m.leaderlessSince = time.Time{}
+	} else {
+		if m.leaderlessSince.IsZero() {
+			m.leaderlessSince = now
+		}
+		leaderless := now.Sub(m.leaderlessSince)
+		health.Leaderless = leaderless.Round(time.Second).String()
+		if leaderless > m.after {
+			health.Alerts = append(health.Alerts, AlertNoLeader)
+		}
+	}
+	health.Healthy = len(health.Alerts) == 0
+
+	changed := !slices.Equal(health.Alerts, m.health.Alerts)
+	m.health = health
+	if !changed {
+		return
+	}
+
+	status := "firing"
+	if health.Healthy {
+		if !m.firing {
+			return
+		}
+		status = "resolved"
+		logger.Info("Cluster alert resolved", "leader", health.LeaderID, "voters", health.Voters)
+	} else {
+		logger.Error("Cluster alert", "alerts", health.Alerts, "leaderless", health.Leaderless, "voters", health.Voters, "quorum", health.Quorum)
+	}
+	m.firing = !health.Healthy
+	m.publish(ClusterAlert{NodeID: m.node.ID(), Status: status, Time: now.UTC(), ClusterHealth: health})
+}
+
+// publish hands alert to every URL's sender, replacing any alert the
+// sender has not taken yet. m.mu must be held.
+func (m *QuorumMonitor) publish(alert ClusterAlert) {
+	for _, alertCh := range m.alertChs {
+		select {
+		case <-alertCh:
+		default:
+		}
+		alertCh <- alert
+	}
+}
+
+// send delivers the alerts received on alertCh to url. A failed call is
+// retried with backoff until it succeeds or a newer alert replaces it.
+func (m *QuorumMonitor) send(ctx context.Context, url string, alertCh <-chan ClusterAlert) {
+	for {
+		var alert ClusterAlert
+		select {
+		case alert = <-alertCh:
+		case <-ctx.Done():
+			return
+		}
+
+		backoff := time.Second
+		for {
+			err := postJSON(ctx, m.client, url, &alert)
+			if err == nil {
+				logger.Info("Notified cluster alert webhook", "url", logging.RedactURL(url), "status", alert.Status, "alerts", alert.Alerts)
+				break
+			}
+			logger.Warn("Cluster alert webhook failed", "url", logging.RedactURL(url), "status", alert.Status, "retry_in", backoff.String(), "error", err)
+
+			select {
+			case alert = <-alertCh:
+				backoff = time.Second
+			case <-time.After(backoff):
+				backoff = min(2*backoff, time.Minute)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Collect reports whether each alert condition holds, how long this node
+// has known no leader and, on the leader, how many voters it reaches.
+func (m *QuorumMonitor) Collect(w *metrics.Writer) {
+	m.mu.Lock()
+	health, leaderlessSince := m.health, m.leaderlessSince
+	m.mu.Unlock()
+
+	healthy := 0.0
+	if health.Healthy {
+		healthy = 1
+	}
+	w.Gauge("raftkv_cluster_healthy", "Whether this node sees a cluster able to commit writes (1) or an alert condition (0).", healthy)
+	for _, alert := range []string{AlertNoLeader, AlertQuorumLost} {
+		firing := 0.0
+		if slices.Contains(health.Alerts, alert) {
+			firing = 1
+		}
+		w.Gauge("raftkv_cluster_alert", "Whether the cluster alert condition holds on this node.", firing, "alert", alert)
+	}
+	leaderless := 0.0
+	if health.LeaderID == "" && !leaderlessSince.IsZero() {
+		leaderless = time.Since(leaderlessSince).Seconds()
+	}
+	w.Gauge("raftkv_leaderless_seconds", "Seconds this node has known no leader, or 0 while it knows one.", leaderless)
+	w.Gauge("raftkv_voters", "Number of voters in the cluster configuration.", float64(health.Voters))
+	if health.ReachableVoters != nil {
+		w.Gauge("raftkv_reachable_voters", "Voters that answered the leader within the leader lease timeout, including itself.", float64(*health.ReachableVoters))
+	}
+}
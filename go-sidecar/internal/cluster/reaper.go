@@ -0,0 +1,137 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+const (
+	// reapCheckInterval is how often the leader looks for dead servers.
+	reapCheckInterval = 10 * time.Second
+	// reapHealthyContact is how recently a voter must have answered the
+	// leader to count towards the quorum that survives a removal.
+	reapHealthyContact = 10 * time.Second
+)
+
+// Reaper removes servers that have been unreachable for too long, in the
+// spirit of Consul autopilot's dead server cleanup. While this node leads,
+// a server counts as dead once it has not answered an AppendEntries RPC for
+// Threshold (or since this node became leader, if it never has). A dead
+// voter is only removed if the remaining voters that are reachable still
+// form a quorum of the smaller configuration. Every removal is logged with
+// the reason.
+type Reaper struct {
+	node      *raftnode.Node
+	threshold time.Duration
+
+	// leaderSince is when this node was first seen leading in the current
+	// term; servers it never reached count as unreachable from then.
+	leaderSince time.Time
+	leaderTerm  uint64
+}
+
+// NewReaper creates a reaper removing servers unreachable for threshold.
+func NewReaper(node *raftnode.Node, threshold time.Duration) *Reaper {
+	return &Reaper{
+		node:      node,
+		threshold: threshold,
+	}
+}
+
+// Start runs the reaper in a goroutine until ctx is cancelled.
+func (r *Reaper) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(reapCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// check removes every dead server that can be removed safely.
+func (r *Reaper) check() {
+	if !r.node.IsLeader() {
+		r.leaderTerm = 0
+		return
+	}
+	if term := r.node.Raft.CurrentTerm(); term != r.leaderTerm {
+		r.leaderTerm = term
+		r.leaderSince = time.Now()
+	}
+
+	configuration, _, err := r.node.Configuration()
+	if err != nil {
+		logger.Warn("Reaper failed to read configuration", "error", err)
+		return
+	}
+	progress := r.node.PeerProgress()
+	now := time.Now()
+
+	lastContact := func(id raft.ServerID) time.Time {
+		if p, ok := progress[string(id)]; ok && !p.LastContact.IsZero() {
+			return p.LastContact
+		}
+		return r.leaderSince
+	}
+
+	voters, healthy := 0, 0
+	for _, server := range configuration.Servers {
+		if server.Suffrage != raft.Voter {
+			continue
+		}
+		voters++
+		if string(server.ID) == r.node.ID() || now.Sub(lastContact(server.ID)) < min(reapHealthyContact, r.threshold) {
+			healthy++
+		}
+	}
+
+	for _, server := range configuration.Servers {
+		if string(server.ID) == r.node.ID() {
+			continue
+		}
+		down := now.Sub(lastContact(server.ID))
+		if down < r.threshold {
+			continue
+		}
+
+		if server.Suffrage == raft.Voter {
+			if quorum := (voters-1)/2 + 1; healthy < quorum {
+				logger.Warn("Not removing dead voter: too few of the remaining voters are healthy", "member", server.ID, "address", server.Address,
+					"unreachable_for", down.Round(time.Second).String(), "healthy", healthy, "voters", voters-1)
+				continue
+			}
+		}
+
+		err := r.node.RemoveServer(string(server.ID))
+		r.node.AuditLog().Record(audit.Entry{Op: audit.OpRemove, Target: string(server.ID), Address: string(server.Address), Initiator: "reaper"}, err)
+		if err != nil {
+			logger.Error("Failed to remove dead server", "member", server.ID, "address", server.Address, "error", err)
+			return
+		}
+		logger.Info("Removed dead server", "member", server.ID, "address", server.Address,
+			"suffrage", suffrageName(server.Suffrage), "unreachable_for", down.Round(time.Second).String())
+		if server.Suffrage == raft.Voter {
+			voters--
+		}
+	}
+}
+
+// suffrageName describes a server's suffrage for log messages.
+func suffrageName(suffrage raft.ServerSuffrage) string {
+	if suffrage == raft.Voter {
+		return "voter"
+	}
+	return "non-voter"
+}
@@ -0,0 +1,241 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// replaceCheckInterval is how often a replacement checks the catch-up
+// progress of the new node.
+const replaceCheckInterval = time.Second
+
+// Steps of a replacement, in order.
+const (
+	ReplaceAdding     = "adding"
+	ReplaceCatchingUp = "catching_up"
+	ReplacePromoting  = "promoting"
+	ReplaceRemoving   = "removing"
+	ReplaceDone       = "done"
+	ReplaceFailed     = "failed"
+)
+
+// Replacement reports the progress of a node replacement.
+type Replacement struct {
+	ID         string `json:"id"`
+	OldID      string `json:"old_id"`
+	NewID      string `json:"new_id"`
+	NewAddress string `json:"new_address"`
+	// State is the current step; Error is set once it has failed.
+	State   string    `json:"state"`
+	Error   string    `json:"error,omitempty"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+	// Lag is how many entries the new node trails the leader's log by,
+	// while it catches up.
+	Lag uint64 `json:"lag"`
+}
+
+// Replacer replaces cluster members one step at a time: the new node is
+// added as a non-voter, promoted to voter once it has stayed within
+// promoteMaxLag entries of the leader's log for promoteStableFor, and the
+// old node is then removed. It runs on the leader; a replacement fails if
+// leadership is lost or the new node does not catch up in time, leaving
+// the cluster at whichever step it had reached.
+type Replacer struct {
+	node *raftnode.Node
+
+	mu     sync.Mutex
+	nextID int
+	ops    map[string]*Replacement
+}
+
+// NewReplacer creates a Replacer.
+func NewReplacer(node *raftnode.Node) *Replacer {
+	return &Replacer{
+		node: node,
+		ops:  make(map[string]*Replacement),
+	}
+}
+
+// Start validates and begins replacing oldID with newID at newAddress in a
+// goroutine. The new node must catch up within timeout. initiator is
+// recorded in the audit log for every step.
+func (r *Replacer) Start(oldID, newID, newAddress string, timeout time.Duration, initiator string) (Replacement, error) {
+	if oldID == newID {
+		return Replacement{}, errors.New("old and new node must differ")
+	}
+	if oldID == r.node.ID() {
+		return Replacement{}, errors.New("cannot replace the leader; transfer leadership first")
+	}
+	configuration, _, err := r.node.Configuration()
+	if err != nil {
+		return Replacement{}, err
+	}
+	var old *raft.Server
+	for i, server := range configuration.Servers {
+		switch string(server.ID) {
+		case oldID:
+			old = &configuration.Servers[i]
+		case newID:
+			if server.Suffrage == raft.Voter {
+				return Replacement{}, fmt.Errorf("%s is already a voter", newID)
+			}
+		}
+	}
+	if old == nil {
+		return Replacement{}, fmt.Errorf("unknown server %s", oldID)
+	}
+
+	r.mu.Lock()
+	for _, op := range r.ops {
+		if op.State != ReplaceDone && op.State != ReplaceFailed && (op.OldID == oldID || op.NewID == newID) {
+			r.mu.Unlock()
+			return Replacement{}, fmt.Errorf("replacement %s of %s is already in progress", op.ID, op.OldID)
+		}
+	}
+	r.nextID++
+	now := time.Now().UTC()
+	op := &Replacement{
+		ID:         strconv.Itoa(r.nextID),
+		OldID:      oldID,
+		NewID:      newID,
+		NewAddress: newAddress,
+		State:      ReplaceAdding,
+		Started:    now,
+		Updated:    now,
+	}
+	r.ops[op.ID] = op
+	snapshot := *op
+	r.mu.Unlock()
+
+	logger.Info("Replacing server", "operation", op.ID, "member", oldID, "replacement", newID, "address", newAddress)
+	go r.run(op, old.Suffrage, timeout, initiator)
+	return snapshot, nil
+}
+
+// Get returns the replacement with the given ID.
+func (r *Replacer) Get(id string) (Replacement, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	op, ok := r.ops[id]
+	if !ok {
+		return Replacement{}, false
+	}
+	return *op, true
+}
+
+// List returns every replacement started on this node, oldest first.
+func (r *Replacer) List() []Replacement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ops := make([]Replacement, 0, len(r.ops))
+	for _, op := range r.ops {
+		ops = append(ops, *op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Started.Before(ops[j].Started) })
+	return ops
+}
+
+// run carries out a replacement.
+func (r *Replacer) run(op *Replacement, oldSuffrage raft.ServerSuffrage, timeout time.Duration, initiator string) {
+	initiator = "replace " + op.ID + " by " + initiator
+
+	err := r.node.AddNonvoter(op.NewID, op.NewAddress)
+	r.node.AuditLog().Record(audit.Entry{Op: audit.OpAddNonvoter, Target: op.NewID, Address: op.NewAddress, Initiator: initiator}, err)
+	if err != nil {
+		r.fail(op, fmt.Errorf("failed to add %s: %w", op.NewID, err))
+		return
+	}
+
+	r.update(op, ReplaceCatchingUp, 0)
+	if err := r.awaitCatchUp(op, timeout); err != nil {
+		r.fail(op, err)
+		return
+	}
+
+	r.update(op, ReplacePromoting, 0)
+	err = r.node.AddVoter(op.NewID, op.NewAddress)
+	r.node.AuditLog().Record(audit.Entry{Op: audit.OpAddVoter, Target: op.NewID, Address: op.NewAddress, Initiator: initiator}, err)
+	if err != nil {
+		r.fail(op, fmt.Errorf("failed to promote %s: %w", op.NewID, err))
+		return
+	}
+
+	r.update(op, ReplaceRemoving, 0)
+	if oldSuffrage == raft.Voter {
+		if err := r.node.CheckRemoval(op.OldID); err != nil {
+			r.fail(op, err)
+			return
+		}
+	}
+	err = r.node.RemoveServer(op.OldID)
+	r.node.AuditLog().Record(audit.Entry{Op: audit.OpRemove, Target: op.OldID, Initiator: initiator}, err)
+	if err != nil {
+		r.fail(op, fmt.Errorf("failed to remove %s: %w", op.OldID, err))
+		return
+	}
+
+	r.update(op, ReplaceDone, 0)
+	logger.Info("Replaced server", "operation", op.ID, "member", op.OldID, "replacement", op.NewID)
+}
+
+// awaitCatchUp waits until the new node has stayed within promoteMaxLag
+// entries of the leader's log for promoteStableFor.
+func (r *Replacer) awaitCatchUp(op *Replacement, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var caughtUpSince time.Time
+
+	ticker := time.NewTicker(replaceCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !r.node.IsLeader() {
+			return errors.New("lost leadership")
+		}
+
+		lastIndex := r.node.Raft.LastIndex()
+		lag := lastIndex
+		if p, ok := r.node.PeerProgress()[op.NewID]; ok {
+			lag = lastIndex - min(p.MatchIndex, lastIndex)
+		}
+		r.update(op, ReplaceCatchingUp, lag)
+
+		if lag > promoteMaxLag {
+			caughtUpSince = time.Time{}
+		} else if caughtUpSince.IsZero() {
+			caughtUpSince = time.Now()
+		} else if time.Since(caughtUpSince) >= promoteStableFor {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not catch up within %s (%d entries behind)", op.NewID, timeout, lag)
+		}
+	}
+	return nil
+}
+
+func (r *Replacer) update(op *Replacement, state string, lag uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	op.State = state
+	op.Lag = lag
+	op.Updated = time.Now().UTC()
+}
+
+func (r *Replacer) fail(op *Replacement, err error) {
+	logger.Error("Replacement failed", "operation", op.ID, "member", op.OldID, "replacement", op.NewID, "error", err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	op.State = ReplaceFailed
+	op.Error = err.Error()
+	op.Updated = time.Now().UTC()
+}
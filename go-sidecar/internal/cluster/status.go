@@ -0,0 +1,51 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// peerStatus is the subset of a peer's /status response used to find
+// members and decide whether they are fit to lead or vote.
+type peerStatus struct {
+	NodeID         string `json:"node_id"`
+	RaftAddr       string `json:"raft_addr"`
+	Bootstrapped   bool   `json:"bootstrapped"`
+	AppliedIndex   uint64 `json:"applied_index"`
+	BackendHealthy bool   `json:"backend_healthy"`
+	Draining       bool   `json:"draining"`
+	Standby        bool   `json:"standby"`
+	ClusterID      string `json:"cluster_id"`
+	Version        string `json:"version"`
+}
+
+// statusClient polls the /status endpoint of other members.
+type statusClient struct {
+	client *http.Client
+}
+
+func newStatusClient() *statusClient {
+	return &statusClient{
+		client: NewManagementClient(2 * time.Second),
+	}
+}
+
+// fetch queries a peer's management API.
+func (c *statusClient) fetch(mgmtAddr string) (*peerStatus, error) {
+	resp, err := c.client.Get(ManagementURL(mgmtAddr, "/status"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var status peerStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("malformed status: %w", err)
+	}
+	return &status, nil
+}
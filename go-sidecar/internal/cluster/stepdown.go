@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/backend"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// StepDownMonitor transfers leadership away from this node when its
+// backend has been unhealthy for too long. A leader whose backend is down
+// keeps committing writes that are never applied locally, so reads served
+// by it would be stale.
+type StepDownMonitor struct {
+	node   *raftnode.Node
+	health *backend.HealthChecker
+	after  time.Duration
+}
+
+// NewStepDownMonitor creates a monitor that steps down once the backend
+// has been unhealthy for the given duration.
+func NewStepDownMonitor(node *raftnode.Node, health *backend.HealthChecker, after time.Duration) *StepDownMonitor {
+	return &StepDownMonitor{
+		node:   node,
+		health: health,
+		after:  after,
+	}
+}
+
+// Start runs the monitor in a goroutine until ctx is cancelled.
+func (m *StepDownMonitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// check transfers leadership if this node leads with an unhealthy backend.
+func (m *StepDownMonitor) check() {
+	unhealthyFor := m.health.UnhealthyFor()
+	if !m.node.IsLeader() || unhealthyFor < m.after {
+		return
+	}
+
+	logger.Warn("Backend unhealthy, transferring leadership", "unhealthy_for", unhealthyFor.Round(time.Second).String(), "error", m.health.LastError())
+	err := m.node.TransferLeadership()
+	m.node.AuditLog().Record(audit.Entry{Op: audit.OpTransferLeadership, Initiator: "backend health monitor"}, err)
+	if err != nil {
+		logger.Error("Leadership transfer failed", "error", err)
+	}
+}
@@ -0,0 +1,129 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"my-raft-sidecar/internal/logging"
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// LeaderWebhooks POSTs to external URLs whenever this node gains or loses
+// leadership, so that systems such as DNS updaters or alerting can follow
+// the leader without polling /status.
+type LeaderWebhooks struct {
+	node   *raftnode.Node
+	urls   []string
+	client *http.Client
+}
+
+// LeaderEvent is the JSON body posted to leadership webhooks.
+type LeaderEvent struct {
+	NodeID string    `json:"node_id"`
+	Role   string    `json:"role"` // "leader" or "follower"
+	Term   uint64    `json:"term"`
+	Time   time.Time `json:"time"`
+}
+
+// NewLeaderWebhooks creates a notifier posting to urls.
+func NewLeaderWebhooks(node *raftnode.Node, urls []string) *LeaderWebhooks {
+	return &LeaderWebhooks{
+		node:   node,
+		urls:   urls,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Start watches for leadership transitions in a goroutine per URL until ctx
+// is cancelled. A failed notification is retried with backoff until it
+// succeeds or is superseded by a newer transition, so a slow or failing
+// endpoint neither delays the others nor receives stale events.
+func (w *LeaderWebhooks) Start(ctx context.Context) {
+	for _, url := range w.urls {
+		leaderCh := w.node.SubscribeLeadership()
+		go w.run(ctx, url, leaderCh)
+	}
+}
+
+// run delivers the transitions received on leaderCh to url.
+func (w *LeaderWebhooks) run(ctx context.Context, url string, leaderCh <-chan bool) {
+	for {
+		var isLeader bool
+		select {
+		case isLeader = <-leaderCh:
+		case <-ctx.Done():
+			return
+		}
+
+		event := w.event(isLeader)
+		backoff := time.Second
+		for {
+			err := w.post(ctx, url, &event)
+			if err == nil {
+				logger.Info("Notified leadership webhook", "url", logging.RedactURL(url), "role", event.Role, "term", event.Term)
+				break
+			}
+			logger.Warn("Leadership webhook failed", "url", logging.RedactURL(url), "role", event.Role, "term", event.Term, "retry_in", backoff.String(), "error", err)
+
+			select {
+			case isLeader = <-leaderCh:
+				event = w.event(isLeader)
+				backoff = time.Second
+			case <-time.After(backoff):
+				backoff = min(2*backoff, time.Minute)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// event describes a transition to or from leadership in the current term.
+func (w *LeaderWebhooks) event(isLeader bool) LeaderEvent {
+	event := LeaderEvent{NodeID: w.node.ID(), Role: "follower", Term: w.node.Raft.CurrentTerm(), Time: time.Now().UTC()}
+	if isLeader {
+		event.Role = "leader"
+	}
+	return event
+}
+
+// post makes a single webhook call; any status other than 2xx fails.
+func (w *LeaderWebhooks) post(ctx context.Context, url string, event *LeaderEvent) error {
+	return postJSON(ctx, w.client, url, event)
+}
+
+// postJSON POSTs v as JSON to rawURL; any status other than 2xx fails.
+// Errors do not include the URL, which may carry credentials.
+func postJSON(ctx context.Context, client *http.Client, rawURL string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = logging.RedactURL(rawURL)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
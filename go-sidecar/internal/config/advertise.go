@@ -0,0 +1,133 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DetectAdvertise sets RaftAdvertise, if it is empty, to an address of
+// the interface named by AdvertiseInterface or, without one, to the
+// host's only private IPv4 address, or failing that its only public IPv4,
+// private IPv6 or public IPv6 address. More than one candidate of the
+// first kind found is refused rather than guessed at, since a wrong
+// guess breaks the cluster as surely as advertising 0.0.0.0. Validate
+// reports a failure. Parse calls it; development mode advertises loopback.
+func (c *Config) DetectAdvertise() {
+	if c.advertiseDetected || c.Dev {
+		return
+	}
+	c.advertiseErr = nil
+	if c.RaftAdvertise != "" {
+		if c.AdvertiseInterface != "" {
+			c.advertiseErr = errors.New("-advertise and -advertise-interface are mutually exclusive")
+		}
+		return
+	}
+	ip, err := detectAdvertise(c.AdvertiseInterface)
+	if err != nil {
+		c.advertiseErr = fmt.Errorf("-advertise is not set and could not be detected: %w", err)
+		return
+	}
+	c.RaftAdvertise = ip.String()
+	c.advertiseDetected = true
+	source := "detected"
+	if c.AdvertiseInterface != "" {
+		source = "detected on " + c.AdvertiseInterface
+	}
+	if c.settings != nil {
+		c.settings["advertise"] = c.RaftAdvertise
+		c.sources["advertise"] = source
+	}
+	logger.Info("Advertising detected address to other nodes; set -advertise to override", "address", c.RaftAdvertise, "source", source)
+}
+
+// detectAdvertise returns the address to advertise: the first IPv4, or
+// failing that IPv6, global unicast address of the named interface, or
+// without a name the host's only address of the first kind it has, in the
+// order DetectAdvertise gives.
+func detectAdvertise(name string) (net.IP, error) {
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("-advertise-interface %s: %w", name, err)
+		}
+		ips, err := interfaceIPs(iface)
+		if err != nil {
+			return nil, fmt.Errorf("-advertise-interface %s: %w", name, err)
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("-advertise-interface %s has no routable address", name)
+		}
+		return ips[0], nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+	// Private and public IPv4, then private and public IPv6
+	kinds := make([][]net.IP, 4)
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ips, err := interfaceIPs(iface)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			kind := 0
+			if ip.To4() == nil {
+				kind = 2
+			}
+			if !ip.IsPrivate() {
+				kind++
+			}
+			kinds[kind] = append(kinds[kind], ip)
+		}
+	}
+	for _, candidates := range kinds {
+		switch len(candidates) {
+		case 0:
+			continue
+		case 1:
+			return candidates[0], nil
+		default:
+			return nil, fmt.Errorf("found several addresses (%s); pick one with -advertise or -advertise-interface", joinIPs(candidates))
+		}
+	}
+	return nil, errors.New("found no routable address on any interface")
+}
+
+// interfaceIPs returns the global unicast addresses of iface, IPv4 first.
+func interfaceIPs(iface *net.Interface) ([]net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var v4, v6 []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ip := ipNet.IP.To4(); ip != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ipNet.IP)
+		}
+	}
+	return append(v4, v6...), nil
+}
+
+// joinIPs formats ips as a comma-separated list.
+func joinIPs(ips []net.IP) string {
+	names := make([]string, len(ips))
+	for i, ip := range ips {
+		names[i] = ip.String()
+	}
+	return strings.Join(names, ", ")
+}
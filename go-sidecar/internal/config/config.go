@@ -4,6 +4,43 @@ package config
 import (
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"my-raft-sidecar/internal/logging"
+)
+
+// logger logs with component=config.
+var logger = logging.Component("config")
+
+// MgmtTokenEnv names the environment variable holding the management API
+// bearer token when -mgmt-token-file is not set.
+const MgmtTokenEnv = "RAFTKV_MGMT_TOKEN"
+
+// EncryptionKeyEnv names the environment variable holding the log store
+// encryption keys when -encryption-key-file is not set.
+const EncryptionKeyEnv = "RAFTKV_ENCRYPTION_KEY"
+
+// JoinMethod is the gRPC method nodes join through, which checks the
+// cluster token itself and is exempt from gRPC authentication by default.
+const JoinMethod = "/consensus.Admin/Join"
+
+// ClusterTokenEnv and JoinTokenEnv name the environment variables holding
+// the cluster and join tokens when neither the flag nor its -file variant
+// is set.
+const (
+	ClusterTokenEnv = "RAFTKV_CLUSTER_TOKEN"
+	JoinTokenEnv    = "RAFTKV_JOIN_TOKEN"
+)
+
+// VaultAddrEnv and VaultTokenEnv are Vault's standard environment
+// variables, used when -vault-addr and -vault-token-file are not set.
+const (
+	VaultAddrEnv  = "VAULT_ADDR"
+	VaultTokenEnv = "VAULT_TOKEN"
 )
 
 // Config holds all configuration values for the sidecar application.
@@ -17,66 +54,846 @@ type Config struct {
 	DataDir       string
 	JoinAddr      string
 	RaftAdvertise string
-}
+	// AdvertiseInterface names the network interface whose address is
+	// advertised when RaftAdvertise is empty (see DetectAdvertise).
+	AdvertiseInterface string
+	CDCBackend         string
+	CDCURL             string
+	CDCURLFile         string
+	CDCTopic           string
+	ProxyReads         bool
+	ReadOnly           bool
+	ForwardProposals   bool
+	// Dev runs a single in-memory node for local development (see
+	// applyDev); its data directory is removed on exit. CheckConfig makes
+	// the sidecar binary check the configuration (see sidecar.Check) and
+	// exit instead of running, and Version print its version and exit.
+	Dev               bool
+	CheckConfig       bool
+	Version           bool
+	ProposeTimeout    time.Duration
+	HealthInterval    time.Duration
+	StepDownAfter     time.Duration
+	Priority          int
+	LeaveOnShutdown   bool
+	Nonvoter          bool
+	Standby           bool
+	Zone              string
+	Rack              string
+	AutoPromote       bool
+	JoinRPCAddr       string
+	ClusterToken      string
+	ClusterTokenFile  string
+	JoinToken         string
+	JoinTokenFile     string
+	BootstrapExpect   int
+	RetryJoin         []string
+	Peers             []string
+	Discovery         string
+	DiscoveryFile     string
+	ReapDeadServers   bool
+	ReapAfter         time.Duration
+	JoinMaxElapsed    time.Duration
+	JoinExitOnFailure bool
+	WipeAndRejoin     bool
+	RaftTLSCert       string
+	RaftTLSKey        string
+	RaftTLSCA         string
+	RaftMTLS          bool
+	// RaftClusterCheck makes Raft connections open with the cluster ID
+	// preamble; nodes accept connections with or without it.
+	RaftClusterCheck  bool
+	RaftAllowedPeers  []string
+	MgmtTLSCert       string
+	MgmtTLSKey        string
+	MgmtTLSCA         string
+	MgmtMTLS          bool
+	MgmtTokenFile     string
+	GRPCAPIKeysFile   string
+	GRPCJWTKeyFile    string
+	GRPCJWTIssuer     string
+	GRPCJWTAudience   string
+	GRPCAuthExempt    []string
+	RBACPolicy        string
+	RBACJWTClaim      string
+	TLSReloadInterval time.Duration
+	SPIFFESocket      string
+	EncryptionKeyFile string
+	// Rate limits, as "<requests per second>[:<burst>]"; empty is unlimited.
+	ProposeRateLimit       string
+	ProposeClientRateLimit string
+	JoinRateLimit          string
+	JoinClientRateLimit    string
+
+	// Durations past which an apply or a proposal is logged and counted as
+	// slow; zero disables the check.
+	SlowApplyThreshold   time.Duration
+	SlowProposeThreshold time.Duration
+
+	// TraceLogEntries records the trace context and origin node of each
+	// proposal in its log entry.
+	TraceLogEntries bool
+
+	// ProposeAuditLog, if set, is the file Propose calls are recorded in,
+	// sampling successful calls at ProposeAuditSampleRate.
+	ProposeAuditLog        string
+	ProposeAuditSampleRate float64
+
+	MgmtBind         string
+	MgmtAllowedCIDRs []string
 
-// flags holds the command-line flag pointers
-var flags struct {
-	nodeID        *string
-	raftPort      *string
-	sidecarPort   *string
-	appAddr       *string
-	mgmtPort      *string
-	bootstrap     *bool
-	dataDir       *string
-	joinAddr      *string
-	raftAdvertise *string
+	// Bind and advertised addresses of the sidecar gRPC and management
+	// listeners, for nodes reached through NAT or published container
+	// ports. The advertised addresses default to -advertise and the ports.
+	SidecarBind      string
+	SidecarAdvertise string
+	MgmtAdvertise    string
+
+	// Unix domain sockets the sidecar gRPC and management APIs are served
+	// on as well, for clients on the same host.
+	SidecarSocket string
+	MgmtSocket    string
+
+	VaultAddr      string
+	VaultTokenFile string
+	VaultCACert    string
+	VaultPKIMount  string
+	VaultPKIRole   string
+	VaultCertTTL   time.Duration
+	VaultAltNames  []string
+
+	CommandACL string
+
+	CommandSigningKeys    string
+	AllowUnsignedCommands bool
+
+	// Raft tunables, all but the last five of which Reload can change at
+	// runtime. LogLevel is the minimum level of the log output, the
+	// sidecar's and, unless RaftLogLevel is set, the Raft library's.
+	// LogSync syncs the log store to disk on every write, and BatchApply
+	// buffers proposals so that they are committed in batches. Profile is
+	// the preset the unset ones were taken from (see profiles).
+	LogLevel           string
+	RaftLogLevel       string
+	SnapshotThreshold  uint64
+	SnapshotInterval   time.Duration
+	TrailingLogs       uint64
+	HeartbeatTimeout   time.Duration
+	ElectionTimeout    time.Duration
+	LeaderLeaseTimeout time.Duration
+	CommitTimeout      time.Duration
+	MaxAppendEntries   int
+	LogSync            bool
+	BatchApply         bool
+	Profile            string
+
+	// LogFormat is the format of the log output, console or json.
+	LogFormat string
+
+	// LogFile, if set, is a file the log output is written to as well as
+	// stderr. It is rotated once it reaches LogMaxSize megabytes or has
+	// been written to for LogMaxAge, whichever is set, and LogMaxBackups
+	// rotated files are kept, gzipped if LogCompress is set.
+	LogFile       string
+	LogMaxSize    int
+	LogMaxAge     time.Duration
+	LogMaxBackups int
+	LogCompress   bool
+
+	// StatsdAddr is the statsd server (host:port) the Raft library's
+	// telemetry and the sidecar's metrics are sent to, in addition to
+	// /metrics, in StatsdFormat. StatsdTags are added to every metric in
+	// the DogStatsD format, and the sidecar's metrics are pushed every
+	// StatsdInterval.
+	StatsdAddr     string
+	StatsdFormat   string
+	StatsdTags     []string
+	StatsdInterval time.Duration
+
+	// LeaderWebhooks are the URLs POSTed to whenever this node gains or
+	// loses leadership.
+	LeaderWebhooks     []string
+	LeaderWebhooksFile string
+	// LeaderlessAlertAfter is how long this node may know no leader before
+	// it raises a cluster alert, posted to ClusterAlertWebhooks.
+	LeaderlessAlertAfter     time.Duration
+	ClusterAlertWebhooks     []string
+	ClusterAlertWebhooksFile string
+
+	// ConfigFile is the configuration file the settings were read from,
+	// Env the environment whose overlay of the file applied, fromFile the
+	// source of each flag the file set, preset the flags -profile set and
+	// commandLine the values of the flags given on the command line.
+	// overrides are the settings changed at runtime (see Patch), settings
+	// the value of every flag and sources where each came from.
+	// temporaryDataDir is set when Parse created DataDir for -dev, and
+	// advertiseDetected or advertiseErr by DetectAdvertise.
+	ConfigFile        string
+	Env               string
+	fromFile          map[string]string
+	preset            map[string]string
+	commandLine       map[string]string
+	overrides         map[string]string
+	settings          map[string]string
+	sources           map[string]string
+	temporaryDataDir  bool
+	advertiseDetected bool
+	advertiseErr      error
 }
 
+// flagSet holds the sidecar's flags. It is separate from flag.CommandLine,
+// so that programs embedding the sidecar keep their own flags.
+var flagSet = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+// flags are the flag pointers of flagSet.
+var flags = defineFlags(flagSet)
+
 func init() {
-	flags.nodeID = flag.String("id", "node1", "Unique Node ID")
-	flags.raftPort = flag.String("raft", "8088", "Raft TCP Port")
-	flags.sidecarPort = flag.String("srv", "50052", "Sidecar gRPC Port")
-	flags.appAddr = flag.String("app", "localhost:50051", "Address of C++ App gRPC")
-	flags.mgmtPort = flag.String("mgmt", "6000", "Management HTTP Port")
-	flags.bootstrap = flag.Bool("bootstrap", false, "Bootstrap the cluster (Leader only)")
-	flags.dataDir = flag.String("data", "raft-data", "Directory to store Raft logs")
-	flags.joinAddr = flag.String("join", "", "Address of Leader's Management API to join")
-	flags.raftAdvertise = flag.String("advertise", "", "Address to advertise to other nodes")
-}
-
-// Parse parses command-line flags and returns a Config.
-func Parse() *Config {
-	flag.Parse()
+	// Invalid flags print the program's usage, as on flag.CommandLine.
+	flagSet.Usage = func() { flag.Usage() }
+}
+
+// flagPointers holds the command-line flag pointers
+type flagPointers struct {
+	nodeID            *string
+	raftPort          *string
+	sidecarPort       *string
+	appAddr           *string
+	mgmtPort          *string
+	bootstrap         *bool
+	dataDir           *string
+	joinAddr          *string
+	raftAdvertise     *string
+	advertiseIface    *string
+	cdcBackend        *string
+	cdcURL            *string
+	cdcURLFile        *string
+	cdcTopic          *string
+	proxyReads        *bool
+	readOnly          *bool
+	forwardProposals  *bool
+	dev               *bool
+	checkConfig       *bool
+	version           *bool
+	proposeTimeout    *time.Duration
+	slowApply         *time.Duration
+	slowPropose       *time.Duration
+	traceLogEntries   *bool
+	proposeAuditLog   *string
+	proposeAuditRate  *float64
+	healthInterval    *time.Duration
+	stepDownAfter     *time.Duration
+	priority          *int
+	leaveOnShutdown   *bool
+	nonvoter          *bool
+	standby           *bool
+	zone              *string
+	rack              *string
+	autoPromote       *bool
+	joinRPCAddr       *string
+	clusterToken      *string
+	clusterTokenFile  *string
+	joinToken         *string
+	joinTokenFile     *string
+	bootstrapExpect   *int
+	retryJoin         *string
+	peers             *string
+	reapDeadServers   *bool
+	reapAfter         *time.Duration
+	discovery         *string
+	discoveryFile     *string
+	joinMaxElapsed    *time.Duration
+	joinExitOnFailure *bool
+	wipeAndRejoin     *bool
+	raftTLSCert       *string
+	raftTLSKey        *string
+	raftTLSCA         *string
+	raftMTLS          *bool
+	raftClusterCheck  *bool
+	raftAllowedPeers  *string
+	mgmtTLSCert       *string
+	mgmtTLSKey        *string
+	mgmtTLSCA         *string
+	mgmtMTLS          *bool
+	mgmtTokenFile     *string
+	grpcAPIKeysFile   *string
+	grpcJWTKeyFile    *string
+	grpcJWTIssuer     *string
+	grpcJWTAudience   *string
+	grpcAuthExempt    *string
+	rbacPolicy        *string
+	rbacJWTClaim      *string
+	tlsReloadInterval *time.Duration
+	spiffeSocket      *string
+	encryptionKeyFile *string
+
+	proposeRateLimit       *string
+	proposeClientRateLimit *string
+	joinRateLimit          *string
+	joinClientRateLimit    *string
+
+	mgmtBind         *string
+	sidecarBind      *string
+	sidecarAdvertise *string
+	mgmtAdvertise    *string
+	sidecarSocket    *string
+	mgmtSocket       *string
+	mgmtAllowedCIDRs *string
+
+	vaultAddr      *string
+	vaultTokenFile *string
+	vaultCACert    *string
+	vaultPKIMount  *string
+	vaultPKIRole   *string
+	vaultCertTTL   *time.Duration
+	vaultAltNames  *string
+
+	commandACL *string
+
+	commandSigningKeys    *string
+	allowUnsignedCommands *bool
+
+	logLevel           *string
+	logFormat          *string
+	logFile            *string
+	logMaxSize         *int
+	logMaxAge          *time.Duration
+	logMaxBackups      *int
+	logCompress        *bool
+	raftLogLevel       *string
+	snapshotThreshold  *uint64
+	snapshotInterval   *time.Duration
+	trailingLogs       *uint64
+	heartbeatTimeout   *time.Duration
+	electionTimeout    *time.Duration
+	leaderLeaseTimeout *time.Duration
+	commitTimeout      *time.Duration
+	maxAppendEntries   *int
+	logSync            *bool
+	batchApply         *bool
+	profile            *string
+	statsdAddr         *string
+	statsdFormat       *string
+	statsdTags         *string
+	statsdInterval     *time.Duration
+	leaderWebhooks     *string
+	leaderWebhooksFile *string
+	leaderlessAlert    *time.Duration
+	clusterAlertHooks  *string
+	clusterAlertFile   *string
+
+	configFile *string
+	env        *string
+}
+
+func defineFlags(fs *flag.FlagSet) *flagPointers {
+	flags := &flagPointers{}
+	flags.configFile = fs.String("config", "", "YAML (.yaml, .yml) or TOML (.toml) file setting any of these flags by name; command-line flags and RAFTKV_ variables override it")
+	flags.env = fs.String("env", "", "Environment, such as prod, whose overlay in the environments section of -config applies over the rest of the file")
+	flags.nodeID = fs.String("id", "node1", "Unique Node ID")
+	flags.raftPort = fs.String("raft", "8088", "Raft TCP Port")
+	flags.sidecarPort = fs.String("srv", "50052", "Sidecar gRPC Port, or off to serve the gRPC API only on -srv-socket")
+	flags.appAddr = fs.String("app", "localhost:50051", "Address of C++ App gRPC")
+	flags.mgmtPort = fs.String("mgmt", "6000", "Management HTTP Port, or off to serve the management API only on -mgmt-socket")
+	flags.bootstrap = fs.Bool("bootstrap", false, "Bootstrap the cluster (Leader only)")
+	flags.dataDir = fs.String("data", "raft-data", "Directory to store Raft logs")
+	flags.joinAddr = fs.String("join", "", "Address of Leader's Management API to join")
+	flags.joinRPCAddr = fs.String("join-rpc", "", "Sidecar gRPC address of any cluster member to join through the Admin service (instead of -join)")
+	flags.joinMaxElapsed = fs.Duration("join-max-elapsed", 5*time.Minute, "Give up joining after retrying this long (0 retries indefinitely)")
+	flags.joinExitOnFailure = fs.Bool("join-exit-on-failure", false, "Exit with a non-zero status if joining fails instead of running un-joined")
+	flags.wipeAndRejoin = fs.Bool("wipe-and-rejoin", false, "Delete the local Raft log and snapshots and the backend's keys, then rejoin through -join or -join-rpc as a learner that is promoted once caught up")
+	flags.clusterToken = fs.String("cluster-token", "", "Shared secret required to join the cluster (prefer -cluster-token-file or "+ClusterTokenEnv+", which stay out of the process list)")
+	flags.clusterTokenFile = fs.String("cluster-token-file", "", "File holding -cluster-token")
+	flags.joinToken = fs.String("join-token", "", "Join token minted by /join-token to present instead of -cluster-token when joining (prefer -join-token-file or "+JoinTokenEnv+")")
+	flags.joinTokenFile = fs.String("join-token-file", "", "File holding -join-token")
+	flags.bootstrapExpect = fs.Int("bootstrap-expect", 0, "Bootstrap automatically once this many servers have discovered each other")
+	flags.retryJoin = fs.String("retry-join", "", "Comma-separated management addresses of peers to discover for -bootstrap-expect or to join")
+	flags.peers = fs.String("peers", "", "Comma-separated Raft addresses (host:port or id=host:port) of every server, to bootstrap them together on first start")
+	flags.discovery = fs.String("discovery", "", `Discover peers instead of using -retry-join, e.g. "dns name=raftkv.internal port=6000"`)
+	flags.discoveryFile = fs.String("discovery-file", "", "File holding -discovery, for settings with credentials")
+	flags.raftTLSCert = fs.String("raft-tls-cert", "", "PEM certificate presented on Raft connections; enables TLS on the Raft transport")
+	flags.raftTLSKey = fs.String("raft-tls-key", "", "PEM private key of -raft-tls-cert")
+	flags.raftTLSCA = fs.String("raft-tls-ca", "", "PEM CA bundle that peers' Raft certificates are verified against (system roots if empty)")
+	flags.raftMTLS = fs.Bool("raft-mtls", false, "Require peers to present a Raft certificate signed by -raft-tls-ca on incoming connections")
+	flags.raftClusterCheck = fs.Bool("raft-cluster-check", false, "Open Raft connections with this node's cluster ID so that nodes of other clusters refuse them; enable once every node runs a version that accepts it")
+	flags.raftAllowedPeers = fs.String("raft-allowed-peers", "", "Comma-separated identities (CN, DNS SAN or URI SAN such as a SPIFFE ID; a trailing * matches a prefix) allowed on Raft connections")
+	flags.mgmtTLSCert = fs.String("mgmt-tls-cert", "", "PEM certificate of the management API; serves it over HTTPS")
+	flags.mgmtTLSKey = fs.String("mgmt-tls-key", "", "PEM private key of -mgmt-tls-cert")
+	flags.mgmtTLSCA = fs.String("mgmt-tls-ca", "", "PEM CA bundle that management API certificates, and client certificates with -mgmt-mtls, are verified against (system roots if empty)")
+	flags.mgmtMTLS = fs.Bool("mgmt-mtls", false, "Require clients of the management API to present a certificate signed by -mgmt-tls-ca")
+	flags.mgmtTokenFile = fs.String("mgmt-token-file", "", "File holding the bearer token required on the management API (overrides "+MgmtTokenEnv+")")
+	flags.grpcAPIKeysFile = fs.String("grpc-api-keys-file", "", "File of API keys accepted on the sidecar gRPC API, one per line with an optional name; enables authentication")
+	flags.grpcJWTKeyFile = fs.String("grpc-jwt-key-file", "", "PEM public key (RS256/ES256) or HS256 secret that JWTs on the sidecar gRPC API are verified with; enables authentication")
+	flags.grpcJWTIssuer = fs.String("grpc-jwt-issuer", "", "Required iss claim of JWTs")
+	flags.grpcJWTAudience = fs.String("grpc-jwt-audience", "", "Required aud claim of JWTs")
+	flags.grpcAuthExempt = fs.String("grpc-auth-exempt", JoinMethod, "Comma-separated full gRPC method names callable without credentials; "+JoinMethod+" requires -cluster-token, which it checks instead")
+	flags.rbacPolicy = fs.String("rbac-policy", "", `File of "<role> <identity>" rules granting admin, writer or reader roles on the gRPC and management APIs`)
+	flags.rbacJWTClaim = fs.String("rbac-jwt-claim", "roles", "JWT claim that may list the caller's roles")
+	flags.tlsReloadInterval = fs.Duration("tls-reload-interval", time.Minute, "How often to check TLS certificate, key and CA files for rotation (0 disables; SIGHUP always reloads)")
+	flags.spiffeSocket = fs.String("spiffe-socket", "", "SPIFFE Workload API socket (e.g. unix:///run/spire/sockets/agent.sock) to fetch the Raft and management API certificates from, instead of the -raft-tls-* and -mgmt-tls-* files")
+	flags.encryptionKeyFile = fs.String("encryption-key-file", "", "File of base64 AES-256 keys, one per line, encrypting the Raft log store at rest; the first encrypts new entries (overrides "+EncryptionKeyEnv+")")
+	flags.proposeRateLimit = fs.String("propose-rate-limit", "", `Limit proposals received by this node from all clients together, as "<per second>[:<burst>]"`)
+	flags.proposeClientRateLimit = fs.String("propose-client-rate-limit", "", `Limit proposals received by this node from each client (authenticated identity or IP), as "<per second>[:<burst>]"`)
+	flags.joinRateLimit = fs.String("join-rate-limit", "", `Limit join requests received by this node from all clients together, as "<per second>[:<burst>]"`)
+	flags.joinClientRateLimit = fs.String("join-client-rate-limit", "", `Limit join requests received by this node from each client IP, as "<per second>[:<burst>]"`)
+	flags.mgmtBind = fs.String("mgmt-bind", "", "IP address of the interface to serve the management API on (all interfaces if empty)")
+	flags.sidecarBind = fs.String("srv-bind", "", "IP address of the interface to serve the sidecar gRPC API on (all interfaces if empty)")
+	flags.sidecarAdvertise = fs.String("srv-advertise", "", "Sidecar gRPC address (host or host:port) other nodes and clients reach this node at, if not -advertise and -srv")
+	flags.sidecarSocket = fs.String("srv-socket", "", "Unix domain socket to serve the sidecar gRPC API on as well, for clients on the same host")
+	flags.mgmtSocket = fs.String("mgmt-socket", "", "Unix domain socket to serve the management API on as well, for clients on the same host")
+	flags.mgmtAdvertise = fs.String("mgmt-advertise", "", "Management API address (host or host:port) other nodes reach this node at, if not -advertise and -mgmt")
+	flags.mgmtAllowedCIDRs = fs.String("mgmt-allowed-cidrs", "", "Comma-separated CIDRs or IP addresses allowed to call the management API, besides loopback (anyone if empty)")
+	flags.vaultAddr = fs.String("vault-addr", os.Getenv(VaultAddrEnv), "Vault address for -vault-pki-role (defaults to "+VaultAddrEnv+")")
+	flags.vaultTokenFile = fs.String("vault-token-file", "", "File holding the Vault token (overrides "+VaultTokenEnv+")")
+	flags.vaultCACert = fs.String("vault-ca-cert", "", "PEM CA bundle that Vault's certificate is verified against (system roots if empty)")
+	flags.vaultPKIMount = fs.String("vault-pki-mount", "pki", "Path of the Vault PKI secrets engine")
+	flags.vaultPKIRole = fs.String("vault-pki-role", "", "Vault PKI role to issue the Raft and management API certificates from, instead of the -raft-tls-* and -mgmt-tls-* files")
+	flags.vaultCertTTL = fs.Duration("vault-cert-ttl", 24*time.Hour, "Requested lifetime of certificates issued by Vault; they are renewed after two thirds of it")
+	flags.vaultAltNames = fs.String("vault-alt-names", "", "Comma-separated extra host names or IP addresses to request in certificates issued by Vault")
+	flags.commandACL = fs.String("command-acl", "", `File of "<identity> <ops> <key>" rules restricting the commands each gRPC client may propose`)
+	flags.commandSigningKeys = fs.String("command-signing-keys", "", `File of "<key id> <base64 key>" HMAC keys commands must be signed with (disabled if empty)`)
+	flags.allowUnsignedCommands = fs.Bool("allow-unsigned-commands", false, "Accept unsigned commands while clients move to signing them; signed ones are still checked")
+	flags.logLevel = fs.String("log-level", "info", "Minimum level of the log output, the sidecar's and, unless -raft-log-level is set, the Raft library's: trace, debug, info, warn or error (reloadable)")
+	flags.raftLogLevel = fs.String("raft-log-level", "", "Minimum level of the Raft library's log output, if it should differ from -log-level: trace, debug, info, warn or error (reloadable)")
+	flags.logFormat = fs.String("log-format", "console", "Format of the log output: console (key=value pairs) or json (one object per line)")
+	flags.logFile = fs.String("log-file", "", "File to write the log output to as well as stderr, rotated by -log-max-size and -log-max-age")
+	flags.logMaxSize = fs.Int("log-max-size", 100, "Size in megabytes at which -log-file is rotated (0 disables rotation by size)")
+	flags.logMaxAge = fs.Duration("log-max-age", 0, "How long -log-file is written to before it is rotated, such as 24h (0 disables rotation by age)")
+	flags.logMaxBackups = fs.Int("log-max-backups", 10, "Rotated log files to keep, removing the oldest (0 keeps them all)")
+	flags.logCompress = fs.Bool("log-compress", true, "Gzip rotated log files")
+	flags.snapshotThreshold = fs.Uint64("snapshot-threshold", 8192, "Take a Raft snapshot, compacting the log, once this many entries were applied since the last (reloadable)")
+	flags.snapshotInterval = fs.Duration("snapshot-interval", 2*time.Minute, "How often to check whether -snapshot-threshold was reached (reloadable)")
+	flags.trailingLogs = fs.Uint64("trailing-logs", 10240, "Log entries to keep after a snapshot, so that slightly lagging followers catch up without one (reloadable)")
+	flags.heartbeatTimeout = fs.Duration("heartbeat-timeout", time.Second, "How long a follower waits without contact from the leader before starting an election (reloadable)")
+	flags.electionTimeout = fs.Duration("election-timeout", time.Second, "How long a candidate waits for votes before starting a new election (reloadable)")
+	flags.leaderLeaseTimeout = fs.Duration("leader-lease-timeout", 500*time.Millisecond, "How long a leader keeps leading without contact from a quorum; at most -heartbeat-timeout")
+	flags.commitTimeout = fs.Duration("commit-timeout", 50*time.Millisecond, "How long the leader waits before sending a heartbeat that carries the commit index when it has nothing to replicate")
+	flags.maxAppendEntries = fs.Int("max-append-entries", 64, "Most log entries sent to a follower in one AppendEntries request (at most 1024)")
+	flags.logSync = fs.Bool("log-sync", true, "Sync the Raft log to disk on every write; without it an OS crash or power loss can lose acknowledged entries")
+	flags.batchApply = fs.Bool("batch-apply", false, "Buffer proposals so that the leader commits up to -max-append-entries of them together, for throughput at some cost in latency")
+	flags.statsdAddr = fs.String("statsd-addr", "", "statsd server (host:port) to send the Raft library's telemetry and the sidecar's metrics to over UDP, in addition to /metrics")
+	flags.statsdFormat = fs.String("statsd-format", "statsd", "Format of the metrics sent to -statsd-addr: statsd, or dogstatsd for tags")
+	flags.statsdTags = fs.String("statsd-tags", "", "Comma-separated key:value tags added to every metric sent to -statsd-addr (dogstatsd format only)")
+	flags.statsdInterval = fs.Duration("statsd-interval", 10*time.Second, "How often the sidecar's metrics are pushed to -statsd-addr; the Raft library's telemetry is sent as it is emitted")
+	flags.leaderWebhooks = fs.String("leader-webhooks", "", "Comma-separated http(s) URLs to POST the node ID, role and term to whenever this node gains or loses leadership")
+	flags.leaderWebhooksFile = fs.String("leader-webhooks-file", "", "File holding -leader-webhooks, one URL per line or comma-separated")
+	flags.leaderlessAlert = fs.Duration("leaderless-alert-after", 10*time.Second, "Raise a cluster alert once this node has known no leader for this long")
+	flags.clusterAlertHooks = fs.String("cluster-alert-webhooks", "", "Comma-separated http(s) URLs to POST cluster alerts to (no leader, or the leader reaching fewer voters than a quorum) when they fire, change and resolve")
+	flags.clusterAlertFile = fs.String("cluster-alert-webhooks-file", "", "File holding -cluster-alert-webhooks, one URL per line or comma-separated")
+	flags.profile = fs.String("profile", "balanced", "Tuning preset for the Raft timeouts, log syncing, batching and snapshots: low-latency, balanced or durable; flags given otherwise override it")
+	flags.raftAdvertise = fs.String("advertise", "", "Address to advertise to other nodes (detected if empty; see -advertise-interface)")
+	flags.advertiseIface = fs.String("advertise-interface", "", "Network interface whose address is advertised when -advertise is empty, instead of the host's only routable address")
+	flags.cdcBackend = fs.String("cdc", "", "Export applied entries to a broker: nats or kafka (disabled if empty)")
+	flags.cdcURL = fs.String("cdc-url", "", "Broker URL: nats://host:4222 or the Kafka REST Proxy base URL")
+	flags.cdcURLFile = fs.String("cdc-url-file", "", "File holding -cdc-url, for URLs with credentials")
+	flags.cdcTopic = fs.String("cdc-topic", "raftkv.changes", "NATS subject or Kafka topic for exported entries")
+	flags.proxyReads = fs.Bool("proxy-reads", true, "Proxy linearizable reads received by a follower to the leader")
+	flags.forwardProposals = fs.Bool("forward-proposals", true, "Forward proposals received by a follower to the leader")
+	flags.dev = fs.Bool("dev", false, "Run a single-node cluster for local development: in-memory Raft log, built-in key-value backend instead of -app, and every listener on 127.0.0.1 at a free port unless given")
+	flags.version = fs.Bool("version", false, "Print the version and build information, then exit")
+	flags.checkConfig = fs.Bool("check-config", false, "Validate the configuration, resolve its addresses and load its TLS material, keys and policies, then exit with status 0 if it is valid or 1 if not, without starting Raft")
+	flags.proposeTimeout = fs.Duration("propose-timeout", 5*time.Second, "How long a proposal may wait to enter the leader's Raft log, or the client's deadline if sooner; proposals that time out fail with ABORTED")
+	flags.slowApply = fs.Duration("slow-apply-threshold", 500*time.Millisecond, "Log a warning and count a log entry whose apply, or the backend's Apply call for it, takes longer than this (0 disables)")
+	flags.slowPropose = fs.Duration("slow-propose-threshold", 2*time.Second, "Log a warning and count a proposal that takes longer than this to be committed and applied on the leader (0 disables)")
+	flags.traceLogEntries = fs.Bool("trace-log-entries", false, "Record the W3C trace context (from the client's traceparent, or generated) and origin node of each proposal in its log entry, and pass them to the backend when it is applied; enable on every node")
+	flags.proposeAuditLog = fs.String("propose-audit-log", "", "File to record Propose calls from clients in, one JSON object per line with the caller, command size, dedup key, commit index and latency (empty disables)")
+	flags.proposeAuditRate = fs.Float64("propose-audit-sample-rate", 1, "Fraction of successful Propose calls recorded in -propose-audit-log, from 0 to 1; failed calls are always recorded")
+	flags.healthInterval = fs.Duration("backend-health-interval", 2*time.Second, "Interval between backend health probes")
+	flags.stepDownAfter = fs.Duration("stepdown-after", 10*time.Second, "Transfer leadership after the backend has been unhealthy this long (0 disables)")
+	flags.priority = fs.Int("priority", 0, "Leadership priority; leadership moves to the healthiest caught-up voter with the highest priority")
+	flags.leaveOnShutdown = fs.Bool("leave-on-shutdown", false, "On SIGTERM, hand off leadership and remove this node from the cluster before exiting")
+	flags.nonvoter = fs.Bool("nonvoter", false, "Join as a non-voting learner that catches up on the log before being promoted")
+	flags.standby = fs.Bool("standby", false, "Join as a hot spare: a non-voter that serves no client traffic until promoted with /promote")
+	flags.zone = fs.String("zone", "", "Zone (failure domain) of this node; leadership and stale reads prefer the same zone")
+	flags.rack = fs.String("rack", "", "Rack of this node within its zone")
+	flags.autoPromote = fs.Bool("autopromote", true, "Promote non-voters to voters once they have caught up (leader only)")
+	flags.reapDeadServers = fs.Bool("reap-dead-servers", true, "Remove servers that have been unreachable for -reap-after, if quorum allows (leader only)")
+	flags.reapAfter = fs.Duration("reap-after", 5*time.Minute, "How long a server must be unreachable before it is removed")
+	flags.readOnly = fs.Bool("nonvoter-readonly", false, "Join as a non-voting read-only replica that rejects Propose")
+	return flags
+}
+
+// Default returns a Config holding the default value of every flag, for
+// programs that embed the sidecar and configure it without flags.
+func Default() *Config {
+	return defineFlags(flag.NewFlagSet("", flag.ContinueOnError)).config()
+}
+
+// PrintDefaults prints the flags and their defaults to w.
+func PrintDefaults(w io.Writer) {
+	flagSet.SetOutput(w)
+	flagSet.PrintDefaults()
+	flagSet.SetOutput(nil)
+}
+
+// Parse parses the command-line flags in args, the RAFTKV_ environment
+// variables (see EnvName), the configuration file named by -config and the
+// -profile preset, in that order of precedence, and returns a Config. Settings changed at
+// runtime and saved in the data directory (see Patch) take precedence over
+// all of them. An invalid variable, configuration file or saved setting
+// exits with status 2, as invalid flags do. With -version, which only the
+// command line sets, nothing else is read and only Version is set.
+func Parse(args []string) *Config {
+	flagSet.Parse(args)
+	if *flags.version {
+		return &Config{Version: true}
+	}
+	commandLine := make(map[string]string)
+	flagSet.Visit(func(f *flag.Flag) { commandLine[f.Name] = f.Value.String() })
+	fromEnv, err := applyEnv()
+	if err != nil {
+		fmt.Fprintln(flagSet.Output(), err)
+		os.Exit(2)
+	}
+	fromFile, err := applyFile(*flags.configFile, *flags.env)
+	if err != nil {
+		fmt.Fprintln(flagSet.Output(), err)
+		os.Exit(2)
+	}
+	// Development mode settings count as given on the command line.
+	if *flags.dev {
+		values, err := applyDev()
+		if err != nil {
+			fmt.Fprintln(flagSet.Output(), err)
+			os.Exit(2)
+		}
+		for name, value := range values {
+			commandLine[name] = value
+		}
+	}
+	preset, err := applyProfile(*flags.profile)
+	if err != nil {
+		fmt.Fprintln(flagSet.Output(), err)
+		os.Exit(2)
+	}
+	overrides, err := applyOverrides(*flags.dataDir)
+	if err != nil {
+		fmt.Fprintln(flagSet.Output(), err)
+		os.Exit(2)
+	}
+	cfg := flags.config()
+	cfg.ConfigFile = *flags.configFile
+	cfg.Env = *flags.env
+	cfg.fromFile = fromFile
+	cfg.preset = preset
+	cfg.commandLine = commandLine
+	cfg.overrides = overrides
+	cfg.settings = flagValues()
+	cfg.sources = make(map[string]string, len(cfg.settings))
+	for name := range cfg.settings {
+		source := "default"
+		if _, ok := overrides[name]; ok {
+			source = OverridesFile
+		} else if _, ok := commandLine[name]; ok {
+			source = "command line"
+		} else if fromEnv[name] {
+			source = EnvName(name)
+		} else if file, ok := fromFile[name]; ok {
+			source = file
+		} else if _, ok := preset[name]; ok {
+			source = "-profile=" + cfg.Profile
+		}
+		cfg.sources[name] = source
+	}
+	cfg.temporaryDataDir = *flags.dev
+
+	cfg.defaultKubernetesID()
+	cfg.DetectAdvertise()
+	return cfg
+}
+
+// defaultKubernetesID makes the pod name, which carries the StatefulSet
+// ordinal, the node ID under Kubernetes discovery unless -id is given.
+func (c *Config) defaultKubernetesID() {
+	provider := strings.Fields(c.Discovery)
+	if len(provider) == 0 || c.sources["id"] != "default" {
+		return
+	}
+	switch strings.TrimPrefix(provider[0], "provider=") {
+	case "kubernetes", "k8s":
+		if hostname, err := os.Hostname(); err == nil {
+			c.NodeID = hostname
+		}
+	}
+}
+
+// config returns a Config holding the values of the flags.
+func (p *flagPointers) config() *Config {
 	return &Config{
-		NodeID:        *flags.nodeID,
-		RaftPort:      *flags.raftPort,
-		SidecarPort:   *flags.sidecarPort,
-		AppAddr:       *flags.appAddr,
-		MgmtPort:      *flags.mgmtPort,
-		Bootstrap:     *flags.bootstrap,
-		DataDir:       *flags.dataDir,
-		JoinAddr:      *flags.joinAddr,
-		RaftAdvertise: *flags.raftAdvertise,
+		NodeID:             *p.nodeID,
+		RaftPort:           *p.raftPort,
+		SidecarPort:        *p.sidecarPort,
+		AppAddr:            *p.appAddr,
+		MgmtPort:           *p.mgmtPort,
+		Bootstrap:          *p.bootstrap,
+		DataDir:            *p.dataDir,
+		JoinAddr:           *p.joinAddr,
+		RaftAdvertise:      *p.raftAdvertise,
+		AdvertiseInterface: *p.advertiseIface,
+		CDCBackend:         *p.cdcBackend,
+		CDCURL:             *p.cdcURL,
+		CDCURLFile:         *p.cdcURLFile,
+		CDCTopic:           *p.cdcTopic,
+		ProxyReads:         *p.proxyReads,
+		ReadOnly:           *p.readOnly,
+		ForwardProposals:   *p.forwardProposals,
+		Dev:                *p.dev,
+		CheckConfig:        *p.checkConfig,
+		ProposeTimeout:     *p.proposeTimeout,
+		HealthInterval:     *p.healthInterval,
+		StepDownAfter:      *p.stepDownAfter,
+		Priority:           *p.priority,
+		LeaveOnShutdown:    *p.leaveOnShutdown,
+		Nonvoter:           *p.nonvoter,
+		Standby:            *p.standby,
+		Zone:               *p.zone,
+		Rack:               *p.rack,
+		AutoPromote:        *p.autoPromote,
+		JoinRPCAddr:        *p.joinRPCAddr,
+		ClusterToken:       *p.clusterToken,
+		ClusterTokenFile:   *p.clusterTokenFile,
+		JoinToken:          *p.joinToken,
+		JoinTokenFile:      *p.joinTokenFile,
+		BootstrapExpect:    *p.bootstrapExpect,
+		RetryJoin:          splitList(*p.retryJoin),
+		Peers:              splitList(*p.peers),
+		Discovery:          *p.discovery,
+		DiscoveryFile:      *p.discoveryFile,
+		ReapDeadServers:    *p.reapDeadServers,
+		ReapAfter:          *p.reapAfter,
+		JoinMaxElapsed:     *p.joinMaxElapsed,
+		JoinExitOnFailure:  *p.joinExitOnFailure,
+		WipeAndRejoin:      *p.wipeAndRejoin,
+		RaftTLSCert:        *p.raftTLSCert,
+		RaftTLSKey:         *p.raftTLSKey,
+		RaftTLSCA:          *p.raftTLSCA,
+		RaftMTLS:           *p.raftMTLS,
+		RaftClusterCheck:   *p.raftClusterCheck,
+		RaftAllowedPeers:   splitList(*p.raftAllowedPeers),
+		MgmtTLSCert:        *p.mgmtTLSCert,
+		MgmtTLSKey:         *p.mgmtTLSKey,
+		MgmtTLSCA:          *p.mgmtTLSCA,
+		MgmtMTLS:           *p.mgmtMTLS,
+		MgmtTokenFile:      *p.mgmtTokenFile,
+		GRPCAPIKeysFile:    *p.grpcAPIKeysFile,
+		GRPCJWTKeyFile:     *p.grpcJWTKeyFile,
+		GRPCJWTIssuer:      *p.grpcJWTIssuer,
+		GRPCJWTAudience:    *p.grpcJWTAudience,
+		GRPCAuthExempt:     splitList(*p.grpcAuthExempt),
+		RBACPolicy:         *p.rbacPolicy,
+		RBACJWTClaim:       *p.rbacJWTClaim,
+		TLSReloadInterval:  *p.tlsReloadInterval,
+		SPIFFESocket:       *p.spiffeSocket,
+		EncryptionKeyFile:  *p.encryptionKeyFile,
+
+		ProposeRateLimit:       *p.proposeRateLimit,
+		ProposeClientRateLimit: *p.proposeClientRateLimit,
+		JoinRateLimit:          *p.joinRateLimit,
+		JoinClientRateLimit:    *p.joinClientRateLimit,
+
+		SlowApplyThreshold:   *p.slowApply,
+		SlowProposeThreshold: *p.slowPropose,
+
+		TraceLogEntries: *p.traceLogEntries,
+
+		ProposeAuditLog:        *p.proposeAuditLog,
+		ProposeAuditSampleRate: *p.proposeAuditRate,
+
+		MgmtBind:         *p.mgmtBind,
+		SidecarBind:      *p.sidecarBind,
+		SidecarAdvertise: *p.sidecarAdvertise,
+		MgmtAdvertise:    *p.mgmtAdvertise,
+		SidecarSocket:    *p.sidecarSocket,
+		MgmtSocket:       *p.mgmtSocket,
+		MgmtAllowedCIDRs: splitList(*p.mgmtAllowedCIDRs),
+
+		VaultAddr:      *p.vaultAddr,
+		VaultTokenFile: *p.vaultTokenFile,
+		VaultCACert:    *p.vaultCACert,
+		VaultPKIMount:  *p.vaultPKIMount,
+		VaultPKIRole:   *p.vaultPKIRole,
+		VaultCertTTL:   *p.vaultCertTTL,
+		VaultAltNames:  splitList(*p.vaultAltNames),
+
+		CommandACL: *p.commandACL,
+
+		CommandSigningKeys:    *p.commandSigningKeys,
+		AllowUnsignedCommands: *p.allowUnsignedCommands,
+
+		LogLevel:           *p.logLevel,
+		RaftLogLevel:       *p.raftLogLevel,
+		LogFormat:          *p.logFormat,
+		LogFile:            *p.logFile,
+		LogMaxSize:         *p.logMaxSize,
+		LogMaxAge:          *p.logMaxAge,
+		LogMaxBackups:      *p.logMaxBackups,
+		LogCompress:        *p.logCompress,
+		SnapshotThreshold:  *p.snapshotThreshold,
+		SnapshotInterval:   *p.snapshotInterval,
+		TrailingLogs:       *p.trailingLogs,
+		HeartbeatTimeout:   *p.heartbeatTimeout,
+		ElectionTimeout:    *p.electionTimeout,
+		LeaderLeaseTimeout: *p.leaderLeaseTimeout,
+		CommitTimeout:      *p.commitTimeout,
+		MaxAppendEntries:   *p.maxAppendEntries,
+		LogSync:            *p.logSync,
+		BatchApply:         *p.batchApply,
+		Profile:            *p.profile,
+		StatsdAddr:         *p.statsdAddr,
+		StatsdFormat:       *p.statsdFormat,
+		StatsdTags:         splitList(*p.statsdTags),
+		StatsdInterval:     *p.statsdInterval,
+		LeaderWebhooks:     splitList(*p.leaderWebhooks),
+		LeaderWebhooksFile: *p.leaderWebhooksFile,
+
+		LeaderlessAlertAfter:     *p.leaderlessAlert,
+		ClusterAlertWebhooks:     splitList(*p.clusterAlertHooks),
+		ClusterAlertWebhooksFile: *p.clusterAlertFile,
 	}
 }
 
+// FromFlags reports whether c was returned by Parse, and so can be
+// reloaded and changed at runtime.
+func (c *Config) FromFlags() bool {
+	return c.commandLine != nil
+}
+
+// TemporaryDataDir reports whether DataDir was created for -dev, to be
+// removed on exit.
+func (c *Config) TemporaryDataDir() bool {
+	return c.temporaryDataDir
+}
+
+// isSet reports whether the named flag was given on the command line, in
+// the environment or in the configuration file.
+func isSet(name string) bool {
+	set := false
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // BindAddr returns the address to bind the Raft transport to.
 func (c *Config) BindAddr() string {
+	if c.Dev {
+		return net.JoinHostPort(devHost, c.RaftPort)
+	}
 	return "0.0.0.0:" + c.RaftPort
 }
 
 // AdvertiseAddr returns the address to advertise to other nodes.
 func (c *Config) AdvertiseAddr() string {
 	if c.RaftAdvertise != "" {
-		return c.RaftAdvertise + ":" + c.RaftPort
+		return net.JoinHostPort(c.RaftAdvertise, c.RaftPort)
 	}
 	return c.BindAddr()
 }
 
-// String returns a human-readable representation of the config.
+// ListenerOff is the value of -srv and -mgmt, besides empty, that turns
+// their TCP listener off, leaving only their Unix domain socket.
+const ListenerOff = "off"
+
+// listenerOff reports whether the port of a listener turns it off.
+func listenerOff(port string) bool {
+	return port == "" || port == ListenerOff
+}
+
+// SidecarListens reports whether the sidecar gRPC API is served on TCP.
+func (c *Config) SidecarListens() bool {
+	return !listenerOff(c.SidecarPort)
+}
+
+// MgmtListens reports whether the management API is served on TCP.
+func (c *Config) MgmtListens() bool {
+	return !listenerOff(c.MgmtPort)
+}
+
+// SidecarBindAddr returns the address to serve the sidecar gRPC API on, or
+// "" if it is only served on its socket.
+func (c *Config) SidecarBindAddr() string {
+	if !c.SidecarListens() {
+		return ""
+	}
+	if c.SidecarBind != "" {
+		return net.JoinHostPort(c.SidecarBind, c.SidecarPort)
+	}
+	return "0.0.0.0:" + c.SidecarPort
+}
+
+// SidecarAdvertiseAddr returns the sidecar gRPC address to advertise to
+// other nodes, or "" if it is only served on its socket.
+func (c *Config) SidecarAdvertiseAddr() string {
+	if !c.SidecarListens() {
+		return ""
+	}
+	if c.SidecarAdvertise != "" {
+		return withPort(c.SidecarAdvertise, c.SidecarPort)
+	}
+	if c.RaftAdvertise != "" {
+		return net.JoinHostPort(c.RaftAdvertise, c.SidecarPort)
+	}
+	return c.SidecarBindAddr()
+}
+
+// MgmtBindAddr returns the address to serve the management API on, or ""
+// if it is only served on its socket.
+func (c *Config) MgmtBindAddr() string {
+	if !c.MgmtListens() {
+		return ""
+	}
+	if c.MgmtBind != "" {
+		return net.JoinHostPort(c.MgmtBind, c.MgmtPort)
+	}
+	return "0.0.0.0:" + c.MgmtPort
+}
+
+// MgmtAdvertiseAddr returns the management API address to advertise to
+// other nodes, or "" if it is only served on its socket.
+func (c *Config) MgmtAdvertiseAddr() string {
+	if !c.MgmtListens() {
+		return ""
+	}
+	if c.MgmtAdvertise != "" {
+		return withPort(c.MgmtAdvertise, c.MgmtPort)
+	}
+	if c.RaftAdvertise != "" {
+		return net.JoinHostPort(c.RaftAdvertise, c.MgmtPort)
+	}
+	return c.MgmtBindAddr()
+}
+
+// withPort returns addr, adding port if it has none.
+func withPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, port)
+}
+
+// String returns a human-readable representation of the config. Secrets
+// are redacted.
 func (c *Config) String() string {
 	return fmt.Sprintf(
-		"Config{NodeID: %s, RaftPort: %s, SidecarPort: %s, AppAddr: %s, MgmtPort: %s, Bootstrap: %v, DataDir: %s}",
-		c.NodeID, c.RaftPort, c.SidecarPort, c.AppAddr, c.MgmtPort, c.Bootstrap, c.DataDir,
+		"Config{NodeID: %s, RaftPort: %s, SidecarPort: %s, AppAddr: %s, MgmtPort: %s, Bootstrap: %v, DataDir: %s, ClusterToken: %s, JoinToken: %s}",
+		c.NodeID, c.RaftPort, c.SidecarPort, c.AppAddr, c.MgmtPort, c.Bootstrap, c.DataDir, redact(c.ClusterToken), redact(c.JoinToken),
 	)
 }
+
+// GoString keeps secrets out of %#v as well.
+func (c *Config) GoString() string {
+	return c.String()
+}
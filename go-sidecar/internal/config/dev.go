@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// devHost is the address every listener binds to and advertises in
+// development mode.
+const devHost = "127.0.0.1"
+
+// applyDev sets the flags that -dev implies, unless they were given: a
+// bootstrapped node advertising and listening on localhost only, on free
+// ports, with its data in a new temporary directory. It returns the values
+// it set by flag name.
+func applyDev() (map[string]string, error) {
+	if isSet("data") {
+		return nil, errors.New("-dev keeps the Raft log in memory and its other state in a temporary directory; it cannot be combined with -data")
+	}
+	dir, err := os.MkdirTemp("", "raftkv-dev-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the -dev data directory: %w", err)
+	}
+	ports, err := freePorts(3)
+	if err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("failed to find free ports for -dev: %w", err)
+	}
+
+	values := map[string]string{
+		"data":      dir,
+		"bootstrap": "true",
+		"advertise": devHost,
+		"srv-bind":  devHost,
+		"mgmt-bind": devHost,
+		"raft":      ports[0],
+		"srv":       ports[1],
+		"mgmt":      ports[2],
+	}
+	for name, value := range values {
+		if name != "data" && isSet(name) {
+			delete(values, name)
+			continue
+		}
+		if err := flagSet.Set(name, value); err != nil {
+			return nil, fmt.Errorf("-dev: invalid value %q for -%s: %w", value, name, err)
+		}
+	}
+	return values, nil
+}
+
+// freePorts returns n distinct ports that are free on localhost.
+func freePorts(n int) ([]string, error) {
+	var ports []string
+	for range n {
+		lis, err := net.Listen("tcp", net.JoinHostPort(devHost, "0"))
+		if err != nil {
+			return nil, err
+		}
+		// Keep each port bound until all are picked, so they differ.
+		defer lis.Close()
+		ports = append(ports, strconv.Itoa(lis.Addr().(*net.TCPAddr).Port))
+	}
+	return ports, nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvPrefix starts the environment variable that sets each flag: the flag
+// name in upper case with hyphens as underscores, so -raft-tls-cert is set
+// by RAFTKV_RAFT_TLS_CERT.
+const EnvPrefix = "RAFTKV_"
+
+// envNames overrides the variable of flags whose names are abbreviations,
+// naming them after the setting instead.
+var envNames = map[string]string{
+	"id":   EnvPrefix + "NODE_ID",
+	"raft": EnvPrefix + "RAFT_PORT",
+	"srv":  EnvPrefix + "SIDECAR_PORT",
+	"app":  EnvPrefix + "APP_ADDR",
+	"mgmt": EnvPrefix + "MGMT_PORT",
+	"data": EnvPrefix + "DATA_DIR",
+	"join": EnvPrefix + "JOIN_ADDR",
+}
+
+// secretFlags are read from their variables by LoadSecrets, after their
+// -file variants, rather than here.
+var secretFlags = map[string]bool{
+	"cluster-token": true,
+	"join-token":    true,
+}
+
+// EnvName returns the environment variable that sets the named flag.
+func EnvName(name string) string {
+	if env, ok := envNames[name]; ok {
+		return env
+	}
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets every flag the command line does not from its environment
+// variable, if that is set and not empty, returning the names it set.
+func applyEnv() (map[string]bool, error) {
+	explicit := make(map[string]bool)
+	flagSet.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	fromEnv := make(map[string]bool)
+	var err error
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] || secretFlags[f.Name] || f.Name == "version" {
+			return
+		}
+		env := EnvName(f.Name)
+		value := os.Getenv(env)
+		if value == "" {
+			return
+		}
+		if setErr := flagSet.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: invalid value %q for -%s: %w", env, value, f.Name, setErr)
+			return
+		}
+		fromEnv[f.Name] = true
+	})
+	return fromEnv, err
+}
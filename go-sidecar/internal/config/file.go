@@ -0,0 +1,413 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyFile sets every flag the configuration file at path, with the
+// overlay of the environment env, names and neither the command line nor
+// the environment sets, returning the source of each flag it set.
+func applyFile(path, env string) (map[string]string, error) {
+	if path == "" {
+		if env != "" {
+			return nil, errors.New("-env requires -config")
+		}
+		return nil, nil
+	}
+	values, overlay, err := readFile(path, env)
+	if err != nil {
+		return nil, err
+	}
+
+	explicit := make(map[string]bool)
+	flagSet.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fromFile := make(map[string]string)
+	for _, name := range names {
+		if explicit[name] {
+			continue
+		}
+		source := fileSource(path, env, overlay[name])
+		if err := flagSet.Set(name, values[name]); err != nil {
+			return nil, fmt.Errorf("%s: invalid value %q for %s: %w", source, values[name], name, err)
+		}
+		fromFile[name] = source
+	}
+	return fromFile, nil
+}
+
+// environmentsKey is the section of a configuration file holding the
+// overlays selected with -env, by environment name. Since nested keys are
+// joined with hyphens, environment names are letters and digits.
+const environmentsKey = "environments"
+
+// readFile reads the configuration file at path and returns its settings
+// by flag name, those of the overlay of environment env, if not empty,
+// replacing the base settings, and the names the overlay set. It rejects
+// unknown options in the base and in every environment, and an environment
+// the file does not define.
+func readFile(path, env string) (map[string]string, map[string]bool, error) {
+	values, err := loadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	base := make(map[string]string)
+	overlays := make(map[string]map[string]string)
+	for key, value := range values {
+		rest, ok := strings.CutPrefix(key, environmentsKey+"-")
+		if !ok {
+			if err := checkFileOption(path, key); err != nil {
+				return nil, nil, err
+			}
+			base[key] = value
+			continue
+		}
+		name, option, ok := strings.Cut(rest, "-")
+		if !ok || name == "" {
+			return nil, nil, fmt.Errorf("%s: %s.%s must be a mapping of settings", path, environmentsKey, rest)
+		}
+		if err := checkFileOption(path+" ("+environmentsKey+"."+name+")", option); err != nil {
+			return nil, nil, err
+		}
+		if overlays[name] == nil {
+			overlays[name] = make(map[string]string)
+		}
+		overlays[name][option] = value
+	}
+
+	overlay := make(map[string]bool)
+	if env == "" {
+		return base, overlay, nil
+	}
+	settings, ok := overlays[env]
+	if !ok {
+		names := make([]string, 0, len(overlays))
+		for name := range overlays {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, nil, fmt.Errorf("-env=%s: %s defines no environments", env, path)
+		}
+		return nil, nil, fmt.Errorf("-env=%s: %s defines no such environment (want %s)", env, path, strings.Join(names, ", "))
+	}
+	for name, value := range settings {
+		base[name] = value
+		overlay[name] = true
+	}
+	return base, overlay, nil
+}
+
+// checkFileOption rejects a key of the configuration file at source that
+// names no flag, or -config, -env or -version, which a file cannot set.
+func checkFileOption(source, name string) error {
+	if name == "config" || name == "env" || name == "version" || flagSet.Lookup(name) == nil {
+		return fmt.Errorf("%s: unknown option %q", source, name)
+	}
+	return nil
+}
+
+// fileSource describes where a setting of the configuration file at path
+// came from: the file, or the overlay of environment env.
+func fileSource(path, env string, overlay bool) string {
+	if overlay {
+		return fmt.Sprintf("%s (%s.%s)", path, environmentsKey, env)
+	}
+	return path
+}
+
+// loadFile reads a YAML (.yaml, .yml) or TOML (.toml) configuration file
+// into flag values by flag name. Keys are flag names, in which underscores
+// may stand for hyphens; nested keys and TOML tables are joined to their
+// parent with a hyphen, so vault: {pki-role: x} sets -vault-pki-role. Lists
+// become comma-separated values.
+func loadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	var values map[string]string
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		values, err = parseYAML(string(data))
+	case ".toml":
+		values, err = parseTOML(string(data))
+	default:
+		return nil, fmt.Errorf("configuration file %s: unsupported format %q, expected .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("configuration file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// settings collects the values of a configuration file, rejecting keys
+// that appear twice.
+type settings map[string]string
+
+func (s settings) set(line int, name, value string) error {
+	if _, ok := s[name]; ok {
+		return fmt.Errorf("line %d: %s is set more than once", line, name)
+	}
+	s[name] = value
+	return nil
+}
+
+// keyName turns a configuration key into a flag name.
+func keyName(key string) string {
+	return strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+}
+
+// parseYAML reads a YAML document. Mappings may nest, in block or flow
+// style; lists, of scalars only, become comma-separated values, and null
+// is empty.
+func parseYAML(data string) (map[string]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+		return nil, err
+	}
+	values := make(settings)
+	if len(doc.Content) == 0 {
+		return values, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping of settings", root.Line)
+	}
+	if err := yamlMapping(values, "", root); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// yamlMapping adds the settings of a YAML mapping to values, prefixing
+// their names with prefix.
+func yamlMapping(values settings, prefix string, node *yaml.Node) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], resolveAlias(node.Content[i+1])
+		if key.Kind != yaml.ScalarNode {
+			return fmt.Errorf("line %d: keys must be strings", key.Line)
+		}
+		name := prefix + keyName(key.Value)
+		switch value.Kind {
+		case yaml.MappingNode:
+			if err := yamlMapping(values, name+"-", value); err != nil {
+				return err
+			}
+			continue
+		case yaml.SequenceNode:
+			items := make([]string, 0, len(value.Content))
+			for _, item := range value.Content {
+				if item = resolveAlias(item); item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("line %d: items of %s must be scalars", item.Line, name)
+				}
+				items = append(items, yamlScalar(item))
+			}
+			if err := values.set(key.Line, name, strings.Join(items, ",")); err != nil {
+				return err
+			}
+		default:
+			if err := values.set(key.Line, name, yamlScalar(value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveAlias returns the node an alias refers to, or node itself.
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// yamlScalar returns the value of a scalar node. Null is empty.
+func yamlScalar(node *yaml.Node) string {
+	if node.ShortTag() == "!!null" {
+		return ""
+	}
+	return node.Value
+}
+
+// parseTOML reads the subset of TOML that configuration needs: key/value
+// pairs, dotted keys, tables, strings, numbers, booleans and arrays, which
+// may span lines.
+func parseTOML(data string) (map[string]string, error) {
+	values := make(settings)
+	prefix := ""
+	lines := strings.Split(data, "\n")
+	for n := 0; n < len(lines); n++ {
+		line := n + 1
+		text := strings.TrimSpace(stripComment(lines[n]))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[[") {
+			return nil, fmt.Errorf("line %d: arrays of tables are not supported", line)
+		}
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: invalid table header", line)
+			}
+			prefix = tomlKey(text[1:len(text)-1]) + "-"
+			continue
+		}
+
+		key, rest, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key = value\"", line)
+		}
+		rest = strings.TrimSpace(rest)
+		// Arrays may continue on the following lines.
+		for strings.HasPrefix(rest, "[") && !balanced(rest) && n+1 < len(lines) {
+			n++
+			rest += " " + strings.TrimSpace(stripComment(lines[n]))
+		}
+		value, err := tomlValue(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := values.set(line, prefix+tomlKey(key), value); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// tomlKey turns a possibly dotted or quoted TOML key into a flag name.
+func tomlKey(key string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = keyName(strings.Trim(strings.TrimSpace(part), `"'`))
+	}
+	return strings.Join(parts, "-")
+}
+
+// tomlValue parses a TOML string, number, boolean or array.
+func tomlValue(text string) (string, error) {
+	switch {
+	case text == "":
+		return "", errors.New("missing value")
+	case strings.HasPrefix(text, `"""`) || strings.HasPrefix(text, "'''"):
+		return "", errors.New("multi-line strings are not supported")
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return "", errors.New("unterminated array")
+		}
+		return joinList(text[1:len(text)-1], tomlValue)
+	case strings.HasPrefix(text, "{"):
+		return "", errors.New("inline tables are not supported; use a table instead")
+	case strings.HasPrefix(text, `"`):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return "", fmt.Errorf("invalid string %s", text)
+		}
+		return text[1 : len(text)-1], nil
+	}
+	return text, nil
+}
+
+// joinList parses the comma-separated items of an array with parse and
+// joins them with commas, as list flags expect.
+func joinList(text string, parse func(string) (string, error)) (string, error) {
+	var items []string
+	for _, item := range splitOutsideQuotes(text, ',') {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		value, err := parse(item)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, value)
+	}
+	return strings.Join(items, ","), nil
+}
+
+// scanUnquoted calls fn with the index and value of every byte of text
+// outside single or double quotes, until fn returns false.
+func scanUnquoted(text string, fn func(i int, c byte) bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		default:
+			if !fn(i, c) {
+				return
+			}
+		}
+	}
+}
+
+// splitOutsideQuotes splits text at every sep that is not inside quotes.
+func splitOutsideQuotes(text string, sep byte) []string {
+	var parts []string
+	start := 0
+	scanUnquoted(text, func(i int, c byte) bool {
+		if c == sep {
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+		return true
+	})
+	return append(parts, text[start:])
+}
+
+// stripComment removes a # comment that is not inside quotes, which starts
+// the line or follows whitespace.
+func stripComment(line string) string {
+	end := len(line)
+	scanUnquoted(line, func(i int, c byte) bool {
+		if c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			end = i
+			return false
+		}
+		return true
+	})
+	return line[:end]
+}
+
+// balanced reports whether every bracket outside quotes in text is closed.
+func balanced(text string) bool {
+	depth := 0
+	scanUnquoted(text, func(_ int, c byte) bool {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+		return true
+	})
+	return depth <= 0
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profiles are the tuning presets selected with -profile, by flag name.
+// balanced holds the defaults. low-latency detects failures and commits
+// sooner and does not wait for the disk, so a node that loses power can
+// lose entries it acknowledged. durable tolerates slow disks and links,
+// commits in larger batches, and compacts the log sooner while keeping
+// more of it for lagging followers.
+var profiles = map[string]map[string]string{
+	"low-latency": {
+		"heartbeat-timeout":    "500ms",
+		"election-timeout":     "500ms",
+		"leader-lease-timeout": "250ms",
+		"commit-timeout":       "5ms",
+		"max-append-entries":   "64",
+		"log-sync":             "false",
+		"batch-apply":          "false",
+		"snapshot-threshold":   "16384",
+		"snapshot-interval":    "2m",
+		"trailing-logs":        "10240",
+	},
+	"balanced": {
+		"heartbeat-timeout":    "1s",
+		"election-timeout":     "1s",
+		"leader-lease-timeout": "500ms",
+		"commit-timeout":       "50ms",
+		"max-append-entries":   "64",
+		"log-sync":             "true",
+		"batch-apply":          "false",
+		"snapshot-threshold":   "8192",
+		"snapshot-interval":    "2m",
+		"trailing-logs":        "10240",
+	},
+	"durable": {
+		"heartbeat-timeout":    "2s",
+		"election-timeout":     "2s",
+		"leader-lease-timeout": "1s",
+		"commit-timeout":       "50ms",
+		"max-append-entries":   "256",
+		"log-sync":             "true",
+		"batch-apply":          "true",
+		"snapshot-threshold":   "4096",
+		"snapshot-interval":    "1m",
+		"trailing-logs":        "20480",
+	},
+}
+
+// profileNames returns the names of the profiles, sorted.
+func profileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyProfile sets the flags of the named profile that were not given
+// on the command line, in the environment or in the configuration file,
+// returning the values it set by flag name.
+func applyProfile(name string) (map[string]string, error) {
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("-profile: unknown profile %q (want %s)", name, strings.Join(profileNames(), ", "))
+	}
+	values := make(map[string]string)
+	for flagName, value := range profile {
+		if isSet(flagName) {
+			continue
+		}
+		if err := flagSet.Set(flagName, value); err != nil {
+			return nil, fmt.Errorf("-profile=%s: invalid value %q for -%s: %w", name, value, flagName, err)
+		}
+		values[flagName] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// reloadable are the flags Reload applies to a running node. The others
+// take effect only on restart.
+var reloadable = map[string]bool{
+	"log-level":                 true,
+	"raft-log-level":            true,
+	"propose-rate-limit":        true,
+	"propose-client-rate-limit": true,
+	"join-rate-limit":           true,
+	"join-client-rate-limit":    true,
+	"snapshot-threshold":        true,
+	"snapshot-interval":         true,
+	"trailing-logs":             true,
+	"heartbeat-timeout":         true,
+	"election-timeout":          true,
+}
+
+// Reload reads the environment and the configuration file again and
+// returns a copy of c with the reloadable settings updated, in the same
+// order of precedence as Parse: settings changed at runtime, the command
+// line, the environment, the file, the profile and the defaults. It also
+// returns the flags whose value changed but that only take effect on
+// restart, which keep their running value. c is not modified, and must come from Parse.
+func (c *Config) Reload() (*Config, []string, error) {
+	if !c.FromFlags() {
+		return nil, nil, errors.New("the configuration was not parsed from flags and cannot be reloaded")
+	}
+	var values map[string]string
+	var overlay map[string]bool
+	if c.ConfigFile != "" {
+		var err error
+		if values, overlay, err = readFile(c.ConfigFile, c.Env); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var restart []string
+	var problems []error
+	previous := make(map[string]string)
+	sources := make(map[string]string)
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if secretFlags[f.Name] || f.Name == "config" || f.Name == "env" || f.Name == "version" {
+			return
+		}
+		source, value := "default", f.DefValue
+		if v, ok := c.overrides[f.Name]; ok {
+			source, value = OverridesFile, v
+		} else if v, ok := c.commandLine[f.Name]; ok {
+			source, value = "command line", v
+		} else if env := os.Getenv(EnvName(f.Name)); env != "" {
+			source, value = EnvName(f.Name), env
+		} else if v, ok := values[f.Name]; ok {
+			source, value = fileSource(c.ConfigFile, c.Env, overlay[f.Name]), v
+		} else if v, ok := c.preset[f.Name]; ok {
+			source, value = "-profile="+c.Profile, v
+		}
+
+		old := f.Value.String()
+		if err := f.Value.Set(value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: invalid value %q for -%s: %w", source, value, f.Name, err))
+			f.Value.Set(old)
+			return
+		}
+		if reloadable[f.Name] {
+			previous[f.Name] = old
+			sources[f.Name] = source
+			return
+		}
+		// Only compare the others, whose value must not change.
+		if f.Value.String() != old {
+			restart = append(restart, f.Name)
+		}
+		f.Value.Set(old)
+	})
+	// On failure, the flags keep the running values.
+	rollback := func() {
+		for name, value := range previous {
+			flagSet.Set(name, value)
+		}
+	}
+	if err := errors.Join(problems...); err != nil {
+		rollback()
+		return nil, nil, err
+	}
+	sort.Strings(restart)
+
+	next := *c
+	next.LogLevel = *flags.logLevel
+	next.RaftLogLevel = *flags.raftLogLevel
+	next.ProposeRateLimit = *flags.proposeRateLimit
+	next.ProposeClientRateLimit = *flags.proposeClientRateLimit
+	next.JoinRateLimit = *flags.joinRateLimit
+	next.JoinClientRateLimit = *flags.joinClientRateLimit
+	next.SnapshotThreshold = *flags.snapshotThreshold
+	next.SnapshotInterval = *flags.snapshotInterval
+	next.TrailingLogs = *flags.trailingLogs
+	next.HeartbeatTimeout = *flags.heartbeatTimeout
+	next.ElectionTimeout = *flags.electionTimeout
+
+	var invalid []error
+	next.validateTunables(func(format string, args ...any) {
+		invalid = append(invalid, fmt.Errorf(format, args...))
+	})
+	if err := errors.Join(invalid...); err != nil {
+		rollback()
+		return nil, nil, err
+	}
+
+	next.settings = make(map[string]string, len(c.settings))
+	for name, value := range c.settings {
+		next.settings[name] = value
+	}
+	for name := range reloadable {
+		next.settings[name] = flagSet.Lookup(name).Value.String()
+	}
+	next.sources = make(map[string]string, len(c.sources))
+	for name, source := range c.sources {
+		next.sources[name] = source
+	}
+	for name, source := range sources {
+		next.sources[name] = source
+	}
+	return &next, restart, nil
+}
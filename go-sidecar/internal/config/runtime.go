@@ -0,0 +1,199 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// OverridesFile is the file in the data directory that holds the settings
+// changed at runtime, so that they survive restarts.
+const OverridesFile = "config-overrides.json"
+
+// Settings is the running configuration, as reported by GET /config.
+type Settings struct {
+	// Values are the value of every flag by name, with secrets redacted.
+	Values map[string]string `json:"settings"`
+	// Overrides are the settings changed at runtime, which take precedence
+	// over every other source.
+	Overrides map[string]string `json:"overrides"`
+	// Reloadable are the flags that can change without a restart.
+	Reloadable []string `json:"reloadable"`
+}
+
+// Dump is the effective configuration of a node, as reported by GET
+// /debug/config.
+type Dump struct {
+	NodeID     string `json:"node_id"`
+	ConfigFile string `json:"config_file,omitempty"`
+	// Settings are the value of every flag by name, with secrets redacted,
+	// and where it came from.
+	Settings map[string]Setting `json:"settings"`
+	// Addresses are the addresses the node listens on and advertises,
+	// derived from the settings, and the backend's.
+	Addresses map[string]string `json:"addresses"`
+}
+
+// Setting is the value of a flag and its source: the command line, an
+// environment variable, the configuration file, the profile, the settings
+// changed at runtime or the default.
+type Setting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// applyOverrides sets the flags saved in dataDir by SaveOverrides,
+// returning them by name.
+func applyOverrides(dataDir string) (map[string]string, error) {
+	path := filepath.Join(dataDir, OverridesFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read runtime settings: %w", err)
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, value := range overrides {
+		if _, ok := reloadable[name]; !ok {
+			return nil, fmt.Errorf("%s: %q cannot be changed at runtime", path, name)
+		}
+		if err := flagSet.Set(name, value); err != nil {
+			return nil, fmt.Errorf("%s: invalid value %q for -%s: %w", path, value, name, err)
+		}
+	}
+	return overrides, nil
+}
+
+// flagValues returns the value of every flag by name.
+func flagValues() map[string]string {
+	values := make(map[string]string)
+	flagSet.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	return values
+}
+
+// Settings returns the running configuration. Secrets are redacted.
+func (c *Config) Settings() *Settings {
+	settings := &Settings{
+		Values:     make(map[string]string, len(c.settings)),
+		Overrides:  make(map[string]string, len(c.overrides)),
+		Reloadable: make([]string, 0, len(reloadable)),
+	}
+	for name, value := range c.settings {
+		settings.Values[name] = redactSetting(name, value)
+	}
+	for name, value := range c.overrides {
+		settings.Overrides[name] = value
+	}
+	for name := range reloadable {
+		settings.Reloadable = append(settings.Reloadable, name)
+	}
+	sort.Strings(settings.Reloadable)
+	return settings
+}
+
+// Dump returns the effective configuration. Secrets and credentials are
+// redacted; the settings LoadSecrets reads from files or the environment
+// are reported as loaded.
+func (c *Config) Dump() *Dump {
+	dump := &Dump{
+		NodeID:     c.NodeID,
+		ConfigFile: c.ConfigFile,
+		Settings:   make(map[string]Setting, len(c.settings)),
+		Addresses: map[string]string{
+			"raft_bind":            c.BindAddr(),
+			"raft_advertise":       c.AdvertiseAddr(),
+			"grpc_bind":            c.SidecarBindAddr(),
+			"grpc_advertise":       c.SidecarAdvertiseAddr(),
+			"management_bind":      c.MgmtBindAddr(),
+			"management_advertise": c.MgmtAdvertiseAddr(),
+			"backend":              c.AppAddr,
+		},
+	}
+	for name, value := range c.settings {
+		dump.Settings[name] = Setting{Value: redactSetting(name, value), Source: c.sources[name]}
+	}
+	for _, secret := range []struct {
+		flag, value, file, env string
+	}{
+		{"cluster-token", c.ClusterToken, c.ClusterTokenFile, ClusterTokenEnv},
+		{"join-token", c.JoinToken, c.JoinTokenFile, JoinTokenEnv},
+		{"discovery", c.Discovery, c.DiscoveryFile, ""},
+		{"cdc-url", c.CDCURL, c.CDCURLFile, ""},
+		{"leader-webhooks", strings.Join(c.LeaderWebhooks, ","), c.LeaderWebhooksFile, ""},
+		{"cluster-alert-webhooks", strings.Join(c.ClusterAlertWebhooks, ","), c.ClusterAlertWebhooksFile, ""},
+	} {
+		setting := Setting{Value: redactSetting(secret.flag, secret.value), Source: c.sources[secret.flag]}
+		if setting.Source == "default" && secret.value != "" {
+			setting.Source = secret.env
+			if secret.file != "" {
+				setting.Source = "-" + secret.flag + "-file"
+			}
+		}
+		dump.Settings[secret.flag] = setting
+	}
+	return dump
+}
+
+// Patch returns a copy of c with the reloadable settings in changes
+// overridden at runtime, or, where the change is nil, returned to the
+// value of the other sources. It reloads the other sources as Reload does,
+// and returns the same flags that need a restart. c is not modified, and
+// the changes are only saved by SaveOverrides.
+func (c *Config) Patch(changes map[string]*string) (*Config, []string, error) {
+	overrides := make(map[string]string, len(c.overrides)+len(changes))
+	for name, value := range c.overrides {
+		overrides[name] = value
+	}
+	for name, value := range changes {
+		if _, ok := reloadable[name]; !ok {
+			if flagSet.Lookup(name) == nil {
+				return nil, nil, fmt.Errorf("unknown setting %q", name)
+			}
+			return nil, nil, fmt.Errorf("-%s cannot be changed at runtime; change it on every node and restart", name)
+		}
+		if value == nil {
+			delete(overrides, name)
+		} else {
+			overrides[name] = *value
+		}
+	}
+
+	base := *c
+	base.overrides = overrides
+	return base.Reload()
+}
+
+// SaveOverrides writes the settings changed at runtime to the data
+// directory, where Parse reads them on the next start.
+func (c *Config) SaveOverrides() error {
+	path := filepath.Join(c.DataDir, OverridesFile)
+	if len(c.overrides) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove runtime settings: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.overrides, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Replace the file atomically, so that a crash leaves either version.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to save runtime settings: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to save runtime settings: %w", err)
+	}
+	return nil
+}
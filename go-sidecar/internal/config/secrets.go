@@ -0,0 +1,214 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"my-raft-sidecar/internal/logging"
+)
+
+// redacted replaces secrets in String.
+const redacted = "[redacted]"
+
+// readSecret returns the secret in the file at path or, if path is empty,
+// in the environment variable env. A named file must not be empty.
+func readSecret(what, path, env string) (string, error) {
+	if path == "" {
+		return strings.TrimSpace(os.Getenv(env)), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", what, err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("%s file %s is empty", what, path)
+	}
+	return secret, nil
+}
+
+// LoadSecrets fills ClusterToken and JoinToken from their files or, if
+// the flags were not given either, from ClusterTokenEnv and JoinTokenEnv.
+// Secrets given as flags still work, with a warning, since they are
+// visible to anyone who can list processes; the configuration file is not.
+func (c *Config) LoadSecrets() error {
+	for _, secret := range []struct {
+		flag  string
+		value *string
+		file  string
+		env   string
+	}{
+		{"cluster-token", &c.ClusterToken, c.ClusterTokenFile, ClusterTokenEnv},
+		{"join-token", &c.JoinToken, c.JoinTokenFile, JoinTokenEnv},
+	} {
+		if *secret.value != "" {
+			if secret.file != "" {
+				return fmt.Errorf("-%s and -%s-file are mutually exclusive", secret.flag, secret.flag)
+			}
+			if _, ok := c.fromFile[secret.flag]; !ok {
+				logger.Warn("Secret flag is visible in the process list; use its -file variant or environment variable instead", "flag", "-"+secret.flag, "file_flag", "-"+secret.flag+"-file", "env", secret.env)
+			}
+			continue
+		}
+		value, err := readSecret(secret.flag, secret.file, secret.env)
+		if err != nil {
+			return err
+		}
+		*secret.value = value
+	}
+
+	// Settings that may carry credentials can be read from files too
+	for _, setting := range []struct {
+		flag  string
+		value *string
+		file  string
+	}{
+		{"discovery", &c.Discovery, c.DiscoveryFile},
+		{"cdc-url", &c.CDCURL, c.CDCURLFile},
+	} {
+		if setting.file == "" {
+			continue
+		}
+		if *setting.value != "" {
+			return fmt.Errorf("-%s and -%s-file are mutually exclusive", setting.flag, setting.flag)
+		}
+		value, err := readSecret(setting.flag, setting.file, "")
+		if err != nil {
+			return err
+		}
+		*setting.value = value
+	}
+	for _, setting := range []struct {
+		flag  string
+		value *[]string
+		file  string
+	}{
+		{"leader-webhooks", &c.LeaderWebhooks, c.LeaderWebhooksFile},
+		{"cluster-alert-webhooks", &c.ClusterAlertWebhooks, c.ClusterAlertWebhooksFile},
+	} {
+		if setting.file == "" {
+			continue
+		}
+		if len(*setting.value) > 0 {
+			return fmt.Errorf("-%s and -%s-file are mutually exclusive", setting.flag, setting.flag)
+		}
+		value, err := readSecret(setting.flag, setting.file, "")
+		if err != nil {
+			return err
+		}
+		*setting.value = splitList(strings.Join(strings.Fields(value), ","))
+	}
+	if c.DiscoveryFile != "" {
+		c.defaultKubernetesID()
+	}
+	return nil
+}
+
+// MgmtToken returns the bearer token required on the management API, read
+// from MgmtTokenFile or the MgmtTokenEnv environment variable. Empty means
+// no authentication.
+func (c *Config) MgmtToken() (string, error) {
+	return readSecret("management token", c.MgmtTokenFile, MgmtTokenEnv)
+}
+
+// VaultToken returns the token authenticating to Vault, read from
+// VaultTokenFile or the VaultTokenEnv environment variable.
+func (c *Config) VaultToken() (string, error) {
+	return readSecret("Vault token", c.VaultTokenFile, VaultTokenEnv)
+}
+
+// EncryptionKeys returns the keys encrypting the Raft log store, read from
+// EncryptionKeyFile or the EncryptionKeyEnv environment variable. None
+// means the log store is not encrypted.
+func (c *Config) EncryptionKeys() ([][]byte, error) {
+	return LoadEncryptionKeys(c.EncryptionKeyFile)
+}
+
+// LoadEncryptionKeys reads base64-encoded 32-byte keys, separated by
+// whitespace, from path, or from the EncryptionKeyEnv environment variable
+// if path is empty. Lines starting with # are ignored. The encoded keys
+// are zeroed once decoded.
+func LoadEncryptionKeys(path string) ([][]byte, error) {
+	data := []byte(os.Getenv(EncryptionKeyEnv))
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read encryption keys: %w", err)
+		}
+	}
+	defer clear(data)
+
+	var keys [][]byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("#")) {
+			continue
+		}
+		for _, field := range bytes.Fields(line) {
+			key := make([]byte, base64.StdEncoding.DecodedLen(len(field)))
+			n, err := base64.StdEncoding.Decode(key, field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid encryption key: %w", err)
+			}
+			if n != 32 {
+				return nil, fmt.Errorf("invalid encryption key: got %d bytes, want 32", n)
+			}
+			keys = append(keys, key[:n])
+		}
+	}
+	if path != "" && len(keys) == 0 {
+		return nil, fmt.Errorf("encryption key file %s has no keys", path)
+	}
+	return keys, nil
+}
+
+// redact returns redacted for a set secret and "" otherwise, so that
+// String shows whether a secret is configured but never its value.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// discoveryCredentials are the -discovery arguments that hold credentials.
+var discoveryCredentials = map[string]bool{
+	"secret_access_key": true,
+	"token":             true,
+	"password":          true,
+}
+
+// redactSetting returns the value of the named flag with any credentials
+// it holds redacted: secrets as a whole, the credentials among the
+// -discovery arguments, the user info of -cdc-url, and the webhook URLs
+// but for their host, since many services carry a secret in the path.
+func redactSetting(name, value string) string {
+	switch name {
+	case "cluster-token", "join-token":
+		return redact(value)
+	case "discovery":
+		fields := strings.Fields(value)
+		for i, field := range fields {
+			if key, _, ok := strings.Cut(field, "="); ok && discoveryCredentials[key] {
+				fields[i] = key + "=" + redacted
+			}
+		}
+		return strings.Join(fields, " ")
+	case "cdc-url":
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			u.User = url.User(redacted)
+			return strings.Replace(u.String(), url.PathEscape(redacted), redacted, 1)
+		}
+		return value
+	case "leader-webhooks", "cluster-alert-webhooks":
+		urls := splitList(value)
+		for i, raw := range urls {
+			urls[i] = logging.RedactURL(raw)
+		}
+		return strings.Join(urls, ",")
+	}
+	return value
+}
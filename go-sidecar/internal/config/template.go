@@ -0,0 +1,216 @@
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// templateSections group the flags of the configuration template, in the
+// order the template lists them. Flags no section names are listed last,
+// under "Other", so a new flag is never left out.
+var templateSections = []struct {
+	title string
+	flags []string
+}{
+	{"Node", []string{
+		"id", "data", "dev", "profile", "log-level", "raft-log-level", "log-format", "zone", "rack", "priority",
+		"nonvoter", "nonvoter-readonly", "standby",
+	}},
+	{"Log file", []string{"log-file", "log-max-size", "log-max-age", "log-max-backups", "log-compress"}},
+	{"Addresses", []string{
+		"raft", "advertise", "advertise-interface", "srv", "srv-bind", "srv-advertise", "srv-socket",
+		"app", "mgmt", "mgmt-bind", "mgmt-advertise", "mgmt-socket", "mgmt-allowed-cidrs",
+	}},
+	{"Cluster membership", []string{
+		"bootstrap", "bootstrap-expect", "peers", "retry-join", "discovery", "discovery-file",
+		"join", "join-rpc", "join-max-elapsed", "join-exit-on-failure", "wipe-and-rejoin",
+		"cluster-token", "cluster-token-file", "join-token", "join-token-file", "raft-cluster-check",
+		"leave-on-shutdown", "stepdown-after", "autopromote", "reap-dead-servers", "reap-after",
+	}},
+	{"Raft TLS", []string{
+		"raft-tls-cert", "raft-tls-key", "raft-tls-ca", "raft-mtls", "raft-allowed-peers",
+		"tls-reload-interval", "spiffe-socket",
+	}},
+	{"Management API security", []string{
+		"mgmt-tls-cert", "mgmt-tls-key", "mgmt-tls-ca", "mgmt-mtls", "mgmt-token-file",
+	}},
+	{"gRPC authentication and authorization", []string{
+		"grpc-api-keys-file", "grpc-jwt-key-file", "grpc-jwt-issuer", "grpc-jwt-audience",
+		"grpc-auth-exempt", "rbac-policy", "rbac-jwt-claim",
+	}},
+	{"Vault PKI", []string{
+		"vault-addr", "vault-token-file", "vault-ca-cert", "vault-pki-mount", "vault-pki-role",
+		"vault-cert-ttl", "vault-alt-names",
+	}},
+	{"Commands and data", []string{
+		"command-acl", "command-signing-keys", "allow-unsigned-commands", "encryption-key-file",
+	}},
+	{"Requests", []string{
+		"propose-timeout", "proxy-reads", "forward-proposals", "backend-health-interval",
+		"slow-apply-threshold", "slow-propose-threshold", "trace-log-entries",
+		"propose-audit-log", "propose-audit-sample-rate",
+		"propose-rate-limit", "propose-client-rate-limit", "join-rate-limit", "join-client-rate-limit",
+	}},
+	{"Raft tuning", []string{
+		"heartbeat-timeout", "election-timeout", "leader-lease-timeout", "commit-timeout",
+		"max-append-entries", "log-sync", "batch-apply",
+		"snapshot-threshold", "snapshot-interval", "trailing-logs",
+	}},
+	{"Change data capture", []string{"cdc", "cdc-url", "cdc-url-file", "cdc-topic"}},
+	{"Telemetry", []string{"statsd-addr", "statsd-format", "statsd-tags", "statsd-interval"}},
+	{"Notifications", []string{
+		"leader-webhooks", "leader-webhooks-file", "leaderless-alert-after",
+		"cluster-alert-webhooks", "cluster-alert-webhooks-file",
+	}},
+}
+
+// TemplateFormats are the formats WriteTemplate writes.
+var TemplateFormats = []string{"yaml", "toml"}
+
+// WriteTemplate writes a configuration file in format, yaml or toml, that
+// documents every setting -config accepts with its usage and default. The
+// settings are commented out, so the file as written changes nothing:
+// a default set in the file would override the -profile preset.
+func WriteTemplate(w io.Writer, format string) error {
+	var setting func(name string, value any) string
+	switch format {
+	case "yaml":
+		setting = func(name string, value any) string { return name + ": " + yamlTemplateValue(value) }
+	case "toml":
+		setting = func(name string, value any) string { return name + " = " + tomlTemplateValue(value) }
+	default:
+		return fmt.Errorf("unknown format %q (want %s)", format, strings.Join(TemplateFormats, " or "))
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# RaftKV sidecar configuration, read with -config.")
+	fmt.Fprintln(bw, "#")
+	fmt.Fprintln(bw, "# Every setting is a flag of the same name and is shown commented out with")
+	fmt.Fprintln(bw, "# its default; uncomment the ones to change. Command-line flags and RAFTKV_")
+	fmt.Fprintln(bw, "# environment variables override this file, which overrides -profile.")
+
+	listed := make(map[string]bool)
+	section := func(title string, names []string) {
+		fmt.Fprintf(bw, "\n# ---- %s ----\n", title)
+		for _, name := range names {
+			f := flagSet.Lookup(name)
+			if f == nil || listed[name] {
+				continue
+			}
+			listed[name] = true
+			fmt.Fprintln(bw)
+			for _, line := range wrapComment(f.Usage, 76) {
+				fmt.Fprintln(bw, "# "+line)
+			}
+			if _, ok := profiles["balanced"][name]; ok {
+				fmt.Fprintln(bw, "# The default depends on -profile; shown is balanced.")
+			}
+			fmt.Fprintln(bw, "# "+setting(name, flagDefault(f)))
+		}
+	}
+	listed["config"] = true
+	listed["check-config"] = true
+	listed["version"] = true
+	listed["env"] = true
+	for _, s := range templateSections {
+		section(s.title, s.flags)
+	}
+	var other []string
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if !listed[f.Name] {
+			other = append(other, f.Name)
+		}
+	})
+	if len(other) > 0 {
+		section("Other", other)
+	}
+
+	fmt.Fprintln(bw, "\n# ---- Environments ----")
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "# Settings of an environment apply over the rest of the file when the")
+	fmt.Fprintln(bw, "# sidecar runs with -env=<name>; names are letters and digits.")
+	if format == "yaml" {
+		fmt.Fprintln(bw, "# "+environmentsKey+":")
+		fmt.Fprintln(bw, "#   prod:")
+		fmt.Fprintln(bw, "#     "+setting("log-level", "warn"))
+	} else {
+		fmt.Fprintln(bw, "# ["+environmentsKey+".prod]")
+		fmt.Fprintln(bw, "# "+setting("log-level", "warn"))
+	}
+	return bw.Flush()
+}
+
+// flagDefault returns the default of f as a bool, a number or, for strings,
+// durations and other flags, a string.
+func flagDefault(f *flag.Flag) any {
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return f.DefValue
+	}
+	switch getter.Get().(type) {
+	case bool:
+		if v, err := strconv.ParseBool(f.DefValue); err == nil {
+			return v
+		}
+	case int, int64, uint, uint64, float64:
+		return number(f.DefValue)
+	}
+	return f.DefValue
+}
+
+// number is a numeric default, written without quotes in either format.
+type number string
+
+// yamlTemplateValue formats a default as a YAML scalar, quoting strings
+// that are empty or hold characters YAML gives a meaning to.
+func yamlTemplateValue(value any) string {
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case number:
+		return string(v)
+	case string:
+		if v == "" || strings.ContainsAny(v, ":#'\"[]{},&*!|>%@`") || strings.TrimSpace(v) != v {
+			return strconv.Quote(v)
+		}
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// tomlTemplateValue formats a default as a TOML value.
+func tomlTemplateValue(value any) string {
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case number:
+		return string(v)
+	case string:
+		return strconv.Quote(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// wrapComment breaks text into lines of at most width bytes, at spaces.
+func wrapComment(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
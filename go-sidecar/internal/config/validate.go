@@ -0,0 +1,480 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-raft-sidecar/internal/ratelimit"
+)
+
+// resolveTimeout bounds the lookup of the advertise address in Validate.
+const resolveTimeout = 5 * time.Second
+
+// minRaftTimeout is the shortest timeout the Raft library accepts.
+const minRaftTimeout = 5 * time.Millisecond
+
+// maxAppendEntries is the largest batch the Raft library accepts.
+const maxAppendEntries = 1024
+
+// Validate checks the configuration for mistakes that would otherwise
+// surface later as obscure runtime errors: conflicting roles, ports used
+// twice, an advertise address other nodes cannot use, a data directory the
+// sidecar cannot write and inconsistent TLS options. It reports every
+// problem it finds, each naming the flags involved.
+func (c *Config) Validate() error {
+	var problems []error
+	fail := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	c.validateRoles(fail)
+	c.validatePorts(fail)
+	c.validateAdvertise(fail)
+	c.validateListenerAdvertise(fail)
+	c.validateDataDir(fail)
+	c.validateTLS(fail)
+	c.validateFiles(fail)
+	c.validateTunables(fail)
+
+	if c.ReapDeadServers && c.ReapAfter <= 0 {
+		fail("-reap-after must be positive")
+	}
+	if c.ProposeTimeout <= 0 {
+		fail("-propose-timeout must be positive")
+	}
+	if c.SlowApplyThreshold < 0 {
+		fail("-slow-apply-threshold must not be negative")
+	}
+	if c.SlowProposeThreshold < 0 {
+		fail("-slow-propose-threshold must not be negative")
+	}
+	if c.ProposeAuditSampleRate < 0 || c.ProposeAuditSampleRate > 1 {
+		fail("-propose-audit-sample-rate must be between 0 and 1")
+	}
+	if c.JoinMaxElapsed < 0 {
+		fail("-join-max-elapsed must not be negative")
+	}
+	if c.TLSReloadInterval < 0 {
+		fail("-tls-reload-interval must not be negative")
+	}
+	if (c.GRPCAPIKeysFile != "" || c.GRPCJWTKeyFile != "") && c.ClusterToken == "" && slices.Contains(c.GRPCAuthExempt, JoinMethod) {
+		fail("-grpc-auth-exempt lists %s, which would let anyone join without -cluster-token; set a cluster token or remove the method", JoinMethod)
+	}
+	if c.AllowUnsignedCommands && c.CommandSigningKeys == "" {
+		fail("-allow-unsigned-commands requires -command-signing-keys")
+	}
+	if c.LogFormat != "console" && c.LogFormat != "json" {
+		fail("-log-format must be console or json, got %q", c.LogFormat)
+	}
+	if c.LogMaxSize < 0 || c.LogMaxAge < 0 || c.LogMaxBackups < 0 {
+		fail("-log-max-size, -log-max-age and -log-max-backups must not be negative")
+	}
+	if info, err := os.Stat(filepath.Dir(c.LogFile)); c.LogFile != "" && (err != nil || !info.IsDir()) {
+		fail("-log-file %s: directory %s does not exist", c.LogFile, filepath.Dir(c.LogFile))
+	}
+	if _, _, err := net.SplitHostPort(c.StatsdAddr); c.StatsdAddr != "" && err != nil {
+		fail("-statsd-addr must be host:port, got %q", c.StatsdAddr)
+	}
+	if c.StatsdFormat != "statsd" && c.StatsdFormat != "dogstatsd" {
+		fail("-statsd-format must be statsd or dogstatsd, got %q", c.StatsdFormat)
+	}
+	if len(c.StatsdTags) > 0 && c.StatsdFormat != "dogstatsd" {
+		fail("-statsd-tags requires -statsd-format=dogstatsd")
+	}
+	for _, tag := range c.StatsdTags {
+		if strings.ContainsAny(tag, "|#@ ") {
+			fail("-statsd-tags must be key:value tags without '|', '#', '@' or spaces, got %q", tag)
+		}
+	}
+	if c.StatsdInterval <= 0 {
+		fail("-statsd-interval must be positive")
+	}
+	for _, hook := range c.LeaderWebhooks {
+		if u, err := url.Parse(hook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("-leader-webhooks must be http or https URLs, got %q", hook)
+		}
+	}
+	if c.LeaderlessAlertAfter <= 0 {
+		fail("-leaderless-alert-after must be positive")
+	}
+	for _, hook := range c.ClusterAlertWebhooks {
+		if u, err := url.Parse(hook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("-cluster-alert-webhooks must be http or https URLs, got %q", hook)
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// validateRoles checks that the ways of starting or joining a cluster, and
+// the kinds of member, are not combined in ways that contradict each other.
+func (c *Config) validateRoles(fail func(string, ...any)) {
+	if c.Bootstrap && !c.Dev && (c.JoinAddr != "" || c.JoinRPCAddr != "") {
+		fail("-bootstrap starts a new cluster and cannot be combined with -join or -join-rpc: bootstrap one node and join the others to it")
+	}
+	if c.JoinAddr != "" && c.JoinRPCAddr != "" {
+		fail("-join and -join-rpc are mutually exclusive")
+	}
+	if c.ReadOnly && c.Bootstrap {
+		fail("a read-only replica (-nonvoter-readonly) cannot bootstrap the cluster")
+	}
+	if (c.Nonvoter || c.Standby) && c.Bootstrap {
+		fail("a non-voter (-nonvoter or -standby) cannot bootstrap the cluster")
+	}
+	if c.Standby && c.ReadOnly {
+		fail("-standby cannot be combined with -nonvoter-readonly")
+	}
+	if len(c.Peers) > 0 && (c.BootstrapExpect > 0 || c.ReadOnly || c.Nonvoter || c.Standby) {
+		fail("-peers cannot be combined with -bootstrap-expect, -nonvoter, -nonvoter-readonly or -standby")
+	}
+	if c.BootstrapExpect > 0 && (c.Bootstrap || c.ReadOnly || c.Nonvoter || c.Standby) {
+		fail("-bootstrap-expect cannot be combined with -bootstrap, -nonvoter, -nonvoter-readonly or -standby")
+	}
+	if c.BootstrapExpect > 0 && len(c.RetryJoin) == 0 && c.Discovery == "" {
+		fail("-bootstrap-expect requires -retry-join or -discovery to find the other servers")
+	}
+	if c.WipeAndRejoin && (c.Bootstrap || len(c.Peers) > 0 || c.BootstrapExpect > 0) {
+		fail("-wipe-and-rejoin cannot be combined with -bootstrap, -peers or -bootstrap-expect")
+	}
+	if c.Dev && (c.JoinAddr != "" || c.JoinRPCAddr != "" || len(c.RetryJoin) > 0 || c.Discovery != "" || len(c.Peers) > 0 || c.BootstrapExpect > 0) {
+		fail("-dev runs a single-node cluster and cannot be combined with -join, -join-rpc, -retry-join, -discovery, -peers or -bootstrap-expect")
+	}
+	if c.Dev && (c.Nonvoter || c.ReadOnly || c.Standby || c.WipeAndRejoin) {
+		fail("-dev runs a single voter and cannot be combined with -nonvoter, -nonvoter-readonly, -standby or -wipe-and-rejoin")
+	}
+	if c.Dev && c.EncryptionKeyFile != "" {
+		fail("-dev keeps the Raft log in memory, so -encryption-key-file does not apply")
+	}
+	if c.WipeAndRejoin && c.JoinAddr == "" && c.JoinRPCAddr == "" {
+		fail("-wipe-and-rejoin requires -join or -join-rpc")
+	}
+	if c.LeaveOnShutdown && !c.MgmtListens() {
+		fail("-leave-on-shutdown requires the -mgmt listener, through which the node asks the leader to remove it")
+	}
+}
+
+// validatePorts checks that the listeners have distinct, valid ports and
+// that -app does not point back at the sidecar.
+func (c *Config) validatePorts(fail func(string, ...any)) {
+	used := make(map[int]string)
+	for _, listener := range []struct{ flag, port, socket string }{
+		{"-raft", c.RaftPort, ""},
+		{"-srv", c.SidecarPort, c.SidecarSocket},
+		{"-mgmt", c.MgmtPort, c.MgmtSocket},
+	} {
+		if listener.flag != "-raft" && listenerOff(listener.port) {
+			if listener.socket == "" {
+				fail("%s can only be off with %s-socket, or the API would not be served at all", listener.flag, listener.flag)
+			}
+			continue
+		}
+		port, err := strconv.Atoi(listener.port)
+		if err != nil || port < 1 || port > 65535 {
+			fail("%s must be a port number between 1 and 65535, got %q", listener.flag, listener.port)
+			continue
+		}
+		if other, ok := used[port]; ok {
+			fail("%s and %s both use port %d; give each listener its own port", other, listener.flag, port)
+			continue
+		}
+		used[port] = listener.flag
+	}
+
+	host, portText, err := net.SplitHostPort(c.AppAddr)
+	if err != nil {
+		fail("-app must be host:port, got %q", c.AppAddr)
+		return
+	}
+	// In development mode the built-in backend replaces -app.
+	if port, err := strconv.Atoi(portText); err == nil && isLocalHost(host) && !c.Dev {
+		if listener, ok := used[port]; ok {
+			fail("-app %s points at this sidecar's own %s listener; it must be the C++ backend's gRPC address", c.AppAddr, listener)
+		}
+	}
+
+	if c.MgmtBind != "" && net.ParseIP(c.MgmtBind) == nil {
+		fail("-mgmt-bind must be an IP address, got %q", c.MgmtBind)
+	}
+	if c.SidecarBind != "" && net.ParseIP(c.SidecarBind) == nil {
+		fail("-srv-bind must be an IP address, got %q", c.SidecarBind)
+	}
+	c.validateSockets(fail)
+}
+
+// maxSocketPath is the longest Unix domain socket path Linux accepts.
+const maxSocketPath = 107
+
+// validateSockets checks that the Unix domain sockets can be created: the
+// paths fit, differ, and name a file in an existing directory that is not
+// something other than a socket.
+func (c *Config) validateSockets(fail func(string, ...any)) {
+	if c.SidecarSocket != "" && c.SidecarSocket == c.MgmtSocket {
+		fail("-srv-socket and -mgmt-socket are both %s; give each API its own socket", c.SidecarSocket)
+		return
+	}
+	for _, socket := range []struct{ flag, path string }{
+		{"-srv-socket", c.SidecarSocket},
+		{"-mgmt-socket", c.MgmtSocket},
+	} {
+		if socket.path == "" {
+			continue
+		}
+		if len(socket.path) > maxSocketPath {
+			fail("%s %s is longer than the %d bytes a socket path may have", socket.flag, socket.path, maxSocketPath)
+			continue
+		}
+		if info, err := os.Stat(filepath.Dir(socket.path)); err != nil || !info.IsDir() {
+			fail("%s %s: directory %s does not exist", socket.flag, socket.path, filepath.Dir(socket.path))
+			continue
+		}
+		if info, err := os.Lstat(socket.path); err == nil && info.Mode()&os.ModeSocket == 0 {
+			fail("%s %s exists and is not a socket", socket.flag, socket.path)
+		}
+	}
+}
+
+// isLocalHost reports whether host names this machine.
+func isLocalHost(host string) bool {
+	if host == "" || host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
+
+// validateAdvertise checks that -advertise is set, or was detected, and
+// resolves, since other nodes reach this one at it.
+func (c *Config) validateAdvertise(fail func(string, ...any)) {
+	if c.advertiseErr != nil {
+		fail("%v", c.advertiseErr)
+		return
+	}
+	if c.RaftAdvertise == "" {
+		fail("-advertise is required: other nodes reach this one at it, and the 0.0.0.0 bind address cannot be advertised")
+		return
+	}
+	if ip := net.ParseIP(c.RaftAdvertise); ip != nil {
+		if ip.IsUnspecified() {
+			fail("-advertise %s cannot be advertised; use an address other nodes can reach", c.RaftAdvertise)
+		}
+		return
+	}
+	if _, _, err := net.SplitHostPort(c.RaftAdvertise); err == nil {
+		fail("-advertise takes a host name or IP address without a port, got %q; the ports come from -raft, -srv and -mgmt", c.RaftAdvertise)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	if _, err := net.DefaultResolver.LookupHost(ctx, c.RaftAdvertise); err != nil {
+		fail("-advertise %s does not resolve (%v); use an IP address or a name that resolves on every node", c.RaftAdvertise, err)
+	}
+}
+
+// validateListenerAdvertise checks the -srv-advertise and -mgmt-advertise
+// overrides of the addresses derived from -advertise.
+func (c *Config) validateListenerAdvertise(fail func(string, ...any)) {
+	for _, listener := range []struct{ flag, addr string }{
+		{"-srv-advertise", c.SidecarAdvertise},
+		{"-mgmt-advertise", c.MgmtAdvertise},
+	} {
+		if listener.addr == "" {
+			continue
+		}
+		host := listener.addr
+		if h, portText, err := net.SplitHostPort(listener.addr); err == nil {
+			host = h
+			if port, err := strconv.Atoi(portText); err != nil || port < 1 || port > 65535 {
+				fail("%s must be a host or host:port with a port between 1 and 65535, got %q", listener.flag, listener.addr)
+				continue
+			}
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			if ip.IsUnspecified() {
+				fail("%s %s cannot be advertised; use an address other nodes can reach", listener.flag, listener.addr)
+			}
+			continue
+		}
+		if host == "" {
+			fail("%s must name a host, got %q", listener.flag, listener.addr)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		_, err := net.DefaultResolver.LookupHost(ctx, host)
+		cancel()
+		if err != nil {
+			fail("%s %s does not resolve (%v); use an IP address or a name that resolves on every node", listener.flag, host, err)
+		}
+	}
+}
+
+// validateDataDir checks that the data directory exists, or can be
+// created, and is writable.
+func (c *Config) validateDataDir(fail func(string, ...any)) {
+	if c.DataDir == "" {
+		fail("-data must not be empty")
+		return
+	}
+	info, err := os.Stat(c.DataDir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := os.MkdirAll(c.DataDir, 0700); err != nil {
+			fail("-data %s does not exist and cannot be created: %v", c.DataDir, err)
+			return
+		}
+	case err != nil:
+		fail("-data %s: %v", c.DataDir, err)
+		return
+	case !info.IsDir():
+		fail("-data %s is not a directory", c.DataDir)
+		return
+	}
+	probe, err := os.CreateTemp(c.DataDir, ".write-check-*")
+	if err != nil {
+		fail("-data %s is not writable by this user (uid %d): %v", c.DataDir, os.Getuid(), err)
+		return
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+}
+
+// validateTLS checks that TLS options are given together and that only one
+// source of certificates is used.
+func (c *Config) validateTLS(fail func(string, ...any)) {
+	raftFiles := c.RaftTLSCert != "" || c.RaftTLSKey != "" || c.RaftTLSCA != ""
+	mgmtFiles := c.MgmtTLSCert != "" || c.MgmtTLSKey != "" || c.MgmtTLSCA != ""
+	provided := c.SPIFFESocket != "" || c.VaultPKIRole != ""
+
+	if c.SPIFFESocket != "" && c.VaultPKIRole != "" {
+		fail("-spiffe-socket cannot be combined with -vault-pki-role")
+	}
+	if provided && (raftFiles || mgmtFiles) {
+		fail("-spiffe-socket and -vault-pki-role cannot be combined with -raft-tls-* or -mgmt-tls-* files")
+	}
+	if c.VaultPKIRole != "" && c.VaultAddr == "" {
+		fail("-vault-pki-role requires -vault-addr or %s", VaultAddrEnv)
+	}
+
+	if (c.RaftTLSCert == "") != (c.RaftTLSKey == "") {
+		fail("-raft-tls-cert and -raft-tls-key must be given together")
+	}
+	if c.RaftTLSCA != "" && c.RaftTLSCert == "" {
+		fail("-raft-tls-ca requires -raft-tls-cert and -raft-tls-key: the Raft transport only verifies peers when it uses TLS")
+	}
+	if !provided {
+		if c.RaftMTLS && c.RaftTLSCA == "" {
+			fail("-raft-mtls requires -raft-tls-cert, -raft-tls-key and -raft-tls-ca")
+		}
+		if len(c.RaftAllowedPeers) > 0 && c.RaftTLSCA == "" {
+			fail("-raft-allowed-peers requires -raft-tls-cert, -raft-tls-key and -raft-tls-ca, since peers are identified by their certificates")
+		}
+	}
+
+	if (c.MgmtTLSCert == "") != (c.MgmtTLSKey == "") {
+		fail("-mgmt-tls-cert and -mgmt-tls-key must be given together")
+	}
+	if c.MgmtTLSCA != "" && c.MgmtTLSCert == "" {
+		fail("-mgmt-tls-ca requires -mgmt-tls-cert and -mgmt-tls-key")
+	}
+	if c.MgmtMTLS && !provided && c.MgmtTLSCA == "" {
+		fail("-mgmt-mtls requires -mgmt-tls-cert, -mgmt-tls-key and -mgmt-tls-ca")
+	}
+}
+
+// validateFiles checks that every file named in the configuration can be
+// read, so that a typo is reported at once rather than when the file is
+// first used.
+func (c *Config) validateFiles(fail func(string, ...any)) {
+	for _, file := range []struct{ flag, path string }{
+		{"-raft-tls-cert", c.RaftTLSCert},
+		{"-raft-tls-key", c.RaftTLSKey},
+		{"-raft-tls-ca", c.RaftTLSCA},
+		{"-mgmt-tls-cert", c.MgmtTLSCert},
+		{"-mgmt-tls-key", c.MgmtTLSKey},
+		{"-mgmt-tls-ca", c.MgmtTLSCA},
+		{"-mgmt-token-file", c.MgmtTokenFile},
+		{"-grpc-api-keys-file", c.GRPCAPIKeysFile},
+		{"-grpc-jwt-key-file", c.GRPCJWTKeyFile},
+		{"-rbac-policy", c.RBACPolicy},
+		{"-encryption-key-file", c.EncryptionKeyFile},
+		{"-vault-token-file", c.VaultTokenFile},
+		{"-vault-ca-cert", c.VaultCACert},
+		{"-command-acl", c.CommandACL},
+		{"-command-signing-keys", c.CommandSigningKeys},
+		{"-discovery-file", c.DiscoveryFile},
+		{"-cdc-url-file", c.CDCURLFile},
+		{"-leader-webhooks-file", c.LeaderWebhooksFile},
+		{"-cluster-alert-webhooks-file", c.ClusterAlertWebhooksFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+		f, err := os.Open(file.path)
+		if err != nil {
+			fail("%s: %v", file.flag, err)
+			continue
+		}
+		f.Close()
+	}
+}
+
+// validateTunables checks the settings Reload can change, and the Raft
+// settings they must agree with, so that a reload is rejected as a whole
+// rather than applied in part. The Raft checks are those of
+// raft.ValidateConfig, naming the flags.
+func (c *Config) validateTunables(fail func(string, ...any)) {
+	switch c.LogLevel {
+	case "trace", "debug", "info", "warn", "error":
+	default:
+		fail("-log-level must be trace, debug, info, warn or error, got %q", c.LogLevel)
+	}
+	switch c.RaftLogLevel {
+	case "", "trace", "debug", "info", "warn", "error":
+	default:
+		fail("-raft-log-level must be trace, debug, info, warn or error, got %q", c.RaftLogLevel)
+	}
+	for _, limit := range []struct{ flag, spec string }{
+		{"-propose-rate-limit", c.ProposeRateLimit},
+		{"-propose-client-rate-limit", c.ProposeClientRateLimit},
+		{"-join-rate-limit", c.JoinRateLimit},
+		{"-join-client-rate-limit", c.JoinClientRateLimit},
+	} {
+		if _, err := ratelimit.ParseLimit(limit.spec); err != nil {
+			fail("%s: %v", limit.flag, err)
+		}
+	}
+	if c.SnapshotThreshold == 0 {
+		fail("-snapshot-threshold must be positive")
+	}
+	if c.SnapshotInterval < minRaftTimeout {
+		fail("-snapshot-interval must be at least %s", minRaftTimeout)
+	}
+	if c.HeartbeatTimeout < minRaftTimeout {
+		fail("-heartbeat-timeout must be at least %s", minRaftTimeout)
+	}
+	if c.ElectionTimeout < c.HeartbeatTimeout {
+		fail("-election-timeout (%s) must not be shorter than -heartbeat-timeout (%s)", c.ElectionTimeout, c.HeartbeatTimeout)
+	}
+	if c.LeaderLeaseTimeout < minRaftTimeout {
+		fail("-leader-lease-timeout must be at least %s", minRaftTimeout)
+	}
+	if c.LeaderLeaseTimeout > c.HeartbeatTimeout {
+		fail("-leader-lease-timeout (%s) must not be longer than -heartbeat-timeout (%s)", c.LeaderLeaseTimeout, c.HeartbeatTimeout)
+	}
+	if c.CommitTimeout < time.Millisecond {
+		fail("-commit-timeout must be at least 1ms")
+	}
+	if c.MaxAppendEntries < 1 || c.MaxAppendEntries > maxAppendEntries {
+		fail("-max-append-entries must be between 1 and %d, got %d", maxAppendEntries, c.MaxAppendEntries)
+	}
+	if _, ok := profiles[c.Profile]; c.Profile != "" && !ok {
+		fail("-profile must be one of %s, got %q", strings.Join(profileNames(), ", "), c.Profile)
+	}
+}
@@ -0,0 +1,118 @@
+// Package events fans out structured cluster events, such as leader
+// elections and membership changes, to live subscribers.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// bufferSize is the number of events buffered per subscriber before it is
+// considered too slow and dropped.
+const bufferSize = 256
+
+// Event types.
+const (
+	LeaderElected    = "leader_elected"
+	LeaderLost       = "leader_lost"
+	MemberJoined     = "member_joined"
+	MemberRemoved    = "member_removed"
+	SnapshotTaken    = "snapshot_taken"
+	BackendUnhealthy = "backend_unhealthy"
+	BackendHealthy   = "backend_healthy"
+)
+
+// Event is something that happened to the cluster, as observed by NodeID.
+// Fields that do not apply to the event's type are empty.
+type Event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	NodeID string    `json:"node_id"`
+	Term   uint64    `json:"term,omitempty"`
+	Index  uint64    `json:"index,omitempty"`
+	// Member and Address identify the server the event is about: the new
+	// leader or the member that joined or was removed.
+	Member   string `json:"member,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Suffrage string `json:"suffrage,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Subscription delivers events to a single subscriber.
+type Subscription struct {
+	// C receives every event published after the subscription was
+	// created. It is closed when the subscription is cancelled or falls
+	// too far behind; Dropped reports which.
+	C <-chan Event
+
+	ch      chan Event
+	bus     *Bus
+	dropped bool
+}
+
+// Dropped reports whether the subscription was closed because the
+// subscriber could not keep up.
+func (s *Subscription) Dropped() bool {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.dropped
+}
+
+// Cancel stops delivery and releases the subscription.
+func (s *Subscription) Cancel() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, ok := s.bus.subs[s]; !ok {
+		return
+	}
+	delete(s.bus.subs, s)
+	close(s.ch)
+}
+
+// Bus fans events out to subscribers. A nil Bus discards events.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// NewBus returns a Bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscriber for events published from now on.
+func (b *Bus) Subscribe() *Subscription {
+	ch := make(chan Event, bufferSize)
+	sub := &Subscription{C: ch, ch: ch, bus: b}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Publish delivers e to every subscriber without blocking, setting its
+// time if unset. A subscriber whose buffer is full is dropped so that a
+// slow client can never hold up the publisher.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		select {
+		case sub.ch <- e:
+		default:
+			sub.dropped = true
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
@@ -2,19 +2,34 @@
 package fsm
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"io"
-	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/metadata"
 
+	"my-raft-sidecar/internal/logging"
+	"my-raft-sidecar/internal/requestid"
+	"my-raft-sidecar/internal/signing"
+	"my-raft-sidecar/internal/tracing"
 	pb "my-raft-sidecar/pb"
 )
 
+// logger logs with component=fsm.
+var logger = logging.Component("fsm")
+
 // StateMachineClient defines the interface for applying commands to the state machine.
 // This abstraction allows for easier testing and decoupling from gRPC.
 type StateMachineClient interface {
 	Apply(ctx context.Context, cmd *pb.Command) (*pb.ApplyResponse, error)
+	Scan(ctx context.Context, req *pb.ScanRequest) (pb.StateMachine_ScanClient, error)
+	Read(ctx context.Context, req *pb.ReadRequest) (*pb.ReadResponse, error)
 }
 
 // grpcStateMachineClient wraps the generated gRPC client to satisfy our interface.
@@ -27,6 +42,16 @@ func (g *grpcStateMachineClient) Apply(ctx context.Context, cmd *pb.Command) (*p
 	return g.client.Apply(ctx, cmd)
 }
 
+// Scan streams a key range from the C++ backend via gRPC.
+func (g *grpcStateMachineClient) Scan(ctx context.Context, req *pb.ScanRequest) (pb.StateMachine_ScanClient, error) {
+	return g.client.Scan(ctx, req)
+}
+
+// Read looks up a key in the C++ backend via gRPC.
+func (g *grpcStateMachineClient) Read(ctx context.Context, req *pb.ReadRequest) (*pb.ReadResponse, error) {
+	return g.client.Read(ctx, req)
+}
+
 // NewStateMachineClient creates a StateMachineClient from a gRPC client.
 func NewStateMachineClient(client pb.StateMachineClient) StateMachineClient {
 	return &grpcStateMachineClient{client: client}
@@ -35,50 +60,248 @@ func NewStateMachineClient(client pb.StateMachineClient) StateMachineClient {
 // CppFSM implements the raft.FSM interface, forwarding Apply calls to the C++ backend.
 type CppFSM struct {
 	client StateMachineClient
+
+	// applyMu is held for writing while an entry is applied and for reading
+	// while a consistent view of the backend is pinned (see Pin).
+	applyMu      sync.RWMutex
+	appliedIndex atomic.Uint64
+	appliedTerm  atomic.Uint64
+
+	// appliedCh is closed and replaced whenever the applied index moves, to
+	// wake WaitApplied.
+	appliedMu sync.Mutex
+	appliedCh chan struct{}
+
+	watchers *watchHub
+	meta     *metaStore
+	metrics  *applyMetrics
+
+	// signatures, if set, holds the keys the signature of every command is
+	// checked with, against the replicated key set, before it is applied.
+	signatures *signing.Verifier
+	// slowApply is the duration past which an apply is logged and counted
+	// as slow; zero disables the check.
+	slowApply time.Duration
 }
 
 // NewCppFSM creates a new FSM that delegates to the given state machine client.
 func NewCppFSM(client StateMachineClient) *CppFSM {
-	return &CppFSM{client: client}
+	return &CppFSM{
+		client:    client,
+		appliedCh: make(chan struct{}),
+		watchers:  newWatchHub(),
+		meta:      newMetaStore(),
+		metrics:   newApplyMetrics(),
+	}
+}
+
+// VerifySignatures gives Apply the keys to check the signature of every
+// command with. Entries are checked against the replicated key set, so
+// every node refuses the same ones; a node that holds a key of the set
+// with another fingerprint, or lacks the key an entry is signed with,
+// exits instead. It must be called before the FSM is handed to Raft.
+func (f *CppFSM) VerifySignatures(v *signing.Verifier) {
+	f.signatures = v
+}
+
+// WarnSlowApplies makes Apply log a warning for every entry whose apply, or
+// the backend's Apply call for it, takes longer than threshold, and count
+// it in raftkv_fsm_slow_applies_total. It must be called before the FSM is
+// handed to Raft.
+func (f *CppFSM) WarnSlowApplies(threshold time.Duration) {
+	f.slowApply = threshold
+}
+
+// ApplyBatch applies the log entries Raft has committed together, in
+// order. Configuration changes are not the backend's and are skipped.
+func (f *CppFSM) ApplyBatch(logs []*raft.Log) []interface{} {
+	f.metrics.batch.Observe(float64(len(logs)))
+	results := make([]interface{}, len(logs))
+	for i, l := range logs {
+		if l.Type == raft.LogCommand {
+			results[i] = f.Apply(l)
+		}
+	}
+	return results
 }
 
 // Apply applies a Raft log entry to the C++ backend.
 func (f *CppFSM) Apply(l *raft.Log) interface{} {
-	_, err := f.client.Apply(context.Background(), &pb.Command{Data: l.Data})
+	f.applyMu.Lock()
+	defer f.applyMu.Unlock()
+
+	start := time.Now()
+	var requestID string
+	var trace tracing.Context
+	var backendDuration time.Duration
+	defer func() { f.observeApply(l, requestID, trace, time.Since(start), backendDuration) }()
+
+	if bytes.Equal(l.Extensions, ClusterIDExtension) {
+		defer f.markApplied(l)
+		f.applyClusterID(string(l.Data))
+		return nil
+	}
+	if bytes.Equal(l.Extensions, SigningKeysExtension) {
+		defer f.markApplied(l)
+		set, err := decodeSigningKeys(l.Data)
+		if err != nil {
+			logger.Error("Failed to apply signing keys", "error", err)
+			return err
+		}
+		if err := f.signatures.Check(set); err != nil {
+			halt(l.Index, err)
+		}
+		f.meta.mu.Lock()
+		f.meta.signingKeys = set
+		f.meta.mu.Unlock()
+		return nil
+	}
+	if IsMeta(l) {
+		defer f.markApplied(l)
+		if err := f.meta.apply(l.Data); err != nil {
+			logger.Error("Failed to apply peer metadata", "error", err)
+			return err
+		}
+		return nil
+	}
+
+	requestID, extensions := requestid.Split(l.Extensions)
+	trace, extensions = tracing.Split(extensions)
+	if err := f.signatures.VerifyEntry(f.SigningKeys(), extensions, l.Data); err != nil {
+		if errors.Is(err, signing.ErrKeyUnavailable) {
+			halt(l.Index, err)
+		}
+		// Every node refuses the entry with the same error. Refused
+		// entries are not passed on to watchers either.
+		logger.Error("Refusing to apply entry", "index", l.Index, "request_id", requestID, "trace_id", trace.TraceID, "error", err)
+		f.setApplied(l.Index, l.Term)
+		return err
+	}
+	defer f.markApplied(l)
+
+	// The backend gets the request ID in the command and as metadata, and
+	// the trace context as metadata, for its logs and any interceptors
+	ctx := context.Background()
+	if requestID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, requestid.MetadataKey, requestID)
+	}
+	if !trace.IsZero() {
+		ctx = metadata.AppendToOutgoingContext(ctx, tracing.TraceparentKey, trace.Traceparent(), tracing.OriginKey, trace.Origin)
+	}
+	rpcStart := time.Now()
+	_, err := f.client.Apply(ctx, &pb.Command{Data: l.Data, Term: l.Term, Index: l.Index, RequestId: requestID})
+	backendDuration = time.Since(rpcStart)
+	f.metrics.backend.ObserveDuration(backendDuration)
 	if err != nil {
-		log.Printf("ERROR: Failed to apply to C++ DB: %v", err)
+		f.metrics.backendError(err)
+		logger.Error("Failed to apply to C++ DB", "index", l.Index, "request_id", requestID, "trace_id", trace.TraceID, "origin_node", trace.Origin, "error", err)
 		return err
 	}
+	if !trace.IsZero() {
+		logger.Debug("Applied entry", "index", l.Index, "request_id", requestID, "trace_id", trace.TraceID, "span_id", trace.SpanID, "origin_node", trace.Origin)
+	}
 	return nil
 }
 
-// Snapshot returns a snapshot of the FSM state.
-// Currently returns a dummy snapshot as snapshot support is not fully implemented.
-func (f *CppFSM) Snapshot() (raft.FSMSnapshot, error) {
-	return &DummySnapshot{}, nil
+// markApplied records l as the last applied entry and notifies watchers of
+// backend commands.
+func (f *CppFSM) markApplied(l *raft.Log) {
+	f.setApplied(l.Index, l.Term)
+	if !IsMeta(l) {
+		f.watchers.publish(AppliedEntry{Index: l.Index, Term: l.Term, Data: l.Data})
+	}
+}
+
+// halt stops the process: the entry at index cannot be applied as the
+// other nodes apply it, and skipping it would diverge from them as well.
+func halt(index uint64, err error) {
+	logger.Error("Cannot apply entry as the other nodes do, exiting", "index", index, "error", err)
+	os.Exit(1)
 }
 
-// Restore restores the FSM from a snapshot.
-// Currently a no-op as snapshot support is not fully implemented.
-func (f *CppFSM) Restore(rc io.ReadCloser) error {
-	defer rc.Close()
-	return nil
+// setApplied records the position of the last applied entry and wakes any
+// WaitApplied callers.
+func (f *CppFSM) setApplied(index, term uint64) {
+	f.appliedIndex.Store(index)
+	f.appliedTerm.Store(term)
+
+	f.appliedMu.Lock()
+	close(f.appliedCh)
+	f.appliedCh = make(chan struct{})
+	f.appliedMu.Unlock()
 }
 
-// DummySnapshot is a placeholder snapshot implementation.
-type DummySnapshot struct{}
+// AppliedIndex returns the index of the last entry applied to the backend.
+func (f *CppFSM) AppliedIndex() uint64 {
+	return f.appliedIndex.Load()
+}
 
-// Persist writes the snapshot to the given sink.
-func (d *DummySnapshot) Persist(sink raft.SnapshotSink) error {
-	defer sink.Close()
-	return nil
+// WaitApplied blocks until the entry at index has been applied to the
+// backend or ctx is done. Only commands are applied, so index must be that
+// of a command (see raftnode.Node.LastCommandIndex).
+func (f *CppFSM) WaitApplied(ctx context.Context, index uint64) error {
+	for {
+		f.appliedMu.Lock()
+		ch := f.appliedCh
+		f.appliedMu.Unlock()
+
+		if f.appliedIndex.Load() >= index {
+			return nil
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Pin blocks further applies until release is called, returning the index
+// of the last applied entry. While pinned, the backend state reflects
+// exactly that point in the log. Callers must release promptly since the
+// Raft apply pipeline stalls for as long as the pin is held.
+func (f *CppFSM) Pin() (index uint64, release func()) {
+	f.applyMu.RLock()
+	return f.appliedIndex.Load(), f.applyMu.RUnlock
+}
+
+// Subscribe registers a watcher for applied entries. The returned index is
+// the last entry applied before the subscription took effect: every later
+// entry is delivered on the subscription's channel.
+func (f *CppFSM) Subscribe() (sub *Subscription, index uint64) {
+	f.applyMu.RLock()
+	defer f.applyMu.RUnlock()
+	return f.watchers.subscribe(), f.appliedIndex.Load()
 }
 
-// Release releases any resources held by the snapshot.
-func (d *DummySnapshot) Release() {}
+// Scan returns a key range from the backend, and the index of the last
+// applied entry, which every key/value reflects. Applies are paused only
+// while the backend streams the range, which is copied so that the caller
+// can send it on at its own pace.
+func (f *CppFSM) Scan(ctx context.Context, req *pb.ScanRequest) (index uint64, kvs []*pb.KeyValue, err error) {
+	index, release := f.Pin()
+	defer release()
 
-// Ensure CppFSM implements raft.FSM at compile time.
-var _ raft.FSM = (*CppFSM)(nil)
+	stream, err := f.client.Scan(ctx, req)
+	if err != nil {
+		return 0, nil, err
+	}
+	for {
+		kv, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return index, kvs, nil
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		kvs = append(kvs, kv)
+	}
+}
+
+// Read looks up a key in the backend's current state.
+func (f *CppFSM) Read(ctx context.Context, req *pb.ReadRequest) (*pb.ReadResponse, error) {
+	return f.client.Read(ctx, req)
+}
 
-// Ensure DummySnapshot implements raft.FSMSnapshot at compile time.
-var _ raft.FSMSnapshot = (*DummySnapshot)(nil)
+// Ensure CppFSM implements raft.BatchingFSM at compile time.
+var _ raft.BatchingFSM = (*CppFSM)(nil)
@@ -0,0 +1,195 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/signing"
+)
+
+// MetaExtension marks log entries that carry sidecar metadata instead of a
+// backend command. Such entries are consumed by the FSM itself and never
+// forwarded to the C++ backend or to watchers.
+var MetaExtension = []byte("raftkv-meta")
+
+// ClusterIDExtension marks log entries that carry the cluster ID. Like
+// metadata entries they are consumed by the FSM itself.
+var ClusterIDExtension = []byte("raftkv-cluster-id")
+
+// SigningKeysExtension marks log entries that carry the signing.KeySet
+// commands are checked against. Like metadata entries they are consumed by
+// the FSM itself.
+var SigningKeysExtension = []byte("raftkv-signing-keys")
+
+// PeerMeta describes how to reach a cluster member's sidecar endpoints.
+type PeerMeta struct {
+	NodeID      string `json:"node_id"`
+	SidecarAddr string `json:"sidecar_addr"`
+	MgmtAddr    string `json:"mgmt_addr"`
+	// Priority is the node's leadership preference; the leader hands off
+	// to a healthy, caught-up voter with a higher priority.
+	Priority int `json:"priority,omitempty"`
+	// ReadOnly marks a non-voter that must never be promoted to voter.
+	ReadOnly bool `json:"read_only,omitempty"`
+	// Standby marks a hot spare: a non-voter that is only promoted on
+	// request, and serves no client traffic until then.
+	Standby bool `json:"standby,omitempty"`
+	// Zone and Rack are the failure domain the node declares itself in.
+	// Leadership and stale reads prefer nodes in the same zone.
+	Zone string `json:"zone,omitempty"`
+	Rack string `json:"rack,omitempty"`
+}
+
+// IsMeta reports whether l is a metadata, cluster ID or signing keys entry.
+func IsMeta(l *raft.Log) bool {
+	return bytes.Equal(l.Extensions, MetaExtension) || bytes.Equal(l.Extensions, ClusterIDExtension) ||
+		bytes.Equal(l.Extensions, SigningKeysExtension)
+}
+
+// EncodePeerMeta serializes metadata for a MetaExtension log entry.
+func EncodePeerMeta(meta *PeerMeta) ([]byte, error) {
+	return json.Marshal(meta)
+}
+
+// metaStore holds the replicated peer metadata.
+type metaStore struct {
+	mu    sync.RWMutex
+	peers map[string]PeerMeta
+
+	clusterID   string
+	onClusterID func(id string)
+
+	// signingKeys is the replicated key set commands are checked against.
+	signingKeys *signing.KeySet
+}
+
+func newMetaStore() *metaStore {
+	return &metaStore{peers: make(map[string]PeerMeta)}
+}
+
+func (m *metaStore) apply(data []byte) error {
+	var meta PeerMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("malformed peer metadata: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.peers[meta.NodeID] = meta
+	return nil
+}
+
+// restoreMeta replaces the peer metadata with peers and the signing keys
+// with signingKeys, and adopts clusterID unless an ID has already been
+// applied, as applyClusterID would.
+func (f *CppFSM) restoreMeta(clusterID string, peers []PeerMeta, signingKeys *signing.KeySet) {
+	f.meta.mu.Lock()
+	f.meta.peers = make(map[string]PeerMeta, len(peers))
+	for _, meta := range peers {
+		f.meta.peers[meta.NodeID] = meta
+	}
+	f.meta.signingKeys = signingKeys
+	f.meta.mu.Unlock()
+
+	if clusterID != "" {
+		f.applyClusterID(clusterID)
+	}
+}
+
+// Peer returns the metadata recorded for a node.
+func (f *CppFSM) Peer(id string) (PeerMeta, bool) {
+	f.meta.mu.RLock()
+	defer f.meta.mu.RUnlock()
+	meta, ok := f.meta.peers[id]
+	return meta, ok
+}
+
+// Peers returns the metadata of every known node, ordered by node ID.
+func (f *CppFSM) Peers() []PeerMeta {
+	f.meta.mu.RLock()
+	defer f.meta.mu.RUnlock()
+
+	peers := make([]PeerMeta, 0, len(f.meta.peers))
+	for _, meta := range f.meta.peers {
+		peers = append(peers, meta)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].NodeID < peers[j].NodeID })
+	return peers
+}
+
+// applyClusterID records the cluster ID carried by an entry. The first ID
+// in the log wins, so that every member agrees even if two leaders
+// generated one.
+func (f *CppFSM) applyClusterID(id string) {
+	f.meta.mu.Lock()
+	if f.meta.clusterID != "" {
+		f.meta.mu.Unlock()
+		return
+	}
+	f.meta.clusterID = id
+	handler := f.meta.onClusterID
+	f.meta.mu.Unlock()
+
+	if handler != nil {
+		handler(id)
+	}
+}
+
+// ClusterID returns the replicated cluster ID, or "" if none has been
+// applied yet.
+func (f *CppFSM) ClusterID() string {
+	f.meta.mu.RLock()
+	defer f.meta.mu.RUnlock()
+	return f.meta.clusterID
+}
+
+// OnClusterID registers fn to be called with the cluster ID once it has
+// been applied.
+func (f *CppFSM) OnClusterID(fn func(id string)) {
+	f.meta.mu.Lock()
+	f.meta.onClusterID = fn
+	id := f.meta.clusterID
+	f.meta.mu.Unlock()
+
+	if id != "" {
+		fn(id)
+	}
+}
+
+// EncodeSigningKeys serializes a key set for a SigningKeysExtension log
+// entry.
+func EncodeSigningKeys(set *signing.KeySet) ([]byte, error) {
+	return json.Marshal(set)
+}
+
+// decodeSigningKeys parses the key set carried by an entry.
+func decodeSigningKeys(data []byte) (*signing.KeySet, error) {
+	var set signing.KeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("malformed signing keys: %w", err)
+	}
+	return &set, nil
+}
+
+// SigningKeys returns the replicated key set, or nil if none has been
+// applied.
+func (f *CppFSM) SigningKeys() *signing.KeySet {
+	f.meta.mu.RLock()
+	defer f.meta.mu.RUnlock()
+	return f.meta.signingKeys
+}
+
+// LocalSigningKeys returns the set describing the keys given to
+// VerifySignatures, which is empty if signatures are not checked on this
+// node.
+func (f *CppFSM) LocalSigningKeys() *signing.KeySet {
+	if f.signatures == nil {
+		return &signing.KeySet{}
+	}
+	return f.signatures.KeySet()
+}
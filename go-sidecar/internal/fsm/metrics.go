@@ -0,0 +1,90 @@
+package fsm
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/status"
+
+	"my-raft-sidecar/internal/metrics"
+	"my-raft-sidecar/internal/tracing"
+)
+
+// batchSizeBuckets are the bounds of the batch size histogram.
+var batchSizeBuckets = []float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024}
+
+// applyMetrics instruments the apply pipeline.
+type applyMetrics struct {
+	// apply times Apply as a whole, backend the backend's Apply RPC
+	// alone, and batch counts the entries Raft hands over at once.
+	apply   *metrics.Histogram
+	backend *metrics.Histogram
+	batch   *metrics.Histogram
+
+	// slowApplies and slowBackend count the applies and backend Apply
+	// RPCs that took longer than the slow apply threshold.
+	slowApplies atomic.Uint64
+	slowBackend atomic.Uint64
+
+	mu sync.Mutex
+	// errors counts failed backend Apply RPCs by gRPC status code.
+	errors map[string]uint64
+}
+
+func newApplyMetrics() *applyMetrics {
+	return &applyMetrics{
+		apply:   metrics.NewHistogram(metrics.DurationBuckets),
+		backend: metrics.NewHistogram(metrics.DurationBuckets),
+		batch:   metrics.NewHistogram(batchSizeBuckets),
+		errors:  make(map[string]uint64),
+	}
+}
+
+// backendError counts a failed backend Apply RPC.
+func (m *applyMetrics) backendError(err error) {
+	code := status.Code(err).String()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[code]++
+}
+
+// observeApply records how long applying l took, and the backend's Apply
+// RPC for it if one was made, warning about it if it was slow.
+func (f *CppFSM) observeApply(l *raft.Log, requestID string, trace tracing.Context, duration, backend time.Duration) {
+	f.metrics.apply.ObserveDuration(duration)
+	if f.slowApply <= 0 || duration <= f.slowApply {
+		return
+	}
+	f.metrics.slowApplies.Add(1)
+	if backend > f.slowApply {
+		f.metrics.slowBackend.Add(1)
+	}
+	logger.Warn("Slow apply", "index", l.Index, "term", l.Term, "request_id", requestID, "trace_id", trace.TraceID, "origin_node", trace.Origin, "size", len(l.Data),
+		"duration", duration.String(), "backend_duration", backend.String(), "threshold", f.slowApply.String())
+}
+
+// Collect reports the apply pipeline's latency, batch size, slow apply and
+// backend error metrics.
+func (f *CppFSM) Collect(w *metrics.Writer) {
+	m := f.metrics
+	w.Histogram("raftkv_fsm_apply_duration_seconds", "Time to apply a log entry, including signature checks and the backend call.", m.apply)
+	w.Histogram("raftkv_fsm_backend_apply_duration_seconds", "Duration of the backend's Apply RPC.", m.backend)
+	w.Histogram("raftkv_fsm_apply_batch_entries", "Number of committed log entries handed to the state machine at once.", m.batch)
+	w.Counter("raftkv_fsm_slow_applies_total", "Log entries whose apply took longer than -slow-apply-threshold.", float64(m.slowApplies.Load()), "stage", "apply")
+	w.Counter("raftkv_fsm_slow_applies_total", "Log entries whose apply took longer than -slow-apply-threshold.", float64(m.slowBackend.Load()), "stage", "backend")
+	w.Gauge("raftkv_fsm_applied_index", "Index of the last log entry applied to the backend.", float64(f.AppliedIndex()))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	codes := make([]string, 0, len(m.errors))
+	for code := range m.errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		w.Counter("raftkv_fsm_backend_apply_errors_total", "Backend Apply RPCs that failed, by gRPC status code.", float64(m.errors[code]), "code", code)
+	}
+}
@@ -0,0 +1,228 @@
+package fsm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/v2/codec"
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/signing"
+	pb "my-raft-sidecar/pb"
+)
+
+// snapshotVersion is the format of the snapshots written by Snapshot.
+const snapshotVersion = 1
+
+// snapshotTimeout bounds each backend call made while taking or restoring
+// a snapshot.
+const snapshotTimeout = 5 * time.Minute
+
+// snapshotHeader is the first line of a snapshot. It is followed by one
+// snapshotPair per key in the backend, in key order.
+type snapshotHeader struct {
+	Version int `json:"version"`
+	// Index and Term are those of the last entry applied to the backend.
+	Index     uint64     `json:"index"`
+	Term      uint64     `json:"term"`
+	ClusterID string     `json:"cluster_id,omitempty"`
+	Peers     []PeerMeta `json:"peers,omitempty"`
+	// SigningKeys is the replicated key set, if signatures are checked.
+	SigningKeys *signing.KeySet `json:"signing_keys,omitempty"`
+	Keys        int             `json:"keys"`
+}
+
+// snapshotPair is a key and its value.
+type snapshotPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// snapshotCommand is a command applied to the backend on Restore, encoded
+// as clients encode theirs.
+type snapshotCommand struct {
+	Op    string `codec:"op"`
+	Key   string `codec:"key"`
+	Value string `codec:"value,omitempty"`
+}
+
+// Snapshot captures the backend's keys and the replicated metadata. Raft
+// does not apply entries while it runs, so the keys scanned reflect the
+// last applied entry; they are copied so that Persist can write them
+// while applies continue.
+func (f *CppFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.applyMu.RLock()
+	defer f.applyMu.RUnlock()
+
+	pairs, err := f.scanAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan backend for snapshot: %w", err)
+	}
+	header := snapshotHeader{
+		Version:     snapshotVersion,
+		Index:       f.appliedIndex.Load(),
+		Term:        f.appliedTerm.Load(),
+		ClusterID:   f.ClusterID(),
+		Peers:       f.Peers(),
+		SigningKeys: f.SigningKeys(),
+		Keys:        len(pairs),
+	}
+	return &fsmSnapshot{header: header, pairs: pairs}, nil
+}
+
+// scanAll returns every key in the backend, in key order.
+func (f *CppFSM) scanAll() ([]snapshotPair, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	stream, err := f.client.Scan(ctx, &pb.ScanRequest{})
+	if err != nil {
+		return nil, err
+	}
+	var pairs []snapshotPair
+	for {
+		kv, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return pairs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, snapshotPair{Key: kv.Key, Value: kv.Value})
+	}
+}
+
+// Restore replaces the FSM's state with a snapshot taken by Snapshot: the
+// backend is brought to the snapshot's keys by setting every key in it
+// and deleting every other key, and the replicated metadata is replaced.
+// Watchers are dropped, as the entries the snapshot covers are never
+// delivered to them; they resume from the log once it is past the
+// snapshot.
+func (f *CppFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	f.applyMu.Lock()
+	defer f.applyMu.Unlock()
+
+	r := bufio.NewReaderSize(rc, 64<<10)
+	decoder := json.NewDecoder(r)
+	var header snapshotHeader
+	if err := decoder.Decode(&header); err != nil {
+		return fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+	if err := f.signatures.Check(header.SigningKeys); err != nil {
+		halt(header.Index, err)
+	}
+
+	existing, err := f.scanAll()
+	if err != nil {
+		return fmt.Errorf("failed to scan backend before restoring snapshot: %w", err)
+	}
+	stale := make(map[string]bool, len(existing))
+	for _, pair := range existing {
+		stale[pair.Key] = true
+	}
+
+	for i := 0; i < header.Keys; i++ {
+		var pair snapshotPair
+		if err := decoder.Decode(&pair); err != nil {
+			return fmt.Errorf("failed to read snapshot key %d of %d: %w", i+1, header.Keys, err)
+		}
+		delete(stale, pair.Key)
+		if err := f.applySnapshotCommand(snapshotCommand{Op: "SET", Key: pair.Key, Value: pair.Value}, header.Term, header.Index); err != nil {
+			return err
+		}
+	}
+	for key := range stale {
+		if err := f.applySnapshotCommand(snapshotCommand{Op: "DELETE", Key: key}, header.Term, header.Index); err != nil {
+			return err
+		}
+	}
+
+	f.restoreMeta(header.ClusterID, header.Peers, header.SigningKeys)
+	f.setApplied(header.Index, header.Term)
+	f.watchers.dropAll()
+	logger.Info("Restored snapshot", "index", header.Index, "term", header.Term, "keys", header.Keys, "deleted", len(stale))
+	return nil
+}
+
+// Clear deletes every key from the backend, for a node whose Raft state was
+// wiped: it rebuilds the backend from the leader's snapshot or log, which
+// would not remove keys that are no longer in the cluster if the log is
+// replayed from its start. It returns the number of keys deleted. It must
+// be called before the FSM is handed to Raft.
+func (f *CppFSM) Clear() (int, error) {
+	f.applyMu.Lock()
+	defer f.applyMu.Unlock()
+
+	pairs, err := f.scanAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan backend: %w", err)
+	}
+	for i, pair := range pairs {
+		if err := f.applySnapshotCommand(snapshotCommand{Op: "DELETE", Key: pair.Key}, 0, 0); err != nil {
+			return i, err
+		}
+	}
+	return len(pairs), nil
+}
+
+// applySnapshotCommand applies cmd to the backend at the given position in
+// the log.
+func (f *CppFSM) applySnapshotCommand(cmd snapshotCommand, term, index uint64) error {
+	var data []byte
+	if err := codec.NewEncoderBytes(&data, &codec.MsgpackHandle{}).Encode(cmd); err != nil {
+		return fmt.Errorf("failed to encode %s of %q: %w", cmd.Op, cmd.Key, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+	resp, err := f.client.Apply(ctx, &pb.Command{Data: data, Term: term, Index: index})
+	if err == nil && !resp.Success {
+		err = errors.New("backend refused the command")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore %s of %q: %w", cmd.Op, cmd.Key, err)
+	}
+	return nil
+}
+
+// fsmSnapshot is a snapshot taken by Snapshot.
+type fsmSnapshot struct {
+	header snapshotHeader
+	pairs  []snapshotPair
+}
+
+// Persist writes the snapshot to sink as a JSON header line followed by a
+// JSON line per key.
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	w := bufio.NewWriterSize(sink, 64<<10)
+	encoder := json.NewEncoder(w)
+	err := encoder.Encode(s.header)
+	for i := 0; err == nil && i < len(s.pairs); i++ {
+		err = encoder.Encode(s.pairs[i])
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		sink.Cancel()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return sink.Close()
+}
+
+// Release releases the snapshot's copy of the keys.
+func (s *fsmSnapshot) Release() {
+	s.pairs = nil
+}
+
+// Ensure fsmSnapshot implements raft.FSMSnapshot at compile time.
+var _ raft.FSMSnapshot = (*fsmSnapshot)(nil)
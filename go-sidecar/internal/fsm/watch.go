@@ -0,0 +1,103 @@
+package fsm
+
+import (
+	"sync"
+)
+
+// watchBufferSize is the number of entries buffered per subscriber before
+// it is considered too slow and dropped.
+const watchBufferSize = 1024
+
+// AppliedEntry is a committed log entry that has been applied to the FSM.
+type AppliedEntry struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+// Subscription delivers applied entries to a single watcher.
+type Subscription struct {
+	// C receives every entry applied after the subscription was created.
+	// It is closed when the subscription is cancelled or falls too far
+	// behind; Dropped reports which.
+	C <-chan AppliedEntry
+
+	ch      chan AppliedEntry
+	hub     *watchHub
+	dropped bool
+}
+
+// Dropped reports whether the subscription was closed because the
+// subscriber could not keep up with the apply rate.
+func (s *Subscription) Dropped() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.dropped
+}
+
+// Cancel stops delivery and releases the subscription.
+func (s *Subscription) Cancel() {
+	s.hub.remove(s)
+}
+
+// watchHub fans applied entries out to subscribers.
+type watchHub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{subs: make(map[*Subscription]struct{})}
+}
+
+func (h *watchHub) subscribe() *Subscription {
+	ch := make(chan AppliedEntry, watchBufferSize)
+	sub := &Subscription{C: ch, ch: ch, hub: h}
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+// publish delivers the entry to every subscriber without blocking. A
+// subscriber whose buffer is full is dropped so that a slow watcher can
+// never stall the apply pipeline.
+func (h *watchHub) publish(entry AppliedEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		select {
+		case sub.ch <- entry:
+		default:
+			sub.dropped = true
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+func (h *watchHub) remove(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; !ok {
+		return
+	}
+	delete(h.subs, sub)
+	close(sub.ch)
+}
+
+// dropAll closes every subscription as if its subscriber had fallen
+// behind.
+func (h *watchHub) dropAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		sub.dropped = true
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
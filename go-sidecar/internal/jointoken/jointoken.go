@@ -0,0 +1,132 @@
+// Package jointoken mints and verifies time-limited join tokens.
+//
+// A join token lets a node join the cluster without knowing the cluster
+// token. It is signed with a key derived from the cluster token, so every
+// member can verify tokens minted by any other, and it can be scoped to a
+// node ID or to joining as a non-voter.
+package jointoken
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// prefix distinguishes join tokens from the cluster token.
+const prefix = "rkvjt."
+
+var (
+	// ErrInvalid is returned for tokens that are malformed or were not
+	// signed with the cluster's key.
+	ErrInvalid = errors.New("invalid join token")
+	// ErrExpired is returned for tokens past their expiry.
+	ErrExpired = errors.New("join token has expired")
+	// ErrScope is returned when a token does not allow the requested join.
+	ErrScope = errors.New("join token does not allow this join")
+)
+
+// Claims are the contents of a join token.
+type Claims struct {
+	Expires time.Time `json:"exp"`
+	// NodeID, if set, is the only node the token lets join.
+	NodeID string `json:"node_id,omitempty"`
+	// Nonvoter restricts the token to joining as a non-voter.
+	Nonvoter bool `json:"nonvoter,omitempty"`
+	// Nonce makes every token unique.
+	Nonce string `json:"nonce"`
+}
+
+// IsToken reports whether token looks like a join token rather than the
+// cluster token.
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, prefix)
+}
+
+// Issue mints a token with claims, signed with a key derived from
+// clusterToken.
+func Issue(clusterToken string, claims Claims) (string, error) {
+	if clusterToken == "" {
+		return "", errors.New("join tokens require a cluster token")
+	}
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	claims.Nonce = base64.RawURLEncoding.EncodeToString(nonce)
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode join token: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return prefix + encoded + "." + sign(clusterToken, encoded), nil
+}
+
+// Verify checks that token was signed with clusterToken's key, has not
+// expired and allows nodeID to join, as a voter if voter is set.
+func Verify(clusterToken, token, nodeID string, voter bool) (Claims, error) {
+	if clusterToken == "" || !IsToken(token) {
+		return Claims{}, ErrInvalid
+	}
+	encoded, signature, ok := strings.Cut(strings.TrimPrefix(token, prefix), ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(sign(clusterToken, encoded))) {
+		return Claims{}, ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Claims{}, ErrInvalid
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Claims{}, ErrInvalid
+	}
+
+	if !time.Now().Before(claims.Expires) {
+		return claims, ErrExpired
+	}
+	if (claims.NodeID != "" && claims.NodeID != nodeID) || (claims.Nonvoter && voter) {
+		return claims, ErrScope
+	}
+	return claims, nil
+}
+
+// Authorize checks the token presented by a joining node: either the
+// cluster token itself or a join token allowing the join. Any token is
+// accepted when the cluster has no cluster token. It also reports whether
+// the node may take over the ID or address of an existing member, which
+// only the cluster token and join tokens minted for nodeID allow: a token
+// that lets any node join must not let its holder impersonate a member.
+func Authorize(clusterToken, presented, nodeID string, voter bool) (takeOver bool, err error) {
+	if clusterToken == "" {
+		return true, nil
+	}
+	if IsToken(presented) {
+		claims, err := Verify(clusterToken, presented, nodeID, voter)
+		if err != nil {
+			return false, err
+		}
+		return claims.NodeID != "", nil
+	}
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(clusterToken)) != 1 {
+		return false, ErrInvalid
+	}
+	return true, nil
+}
+
+// sign returns the signature of an encoded payload. The signing key is
+// derived from the cluster token rather than being the token itself.
+func sign(clusterToken, encoded string) string {
+	key := hmac.New(sha256.New, []byte(clusterToken))
+	key.Write([]byte("raftkv join token"))
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
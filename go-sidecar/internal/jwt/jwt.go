@@ -0,0 +1,187 @@
+// Package jwt verifies JSON Web Tokens presented by clients of the sidecar.
+//
+// Only compact JWS tokens signed with HS256, RS256 or ES256 are accepted;
+// the algorithm must match the configured key, so a token cannot pick a
+// weaker one.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalid is returned for tokens that are malformed or whose
+	// signature does not verify.
+	ErrInvalid = errors.New("invalid token")
+	// ErrExpired is returned for tokens outside their validity period.
+	ErrExpired = errors.New("token has expired or is not valid yet")
+	// ErrClaims is returned for tokens from another issuer or for another
+	// audience.
+	ErrClaims = errors.New("token issuer or audience not accepted")
+)
+
+// leeway absorbs clock skew between the issuer and this node.
+const leeway = time.Minute
+
+// Claims are the verified contents of a token. Raw holds every claim,
+// including those not broken out.
+type Claims struct {
+	Subject  string
+	Issuer   string
+	Audience []string
+	Raw      map[string]any
+}
+
+// Verifier checks tokens against a key, an issuer and an audience.
+type Verifier struct {
+	alg      string
+	secret   []byte
+	key      crypto.PublicKey
+	issuer   string
+	audience string
+}
+
+// NewVerifier returns a verifier for tokens signed with key: a PEM public
+// key (RSA for RS256, P-256 for ES256) or, for anything else, an HS256
+// secret. An empty issuer or audience is not checked.
+func NewVerifier(key []byte, issuer, audience string) (*Verifier, error) {
+	v := &Verifier{issuer: issuer, audience: audience}
+
+	block, _ := pem.Decode(key)
+	if block == nil {
+		if len(key) < 32 {
+			return nil, errors.New("HS256 secrets must be at least 32 bytes")
+		}
+		v.alg, v.secret = "HS256", key
+		return v, nil
+	}
+
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		v.alg = "RS256"
+	case *ecdsa.PublicKey:
+		if public.Curve.Params().Name != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s, expected P-256", public.Curve.Params().Name)
+		}
+		v.alg = "ES256"
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+	v.key = public
+	return v, nil
+}
+
+// LooksLikeToken reports whether s has the shape of a compact JWS.
+func LooksLikeToken(s string) bool {
+	return strings.Count(s, ".") == 2
+}
+
+// Verify checks token's signature, validity period, issuer and audience
+// and returns its claims.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalid
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != v.alg {
+		return nil, fmt.Errorf("%w: algorithm %q, expected %s", ErrInvalid, header.Alg, v.alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	if !v.verifySignature(parts[0]+"."+parts[1], signature) {
+		return nil, ErrInvalid
+	}
+
+	var raw map[string]any
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, err
+	}
+	claims := &Claims{Raw: raw}
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Issuer, _ = raw["iss"].(string)
+	switch aud := raw["aud"].(type) {
+	case string:
+		claims.Audience = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				claims.Audience = append(claims.Audience, s)
+			}
+		}
+	}
+
+	now := time.Now()
+	if exp, ok := raw["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return nil, ErrExpired
+	}
+	if nbf, ok := raw["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, ErrExpired
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return nil, fmt.Errorf("%w: issuer %q", ErrClaims, claims.Issuer)
+	}
+	if v.audience != "" && !slices.Contains(claims.Audience, v.audience) {
+		return nil, fmt.Errorf("%w: audience %q", ErrClaims, claims.Audience)
+	}
+	return claims, nil
+}
+
+// verifySignature checks signature over signed with the verifier's key.
+func (v *Verifier) verifySignature(signed string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+	switch v.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signed))
+		return hmac.Equal(signature, mac.Sum(nil))
+	case "RS256":
+		return rsa.VerifyPKCS1v15(v.key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	case "ES256":
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(v.key.(*ecdsa.PublicKey), digest[:], r, s)
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url JSON segment into out.
+func decodeSegment(segment string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrInvalid
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// HCLog returns an hclog.Logger, as the Raft library takes, that writes
+// through a Component logger named name at the level set by SetRaftLevel
+// or, failing that, by Setup or SetLevel. Its records bypass the level of
+// the default logger's handler, so the Raft library can log more than the
+// rest of the process. SetLevel on it, or on any logger derived from it,
+// sets the Raft level for the whole process.
+func HCLog(name string) hclog.Logger {
+	return &hclogAdapter{name: name, logger: Component(name)}
+}
+
+// hclogAdapter is an hclog.Logger writing to slog.
+type hclogAdapter struct {
+	name    string
+	logger  *slog.Logger
+	implied []interface{}
+}
+
+// slogLevel maps an hclog level to the slog level records are written at.
+func slogLevel(l hclog.Level) slog.Level {
+	switch l {
+	case hclog.Trace:
+		return LevelTrace
+	case hclog.Debug:
+		return slog.LevelDebug
+	case hclog.Warn:
+		return slog.LevelWarn
+	case hclog.Error:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// hclogLevel maps a slog level to the closest hclog level.
+func hclogLevel(l slog.Level) hclog.Level {
+	switch {
+	case l <= LevelTrace:
+		return hclog.Trace
+	case l <= slog.LevelDebug:
+		return hclog.Debug
+	case l <= slog.LevelInfo:
+		return hclog.Info
+	case l <= slog.LevelWarn:
+		return hclog.Warn
+	}
+	return hclog.Error
+}
+
+func (a *hclogAdapter) enabled(l slog.Level) bool {
+	return l >= minRaftLevel()
+}
+
+func (a *hclogAdapter) Log(l hclog.Level, msg string, args ...interface{}) {
+	if l == hclog.Off || l == hclog.NoLevel {
+		return
+	}
+	if sl := slogLevel(l); a.enabled(sl) {
+		r := slog.NewRecord(time.Now(), sl, msg, 0)
+		r.Add(convertArgs(args)...)
+		_ = a.logger.Handler().Handle(context.Background(), r)
+	}
+}
+
+// convertArgs formats the values in the key-value pairs args as hclog
+// would: hclog.Fmt values with their format and fmt.Stringers as their
+// String, which the JSON format would otherwise write as objects.
+func convertArgs(args []interface{}) []interface{} {
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if i%2 == 1 {
+			switch v := arg.(type) {
+			case hclog.Format:
+				if len(v) > 0 {
+					if format, ok := v[0].(string); ok {
+						arg = fmt.Sprintf(format, v[1:]...)
+					}
+				}
+			case error:
+			case fmt.Stringer:
+				arg = v.String()
+			}
+		}
+		converted[i] = arg
+	}
+	return converted
+}
+
+func (a *hclogAdapter) Trace(msg string, args ...interface{}) { a.Log(hclog.Trace, msg, args...) }
+func (a *hclogAdapter) Debug(msg string, args ...interface{}) { a.Log(hclog.Debug, msg, args...) }
+func (a *hclogAdapter) Info(msg string, args ...interface{})  { a.Log(hclog.Info, msg, args...) }
+func (a *hclogAdapter) Warn(msg string, args ...interface{})  { a.Log(hclog.Warn, msg, args...) }
+func (a *hclogAdapter) Error(msg string, args ...interface{}) { a.Log(hclog.Error, msg, args...) }
+
+func (a *hclogAdapter) IsTrace() bool { return a.enabled(LevelTrace) }
+func (a *hclogAdapter) IsDebug() bool { return a.enabled(slog.LevelDebug) }
+func (a *hclogAdapter) IsInfo() bool  { return a.enabled(slog.LevelInfo) }
+func (a *hclogAdapter) IsWarn() bool  { return a.enabled(slog.LevelWarn) }
+func (a *hclogAdapter) IsError() bool { return a.enabled(slog.LevelError) }
+
+func (a *hclogAdapter) ImpliedArgs() []interface{} { return a.implied }
+
+func (a *hclogAdapter) With(args ...interface{}) hclog.Logger {
+	return &hclogAdapter{
+		name:    a.name,
+		logger:  a.logger.With(convertArgs(args)...),
+		implied: append(a.implied[:len(a.implied):len(a.implied)], args...),
+	}
+}
+
+func (a *hclogAdapter) Name() string { return a.name }
+
+func (a *hclogAdapter) Named(name string) hclog.Logger {
+	if a.name != "" {
+		name = a.name + "." + name
+	}
+	return a.ResetNamed(name)
+}
+
+func (a *hclogAdapter) ResetNamed(name string) hclog.Logger {
+	return &hclogAdapter{name: name, logger: Component(name).With(convertArgs(a.implied)...), implied: a.implied}
+}
+
+func (a *hclogAdapter) SetLevel(l hclog.Level) {
+	if l != hclog.NoLevel && l != hclog.Off {
+		raftLevel.Set(slogLevel(l))
+		raftLevelSet.Store(true)
+	}
+}
+
+func (a *hclogAdapter) GetLevel() hclog.Level { return hclogLevel(minRaftLevel()) }
+
+func (a *hclogAdapter) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(a.StandardWriter(opts), "", 0)
+}
+
+// StandardWriter returns a writer logging each line written to it at
+// info level or, with InferLevels, at the level of its [DEBUG], [WARN] or
+// similar prefix.
+func (a *hclogAdapter) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	infer := opts != nil && (opts.InferLevels || opts.InferLevelsWithTimestamp)
+	return writerFunc(func(p []byte) (int, error) {
+		for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+			msg, l := strings.TrimSpace(string(line)), hclog.Info
+			if infer {
+				msg, l = inferLevel(msg)
+			}
+			a.Log(l, msg)
+		}
+		return len(p), nil
+	})
+}
+
+// inferLevel strips a level prefix such as [WARN] from msg, wherever a
+// timestamp leaves it, and returns the level it names.
+func inferLevel(msg string) (string, hclog.Level) {
+	for _, prefix := range []struct {
+		text  string
+		level hclog.Level
+	}{
+		{"[TRACE]", hclog.Trace},
+		{"[DEBUG]", hclog.Debug},
+		{"[INFO]", hclog.Info},
+		{"[WARN]", hclog.Warn},
+		{"[ERR]", hclog.Error},
+		{"[ERROR]", hclog.Error},
+	} {
+		if i := strings.Index(msg, prefix.text); i >= 0 {
+			return strings.TrimSpace(msg[i+len(prefix.text):]), prefix.level
+		}
+	}
+	return msg, hclog.Info
+}
+
+// writerFunc adapts a function to io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,160 @@
+// Package logging sets up the sidecar's structured, leveled logs. Records
+// go to the default slog logger; each package logs through a Component
+// logger that adds a component field, and Setup adds the node_id field to
+// every record.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// LevelTrace is below slog.LevelDebug, for the Raft library's most verbose
+// output.
+const LevelTrace = slog.LevelDebug - 4
+
+// Levels are the level names ParseLevel accepts, from the most verbose.
+var Levels = []string{"trace", "debug", "info", "warn", "error"}
+
+// Formats are the formats Setup accepts: console writes key=value pairs,
+// json one object per record.
+var Formats = []string{"console", "json"}
+
+// level is the minimum level of the records Setup's handler writes.
+var level = new(slog.LevelVar)
+
+// raftLevel is the minimum level of the records of HCLog loggers, if
+// raftLevelSet; otherwise they follow level.
+var (
+	raftLevel    = new(slog.LevelVar)
+	raftLevelSet atomic.Bool
+)
+
+// ParseLevel returns the level named by name, one of Levels.
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "trace":
+		return LevelTrace, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want %s)", name, strings.Join(Levels, ", "))
+}
+
+// SetLevel changes the minimum level of the records logged, taking effect
+// at once.
+func SetLevel(name string) error {
+	l, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// SetRaftLevel changes the minimum level of the records of the Raft
+// library, logged through HCLog, taking effect at once. An empty name makes
+// them follow SetLevel again.
+func SetRaftLevel(name string) error {
+	if name == "" {
+		raftLevelSet.Store(false)
+		return nil
+	}
+	l, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	raftLevel.Set(l)
+	raftLevelSet.Store(true)
+	return nil
+}
+
+// minRaftLevel returns the minimum level of the records of HCLog loggers.
+func minRaftLevel() slog.Level {
+	if raftLevelSet.Load() {
+		return raftLevel.Level()
+	}
+	return level.Level()
+}
+
+// Setup makes the default slog logger, which the standard log package then
+// also writes to, write records of at least levelName to w in format. attrs
+// are added to every record.
+func Setup(w io.Writer, format, levelName string, attrs ...slog.Attr) error {
+	if err := SetLevel(levelName); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: nameLevel}
+	var handler slog.Handler
+	switch format {
+	case "console":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (want %s)", format, strings.Join(Formats, " or "))
+	}
+	slog.SetDefault(slog.New(handler.WithAttrs(attrs)))
+	return nil
+}
+
+// nameLevel names LevelTrace, which slog would write as DEBUG-4.
+func nameLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if l, ok := a.Value.Any().(slog.Level); ok && l == LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+// Component returns a logger whose records carry component=name. It writes
+// through whichever logger is the default when it logs, so package-level
+// loggers follow Setup.
+func Component(name string) *slog.Logger {
+	return slog.New(&deferredHandler{}).With("component", name)
+}
+
+// deferredHandler passes records to the default logger's handler, after
+// applying the attributes and groups it was given.
+type deferredHandler struct {
+	with []func(slog.Handler) slog.Handler
+}
+
+func (h *deferredHandler) handler() slog.Handler {
+	handler := slog.Default().Handler()
+	for _, with := range h.with {
+		handler = with(handler)
+	}
+	return handler
+}
+
+func (h *deferredHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, l)
+}
+
+func (h *deferredHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler().Handle(ctx, r)
+}
+
+func (h *deferredHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.extend(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *deferredHandler) WithGroup(name string) slog.Handler {
+	return h.extend(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *deferredHandler) extend(with func(slog.Handler) slog.Handler) slog.Handler {
+	return &deferredHandler{with: append(h.with[:len(h.with):len(h.with)], with)}
+}
@@ -0,0 +1,20 @@
+package logging
+
+import "net/url"
+
+// redacted replaces the parts of a URL that RedactURL hides.
+const redacted = "[redacted]"
+
+// RedactURL returns rawURL with only its scheme and host shown, for logging
+// URLs that may carry credentials in their user info, path or query, as
+// webhook URLs often do.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+	if u.User == nil && (u.Path == "" || u.Path == "/") && u.RawQuery == "" && u.Fragment == "" {
+		return u.Scheme + "://" + u.Host + u.Path
+	}
+	return u.Scheme + "://" + u.Host + "/" + redacted
+}
@@ -0,0 +1,174 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat names rotated files after the time they were rotated,
+// so that they sort in the order they were written.
+const rotatedTimeFormat = "20060102T150405.000"
+
+// RotationConfig is how a RotatingFile rotates and keeps its files.
+type RotationConfig struct {
+	// MaxSize is the size in bytes the file may reach before it is
+	// rotated; zero disables rotation by size.
+	MaxSize int64
+	// MaxAge is how long the file is written to before it is rotated;
+	// zero disables rotation by age.
+	MaxAge time.Duration
+	// MaxBackups is how many rotated files are kept; zero keeps them all.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// RotatingFile is an io.Writer appending to a log file, which it renames to
+// <path>.<time> and replaces with an empty one once it grows too large or
+// too old. It is safe for concurrent use.
+type RotatingFile struct {
+	path string
+	cfg  RotationConfig
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	// cleanup serialises compressing and removing rotated files.
+	cleanup sync.Mutex
+}
+
+// OpenRotatingFile opens, or creates, the log file at path for appending.
+func OpenRotatingFile(path string, cfg RotationConfig) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, cfg: cfg}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file at f.path for appending.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would take it past
+// MaxSize or it has been written to for MaxAge. A single record larger than
+// MaxSize is written to a file of its own.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tooLarge := f.cfg.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.cfg.MaxSize
+	tooOld := f.cfg.MaxAge > 0 && time.Since(f.opened) >= f.cfg.MaxAge
+	if tooLarge || tooOld {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than lose records.
+			fmt.Fprintf(os.Stderr, "failed to rotate log file %s: %v\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file and opens a new one, then compresses
+// and removes rotated files in the background.
+func (f *RotatingFile) rotate() error {
+	rotated := f.path + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	previous := f.file
+	if err := f.open(); err != nil {
+		// Write to the renamed file until a new one can be created.
+		f.opened = time.Now()
+		return err
+	}
+	previous.Close()
+	go f.tidy(rotated)
+	return nil
+}
+
+// tidy compresses the newly rotated file if configured to, and removes
+// the oldest rotated files beyond MaxBackups.
+func (f *RotatingFile) tidy(rotated string) {
+	f.cleanup.Lock()
+	defer f.cleanup.Unlock()
+
+	if f.cfg.Compress {
+		if err := compress(rotated); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to compress rotated log file %s: %v\n", rotated, err)
+		}
+	}
+	if f.cfg.MaxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	// Skip compressions in progress from an earlier run.
+	backups = slices.DeleteFunc(backups, func(name string) bool { return strings.HasSuffix(name, ".gz.tmp") })
+	sort.Strings(backups)
+	for len(backups) > f.cfg.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+// compress replaces path by path.gz.
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
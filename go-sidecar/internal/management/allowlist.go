@@ -0,0 +1,65 @@
+package management
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseNetworks parses CIDRs, or single IP addresses, for
+// Options.AllowedNetworks.
+func ParseNetworks(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// restrictNetworks rejects requests from addresses outside the allowed
+// networks with 403 Forbidden, before any other check. Loopback and the
+// Unix domain socket are always allowed so the node can reach its own API.
+func (s *Server) restrictNetworks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fromSocket(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := net.ParseIP(clientIP(r))
+		if ip == nil || !s.allowedIP(ip) {
+			logger.Warn("Rejected request: address not allowed", "method", r.Method, "path", r.URL.Path, "address", r.RemoteAddr)
+			http.Error(w, "Forbidden: address not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) allowedIP(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, network := range s.opts.AllowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package management
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/requestid"
+	"my-raft-sidecar/internal/tlsutil"
+	"my-raft-sidecar/internal/unixsock"
+)
+
+// handleAudit returns the privileged operations recorded on this node,
+// oldest first. The optional since (RFC 3339), op, target and limit parameters
+// narrow the result; limit defaults to 100.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Op:     query.Get("op"),
+		Target: query.Get("target"),
+		Limit:  100,
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "Invalid since, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+
+	entries, err := s.node.AuditLog().Query(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// handleAuditVerify checks the audit log's hash chain and, if the anchor
+// parameter is given, that it still contains that earlier head hash. It
+// answers 200 with the number of entries and the head hash if the log is
+// intact, and 409 with the first broken entry otherwise.
+func (s *Server) handleAuditVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := s.node.AuditLog().Verify(r.URL.Query().Get("anchor"))
+	response := struct {
+		audit.Verification
+		Valid bool   `json:"valid"`
+		Error string `json:"error,omitempty"`
+	}{Verification: result, Valid: err == nil}
+	status := http.StatusOK
+	if err != nil {
+		response.Error = err.Error()
+		status = http.StatusConflict
+		if !errors.Is(err, audit.ErrTampered) {
+			status = http.StatusInternalServerError
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// initiator identifies the caller of a management request for the audit
+// log.
+func initiator(r *http.Request) string {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && unixsock.IsUnix(addr) {
+		return "http:unix:" + addr.String()
+	}
+	return "http:" + r.RemoteAddr
+}
+
+// secretParams are query parameters left out of audit entries.
+var secretParams = map[string]bool{"token": true, "confirm": true}
+
+// auditEntry returns an audit entry for op on target made by the caller of
+// r, with the request's parameters.
+func (s *Server) auditEntry(r *http.Request, op, target, address string) audit.Entry {
+	caller := &audit.Caller{SourceIP: clientIP(r)}
+	switch token := r.URL.Query().Get("token"); {
+	case s.opts.AuthToken != "":
+		caller.Subject = "management token"
+	case token != "" && token == s.opts.ClusterToken:
+		caller.Subject = "cluster token"
+	case token != "":
+		caller.Subject = "join token"
+	}
+	// Only certificates required, and so verified, by -mgmt-mtls identify
+	// anyone.
+	caller.CertCN = tlsutil.ClientCommonName(s.opts.TLS, r.TLS)
+
+	var params map[string]string
+	for name, values := range r.URL.Query() {
+		if secretParams[name] || len(values) == 0 {
+			continue
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[name] = values[0]
+	}
+
+	return audit.Entry{
+		Op:        op,
+		Target:    target,
+		Address:   address,
+		Initiator: initiator(r),
+		Caller:    caller,
+		Params:    params,
+		RequestID: requestid.FromContext(r.Context()),
+	}
+}
@@ -0,0 +1,78 @@
+package management
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	"my-raft-sidecar/internal/rbac"
+)
+
+// adminPaths change the cluster or expose secrets and require the admin
+// role even when read with GET. Other endpoints require the reader role for
+// GET and the admin role otherwise.
+var adminPaths = map[string]bool{
+	"/join":         true,
+	"/join-token":   true,
+	"/remove":       true,
+	"/promote":      true,
+	"/force-remove": true,
+	"/audit":        true,
+	"/audit/verify": true,
+	"/reload":       true,
+	"/snapshot":     true,
+	"/config":       true,
+	"/debug/config": true,
+}
+
+// requiredRole returns the role a request requires under an RBAC policy.
+func requiredRole(r *http.Request) rbac.Role {
+	if !adminPaths[r.URL.Path] && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		return rbac.Reader
+	}
+	return rbac.Admin
+}
+
+// authenticate requires the bearer token, if one is configured, on every
+// request but /health, which load balancers and orchestrators probe
+// without credentials. Under an RBAC policy it also checks the caller's
+// role: holders of the bearer token are admins, and clients presenting a
+// verified certificate get the role the policy grants its identities.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	want := []byte("Bearer " + s.opts.AuthToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		role := rbac.None
+		if s.opts.AuthToken != "" {
+			got := []byte(strings.TrimSpace(r.Header.Get("Authorization")))
+			if subtle.ConstantTimeCompare(got, want) != 1 {
+				logger.Warn("Rejected unauthenticated request", "method", r.Method, "path", r.URL.Path, "address", r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="raftkv"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			// The token is one secret shared by every holder, with no
+			// identity the policy could grant a narrower role to, so it
+			// keeps the full access it gives without a policy
+			role = rbac.Admin
+		}
+
+		if s.opts.Policy != nil {
+			// Client certificates were verified during the handshake.
+			if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && s.opts.TLS.ClientAuth != tls.NoClientCert {
+				role = max(role, s.opts.Policy.RoleOf(rbac.CertIdentities(r.TLS.PeerCertificates[0]), nil))
+			}
+			if required := requiredRole(r); role < required {
+				logger.Warn("Rejected request: role not allowed", "method", r.Method, "path", r.URL.Path, "address", r.RemoteAddr, "required", required.String(), "role", role.String())
+				http.Error(w, "Forbidden: requires the "+required.String()+" role", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,115 @@
+package management
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/config"
+)
+
+// handleConfig reports the running settings on GET and changes reloadable
+// ones on PATCH. A PATCH body is a JSON object of settings by flag name,
+// with values as on the command line (numbers and booleans may be given
+// bare); null returns a setting to its value from the flags, environment
+// or configuration file. The changes are applied at once, saved in the
+// data directory so that they survive restarts, and answered with the new
+// settings and the changed settings that need a restart, as /reload
+// reports them. Invalid changes answer 400 and none are applied.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.opts.Config())
+	case http.MethodPatch:
+		s.patchConfig(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDebugConfig reports the effective configuration: every setting
+// with where it came from, secrets redacted, and the addresses derived
+// from them, for support to see exactly what the node runs with.
+func (s *Server) handleDebugConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.opts.DebugConfig())
+}
+
+// patchConfig handles PATCH /config.
+func (s *Server) patchConfig(w http.ResponseWriter, r *http.Request) {
+	changes, err := decodeChanges(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry := s.auditEntry(r, audit.OpPatchConfig, "", "")
+	if entry.Params == nil {
+		entry.Params = make(map[string]string, len(changes))
+	}
+	for name, value := range changes {
+		if value == nil {
+			entry.Params[name] = "null"
+		} else {
+			entry.Params[name] = *value
+		}
+	}
+	restart, err := s.opts.PatchConfig(changes)
+	s.node.AuditLog().Record(entry, err)
+	if err != nil {
+		logger.Warn("Configuration change failed", "initiator", clientIP(r), "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if restart == nil {
+		restart = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*config.Settings
+		RestartRequired []string `json:"restart_required"`
+	}{s.opts.Config(), restart})
+}
+
+// decodeChanges reads a PATCH /config body: settings by name, each a
+// string, number, boolean or null.
+func decodeChanges(body io.Reader) (map[string]*string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("no settings to change")
+	}
+
+	changes := make(map[string]*string, len(raw))
+	for name, data := range raw {
+		value := strings.TrimSpace(string(data))
+		switch {
+		case value == "null":
+			changes[name] = nil
+		case strings.HasPrefix(value, `"`):
+			var text string
+			if err := json.Unmarshal(data, &text); err != nil {
+				return nil, fmt.Errorf("invalid value for %q: %w", name, err)
+			}
+			changes[name] = &text
+		case strings.HasPrefix(value, "{") || strings.HasPrefix(value, "["):
+			return nil, fmt.Errorf("invalid value for %q: must be a string, number, boolean or null", name)
+		default:
+			changes[name] = &value
+		}
+	}
+	return changes, nil
+}
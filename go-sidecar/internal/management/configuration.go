@@ -0,0 +1,75 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// configurationServer is one member in the /configuration response.
+type configurationServer struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+	Leader   bool   `json:"leader"`
+	Zone     string `json:"zone,omitempty"`
+	Rack     string `json:"rack,omitempty"`
+	// MgmtAddr is the management API address the server published.
+	MgmtAddr string `json:"mgmt_addr,omitempty"`
+	// Replication progress, reported by the leader for its followers in
+	// the same format as raft.Stats.
+	MatchIndex  *uint64 `json:"match_index,omitempty"`
+	LastContact string  `json:"last_contact,omitempty"`
+}
+
+// configurationResponse is the JSON body returned by /configuration.
+type configurationResponse struct {
+	Index    uint64                `json:"index"`
+	LeaderID string                `json:"leader_id"`
+	Servers  []configurationServer `json:"servers"`
+}
+
+// handleConfiguration returns the current Raft configuration. On the
+// leader every follower also reports its match index and last contact.
+func (s *Server) handleConfiguration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	configuration, index, err := s.node.Configuration()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	leaderID := s.node.LeaderID()
+	progress := s.node.PeerProgress()
+	resp := configurationResponse{
+		Index:    index,
+		LeaderID: leaderID,
+		Servers:  make([]configurationServer, 0, len(configuration.Servers)),
+	}
+	for _, server := range configuration.Servers {
+		entry := configurationServer{
+			ID:       string(server.ID),
+			Address:  string(server.Address),
+			Suffrage: server.Suffrage.String(),
+			Leader:   string(server.ID) == leaderID,
+		}
+		if meta, ok := s.fsm.Peer(entry.ID); ok {
+			entry.Zone, entry.Rack, entry.MgmtAddr = meta.Zone, meta.Rack, meta.MgmtAddr
+		}
+		if p, ok := progress[entry.ID]; ok {
+			match := p.MatchIndex
+			entry.MatchIndex = &match
+			entry.LastContact = time.Since(p.LastContact).String()
+		}
+		resp.Servers = append(resp.Servers, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(epochHeader, strconv.FormatUint(index, 10))
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,82 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"my-raft-sidecar/internal/audit"
+)
+
+// drainStatus reports the progress of a drain.
+type drainStatus struct {
+	Draining     bool   `json:"draining"`
+	Reason       string `json:"reason,omitempty"`
+	IsLeader     bool   `json:"is_leader"`
+	InFlight     int64  `json:"in_flight"`
+	CommitIndex  uint64 `json:"commit_index"`
+	AppliedIndex uint64 `json:"applied_index"`
+	SafeToStop   bool   `json:"safe_to_stop"`
+}
+
+// handleDrain manages maintenance drains.
+//
+//	POST   /drain?reason=...  stop accepting proposals and hand off leadership
+//	GET    /drain             report progress; safe_to_stop turns true once done
+//	DELETE /drain             cancel the drain and accept proposals again
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		reason := r.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "maintenance"
+		}
+		logger.Info("Draining node", "reason", reason)
+		s.node.StartDrain(reason)
+		s.node.AuditLog().Record(s.auditEntry(r, audit.OpDrain, "", ""), nil)
+
+		if s.node.IsLeader() {
+			entry := s.auditEntry(r, audit.OpTransferLeadership, "", "")
+			entry.Initiator += " (drain)"
+			go func() {
+				err := s.node.TransferLeadership()
+				s.node.AuditLog().Record(entry, err)
+				if err != nil {
+					logger.Error("Leadership transfer during drain failed", "error", err)
+				}
+			}()
+		}
+		s.writeDrainStatus(w, http.StatusAccepted)
+	case http.MethodGet:
+		s.writeDrainStatus(w, http.StatusOK)
+	case http.MethodDelete:
+		logger.Info("Drain cancelled, accepting proposals again")
+		s.node.StopDrain()
+		entry := s.auditEntry(r, audit.OpDrain, "", "")
+		entry.Params = map[string]string{"cancel": "true"}
+		s.node.AuditLog().Record(entry, nil)
+		s.writeDrainStatus(w, http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeDrainStatus writes the current drain progress as JSON. A node is
+// safe to stop once it is draining, no longer leader, has no proposals in
+// flight and has applied everything it knows to be committed.
+func (s *Server) writeDrainStatus(w http.ResponseWriter, code int) {
+	draining, reason := s.node.Draining()
+	status := drainStatus{
+		Draining:     draining,
+		Reason:       reason,
+		IsLeader:     s.node.IsLeader(),
+		InFlight:     s.node.InFlight(),
+		CommitIndex:  s.node.Raft.CommitIndex(),
+		AppliedIndex: s.node.Raft.AppliedIndex(),
+	}
+	status.SafeToStop = status.Draining && !status.IsLeader &&
+		status.InFlight == 0 && status.AppliedIndex >= status.CommitIndex
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,45 @@
+package management
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"my-raft-sidecar/internal/raftnode"
+)
+
+// epochHeader carries the membership epoch (the configuration index) on
+// membership queries and changes.
+const epochHeader = "X-Raftkv-Epoch"
+
+// expectedEpoch parses the optional expected_epoch parameter of a
+// membership change. Zero means any epoch.
+func expectedEpoch(r *http.Request) (uint64, error) {
+	value := r.URL.Query().Get("expected_epoch")
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// setEpoch reports the current membership epoch in the response headers.
+func (s *Server) setEpoch(w http.ResponseWriter) {
+	if epoch, err := s.node.Epoch(); err == nil {
+		w.Header().Set(epochHeader, strconv.FormatUint(epoch, 10))
+	}
+}
+
+// membershipError answers a failed membership change: 409 with the
+// current epoch if the expected epoch was stale, 500 otherwise.
+func (s *Server) membershipError(w http.ResponseWriter, err error) {
+	if errors.Is(err, raftnode.ErrEpochMismatch) {
+		s.setEpoch(w)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, raftnode.ErrMemberExists) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,157 @@
+package management
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"my-raft-sidecar/internal/audit"
+)
+
+// forceRemoveTokenTTL is how long a force-remove confirmation token stays
+// valid.
+const forceRemoveTokenTTL = 2 * time.Minute
+
+// forceRemoveTokens holds the outstanding confirmation tokens, one per
+// peer.
+type forceRemoveTokens struct {
+	mu     sync.Mutex
+	tokens map[string]forceRemoveToken
+}
+
+type forceRemoveToken struct {
+	value   string
+	expires time.Time
+}
+
+// issue creates a token confirming the force-removal of peerID, replacing
+// any earlier one.
+func (t *forceRemoveTokens) issue(peerID string) (forceRemoveToken, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return forceRemoveToken{}, err
+	}
+	token := forceRemoveToken{value: hex.EncodeToString(buf), expires: time.Now().Add(forceRemoveTokenTTL)}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokens == nil {
+		t.tokens = make(map[string]forceRemoveToken)
+	}
+	t.tokens[peerID] = token
+	return token, nil
+}
+
+// consume reports whether value is the unexpired token for peerID. A
+// token can only be used once.
+func (t *forceRemoveTokens) consume(peerID, value string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	token, ok := t.tokens[peerID]
+	if !ok || time.Now().After(token.expires) ||
+		subtle.ConstantTimeCompare([]byte(value), []byte(token.value)) != 1 {
+		return false
+	}
+	delete(t.tokens, peerID)
+	return true
+}
+
+// forceRemoveResponse is the JSON body returned by /force-remove.
+type forceRemoveResponse struct {
+	PeerID       string                `json:"peer_id"`
+	Warning      string                `json:"warning,omitempty"`
+	ConfirmToken string                `json:"confirm_token,omitempty"`
+	ExpiresAt    time.Time             `json:"expires_at,omitzero"`
+	Servers      []configurationServer `json:"servers,omitempty"`
+	Message      string                `json:"message,omitempty"`
+}
+
+// handleForceRemove removes a peer from this node's configuration without
+// committing the change through Raft, for clusters whose configuration
+// changes are stuck, such as after losing quorum. It is applied to the node
+// receiving the request only, never redirected, and takes two calls:
+//
+//	POST /force-remove?peerID=...                   returns a confirm_token
+//	POST /force-remove?peerID=...&confirm=<token>   performs the removal
+//
+// The removal is written to peers.json and applied by raft.RecoverCluster
+// when the sidecar restarts (see Node.ForceRemoveServer).
+func (s *Server) handleForceRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peerID := r.URL.Query().Get("peerID")
+	if peerID == "" {
+		http.Error(w, "Missing peerID", http.StatusBadRequest)
+		return
+	}
+	if peerID == s.node.ID() {
+		http.Error(w, "Cannot force-remove this node from its own configuration", http.StatusBadRequest)
+		return
+	}
+
+	member, err := s.node.HasServer(peerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		http.Error(w, "Unknown peerID", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	confirm := r.URL.Query().Get("confirm")
+	if confirm == "" {
+		token, err := s.forceRemove.issue(peerID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Warn("Force-removal requested, awaiting confirmation", "member", peerID, "initiator", initiator(r))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(forceRemoveResponse{
+			PeerID: peerID,
+			Warning: "Force-removal bypasses Raft consensus and can lose committed writes or split the cluster. " +
+				"Use DELETE /remove while a leader can still commit. Repeat this request with confirm set to the token to proceed.",
+			ConfirmToken: token.value,
+			ExpiresAt:    token.expires.UTC(),
+		})
+		return
+	}
+
+	if !s.forceRemove.consume(peerID, confirm) {
+		http.Error(w, "Invalid or expired confirmation token", http.StatusForbidden)
+		return
+	}
+
+	logger.Warn("Force-removal confirmed", "member", peerID, "initiator", initiator(r))
+	configuration, err := s.node.ForceRemoveServer(peerID)
+	s.node.AuditLog().Record(s.auditEntry(r, audit.OpForceRemove, peerID, ""), err)
+	if err != nil {
+		logger.Error("Failed to force-remove server", "member", peerID, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	servers := make([]configurationServer, 0, len(configuration.Servers))
+	for _, server := range configuration.Servers {
+		servers = append(servers, configurationServer{
+			ID:       string(server.ID),
+			Address:  string(server.Address),
+			Suffrage: server.Suffrage.String(),
+		})
+	}
+	json.NewEncoder(w).Encode(forceRemoveResponse{
+		PeerID:  peerID,
+		Servers: servers,
+		Message: "Restart this sidecar to apply the new configuration. Repeat on every surviving server.",
+	})
+}
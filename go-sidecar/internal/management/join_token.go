@@ -0,0 +1,81 @@
+package management
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/jointoken"
+)
+
+const (
+	// defaultJoinTokenTTL and maxJoinTokenTTL bound how long a join token
+	// minted by /join-token is valid.
+	defaultJoinTokenTTL = time.Hour
+	maxJoinTokenTTL     = 24 * time.Hour
+)
+
+// joinTokenResponse is the JSON body returned by /join-token.
+type joinTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	PeerID    string    `json:"peer_id,omitempty"`
+	Nonvoter  bool      `json:"nonvoter,omitempty"`
+}
+
+// handleJoinToken mints a join token that /join and Admin.Join accept in
+// place of the cluster token, for handing to provisioning automation. The
+// caller must present the cluster token. The token is valid for ttl
+// (default 1h, at most 24h) and can be limited to one peerID or, with
+// nonvoter=true, to joining as a non-voter.
+func (s *Server) handleJoinToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.opts.ClusterToken == "" {
+		http.Error(w, "Join tokens require -cluster-token", http.StatusNotImplemented)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(s.opts.ClusterToken)) != 1 {
+		http.Error(w, "Invalid cluster token", http.StatusForbidden)
+		return
+	}
+
+	ttl := defaultJoinTokenTTL
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 || ttl > maxJoinTokenTTL {
+			http.Error(w, "Invalid ttl, expected a duration of at most 24h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if s.redirectToLeader(w, r) {
+		return
+	}
+
+	claims := jointoken.Claims{
+		Expires:  time.Now().Add(ttl).UTC().Truncate(time.Second),
+		NodeID:   r.URL.Query().Get("peerID"),
+		Nonvoter: r.URL.Query().Get("nonvoter") == "true",
+	}
+	token, err := jointoken.Issue(s.opts.ClusterToken, claims)
+	s.node.AuditLog().Record(s.auditEntry(r, audit.OpMintJoinToken, claims.NodeID, ""), err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Info("Minted join token", "initiator", initiator(r), "expires", claims.Expires.Format(time.RFC3339),
+		"member", claims.NodeID, "nonvoter", claims.Nonvoter)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(joinTokenResponse{
+		Token:     token,
+		ExpiresAt: claims.Expires,
+		PeerID:    claims.NodeID,
+		Nonvoter:  claims.Nonvoter,
+	})
+}
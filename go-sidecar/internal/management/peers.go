@@ -0,0 +1,73 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// peerHealth is one follower in the /peers response.
+type peerHealth struct {
+	ID           string  `json:"id"`
+	Address      string  `json:"address"`
+	Suffrage     string  `json:"suffrage"`
+	MatchIndex   uint64  `json:"match_index"`
+	NextIndex    uint64  `json:"next_index"`
+	Lag          uint64  `json:"lag"`
+	LastContact  *string `json:"last_contact"`
+	Failures     uint64  `json:"append_failures"`
+	Rejections   uint64  `json:"append_rejections"`
+	HeartbeatRTT string  `json:"heartbeat_rtt"`
+	Pipelined    bool    `json:"pipelined"`
+}
+
+// handlePeers reports the replication health of every follower. Only the
+// leader knows it, so followers redirect to the leader.
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.redirectToLeader(w, r) {
+		return
+	}
+
+	configuration, index, err := s.node.Configuration()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	progress := s.node.PeerProgress()
+	lastIndex := s.node.Raft.LastIndex()
+
+	peers := make([]peerHealth, 0, len(configuration.Servers))
+	for _, server := range configuration.Servers {
+		if string(server.ID) == s.node.ID() {
+			continue
+		}
+		// A follower that has never answered this term has no progress.
+		p := progress[string(server.ID)]
+		peer := peerHealth{
+			ID:           string(server.ID),
+			Address:      string(server.Address),
+			Suffrage:     server.Suffrage.String(),
+			MatchIndex:   p.MatchIndex,
+			NextIndex:    p.NextIndex,
+			Lag:          lastIndex - min(p.MatchIndex, lastIndex),
+			Failures:     p.Failures,
+			Rejections:   p.Rejections,
+			HeartbeatRTT: p.HeartbeatRTT.String(),
+			Pipelined:    p.Pipelined,
+		}
+		if !p.LastContact.IsZero() {
+			lastContact := time.Since(p.LastContact).String()
+			peer.LastContact = &lastContact
+		}
+		peers = append(peers, peer)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(epochHeader, strconv.FormatUint(index, 10))
+	json.NewEncoder(w).Encode(peers)
+}
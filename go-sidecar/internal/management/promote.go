@@ -0,0 +1,79 @@
+package management
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/audit"
+)
+
+// handlePromote promotes a non-voter, typically a standby, to voter. A
+// promoted standby starts serving client traffic once it sees itself in the
+// configuration as a voter.
+func (s *Server) handlePromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peerID := r.URL.Query().Get("peerID")
+	if peerID == "" {
+		http.Error(w, "Missing peerID", http.StatusBadRequest)
+		return
+	}
+	epoch, err := expectedEpoch(r)
+	if err != nil {
+		http.Error(w, "Invalid expected_epoch", http.StatusBadRequest)
+		return
+	}
+
+	if s.redirectToLeader(w, r) {
+		return
+	}
+
+	configuration, _, err := s.node.Configuration()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var target *raft.Server
+	for i := range configuration.Servers {
+		if string(configuration.Servers[i].ID) == peerID {
+			target = &configuration.Servers[i]
+		}
+	}
+	if target == nil {
+		http.Error(w, "Unknown peerID", http.StatusNotFound)
+		return
+	}
+	if target.Suffrage == raft.Voter {
+		http.Error(w, "Peer is already a voter", http.StatusConflict)
+		return
+	}
+	meta, _ := s.fsm.Peer(peerID)
+	if meta.ReadOnly {
+		http.Error(w, "Read-only replicas cannot be promoted", http.StatusConflict)
+		return
+	}
+
+	logger.Info("Promoting to voter", "member", peerID)
+	err = s.node.AddVoterAt(peerID, string(target.Address), epoch)
+	s.node.AuditLog().Record(s.auditEntry(r, audit.OpAddVoter, peerID, string(target.Address)), err)
+	if err != nil {
+		logger.Error("Failed to promote", "member", peerID, "error", err)
+		s.membershipError(w, err)
+		return
+	}
+
+	if meta.Standby {
+		meta.Standby = false
+		if err := s.node.PublishPeerMeta(&meta); err != nil {
+			logger.Error("Failed to publish metadata", "member", peerID, "error", err)
+		}
+	}
+
+	s.setEpoch(w)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Promoted successfully"))
+}
@@ -0,0 +1,56 @@
+package management
+
+import (
+	"net"
+	"net/http"
+)
+
+// redirectToLeader answers a request that only the leader can serve. On the
+// leader it returns false and the caller handles the request. Elsewhere it
+// responds with a 307 redirect to the leader's management API (or 503 if
+// there is no known leader) and returns true.
+func (s *Server) redirectToLeader(w http.ResponseWriter, r *http.Request) bool {
+	if s.node.IsLeader() {
+		return false
+	}
+
+	leaderAddr := s.leaderMgmtAddr()
+	if leaderAddr == "" {
+		http.Error(w, "No known leader", http.StatusServiceUnavailable)
+		return true
+	}
+
+	target := *r.URL
+	target.Scheme = "http"
+	if s.opts.TLS != nil {
+		target.Scheme = "https"
+	}
+	target.Host = leaderAddr
+	logger.Debug("Redirecting to leader", "method", r.Method, "path", r.URL.Path, "leader", leaderAddr)
+	http.Redirect(w, r, target.String(), http.StatusTemporaryRedirect)
+	return true
+}
+
+// leaderMgmtAddr returns the leader's management address from the
+// replicated peer metadata. For leaders that have not published metadata it
+// is derived from the Raft address, assuming every node serves the
+// management API on the same port; a node serving it on its socket only
+// has no port to assume, and returns "".
+func (s *Server) leaderMgmtAddr() string {
+	if meta, ok := s.fsm.Peer(s.node.LeaderID()); ok && meta.MgmtAddr != "" {
+		return meta.MgmtAddr
+	}
+	if s.port == "" {
+		return ""
+	}
+
+	raftAddr := s.node.LeaderAddr()
+	if raftAddr == "" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(raftAddr)
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, s.port)
+}
@@ -0,0 +1,35 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"my-raft-sidecar/internal/audit"
+)
+
+// handleReload applies changed settings without a restart, as SIGHUP does.
+// It answers 200 with the changed settings that need a restart to take
+// effect, and 400 if the new settings are invalid, in which case none of
+// them are applied.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	restart, err := s.opts.Reload()
+	s.node.AuditLog().Record(s.auditEntry(r, audit.OpReload, "", ""), err)
+	if err != nil {
+		logger.Warn("Reload failed", "initiator", clientIP(r), "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if restart == nil {
+		restart = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		RestartRequired []string `json:"restart_required"`
+	}{restart})
+}
@@ -0,0 +1,68 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"my-raft-sidecar/internal/audit"
+)
+
+// defaultReplaceTimeout is how long the new node of a replacement has to
+// catch up unless the request sets timeout.
+const defaultReplaceTimeout = 10 * time.Minute
+
+// handleReplace replaces a member with a new node, which is added as a
+// non-voter, promoted once it has caught up, after which the old member is
+// removed (see cluster.Replacer). Replacements run on the leader.
+//
+//	POST /replace?oldID=...&newID=...&newAddress=...[&timeout=10m]
+//	GET  /replace[?id=...]
+func (s *Server) handleReplace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	timeout := defaultReplaceTimeout
+	if r.Method == http.MethodPost {
+		if query.Get("oldID") == "" || query.Get("newID") == "" || query.Get("newAddress") == "" {
+			http.Error(w, "Missing oldID, newID or newAddress", http.StatusBadRequest)
+			return
+		}
+		if v := query.Get("timeout"); v != "" {
+			var err error
+			if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 {
+				http.Error(w, "Invalid timeout", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	if s.redirectToLeader(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodPost:
+		op, err := s.replacer.Start(query.Get("oldID"), query.Get("newID"), query.Get("newAddress"), timeout, initiator(r))
+		s.node.AuditLog().Record(s.auditEntry(r, audit.OpReplace, query.Get("oldID"), query.Get("newAddress")), err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(op)
+	case query.Get("id") != "":
+		op, ok := s.replacer.Get(query.Get("id"))
+		if !ok {
+			http.Error(w, "Unknown replacement", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(op)
+	default:
+		json.NewEncoder(w).Encode(s.replacer.List())
+	}
+}
@@ -0,0 +1,19 @@
+package management
+
+import (
+	"net/http"
+
+	"my-raft-sidecar/internal/requestid"
+)
+
+// withRequestID gives every request a request ID: the X-Request-ID header
+// the client sent, or a new one. It is echoed in the response, recorded in
+// audit entries and logged, so that a call can be found in the logs.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestid.OrNew(r.Header.Get(requestid.Header))
+		w.Header().Set(requestid.Header, id)
+		logger.Debug("Management request", "method", r.Method, "path", r.URL.Path, "request_id", id)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
@@ -3,26 +3,113 @@ package management
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/backend"
+	"my-raft-sidecar/internal/cluster"
+	"my-raft-sidecar/internal/config"
+	"my-raft-sidecar/internal/events"
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/jointoken"
+	"my-raft-sidecar/internal/logging"
+	"my-raft-sidecar/internal/metrics"
 	"my-raft-sidecar/internal/raftnode"
+	"my-raft-sidecar/internal/ratelimit"
+	"my-raft-sidecar/internal/rbac"
+	"my-raft-sidecar/internal/unixsock"
+	"my-raft-sidecar/internal/version"
 )
 
+// logger logs with component=management.
+var logger = logging.Component("management")
+
 // Server represents the HTTP management server.
 type Server struct {
 	node       *raftnode.Node
+	fsm        *fsm.CppFSM
+	health     *backend.HealthChecker
+	opts       *Options
 	httpServer *http.Server
 	port       string
+
+	forceRemove forceRemoveTokens
+	replacer    *cluster.Replacer
+}
+
+// Options contains optional parameters for the management server.
+type Options struct {
+	// ClusterToken, if set, must be passed as the token parameter of /join,
+	// unless a join token minted with it is passed instead. It is also
+	// required to mint join tokens on /join-token.
+	ClusterToken string
+	// Metrics, if set, is served on /metrics.
+	Metrics *metrics.Registry
+	// Drift, if set, reports configuration drift on /status while this
+	// node leads.
+	Drift *cluster.DriftDetector
+	// TLS, if set, serves the API over HTTPS. Set its ClientAuth to
+	// require client certificates.
+	TLS *tls.Config
+	// AuthToken, if set, must be presented as a bearer token on every
+	// endpoint but /health.
+	AuthToken string
+	// Policy, if set, restricts endpoints to callers with the required
+	// role (see requiredRole).
+	Policy *rbac.Policy
+	// JoinLimiter, if set, rate limits /join by client IP address.
+	JoinLimiter *ratelimit.Limiter
+	// BindAddr is the IP address to listen on; all interfaces if empty.
+	BindAddr string
+	// SocketPath, if set, is a Unix domain socket served as well, with the
+	// same TLS and authentication.
+	SocketPath string
+	// AllowedNetworks, if set, are the only networks, besides loopback,
+	// that may call the API (see restrictNetworks).
+	AllowedNetworks []*net.IPNet
+	// Reload, if set, is called by POST /reload to apply changed settings
+	// at runtime. It returns the changed settings that need a restart.
+	Reload func() ([]string, error)
+	// Config and PatchConfig, if set, serve GET and PATCH /config: Config
+	// returns the running settings, and PatchConfig changes reloadable
+	// ones at runtime, returning the changed settings that need a restart.
+	Config      func() *config.Settings
+	PatchConfig func(changes map[string]*string) ([]string, error)
+	// DebugConfig, if set, serves GET /debug/config with the effective
+	// configuration.
+	DebugConfig func() *config.Dump
+	// Events, if set, is streamed on /events.
+	Events *events.Bus
+	// Quorum, if set, reports the cluster's health on
+	// /health?mode=cluster.
+	Quorum *cluster.QuorumMonitor
+}
+
+// DefaultOptions returns sensible default options.
+func DefaultOptions() *Options {
+	return &Options{}
 }
 
 // NewServer creates a new management server.
-func NewServer(node *raftnode.Node, port string) *Server {
+func NewServer(node *raftnode.Node, stateMachine *fsm.CppFSM, health *backend.HealthChecker, port string, opts *Options) *Server {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
 	return &Server{
-		node: node,
-		port: port,
+		node:   node,
+		fsm:    stateMachine,
+		health: health,
+		opts:   opts,
+		port:   port,
+
+		replacer: cluster.NewReplacer(node),
 	}
 }
 
@@ -30,23 +117,101 @@ func NewServer(node *raftnode.Node, port string) *Server {
 func (s *Server) Start() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/join", s.handleJoin)
+	mux.HandleFunc("/join-token", s.handleJoinToken)
+	mux.HandleFunc("/remove", s.handleRemove)
+	mux.HandleFunc("/promote", s.handlePromote)
+	mux.HandleFunc("/replace", s.handleReplace)
+	mux.HandleFunc("/force-remove", s.handleForceRemove)
+	mux.HandleFunc("/configuration", s.handleConfiguration)
 	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/stats", s.handleStats)
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/version", s.handleVersion)
+	mux.HandleFunc("/drain", s.handleDrain)
+	mux.HandleFunc("/peers", s.handlePeers)
+	mux.HandleFunc("/audit", s.handleAudit)
+	mux.HandleFunc("/audit/verify", s.handleAuditVerify)
+	mux.HandleFunc("/snapshot", s.handleSnapshot)
+	if s.opts.Reload != nil {
+		mux.HandleFunc("/reload", s.handleReload)
+	}
+	if s.opts.Config != nil && s.opts.PatchConfig != nil {
+		mux.HandleFunc("/config", s.handleConfig)
+	}
+	if s.opts.DebugConfig != nil {
+		mux.HandleFunc("/debug/config", s.handleDebugConfig)
+	}
+	if s.opts.Events != nil {
+		mux.HandleFunc("/events", s.handleEvents)
+	}
+	if s.opts.Metrics != nil {
+		mux.Handle("/metrics", s.opts.Metrics)
+	}
+
+	var handler http.Handler = mux
+	if s.opts.AuthToken != "" || s.opts.Policy != nil {
+		handler = s.authenticate(mux)
+	}
+	if len(s.opts.AllowedNetworks) > 0 {
+		handler = s.restrictNetworks(handler)
+		logger.Info("Management API only accepts calls from loopback and the allowed networks", "networks", fmt.Sprint(s.opts.AllowedNetworks))
+	}
+	handler = withRequestID(handler)
 
 	addr := "0.0.0.0:" + s.port
+	if s.opts.BindAddr != "" {
+		addr = net.JoinHostPort(s.opts.BindAddr, s.port)
+	}
+	// Requests are cancelled on shutdown, which would otherwise wait for
+	// streams such as /events to end
+	base, cancel := context.WithCancel(context.Background())
 	s.httpServer = &http.Server{
 		Addr:         addr,
-		Handler:      mux,
+		Handler:      handler,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
+		TLSConfig:    s.opts.TLS,
+		BaseContext:  func(net.Listener) context.Context { return base },
+	}
+	s.httpServer.RegisterOnShutdown(cancel)
+
+	// An empty port serves the API only on the socket
+	if s.port != "" {
+		logger.Info("Management API listening", "address", addr, "tls", s.opts.TLS != nil)
+		go func() {
+			serve := s.httpServer.ListenAndServe
+			if s.opts.TLS != nil {
+				serve = func() error { return s.httpServer.ListenAndServeTLS("", "") }
+			}
+			if err := serve(); err != nil && err != http.ErrServerClosed {
+				logger.Error("Management server error", "error", err)
+			}
+		}()
 	}
 
-	log.Printf("Management API listening on %s", addr)
-	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("Management server error: %v", err)
+	if s.opts.SocketPath != "" {
+		lis, err := unixsock.Listen(s.opts.SocketPath)
+		if err != nil {
+			logger.Error("Management server failed to listen", "socket", s.opts.SocketPath, "error", err)
+			return
 		}
-	}()
+		logger.Info("Management API listening", "socket", s.opts.SocketPath, "tls", s.opts.TLS != nil)
+		go func() {
+			serve := s.httpServer.Serve
+			if s.opts.TLS != nil {
+				serve = func(lis net.Listener) error { return s.httpServer.ServeTLS(lis, "", "") }
+			}
+			if err := serve(lis); err != nil && err != http.ErrServerClosed {
+				logger.Error("Management server error", "socket", s.opts.SocketPath, "error", err)
+			}
+		}()
+	}
+}
+
+// fromSocket reports whether r was received on the Unix domain socket.
+func fromSocket(r *http.Request) bool {
+	addr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return unixsock.IsUnix(addr)
 }
 
 // Stop gracefully shuts down the management server.
@@ -57,6 +222,18 @@ func (s *Server) Stop(ctx context.Context) error {
 	return nil
 }
 
+// clientIP returns the address of the client that sent r, without port,
+// or "" if it came in on the Unix domain socket.
+func clientIP(r *http.Request) string {
+	if fromSocket(r) {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
 // handleJoin handles requests from nodes wanting to join the cluster.
 func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet && r.Method != http.MethodPost {
@@ -64,42 +241,281 @@ func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.opts.JoinLimiter.Allow(clientIP(r)) {
+		logger.Warn("Rate limited join request", "address", r.RemoteAddr)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many join requests, retry later", http.StatusTooManyRequests)
+		return
+	}
+
 	peerAddress := r.URL.Query().Get("peerAddress")
 	peerID := r.URL.Query().Get("peerID")
+	voter := r.URL.Query().Get("voter") != "false"
+	sidecarAddr := r.URL.Query().Get("sidecarAddr")
+	mgmtAddr := r.URL.Query().Get("mgmtAddr")
+	readOnly := r.URL.Query().Get("readOnly") == "true"
+	standby := r.URL.Query().Get("standby") == "true"
+	zone := r.URL.Query().Get("zone")
+	rack := r.URL.Query().Get("rack")
 
 	if peerAddress == "" || peerID == "" {
 		http.Error(w, "Missing peerAddress or peerID", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Received join request for %s at %s", peerID, peerAddress)
+	takeOver, err := jointoken.Authorize(s.opts.ClusterToken, r.URL.Query().Get("token"), peerID, voter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	epoch, err := expectedEpoch(r)
+	if err != nil {
+		http.Error(w, "Invalid expected_epoch", http.StatusBadRequest)
+		return
+	}
 
-	if err := s.node.AddVoter(peerID, peerAddress); err != nil {
-		log.Printf("Failed to add voter: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	priority := 0
+	if p := r.URL.Query().Get("priority"); p != "" {
+		var err error
+		if priority, err = strconv.Atoi(p); err != nil {
+			http.Error(w, "Invalid priority", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if s.redirectToLeader(w, r) {
+		return
+	}
+
+	if err := s.node.CheckClusterID(r.URL.Query().Get("clusterID")); err != nil {
+		logger.Warn("Rejected join request", "member", peerID, "error", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	logger.Info("Received join request", "member", peerID, "address", peerAddress, "voter", voter)
+
+	meta := &fsm.PeerMeta{
+		NodeID:      peerID,
+		SidecarAddr: sidecarAddr,
+		MgmtAddr:    mgmtAddr,
+		Priority:    priority,
+		ReadOnly:    readOnly,
+		Standby:     standby,
+		Zone:        zone,
+		Rack:        rack,
+	}
+	join := s.node.JoinAt
+	if !takeOver {
+		join = s.node.JoinNewAt
+	}
+	err = join(peerAddress, voter, meta, epoch)
+	s.node.AuditLog().Record(s.auditEntry(r, audit.OpJoin, peerID, peerAddress), err)
+	if err != nil {
+		logger.Error("Failed to add peer", "member", peerID, "error", err)
+		s.membershipError(w, err)
 		return
 	}
 
+	s.setEpoch(w)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Joined successfully"))
 }
 
+// handleRemove removes a failed or decommissioned node from the cluster
+// configuration. Removals that would leave the remaining voters without a
+// reachable quorum are refused unless force=true.
+func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peerID := r.URL.Query().Get("peerID")
+	if peerID == "" {
+		http.Error(w, "Missing peerID", http.StatusBadRequest)
+		return
+	}
+	epoch, err := expectedEpoch(r)
+	if err != nil {
+		http.Error(w, "Invalid expected_epoch", http.StatusBadRequest)
+		return
+	}
+
+	if s.redirectToLeader(w, r) {
+		return
+	}
+
+	member, err := s.node.HasServer(peerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		http.Error(w, "Unknown peerID", http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("force") != "true" {
+		if err := s.node.CheckRemoval(peerID); err != nil {
+			logger.Warn("Refusing to remove server", "member", peerID, "error", err)
+			http.Error(w, err.Error()+"; pass force=true to remove anyway", http.StatusConflict)
+			return
+		}
+	}
+
+	logger.Info("Received remove request", "member", peerID)
+	err = s.node.RemoveServerAt(peerID, epoch)
+	s.node.AuditLog().Record(s.auditEntry(r, audit.OpRemove, peerID, ""), err)
+	if err != nil {
+		logger.Error("Failed to remove peer", "member", peerID, "error", err)
+		s.membershipError(w, err)
+		return
+	}
+
+	s.setEpoch(w)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Removed successfully"))
+}
+
+// nodeStatus is the JSON body returned by /status.
+type nodeStatus struct {
+	NodeID            string `json:"node_id"`
+	ClusterID         string `json:"cluster_id"`
+	RaftAddr          string `json:"raft_addr"`
+	Bootstrapped      bool   `json:"bootstrapped"`
+	State             string `json:"state"`
+	Term              uint64 `json:"term"`
+	IsLeader          bool   `json:"is_leader"`
+	LeaderID          string `json:"leader_id"`
+	LeaderAddr        string `json:"leader_addr"`
+	Verified          bool   `json:"verified"`
+	CommitIndex       uint64 `json:"commit_index"`
+	AppliedIndex      uint64 `json:"applied_index"`
+	LastSnapshotIndex uint64 `json:"last_snapshot_index"`
+	// Peers are the servers of the latest configuration this node knows,
+	// itself included.
+	Peers []statusPeer `json:"peers"`
+	// BackendHealthy is false while the backend fails its health checks,
+	// for BackendUnhealthyFor with BackendError.
+	BackendHealthy      bool   `json:"backend_healthy"`
+	BackendUnhealthyFor string `json:"backend_unhealthy_for,omitempty"`
+	BackendError        string `json:"backend_error,omitempty"`
+	Draining            bool   `json:"draining"`
+	Standby             bool   `json:"standby"`
+	Version             string `json:"version"`
+	// Drift lists members that disagree with the configuration; only the
+	// leader reports it.
+	Drift []cluster.Drift `json:"drift,omitempty"`
+}
+
+// statusPeer is one server of the configuration in the /status response.
+type statusPeer struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+	Leader   bool   `json:"leader"`
+}
+
 // handleStatus returns the current status of the Raft node.
+// With ?verify=true leadership is confirmed with a quorum via VerifyLeader
+// instead of being read from local state.
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
-	status := struct {
-		IsLeader   bool   `json:"is_leader"`
-		LeaderAddr string `json:"leader_addr"`
-	}{
-		IsLeader:   s.node.IsLeader(),
-		LeaderAddr: s.node.LeaderAddr(),
+	isLeader := s.node.IsLeader()
+	verified := false
+	if r.URL.Query().Get("verify") == "true" && isLeader {
+		if err := s.node.VerifyLeader(); err != nil {
+			logger.Warn("Leadership verification failed", "error", err)
+			isLeader = false
+		} else {
+			verified = true
+		}
 	}
 
+	draining, _ := s.node.Draining()
+	var drift []cluster.Drift
+	if s.opts.Drift != nil {
+		drift = s.opts.Drift.Drifts()
+	}
+	leaderID := s.node.LeaderID()
+	peers := []statusPeer{}
+	if configuration, _, err := s.node.Configuration(); err == nil {
+		for _, server := range configuration.Servers {
+			peers = append(peers, statusPeer{
+				ID:       string(server.ID),
+				Address:  string(server.Address),
+				Suffrage: server.Suffrage.String(),
+				Leader:   string(server.ID) == leaderID,
+			})
+		}
+	}
+	lastSnapshot, _ := strconv.ParseUint(s.node.Raft.Stats()["last_snapshot_index"], 10, 64)
+	status := nodeStatus{
+		NodeID:            s.node.ID(),
+		ClusterID:         s.node.ClusterID(),
+		RaftAddr:          s.node.Addr(),
+		Bootstrapped:      s.node.Bootstrapped(),
+		State:             strings.ToLower(s.node.Raft.State().String()),
+		Term:              s.node.Raft.CurrentTerm(),
+		IsLeader:          isLeader,
+		LeaderID:          leaderID,
+		LeaderAddr:        s.node.LeaderAddr(),
+		Verified:          verified,
+		CommitIndex:       s.node.Raft.CommitIndex(),
+		AppliedIndex:      s.fsm.AppliedIndex(),
+		LastSnapshotIndex: lastSnapshot,
+		Peers:             peers,
+		BackendHealthy:    s.health == nil || s.health.Healthy(),
+		Draining:          draining,
+		Standby:           s.node.Standby(),
+		Version:           version.Version,
+		Drift:             drift,
+	}
+	if !status.BackendHealthy {
+		status.BackendUnhealthyFor = s.health.UnhealthyFor().Round(time.Millisecond).String()
+		if err := s.health.LastError(); err != nil {
+			status.BackendError = err.Error()
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"is_leader": %v, "leader_addr": %q}`, status.IsLeader, status.LeaderAddr)
+	json.NewEncoder(w).Encode(status)
 }
 
-// handleHealth returns a simple health check response.
+// handleHealth returns a simple health check response. With mode=cluster
+// it reports instead whether the cluster can commit writes, as seen from
+// this node, answering 503 Service Unavailable while an alert condition
+// holds.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	switch mode := r.URL.Query().Get("mode"); {
+	case mode == "cluster" && s.opts.Quorum != nil:
+		health := s.opts.Quorum.Health()
+		w.Header().Set("Content-Type", "application/json")
+		if !health.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+		return
+	case mode != "":
+		http.Error(w, fmt.Sprintf("Unknown health mode %q", mode), http.StatusBadRequest)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+// nodeVersion is the JSON body returned by /version.
+type nodeVersion struct {
+	NodeID string `json:"node_id"`
+	version.Info
+}
+
+// handleVersion returns the version and build information of the node,
+// to tell which members still run an old version during rolling upgrades.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodeVersion{NodeID: s.node.ID(), Info: version.Get()})
+}
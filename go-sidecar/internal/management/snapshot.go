@@ -0,0 +1,52 @@
+package management
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/audit"
+)
+
+// snapshotResponse describes the snapshot taken by /snapshot.
+type snapshotResponse struct {
+	ID    string `json:"id"`
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+}
+
+// handleSnapshot takes a Raft snapshot on this node, compacting its log
+// without waiting for -snapshot-threshold. It answers 409 if nothing was
+// applied since the last snapshot.
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	future := s.node.Raft.Snapshot()
+	err := future.Error()
+	s.node.AuditLog().Record(s.auditEntry(r, audit.OpSnapshot, s.node.ID(), ""), err)
+	if errors.Is(err, raft.ErrNothingNewToSnapshot) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Error("Snapshot failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	meta, reader, err := future.Open()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reader.Close()
+	logger.Info("Took snapshot", "snapshot", meta.ID, "index", meta.Index)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshotResponse{ID: meta.ID, Index: meta.Index, Term: meta.Term})
+}
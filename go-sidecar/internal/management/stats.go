@@ -0,0 +1,65 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// nodeStats is the JSON body returned by /stats.
+type nodeStats struct {
+	NodeID string `json:"node_id"`
+	// Raft is raft.Raft.Stats: the state, term, last log, commit and
+	// applied indexes, last contact with the leader and so on, as the
+	// library formats them.
+	Raft    map[string]string `json:"raft"`
+	Runtime runtimeStats      `json:"runtime"`
+}
+
+// runtimeStats are the Go runtime's figures in /stats.
+type runtimeStats struct {
+	GoVersion    string `json:"go_version"`
+	GOMAXPROCS   int    `json:"gomaxprocs"`
+	NumCPU       int    `json:"num_cpu"`
+	Goroutines   int    `json:"goroutines"`
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapInuse    uint64 `json:"heap_inuse_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	Sys          uint64 `json:"sys_bytes"`
+	NumGC        uint32 `json:"num_gc"`
+	GCPauseTotal string `json:"gc_pause_total"`
+	LastGC       string `json:"last_gc,omitempty"`
+}
+
+// handleStats returns the Raft library's statistics of the node, such as
+// its commit index and last contact with the leader, and the Go runtime's.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := nodeStats{
+		NodeID: s.node.ID(),
+		Raft:   s.node.Raft.Stats(),
+		Runtime: runtimeStats{
+			GoVersion:    runtime.Version(),
+			GOMAXPROCS:   runtime.GOMAXPROCS(0),
+			NumCPU:       runtime.NumCPU(),
+			Goroutines:   runtime.NumGoroutine(),
+			HeapAlloc:    mem.HeapAlloc,
+			HeapInuse:    mem.HeapInuse,
+			HeapObjects:  mem.HeapObjects,
+			Sys:          mem.Sys,
+			NumGC:        mem.NumGC,
+			GCPauseTotal: time.Duration(mem.PauseTotalNs).String(),
+		},
+	}
+	if mem.LastGC > 0 {
+		stats.Runtime.LastGC = time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339Nano)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
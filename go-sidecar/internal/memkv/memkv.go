@@ -0,0 +1,117 @@
+// Package memkv is an in-memory key-value store serving the StateMachine
+// gRPC service, which the sidecar runs in place of the C++ backend in
+// development mode (-dev). It applies the same SET and DELETE commands.
+package memkv
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/go-msgpack/v2/codec"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"my-raft-sidecar/internal/logging"
+	pb "my-raft-sidecar/pb"
+)
+
+// logger logs with component=memkv.
+var logger = logging.Component("memkv")
+
+// command is a MsgPack-encoded command, as the C++ backend decodes it.
+type command struct {
+	Op    string `codec:"op"`
+	Key   string `codec:"key"`
+	Value string `codec:"value"`
+}
+
+// Store is an in-memory key-value state machine.
+type Store struct {
+	pb.UnimplementedStateMachineServer
+
+	mu   sync.RWMutex
+	data map[string]string
+}
+
+// New returns an empty store.
+func New() *Store {
+	return &Store{data: make(map[string]string)}
+}
+
+// Serve serves the store, and the gRPC health service the sidecar probes,
+// on lis until it is closed.
+func (s *Store) Serve(lis net.Listener) error {
+	server := grpc.NewServer()
+	pb.RegisterStateMachineServer(server, s)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	return server.Serve(lis)
+}
+
+// Apply applies a committed command. Unknown operations are refused, as
+// the C++ backend refuses them.
+func (s *Store) Apply(ctx context.Context, req *pb.Command) (*pb.ApplyResponse, error) {
+	var cmd command
+	handle := &codec.MsgpackHandle{}
+	handle.RawToString = true
+	if err := codec.NewDecoderBytes(req.Data, handle).Decode(&cmd); err != nil {
+		return nil, fmt.Errorf("failed to decode command %d: %w", req.Index, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch cmd.Op {
+	case "SET":
+		s.data[cmd.Key] = cmd.Value
+	case "DELETE":
+		delete(s.data, cmd.Key)
+	default:
+		logger.Warn("Unknown operation in entry", "op", cmd.Op, "index", req.Index)
+		return &pb.ApplyResponse{Success: false}, nil
+	}
+	return &pb.ApplyResponse{Success: true}, nil
+}
+
+// Scan streams the keys in [start_key, end_key) in order.
+func (s *Store) Scan(req *pb.ScanRequest, stream grpc.ServerStreamingServer[pb.KeyValue]) error {
+	s.mu.RLock()
+	var keys []string
+	for key := range s.data {
+		if key >= req.StartKey && (req.EndKey == "" || key < req.EndKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if req.Limit > 0 && len(keys) > int(req.Limit) {
+		keys = keys[:req.Limit]
+	}
+	kvs := make([]*pb.KeyValue, len(keys))
+	for i, key := range keys {
+		kvs[i] = &pb.KeyValue{Key: key, Value: s.data[key]}
+	}
+	s.mu.RUnlock()
+
+	for _, kv := range kvs {
+		if err := stream.Send(kv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read looks up a key.
+func (s *Store) Read(ctx context.Context, req *pb.ReadRequest) (*pb.ReadResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, found := s.data[req.Key]
+	return &pb.ReadResponse{Found: found, Value: value}, nil
+}
+
+// OnLeadershipChange acknowledges leadership changes; the store runs no
+// leader-only work.
+func (s *Store) OnLeadershipChange(ctx context.Context, req *pb.LeadershipChange) (*pb.LeadershipChangeAck, error) {
+	return &pb.LeadershipChangeAck{}, nil
+}
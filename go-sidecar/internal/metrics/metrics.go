@@ -0,0 +1,161 @@
+// Package metrics exposes sidecar metrics in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector reports current metric values when the registry is scraped.
+type Collector interface {
+	Collect(w *Writer)
+}
+
+// CollectorFunc adapts a function to the Collector interface.
+type CollectorFunc func(w *Writer)
+
+// Collect calls f(w).
+func (f CollectorFunc) Collect(w *Writer) {
+	f(w)
+}
+
+// Registry holds the collectors served on /metrics.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a collector to the registry.
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// ServeHTTP writes every collector's metrics in the text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	writer := &Writer{families: make(map[string]*family)}
+	for _, c := range collectors {
+		c.Collect(writer)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buf := bufio.NewWriter(w)
+	writer.writeTo(buf)
+	buf.Flush()
+}
+
+// family is a metric and its samples, in the text format and as points.
+type family struct {
+	help    string
+	typ     string
+	samples []string
+	points  []point
+}
+
+// point is a sample's name, labels and value.
+type point struct {
+	name   string
+	labels []string
+	value  float64
+}
+
+// Writer collects samples during a scrape. Samples of the same metric are
+// grouped together regardless of the order they are reported in.
+type Writer struct {
+	families map[string]*family
+	order    []string
+}
+
+// Gauge reports a gauge sample. labels are alternating names and values.
+func (w *Writer) Gauge(name, help string, value float64, labels ...string) {
+	w.sample(name, name, help, "gauge", value, labels)
+}
+
+// Counter reports a counter sample. labels are alternating names and
+// values.
+func (w *Writer) Counter(name, help string, value float64, labels ...string) {
+	w.sample(name, name, help, "counter", value, labels)
+}
+
+// Summary reports the number and sum of a summary's observations. labels
+// are alternating names and values.
+func (w *Writer) Summary(name, help string, count uint64, sum float64, labels ...string) {
+	w.sample(name, name+"_sum", help, "summary", sum, labels)
+	w.sample(name, name+"_count", help, "summary", float64(count), labels)
+}
+
+// sample reports a sample, named name, of metric.
+func (w *Writer) sample(metric, name, help, typ string, value float64, labels []string) {
+	f, ok := w.families[metric]
+	if !ok {
+		f = &family{help: help, typ: typ}
+		w.families[metric] = f
+		w.order = append(w.order, metric)
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(value))
+	f.samples = append(f.samples, b.String())
+	f.points = append(f.points, point{name: name, labels: labels, value: value})
+}
+
+func (w *Writer) writeTo(buf *bufio.Writer) {
+	for _, name := range w.order {
+		f := w.families[name]
+		fmt.Fprintf(buf, "# HELP %s %s\n", name, strings.ReplaceAll(f.help, "\n", " "))
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, f.typ)
+		for _, sample := range f.samples {
+			buf.WriteString(sample)
+			buf.WriteByte('\n')
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
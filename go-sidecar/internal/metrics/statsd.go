@@ -0,0 +1,267 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	gometrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// Formats of the lines sent to a statsd server.
+const (
+	// FormatStatsd is plain statsd, without tags: label values are
+	// appended to the metric name, as in raftkv.raft.replication.heartbeat.node2.
+	FormatStatsd = "statsd"
+	// FormatDogStatsd is the DogStatsD extension, which carries labels and
+	// the configured tags as tags.
+	FormatDogStatsd = "dogstatsd"
+)
+
+// statsdMaxPacket is the largest UDP packet sent, small enough to avoid
+// fragmentation on common networks.
+const statsdMaxPacket = 1400
+
+// StatsdConfig is where and how a Statsd sends metrics.
+type StatsdConfig struct {
+	// Addr is the statsd server, as host:port.
+	Addr string
+	// Format is FormatStatsd or FormatDogStatsd.
+	Format string
+	// Tags, as "key:value", are added to every line in the DogStatsD
+	// format.
+	Tags []string
+	// Interval is how often the registry's metrics are pushed.
+	Interval time.Duration
+}
+
+// Statsd pushes metrics to a statsd or DogStatsD server over UDP. It is a
+// go-metrics sink, which sends the Raft library's telemetry as it is
+// emitted, and pushes the metrics of a registry's other collectors every
+// interval (see Start). Lines that cannot be queued are dropped, so a slow
+// or missing server never holds up the caller.
+type Statsd struct {
+	conn  net.Conn
+	cfg   StatsdConfig
+	lines chan string
+
+	// last holds the value of every counter at the previous push, as
+	// statsd counters are increments.
+	last map[string]float64
+}
+
+// NewStatsd creates a Statsd sending to cfg.Addr. Lines are queued until
+// Start is called.
+func NewStatsd(cfg StatsdConfig) (*Statsd, error) {
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Statsd{
+		conn:  conn,
+		cfg:   cfg,
+		lines: make(chan string, 4096),
+		last:  make(map[string]float64),
+	}, nil
+}
+
+// Start sends queued lines, and pushes the metrics of every collector in
+// registry but the Raft library's telemetry every interval, in a goroutine
+// until ctx is cancelled.
+func (s *Statsd) Start(ctx context.Context, registry *Registry) {
+	go s.flush(ctx)
+	go func() {
+		ticker := time.NewTicker(s.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.push(registry)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// push queues the current samples of the registry's collectors. Gauges are
+// sent as they are; counters, and the sums and counts of histograms and
+// summaries, as the increment since the previous push. Histogram buckets
+// have no statsd equivalent and are left out.
+func (s *Statsd) push(registry *Registry) {
+	registry.mu.Lock()
+	collectors := append([]Collector(nil), registry.collectors...)
+	registry.mu.Unlock()
+
+	w := &Writer{families: make(map[string]*family)}
+	for _, c := range collectors {
+		// The telemetry reaches the sink directly as it is emitted
+		if _, ok := c.(*Telemetry); ok {
+			continue
+		}
+		c.Collect(w)
+	}
+
+	for _, name := range w.order {
+		f := w.families[name]
+		for _, p := range f.points {
+			if f.typ == "gauge" {
+				s.send(p.name, p.labels, p.value, "g")
+				continue
+			}
+			if f.typ == "histogram" && strings.HasSuffix(p.name, "_bucket") {
+				continue
+			}
+			id := p.name + "\x00" + strings.Join(p.labels, "\x00")
+			delta := p.value - s.last[id]
+			if delta < 0 {
+				// The counter was reset
+				delta = p.value
+			}
+			s.last[id] = p.value
+			if delta != 0 {
+				s.send(p.name, p.labels, delta, "c")
+			}
+		}
+	}
+}
+
+// flush writes queued lines to the server, packing them into packets of up
+// to statsdMaxPacket bytes and sending a partial packet at least every
+// 100ms.
+func (s *Statsd) flush(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var packet bytes.Buffer
+	for {
+		select {
+		case line := <-s.lines:
+			if packet.Len()+len(line) > statsdMaxPacket {
+				s.conn.Write(packet.Bytes())
+				packet.Reset()
+			}
+			packet.WriteString(line)
+		case <-ticker.C:
+			if packet.Len() > 0 {
+				s.conn.Write(packet.Bytes())
+				packet.Reset()
+			}
+		case <-ctx.Done():
+			s.conn.Close()
+			return
+		}
+	}
+}
+
+// send queues a line for the metric name with labels, given as alternating
+// names and values, dropping it if the queue is full.
+func (s *Statsd) send(name string, labels []string, value float64, typ string) {
+	var b strings.Builder
+	b.WriteString(statsdName(name))
+	dogstatsd := s.cfg.Format == FormatDogStatsd
+	if !dogstatsd {
+		for i := 1; i < len(labels); i += 2 {
+			b.WriteByte('.')
+			b.WriteString(statsdName(labels[i]))
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	b.WriteByte('|')
+	b.WriteString(typ)
+	if dogstatsd && len(labels)+len(s.cfg.Tags) > 0 {
+		b.WriteString("|#")
+		sep := ""
+		for i := 0; i+1 < len(labels); i += 2 {
+			b.WriteString(sep + statsdTag(labels[i]) + ":" + statsdTag(labels[i+1]))
+			sep = ","
+		}
+		for _, tag := range s.cfg.Tags {
+			b.WriteString(sep + tag)
+			sep = ","
+		}
+	}
+	b.WriteByte('\n')
+
+	select {
+	case s.lines <- b.String():
+	default:
+	}
+}
+
+// statsdName replaces the characters that delimit a statsd line in a
+// metric name or name part.
+func statsdName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// statsdTag replaces the characters that delimit DogStatsD tags in a tag
+// name or value.
+func statsdTag(tag string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, tag)
+}
+
+// emit sends a go-metrics sample of key, dropping the unlabelled copies of
+// per-peer keys, which the labelled samples already carry.
+func (s *Statsd) emit(key []string, labels []gometrics.Label, value float32, typ string) {
+	if len(labels) == 0 && len(key) > 1 && perPeerKeys[strings.Join(key[:len(key)-1], ".")] {
+		return
+	}
+	pairs := make([]string, 0, 2*len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, label.Name, label.Value)
+	}
+	s.send(strings.Join(key, "."), pairs, float64(value), typ)
+}
+
+// SetGauge implements gometrics.MetricSink.
+func (s *Statsd) SetGauge(key []string, val float32) {
+	s.emit(key, nil, val, "g")
+}
+
+// SetGaugeWithLabels implements gometrics.MetricSink.
+func (s *Statsd) SetGaugeWithLabels(key []string, val float32, labels []gometrics.Label) {
+	s.emit(key, labels, val, "g")
+}
+
+// EmitKey implements gometrics.MetricSink. The Raft library does not emit
+// keys, so they are dropped.
+func (s *Statsd) EmitKey(key []string, val float32) {}
+
+// IncrCounter implements gometrics.MetricSink.
+func (s *Statsd) IncrCounter(key []string, val float32) {
+	s.emit(key, nil, val, "c")
+}
+
+// IncrCounterWithLabels implements gometrics.MetricSink.
+func (s *Statsd) IncrCounterWithLabels(key []string, val float32, labels []gometrics.Label) {
+	s.emit(key, labels, val, "c")
+}
+
+// AddSample implements gometrics.MetricSink. Samples are timings in
+// milliseconds.
+func (s *Statsd) AddSample(key []string, val float32) {
+	s.emit(key, nil, val, "ms")
+}
+
+// AddSampleWithLabels implements gometrics.MetricSink.
+func (s *Statsd) AddSampleWithLabels(key []string, val float32, labels []gometrics.Label) {
+	s.emit(key, labels, val, "ms")
+}
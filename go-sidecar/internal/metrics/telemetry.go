@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	gometrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// TelemetryService prefixes the keys of the telemetry the Raft library
+// emits through go-metrics, as in raftkv.raft.fsm.apply.
+const TelemetryService = "raftkv"
+
+// perPeerKeys are the Raft library's keys that it also emits with the peer
+// appended to the key, for sinks without labels. Telemetry keeps only the
+// labelled form.
+var perPeerKeys = map[string]bool{
+	"raftkv.raft.replication.appendEntries.rpc":  true,
+	"raftkv.raft.replication.appendEntries.logs": true,
+	"raftkv.raft.replication.heartbeat":          true,
+	"raftkv.raft.replication.installSnapshot":    true,
+}
+
+// Telemetry is a go-metrics sink that keeps the last value of each gauge,
+// the total of each counter and the number and sum of each sample, and
+// reports them when the registry is scraped. Samples of timings are in
+// milliseconds.
+type Telemetry struct {
+	mu       sync.Mutex
+	gauges   map[string]*telemetryPoint
+	counters map[string]*telemetryPoint
+	samples  map[string]*telemetryPoint
+}
+
+// telemetryPoint is the state of one metric and set of labels.
+type telemetryPoint struct {
+	name   string
+	key    string
+	labels []string
+	value  float64
+	count  uint64
+}
+
+// NewTelemetry creates an empty sink.
+func NewTelemetry() *Telemetry {
+	return &Telemetry{
+		gauges:   make(map[string]*telemetryPoint),
+		counters: make(map[string]*telemetryPoint),
+		samples:  make(map[string]*telemetryPoint),
+	}
+}
+
+// Install makes t, and statsd if not nil, the global go-metrics sink the
+// Raft library reports to. Being global, it receives the telemetry of
+// every node in the process.
+func (t *Telemetry) Install(statsd *Statsd) error {
+	var sink gometrics.MetricSink = t
+	if statsd != nil {
+		sink = gometrics.FanoutSink{t, statsd}
+	}
+	conf := gometrics.DefaultConfig(TelemetryService)
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	_, err := gometrics.NewGlobal(conf, sink)
+	return err
+}
+
+// SetGauge implements gometrics.MetricSink.
+func (t *Telemetry) SetGauge(key []string, val float32) {
+	t.SetGaugeWithLabels(key, val, nil)
+}
+
+// SetGaugeWithLabels implements gometrics.MetricSink.
+func (t *Telemetry) SetGaugeWithLabels(key []string, val float32, labels []gometrics.Label) {
+	t.update(t.gauges, key, labels, func(p *telemetryPoint) { p.value = float64(val) })
+}
+
+// EmitKey implements gometrics.MetricSink. The Raft library does not emit
+// keys, which have no Prometheus equivalent, so they are dropped.
+func (t *Telemetry) EmitKey(key []string, val float32) {}
+
+// IncrCounter implements gometrics.MetricSink.
+func (t *Telemetry) IncrCounter(key []string, val float32) {
+	t.IncrCounterWithLabels(key, val, nil)
+}
+
+// IncrCounterWithLabels implements gometrics.MetricSink.
+func (t *Telemetry) IncrCounterWithLabels(key []string, val float32, labels []gometrics.Label) {
+	t.update(t.counters, key, labels, func(p *telemetryPoint) { p.value += float64(val) })
+}
+
+// AddSample implements gometrics.MetricSink.
+func (t *Telemetry) AddSample(key []string, val float32) {
+	t.AddSampleWithLabels(key, val, nil)
+}
+
+// AddSampleWithLabels implements gometrics.MetricSink.
+func (t *Telemetry) AddSampleWithLabels(key []string, val float32, labels []gometrics.Label) {
+	t.update(t.samples, key, labels, func(p *telemetryPoint) {
+		p.value += float64(val)
+		p.count++
+	})
+}
+
+// update applies fn to the point of key and labels in points, creating it
+// if needed.
+func (t *Telemetry) update(points map[string]*telemetryPoint, key []string, labels []gometrics.Label, fn func(p *telemetryPoint)) {
+	dotted := strings.Join(key, ".")
+	if len(labels) == 0 && len(key) > 1 && perPeerKeys[strings.Join(key[:len(key)-1], ".")] {
+		return
+	}
+	id := dotted
+	var pairs []string
+	for _, label := range labels {
+		pairs = append(pairs, sanitizeName(label.Name), label.Value)
+		id += "\x00" + label.Name + "=" + label.Value
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := points[id]
+	if !ok {
+		p = &telemetryPoint{name: sanitizeName(strings.Join(key, "_")), key: dotted, labels: pairs}
+		points[id] = p
+	}
+	fn(p)
+}
+
+// Collect implements Collector.
+func (t *Telemetry) Collect(w *Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range sortedPoints(t.gauges) {
+		w.Gauge(p.name, "Raft library gauge "+p.key+".", p.value, p.labels...)
+	}
+	for _, p := range sortedPoints(t.counters) {
+		w.Counter(p.name+"_total", "Raft library counter "+p.key+".", p.value, p.labels...)
+	}
+	for _, p := range sortedPoints(t.samples) {
+		w.Summary(p.name, "Raft library samples "+p.key+"; timings are in milliseconds.", p.count, p.value, p.labels...)
+	}
+}
+
+// sortedPoints returns points ordered by their identity, so that scrapes
+// list metrics in a stable order.
+func sortedPoints(points map[string]*telemetryPoint) []*telemetryPoint {
+	ids := make([]string, 0, len(points))
+	for id := range points {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	sorted := make([]*telemetryPoint, len(ids))
+	for i, id := range ids {
+		sorted[i] = points[id]
+	}
+	return sorted
+}
+
+// sanitizeName replaces the characters Prometheus does not allow in metric
+// and label names with underscores.
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
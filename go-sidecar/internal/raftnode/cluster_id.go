@@ -0,0 +1,136 @@
+package raftnode
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/fsm"
+)
+
+// ErrClusterMismatch is returned when a node that belongs to another
+// cluster tries to join this one.
+var ErrClusterMismatch = errors.New("node belongs to a different cluster")
+
+// clusterIDNotifier is implemented by state machines that report the
+// replicated cluster ID once it has been applied.
+type clusterIDNotifier interface {
+	OnClusterID(fn func(id string))
+}
+
+// clusterIdentity holds the ID of the cluster this node belongs to. It is
+// persisted in the data directory, so it is known to the transport before
+// the log has been replayed.
+type clusterIdentity struct {
+	mu   sync.RWMutex
+	id   string
+	path string
+}
+
+// loadClusterIdentity reads the persisted cluster ID, if any.
+func loadClusterIdentity(dataDir string) (*clusterIdentity, error) {
+	identity := &clusterIdentity{path: filepath.Join(dataDir, "cluster-id")}
+	data, err := os.ReadFile(identity.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read cluster ID: %w", err)
+	}
+	identity.id = strings.TrimSpace(string(data))
+	return identity, nil
+}
+
+func (c *clusterIdentity) get() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.id
+}
+
+// set records and persists id.
+func (c *clusterIdentity) set(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(id+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write cluster ID: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to write cluster ID: %w", err)
+	}
+	c.id = id
+	return nil
+}
+
+// ClusterID returns the ID of the cluster this node belongs to, or "" if it
+// has not been assigned or replicated to this node yet.
+func (n *Node) ClusterID() string {
+	return n.identity.get()
+}
+
+// CheckClusterID returns ErrClusterMismatch if id, the cluster ID presented
+// by a joining node, names another cluster. Nodes that have never belonged
+// to a cluster present an empty ID.
+func (n *Node) CheckClusterID(id string) error {
+	own := n.ClusterID()
+	if id == "" || own == "" || id == own {
+		return nil
+	}
+	return fmt.Errorf("%w: %s (this cluster is %s)", ErrClusterMismatch, id, own)
+}
+
+// PublishClusterID replicates the cluster ID through the Raft log,
+// generating it if the cluster does not have one yet. Re-publishing it on
+// every leadership change keeps it available to nodes that join after the
+// original entry was compacted. It must be called on the leader.
+func (n *Node) PublishClusterID() error {
+	id := n.ClusterID()
+	if id == "" {
+		// Make sure an ID already in the log has been applied.
+		if err := n.Raft.Barrier(5 * time.Second).Error(); err != nil {
+			return err
+		}
+		if id = n.ClusterID(); id == "" {
+			var err error
+			if id, err = newClusterID(); err != nil {
+				return err
+			}
+			logger.Info("Generated cluster ID", "cluster_id", id)
+		}
+	}
+	future := n.Raft.ApplyLog(raft.Log{Data: []byte(id), Extensions: fsm.ClusterIDExtension}, 5*time.Second)
+	return future.Error()
+}
+
+// adoptClusterID records the cluster ID applied by the state machine.
+func (n *Node) adoptClusterID(id string) {
+	own := n.identity.get()
+	if own == id {
+		return
+	}
+	if own != "" {
+		logger.Warn("Replicated cluster ID differs from the persisted one, keeping it", "cluster_id", own, "replicated", id)
+		return
+	}
+	if err := n.identity.set(id); err != nil {
+		logger.Error("Failed to persist cluster ID", "error", err)
+		return
+	}
+	logger.Info("Node belongs to cluster", "cluster_id", id)
+}
+
+// newClusterID returns a random (version 4) UUID.
+func newClusterID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate cluster ID: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,280 @@
+package raftnode
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+)
+
+// Every value written by an encryptedStore starts with one of these flags:
+// plainValue is followed by the value as it is, and sealedValue by the key
+// ID, the nonce and the AES-GCM ciphertext. The flag is written with and
+// without keys, so a value is never told apart by its content, which for
+// log entries the client chooses.
+const (
+	plainValue  byte = 0
+	sealedValue byte = 1
+)
+
+// legacyMagic started the encrypted values of stores written before the
+// flags; their other values were written as they are (see framedFrom).
+var legacyMagic = []byte("RKE\x01")
+
+const keyIDSize = 4
+
+// framedFromKey holds, in the stable store, the index of the first log
+// entry written with a flag. Entries below it predate the flags.
+var framedFromKey = []byte("raftkv-framed-from")
+
+// stablePrefix prefixes the keys of stable store values written with a
+// flag, so that those written before, under the bare key, are still read
+// as they were.
+const stablePrefix = "raftkv/"
+
+// ErrNoEncryptionKey is returned when reading a value encrypted with a key
+// that is not configured.
+var ErrNoEncryptionKey = errors.New("log store value is encrypted with a key that is not configured")
+
+// encryptedStore wraps the Bolt log and stable store, encrypting the data of
+// log entries and the stable store's values with AES-256-GCM. Entries are
+// bound to their index so they cannot be swapped on disk. Without keys,
+// values are written in the clear, but encrypted values are still
+// recognised and refused rather than handed to the state machine.
+// Values written before encryption was enabled are read as they are, and
+// are replaced as the log is compacted.
+type encryptedStore struct {
+	*raftboltdb.BoltStore
+
+	// primary encrypts new values; keys decrypt them by key ID.
+	primary []byte
+	keys    map[string]cipher.AEAD
+
+	// framedFrom is the index of the first log entry written with a flag.
+	// Entries below it were written by earlier versions and are read by
+	// their legacyMagic prefix.
+	framedFrom atomic.Uint64
+}
+
+// openLogStore opens the log store in dataDir. The first of keys encrypts
+// new entries; all of them decrypt existing ones. keys are zeroed once the
+// ciphers have been set up. Unless sync is set, writes are not synced to
+// disk.
+func openLogStore(dataDir string, keys [][]byte, sync bool) (*encryptedStore, error) {
+	s := &encryptedStore{keys: make(map[string]cipher.AEAD)}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %w", err)
+		}
+		id := keyID(key)
+		if i == 0 {
+			s.primary = id
+		}
+		s.keys[string(id)] = aead
+		clear(key)
+	}
+
+	store, err := raftboltdb.New(raftboltdb.Options{
+		Path:   filepath.Join(dataDir, "logs.dat"),
+		NoSync: !sync,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.BoltStore = store
+	if err := s.loadFramedFrom(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadFramedFrom reads framedFrom, or for a store written before the flags
+// (or a new one) records that entries after its last one have them.
+func (s *encryptedStore) loadFramedFrom() error {
+	from, err := s.BoltStore.GetUint64(framedFromKey)
+	if err == nil {
+		s.framedFrom.Store(from)
+		return nil
+	}
+	if !errors.Is(err, raftboltdb.ErrKeyNotFound) {
+		return fmt.Errorf("failed to read log store format: %w", err)
+	}
+	last, err := s.BoltStore.LastIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read last log index: %w", err)
+	}
+	return s.setFramedFrom(last + 1)
+}
+
+// setFramedFrom records framedFrom.
+func (s *encryptedStore) setFramedFrom(index uint64) error {
+	if err := s.BoltStore.SetUint64(framedFromKey, index); err != nil {
+		return fmt.Errorf("failed to write log store format: %w", err)
+	}
+	s.framedFrom.Store(index)
+	return nil
+}
+
+// keyID identifies key without revealing it.
+func keyID(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return sum[:keyIDSize]
+}
+
+// Encrypted reports whether new values are encrypted.
+func (s *encryptedStore) Encrypted() bool {
+	return s.primary != nil
+}
+
+// seal returns plaintext with its flag, encrypted if there is a key.
+func (s *encryptedStore) seal(plaintext, aad []byte) ([]byte, error) {
+	if s.primary == nil {
+		return append([]byte{plainValue}, plaintext...), nil
+	}
+	aead := s.keys[string(s.primary)]
+	out := make([]byte, 0, 1+keyIDSize+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	out = append(out, sealedValue)
+	out = append(out, s.primary...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, aad), nil
+}
+
+// open returns the plaintext of a value written by seal.
+func (s *encryptedStore) open(value, aad []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, errors.New("log store value has no format flag")
+	}
+	switch value[0] {
+	case plainValue:
+		return value[1:], nil
+	case sealedValue:
+		return s.decrypt(value[1:], aad)
+	}
+	return nil, fmt.Errorf("log store value has unknown format flag %d", value[0])
+}
+
+// openLegacy returns the plaintext of a value written before the flags,
+// which was encrypted if it starts with legacyMagic.
+func (s *encryptedStore) openLegacy(value, aad []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, legacyMagic) {
+		return value, nil
+	}
+	return s.decrypt(value[len(legacyMagic):], aad)
+}
+
+// decrypt returns the plaintext of the key ID, nonce and ciphertext in
+// rest.
+func (s *encryptedStore) decrypt(rest, aad []byte) ([]byte, error) {
+	if len(rest) < keyIDSize {
+		return nil, errors.New("truncated encrypted log store value")
+	}
+	aead, ok := s.keys[string(rest[:keyIDSize])]
+	if !ok {
+		return nil, fmt.Errorf("%w (key ID %x)", ErrNoEncryptionKey, rest[:keyIDSize])
+	}
+	rest = rest[keyIDSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("truncated encrypted log store value")
+	}
+	plaintext, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt log store value: %w", err)
+	}
+	return plaintext, nil
+}
+
+func indexAAD(index uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte("log:"), index)
+}
+
+func (s *encryptedStore) GetLog(index uint64, log *raft.Log) error {
+	if err := s.BoltStore.GetLog(index, log); err != nil {
+		return err
+	}
+	open := s.open
+	if index < s.framedFrom.Load() {
+		open = s.openLegacy
+	}
+	data, err := open(log.Data, indexAAD(index))
+	if err != nil {
+		return fmt.Errorf("log entry %d: %w", index, err)
+	}
+	log.Data = data
+	return nil
+}
+
+func (s *encryptedStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs flags and encrypts copies of logs; Raft keeps the originals in
+// memory.
+func (s *encryptedStore) StoreLogs(logs []*raft.Log) error {
+	sealed := make([]*raft.Log, len(logs))
+	for i, log := range logs {
+		data, err := s.seal(log.Data, indexAAD(log.Index))
+		if err != nil {
+			return err
+		}
+		entry := *log
+		entry.Data = data
+		sealed[i] = &entry
+	}
+	return s.BoltStore.StoreLogs(sealed)
+}
+
+// DeleteRange deletes the log entries in [min, max]. Once every entry from
+// min up to framedFrom is gone, entries written there again have flags, so
+// framedFrom moves down to min first.
+func (s *encryptedStore) DeleteRange(min, max uint64) error {
+	if from := s.framedFrom.Load(); min < from && max+1 >= from {
+		if err := s.setFramedFrom(min); err != nil {
+			return err
+		}
+	}
+	return s.BoltStore.DeleteRange(min, max)
+}
+
+// Get returns the value of key, as last written by Set or, if Set has not
+// written it since the flags were introduced, by an earlier version.
+func (s *encryptedStore) Get(key []byte) ([]byte, error) {
+	aad := append([]byte("stable:"), key...)
+	value, err := s.BoltStore.Get(append([]byte(stablePrefix), key...))
+	if err == nil {
+		return s.open(value, aad)
+	}
+	if !errors.Is(err, raftboltdb.ErrKeyNotFound) {
+		return nil, err
+	}
+	if value, err = s.BoltStore.Get(key); err != nil {
+		return nil, err
+	}
+	return s.openLegacy(value, aad)
+}
+
+func (s *encryptedStore) Set(key, value []byte) error {
+	sealed, err := s.seal(value, append([]byte("stable:"), key...))
+	if err != nil {
+		return err
+	}
+	return s.BoltStore.Set(append([]byte(stablePrefix), key...), sealed)
+}
@@ -0,0 +1,45 @@
+package raftnode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrEpochMismatch is returned for membership changes made against an
+// expected epoch that is no longer current.
+var ErrEpochMismatch = errors.New("membership has changed since the expected epoch")
+
+// Epoch returns the membership epoch: the log index of the latest
+// configuration. Every membership change advances it, so a change made
+// with the epoch read beforehand (see AddVoterAt, AddNonvoterAt,
+// RemoveServerAt and JoinAt) fails if anything else changed in between.
+func (n *Node) Epoch() (uint64, error) {
+	_, index, err := n.Configuration()
+	return index, err
+}
+
+// checkEpoch fails with ErrEpochMismatch unless expected is zero or the
+// current epoch.
+func (n *Node) checkEpoch(expected uint64) error {
+	if expected == 0 {
+		return nil
+	}
+	current, err := n.Epoch()
+	if err != nil {
+		return err
+	}
+	if current != expected {
+		return fmt.Errorf("%w: expected %d, current %d", ErrEpochMismatch, expected, current)
+	}
+	return nil
+}
+
+// epochError maps the error hashicorp/raft returns for a stale prevIndex
+// to ErrEpochMismatch.
+func epochError(err error, expected uint64) error {
+	if err != nil && expected != 0 && strings.Contains(err.Error(), "configuration changed since") {
+		return fmt.Errorf("%w: %v", ErrEpochMismatch, err)
+	}
+	return err
+}
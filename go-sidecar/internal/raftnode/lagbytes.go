@@ -0,0 +1,75 @@
+package raftnode
+
+import (
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// maxCachedEntrySizes bounds the number of entry sizes entrySizes keeps.
+// Past it, the sizes of further entries are read from the log store on
+// every scrape.
+const maxCachedEntrySizes = 1 << 20
+
+// entrySizes caches the sizes of the leader's log entries that some
+// follower has yet to receive, so that follower lag can be reported in
+// bytes without reading the same entries from the log store on every
+// scrape.
+type entrySizes struct {
+	mu    sync.Mutex
+	term  uint64
+	sizes map[uint64]int
+}
+
+func newEntrySizes() *entrySizes {
+	return &entrySizes{sizes: make(map[uint64]int)}
+}
+
+// lagBytes returns, for every follower in progress, the total size of the
+// entries after its match index up to lastIndex. Entries already compacted
+// out of the log are not counted. Sizes are only cached within a term, as
+// a new leader may have overwritten uncommitted entries.
+func (c *entrySizes) lagBytes(store raft.LogStore, term, lastIndex uint64, progress map[string]PeerProgress) map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if term != c.term {
+		c.term = term
+		c.sizes = make(map[uint64]int)
+	}
+	firstIndex, err := store.FirstIndex()
+	if err != nil {
+		return nil
+	}
+
+	result := make(map[string]uint64, len(progress))
+	minMatch := lastIndex
+	for id, p := range progress {
+		minMatch = min(minMatch, p.MatchIndex)
+		var total uint64
+		for index := max(p.MatchIndex+1, firstIndex); index <= lastIndex; index++ {
+			size, ok := c.sizes[index]
+			if !ok {
+				var entry raft.Log
+				if err := store.GetLog(index, &entry); err != nil {
+					continue
+				}
+				size = len(entry.Data) + len(entry.Extensions)
+				if len(c.sizes) < maxCachedEntrySizes {
+					c.sizes[index] = size
+				}
+			}
+			total += uint64(size)
+		}
+		result[id] = total
+	}
+
+	// Every follower has the entries up to the lowest match index, and
+	// those before the first index are gone from the log
+	for index := range c.sizes {
+		if index <= minMatch || index < firstIndex {
+			delete(c.sizes, index)
+		}
+	}
+	return result
+}
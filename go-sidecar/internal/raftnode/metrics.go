@@ -0,0 +1,77 @@
+package raftnode
+
+import (
+	"strconv"
+	"time"
+
+	"my-raft-sidecar/internal/metrics"
+)
+
+// Collect reports the apply backlog, proposals in flight and slow
+// proposals, and while this node leads the replication health of every
+// follower: its position and lag in entries and bytes, contact, append
+// latency and failures, and whether it is replicated to over a pipeline.
+func (n *Node) Collect(w *metrics.Writer) {
+	commitIndex := n.Raft.CommitIndex()
+	w.Gauge("raftkv_commit_index", "Index of the last log entry known to be committed.", float64(commitIndex))
+	if backlog, err := n.applyBacklog(commitIndex); err == nil {
+		w.Gauge("raftkv_apply_backlog_entries", "Number of committed log entries not yet applied by the state machine.", float64(backlog))
+	}
+	if pending, err := strconv.ParseUint(n.Raft.Stats()["fsm_pending"], 10, 64); err == nil {
+		w.Gauge("raftkv_fsm_pending_batches", "Batches of committed log entries handed to the state machine and waiting to be applied.", float64(pending))
+	}
+	w.Gauge("raftkv_proposals_in_flight", "Proposals made on this node that are waiting to be committed.", float64(n.InFlight()))
+	w.Counter("raftkv_slow_proposals_total", "Proposals made on this node that took longer than -slow-propose-threshold to be committed and applied.", float64(n.slowProposals.Load()))
+
+	progress := n.PeerProgress()
+	if len(progress) == 0 {
+		return
+	}
+	lastIndex := n.Raft.LastIndex()
+	lagBytes := n.entrySizes.lagBytes(n.logStore, n.Raft.CurrentTerm(), lastIndex, progress)
+	latencies := n.replication.latencies()
+	now := time.Now()
+
+	for id, p := range progress {
+		w.Gauge("raftkv_peer_match_index", "Highest log index known to be replicated to the follower.", float64(p.MatchIndex), "peer", id)
+		w.Gauge("raftkv_peer_next_index", "Index of the next log entry the leader sends to the follower.", float64(p.NextIndex), "peer", id)
+		w.Gauge("raftkv_peer_lag_entries", "Number of log entries the follower is behind the leader.", float64(lastIndex-min(p.MatchIndex, lastIndex)), "peer", id)
+		if bytes, ok := lagBytes[id]; ok {
+			w.Gauge("raftkv_peer_lag_bytes", "Size of the log entries the follower is behind the leader, not counting entries compacted into a snapshot.", float64(bytes), "peer", id)
+		}
+		// A follower that has not answered this term has no contact to report
+		if !p.LastContact.IsZero() {
+			w.Gauge("raftkv_peer_last_contact_seconds", "Seconds since the follower last answered an AppendEntries RPC.", now.Sub(p.LastContact).Seconds(), "peer", id)
+		}
+		w.Gauge("raftkv_peer_heartbeat_rtt_seconds", "Round-trip time of the last heartbeat to the follower.", p.HeartbeatRTT.Seconds(), "peer", id)
+		// Gauges rather than counters, as they restart with every term
+		w.Gauge("raftkv_peer_append_failures", "AppendEntries RPCs to the follower that failed in transport this term.", float64(p.Failures), "peer", id)
+		w.Gauge("raftkv_peer_append_rejections", "AppendEntries RPCs the follower rejected this term.", float64(p.Rejections), "peer", id)
+		pipelined := 0.0
+		if p.Pipelined {
+			pipelined = 1
+		}
+		w.Gauge("raftkv_peer_pipeline_active", "Whether entries are streamed to the follower over a pipeline (1) or sent one RPC at a time (0).", pipelined, "peer", id)
+		if h, ok := latencies[id]; ok {
+			w.Histogram("raftkv_peer_append_duration_seconds", "Duration of AppendEntries RPCs carrying log entries to the follower.", h, "peer", id)
+		}
+	}
+}
+
+// applyBacklog returns how many committed entries the state machine has yet
+// to apply, up to the last command at or below commitIndex: trailing
+// configuration changes and no-ops are never applied, and would otherwise
+// count as backlog until the next command. State machines that do not
+// report their applied index are measured by what Raft has handed them.
+func (n *Node) applyBacklog(commitIndex uint64) (uint64, error) {
+	if n.applied == nil {
+		appliedIndex := n.Raft.AppliedIndex()
+		return commitIndex - min(appliedIndex, commitIndex), nil
+	}
+	appliedIndex := n.applied.AppliedIndex()
+	last, err := n.LastCommandIndex(commitIndex, appliedIndex)
+	if err != nil {
+		return 0, err
+	}
+	return last - min(appliedIndex, last), nil
+}
@@ -2,32 +2,106 @@
 package raftnode
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"os"
-	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/raft"
-	raftboltdb "github.com/hashicorp/raft-boltdb"
 
+	"my-raft-sidecar/internal/audit"
 	"my-raft-sidecar/internal/config"
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/logging"
+	"my-raft-sidecar/internal/requestid"
+	"my-raft-sidecar/internal/signing"
+	"my-raft-sidecar/internal/tlsutil"
+	"my-raft-sidecar/internal/tracing"
 )
 
+// logger logs with component=raftnode.
+var logger = logging.Component("raftnode")
+
 // Node wraps the Raft instance and provides high-level operations.
 type Node struct {
 	Raft      *raft.Raft
 	Transport *raft.NetworkTransport
 	config    *config.Config
+	logStore  raft.LogStore
+	identity  *clusterIdentity
+	auditLog  *audit.Log
+	// applied reports how far the state machine has applied the log, if it
+	// can (see appliedIndexer).
+	applied appliedIndexer
+
+	// replication records follower progress while this node leads, and
+	// entrySizes the sizes of the entries followers lag behind by.
+	replication *trackingTransport
+	entrySizes  *entrySizes
+
+	leaderMu   sync.Mutex
+	leaderSubs []chan bool
+
+	drainMu     sync.RWMutex
+	draining    bool
+	drainReason string
+	inFlight    atomic.Int64
+	// slowProposals counts proposals that took longer than
+	// -slow-propose-threshold.
+	slowProposals atomic.Uint64
+
+	// standby is set while this node is a hot spare that has not been
+	// promoted to voter.
+	standby atomic.Bool
+
+	// readTerm is the last term in which a barrier committed, after which
+	// the commit index is known to be current (see ReadIndex).
+	readTerm atomic.Uint64
 }
 
+// ErrDraining is returned for proposals made while the node is draining.
+var ErrDraining = errors.New("node is draining")
+
+// ErrLogCompacted is returned when requested log entries have already been
+// removed from the log store by compaction.
+var ErrLogCompacted = errors.New("log entries have been compacted")
+
+// ErrMemberExists is returned by JoinNewAt when the joining node's ID or
+// address belongs to an existing member.
+var ErrMemberExists = errors.New("ID or address belongs to an existing member")
+
 // Options contains optional parameters for creating a Raft node.
 type Options struct {
 	// MaxPool is the maximum number of connections in the transport pool.
 	MaxPool int
 	// Timeout is the timeout for transport operations.
 	Timeout time.Duration
+	// AuditLog, if set, records membership changes made through the node.
+	AuditLog *audit.Log
+	// Standby starts the node as a hot spare that serves no client traffic
+	// until it has been promoted to voter (see Node.Standby).
+	Standby bool
+	// TLS, if set, encrypts the Raft transport with its certificate (see
+	// transportTLS).
+	TLS *tlsutil.Source
+	// VerifyPeer, if set, replaces the host name check on peer
+	// certificates when no allowlist is configured, such as a check that
+	// the peer's SPIFFE ID is in this node's trust domain.
+	VerifyPeer func(*x509.Certificate) error
+	// EncryptionKeys, if set, are AES-256 keys encrypting the log store at
+	// rest (see encryptedStore). The first encrypts new entries.
+	EncryptionKeys [][]byte
+	// InMemory keeps the log, stable state and snapshots in memory, so
+	// they are lost when the node stops. It is meant for development.
+	InMemory bool
 }
 
 // DefaultOptions returns sensible default options.
@@ -39,7 +113,7 @@ func DefaultOptions() *Options {
 }
 
 // New creates and configures a new Raft node.
-func New(cfg *config.Config, fsm raft.FSM, opts *Options) (*Node, error) {
+func New(cfg *config.Config, stateMachine raft.FSM, opts *Options) (*Node, error) {
 	if opts == nil {
 		opts = DefaultOptions()
 	}
@@ -52,41 +126,107 @@ func New(cfg *config.Config, fsm raft.FSM, opts *Options) (*Node, error) {
 	// Configure Raft
 	raftConfig := raft.DefaultConfig()
 	raftConfig.LocalID = raft.ServerID(cfg.NodeID)
+	applyTunables(raftConfig, cfg)
+	raftConfig.LeaderLeaseTimeout = cfg.LeaderLeaseTimeout
+	raftConfig.CommitTimeout = cfg.CommitTimeout
+	raftConfig.MaxAppendEntries = cfg.MaxAppendEntries
+	raftConfig.BatchApplyCh = cfg.BatchApply
+	if err := applyLogLevels(cfg); err != nil {
+		return nil, err
+	}
+	raftConfig.Logger = logging.HCLog("raft")
 
 	// Setup log store
-	logStore, err := raftboltdb.NewBoltStore(filepath.Join(cfg.DataDir, "logs.dat"))
+	var logStore raft.LogStore
+	var stableStore raft.StableStore
+	var diskStore *encryptedStore
+	if opts.InMemory {
+		store := raft.NewInmemStore()
+		logStore, stableStore = store, store
+	} else {
+		var err error
+		diskStore, err = openLogStore(cfg.DataDir, opts.EncryptionKeys, cfg.LogSync)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create log store: %w", err)
+		}
+		if !cfg.LogSync {
+			logger.Warn("-log-sync=false: the Raft log is not synced to disk, so a crash of the host can lose acknowledged entries")
+		}
+		if diskStore.Encrypted() {
+			logger.Info("Raft log store is encrypted at rest", "keys", len(opts.EncryptionKeys))
+		}
+		logStore, stableStore = diskStore, diskStore // Use same store for stable store
+	}
+
+	identity, err := loadClusterIdentity(cfg.DataDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create log store: %w", err)
+		return nil, err
 	}
 
 	// Create transport
-	transport, err := createTransport(cfg, opts)
+	transport, err := createTransport(cfg, opts, identity)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create transport: %w", err)
 	}
 
+	node := &Node{
+		Transport: transport,
+		config:    cfg,
+		logStore:  logStore,
+		identity:  identity,
+		auditLog:  opts.AuditLog,
+
+		replication: newTrackingTransport(transport),
+		entrySizes:  newEntrySizes(),
+	}
+	node.standby.Store(opts.Standby)
+	if notifier, ok := stateMachine.(clusterIDNotifier); ok {
+		notifier.OnClusterID(node.adoptClusterID)
+	}
+	if applied, ok := stateMachine.(appliedIndexer); ok {
+		node.applied = applied
+	}
+
+	// Snapshots carry the backend's keys and the replicated metadata (see
+	// fsm.CppFSM.Snapshot), so that lagging followers and new members can
+	// be brought up to date once the log has been compacted.
+	var snapshots raft.SnapshotStore
+	if opts.InMemory {
+		snapshots = raft.NewInmemSnapshotStore()
+	} else {
+		snapshots, err = raft.NewFileSnapshotStoreWithLogger(cfg.DataDir, 2, logging.HCLog("raft").Named("snapshot"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create snapshot store: %w", err)
+		}
+
+		// Apply a manual recovery configuration, if one was provided
+		if err := recoverFromPeersFile(cfg.DataDir, raftConfig, stateMachine, diskStore, snapshots, transport); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create Raft instance
-	r, err := raft.NewRaft(
+	node.Raft, err = raft.NewRaft(
 		raftConfig,
-		fsm,
+		stateMachine,
 		logStore,
-		logStore, // Use same store for stable store
-		raft.NewDiscardSnapshotStore(),
-		transport,
+		stableStore,
+		snapshots,
+		node.replication,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create raft instance: %w", err)
 	}
+	go node.watchLeadership()
 
-	return &Node{
-		Raft:      r,
-		Transport: transport,
-		config:    cfg,
-	}, nil
+	return node, nil
 }
 
-// createTransport creates and configures the Raft network transport.
-func createTransport(cfg *config.Config, opts *Options) (*raft.NetworkTransport, error) {
+// createTransport creates and configures the Raft network transport. Its
+// connections carry this node's cluster ID (see clusterStreamLayer) if
+// -raft-cluster-check is set, and are encrypted if a TLS certificate is
+// configured.
+func createTransport(cfg *config.Config, opts *Options, identity *clusterIdentity) (*raft.NetworkTransport, error) {
 	bindAddr := cfg.BindAddr()
 	advertiseAddr := cfg.AdvertiseAddr()
 
@@ -95,57 +235,591 @@ func createTransport(cfg *config.Config, opts *Options) (*raft.NetworkTransport,
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve advertise address %s: %w", advertiseAddr, err)
 	}
+	if advAddr.IP == nil || advAddr.IP.IsUnspecified() {
+		return nil, fmt.Errorf("advertise address %s is not advertisable", advertiseAddr)
+	}
 
-	transport, err := raft.NewTCPTransport(
-		bindAddr,
-		advAddr,
-		opts.MaxPool,
-		opts.Timeout,
-		os.Stderr,
-	)
+	serverTLS, clientTLS, err := transportTLS(cfg, opts.TLS, opts.VerifyPeer)
+	if err != nil {
+		return nil, err
+	}
+	if serverTLS != nil {
+		logger.Info("Raft transport uses TLS", "peer_certificates_required", serverTLS.ClientAuth != tls.NoClientCert)
+	}
+
+	stream, err := newClusterStreamLayer(bindAddr, advAddr, identity, serverTLS, clientTLS, cfg.RaftClusterCheck)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create TCP transport: %w", err)
 	}
 
-	return transport, nil
+	return raft.NewNetworkTransportWithLogger(stream, opts.MaxPool, opts.Timeout, logging.HCLog("raft").Named("transport")), nil
 }
 
-// Bootstrap bootstraps the Raft cluster with this node as the initial leader.
+// Bootstrap bootstraps the Raft cluster. Without a static peer list this
+// node is the only server and becomes the initial leader; with one, every
+// listed server is part of the initial configuration.
 func (n *Node) Bootstrap() error {
-	log.Println("Bootstrapping cluster...")
-	future := n.Raft.BootstrapCluster(raft.Configuration{
-		Servers: []raft.Server{
-			{
-				ID:      raft.ServerID(n.config.NodeID),
-				Address: n.Transport.LocalAddr(),
-			},
+	servers := []raft.Server{
+		{
+			ID:      raft.ServerID(n.config.NodeID),
+			Address: n.Transport.LocalAddr(),
 		},
-	})
-	return future.Error()
+	}
+	if len(n.config.Peers) > 0 {
+		var err error
+		if servers, err = n.staticServers(); err != nil {
+			return err
+		}
+	}
+	return n.BootstrapServers(servers)
+}
+
+// staticServers parses the configured peer list. Each entry is either
+// "id=host:port" or "host:port", in which case the host doubles as the
+// node ID. This node is added if it is not listed.
+func (n *Node) staticServers() ([]raft.Server, error) {
+	self := raft.Server{ID: raft.ServerID(n.config.NodeID), Address: n.Transport.LocalAddr()}
+	servers := []raft.Server{self}
+	seen := map[raft.ServerID]bool{self.ID: true}
+
+	for _, peer := range n.config.Peers {
+		id, addr, ok := strings.Cut(peer, "=")
+		if !ok {
+			addr = peer
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid peer %q: %w", peer, err)
+			}
+			id = host
+		}
+		if id == "" || addr == "" {
+			return nil, fmt.Errorf("invalid peer %q", peer)
+		}
+		if seen[raft.ServerID(id)] || raft.ServerAddress(addr) == self.Address {
+			continue
+		}
+		seen[raft.ServerID(id)] = true
+		servers = append(servers, raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(addr)})
+	}
+
+	sort.Slice(servers, func(i, j int) bool { return servers[i].ID < servers[j].ID })
+	return servers, nil
+}
+
+// BootstrapServers bootstraps the cluster with a full initial
+// configuration. Every listed server may call it with the same list.
+func (n *Node) BootstrapServers(servers []raft.Server) error {
+	logger.Info("Bootstrapping cluster", "servers", len(servers))
+	return n.Raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+}
+
+// Bootstrapped reports whether this node has a cluster configuration,
+// either from bootstrapping or from joining an existing cluster.
+func (n *Node) Bootstrapped() bool {
+	configuration, _, err := n.Configuration()
+	return err == nil && len(configuration.Servers) > 0
+}
+
+// AuditLog returns the log membership changes are recorded in. It may be
+// nil, which discards records.
+func (n *Node) AuditLog() *audit.Log {
+	return n.auditLog
+}
+
+// ID returns this node's server ID.
+func (n *Node) ID() string {
+	return n.config.NodeID
+}
+
+// Addr returns the Raft address this node advertises.
+func (n *Node) Addr() string {
+	return string(n.Transport.LocalAddr())
 }
 
 // AddVoter adds a new voting member to the cluster.
 func (n *Node) AddVoter(id, address string) error {
+	return n.AddVoterAt(id, address, 0)
+}
+
+// AddVoterAt adds a voting member if the membership epoch is still epoch
+// (any epoch if zero).
+func (n *Node) AddVoterAt(id, address string, epoch uint64) error {
 	future := n.Raft.AddVoter(
 		raft.ServerID(id),
 		raft.ServerAddress(address),
+		epoch,
 		0,
+	)
+	return epochError(future.Error(), epoch)
+}
+
+// AddNonvoter adds a new member to the cluster that receives the log but
+// does not vote or count towards quorum.
+func (n *Node) AddNonvoter(id, address string) error {
+	return n.AddNonvoterAt(id, address, 0)
+}
+
+// AddNonvoterAt adds a non-voting member if the membership epoch is still
+// epoch (any epoch if zero).
+func (n *Node) AddNonvoterAt(id, address string, epoch uint64) error {
+	future := n.Raft.AddNonvoter(
+		raft.ServerID(id),
+		raft.ServerAddress(address),
+		epoch,
 		0,
 	)
+	return epochError(future.Error(), epoch)
+}
+
+// Join adds a member to the cluster, as a voter or a non-voter, and
+// replicates its endpoint metadata. It must be called on the leader.
+//
+// Joining is idempotent: a node that is already a member at the same
+// address causes no configuration change, a member re-joining from a new
+// address has its address updated, and any other member still registered
+// at that address (a previous incarnation under a different ID) is
+// removed. A voter that re-joins as a non-voter stays a voter.
+func (n *Node) Join(address string, voter bool, meta *fsm.PeerMeta) error {
+	return n.JoinAt(address, voter, meta, 0)
+}
+
+// JoinAt is Join if the membership epoch is still epoch (any epoch if
+// zero). Only the first configuration change it makes is checked.
+func (n *Node) JoinAt(address string, voter bool, meta *fsm.PeerMeta, epoch uint64) error {
+	configuration, index, err := n.Configuration()
+	if err != nil {
+		return err
+	}
+	if epoch != 0 && epoch != index {
+		return fmt.Errorf("%w: expected %d, current %d", ErrEpochMismatch, epoch, index)
+	}
+
+	unchanged := false
+	for _, server := range configuration.Servers {
+		id, addr := string(server.ID), string(server.Address)
+		switch {
+		case id == meta.NodeID && addr == address:
+			unchanged = server.Suffrage == raft.Voter || !voter
+		case id == meta.NodeID:
+			logger.Info("Member re-joining from a new address, updating it", "member", id, "address", address, "previous_address", addr)
+		case addr == address:
+			if id == n.config.NodeID {
+				return fmt.Errorf("address %s belongs to this node", address)
+			}
+			logger.Info("Removing stale member", "member", id, "address", addr)
+			if err := n.RemoveServerAt(id, epoch); err != nil {
+				return fmt.Errorf("failed to remove stale member %s: %w", id, err)
+			}
+			epoch = 0
+		}
+	}
+
+	if unchanged {
+		logger.Info("Already a member", "member", meta.NodeID, "address", address)
+	} else if voter {
+		err = n.AddVoterAt(meta.NodeID, address, epoch)
+	} else {
+		err = n.AddNonvoterAt(meta.NodeID, address, epoch)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *meta != (fsm.PeerMeta{NodeID: meta.NodeID}) {
+		if err := n.PublishPeerMeta(meta); err != nil {
+			logger.Error("Failed to publish metadata", "member", meta.NodeID, "error", err)
+		}
+	}
+	return nil
+}
+
+// JoinNewAt is JoinAt for a node that may not take over an existing
+// member's ID or address, such as one presenting a join token that was not
+// minted for its ID. It fails with ErrMemberExists if a member has either,
+// unless the node is already that member, in which case nothing changes,
+// not even its metadata.
+func (n *Node) JoinNewAt(address string, voter bool, meta *fsm.PeerMeta, epoch uint64) error {
+	configuration, index, err := n.Configuration()
+	if err != nil {
+		return err
+	}
+	if epoch != 0 && epoch != index {
+		return fmt.Errorf("%w: expected %d, current %d", ErrEpochMismatch, epoch, index)
+	}
+	for _, server := range configuration.Servers {
+		id, addr := string(server.ID), string(server.Address)
+		if id == meta.NodeID && addr == address && (server.Suffrage == raft.Voter || !voter) {
+			logger.Info("Already a member", "member", id, "address", address)
+			return nil
+		}
+		if id == meta.NodeID || addr == address {
+			return fmt.Errorf("%w: %s at %s", ErrMemberExists, id, addr)
+		}
+	}
+	// The configuration checked must still be current when the node is
+	// added
+	return n.JoinAt(address, voter, meta, index)
+}
+
+// RemoveServer removes a member from the cluster configuration.
+func (n *Node) RemoveServer(id string) error {
+	return n.RemoveServerAt(id, 0)
+}
+
+// RemoveServerAt removes a member if the membership epoch is still epoch
+// (any epoch if zero).
+func (n *Node) RemoveServerAt(id string, epoch uint64) error {
+	future := n.Raft.RemoveServer(raft.ServerID(id), epoch, 0)
+	return epochError(future.Error(), epoch)
+}
+
+// HasServer reports whether id is a member of the current configuration.
+func (n *Node) HasServer(id string) (bool, error) {
+	future := n.Raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return false, err
+	}
+	for _, server := range future.Configuration().Servers {
+		if string(server.ID) == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PublishPeerMeta replicates a member's endpoint metadata through the Raft
+// log. It must be called on the leader.
+func (n *Node) PublishPeerMeta(meta *fsm.PeerMeta) error {
+	data, err := fsm.EncodePeerMeta(meta)
+	if err != nil {
+		return err
+	}
+	future := n.Raft.ApplyLog(raft.Log{Data: data, Extensions: fsm.MetaExtension}, 5*time.Second)
 	return future.Error()
 }
 
-// Apply proposes a command to the Raft cluster.
-func (n *Node) Apply(data []byte, timeout time.Duration) error {
-	future := n.Raft.Apply(data, timeout)
+// PublishSigningKeys replicates the key set commands are checked against
+// through the Raft log. It must be called on the leader.
+func (n *Node) PublishSigningKeys(set *signing.KeySet) error {
+	data, err := fsm.EncodeSigningKeys(set)
+	if err != nil {
+		return err
+	}
+	future := n.Raft.ApplyLog(raft.Log{Data: data, Extensions: fsm.SigningKeysExtension}, 5*time.Second)
 	return future.Error()
 }
 
+// TransferLeadership hands leadership to the most up-to-date voter.
+func (n *Node) TransferLeadership() error {
+	return n.Raft.LeadershipTransfer().Error()
+}
+
+// TransferLeadershipTo hands leadership to the given voter.
+func (n *Node) TransferLeadershipTo(id, address string) error {
+	return n.Raft.LeadershipTransferToServer(raft.ServerID(id), raft.ServerAddress(address)).Error()
+}
+
+// Voters returns the voting members of the current configuration.
+func (n *Node) Voters() ([]raft.Server, error) {
+	return n.servers(raft.Voter)
+}
+
+// Nonvoters returns the non-voting members of the current configuration.
+func (n *Node) Nonvoters() ([]raft.Server, error) {
+	return n.servers(raft.Nonvoter)
+}
+
+// Configuration returns the latest cluster configuration and the log index
+// it was written at.
+func (n *Node) Configuration() (raft.Configuration, uint64, error) {
+	future := n.Raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return raft.Configuration{}, 0, err
+	}
+	return future.Configuration(), future.Index(), nil
+}
+
+// PeerProgress returns the replication progress of each follower, keyed by
+// server ID. It is empty unless this node is the leader.
+func (n *Node) PeerProgress() map[string]PeerProgress {
+	if !n.IsLeader() {
+		return map[string]PeerProgress{}
+	}
+	return n.replication.progress(n.Raft.CurrentTerm())
+}
+
+// servers returns the members of the current configuration with the given
+// suffrage.
+func (n *Node) servers(suffrage raft.ServerSuffrage) ([]raft.Server, error) {
+	future := n.Raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+
+	var servers []raft.Server
+	for _, server := range future.Configuration().Servers {
+		if server.Suffrage == suffrage {
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
+
+// Fence identifies a point in the replicated log. Fences from later leaders
+// always compare greater: compare Term first, then Token.
+type Fence struct {
+	Term  uint64
+	Token uint64
+}
+
+// Apply proposes a command, with the log entry extensions given, to the
+// Raft cluster and returns the fence of the committed entry. Proposals are
+// rejected with ErrDraining while the node is draining. Proposals that take
+// longer than -slow-propose-threshold to be committed and applied are
+// logged and counted.
+func (n *Node) Apply(data, extensions []byte, timeout time.Duration) (Fence, error) {
+	if draining, reason := n.Draining(); draining {
+		return Fence{}, fmt.Errorf("%w: %s", ErrDraining, reason)
+	}
+
+	n.inFlight.Add(1)
+	defer n.inFlight.Add(-1)
+
+	start := time.Now()
+	future := n.Raft.ApplyLog(raft.Log{Data: data, Extensions: extensions}, timeout)
+	err := future.Error()
+	if threshold := n.config.SlowProposeThreshold; threshold > 0 {
+		if duration := time.Since(start); duration > threshold {
+			n.slowProposals.Add(1)
+			requestID, rest := requestid.Split(extensions)
+			trace, _ := tracing.Split(rest)
+			logger.Warn("Slow proposal", "index", future.Index(), "request_id", requestID, "trace_id", trace.TraceID, "size", len(data),
+				"duration", duration.String(), "threshold", threshold.String())
+		}
+	}
+	if err != nil {
+		return Fence{}, err
+	}
+
+	var entry raft.Log
+	if err := n.logStore.GetLog(future.Index(), &entry); err != nil {
+		return Fence{}, fmt.Errorf("failed to read committed entry %d: %w", future.Index(), err)
+	}
+	return Fence{Term: entry.Term, Token: entry.Index}, nil
+}
+
+// CurrentFence returns a fence greater than or equal to that of every entry
+// this leader has written. Leadership is confirmed with a quorum first, so
+// a deposed leader cannot hand out a fence for its old term.
+func (n *Node) CurrentFence() (Fence, error) {
+	term := n.Raft.CurrentTerm()
+	index := n.Raft.LastIndex()
+	if err := n.VerifyLeader(); err != nil {
+		return Fence{}, err
+	}
+	return Fence{Term: term, Token: index}, nil
+}
+
+// StartDrain stops the node from accepting new proposals.
+func (n *Node) StartDrain(reason string) {
+	n.drainMu.Lock()
+	defer n.drainMu.Unlock()
+	n.draining = true
+	n.drainReason = reason
+}
+
+// StopDrain resumes accepting proposals.
+func (n *Node) StopDrain() {
+	n.drainMu.Lock()
+	defer n.drainMu.Unlock()
+	n.draining = false
+	n.drainReason = ""
+}
+
+// Draining reports whether the node is draining and why.
+func (n *Node) Draining() (bool, string) {
+	n.drainMu.RLock()
+	defer n.drainMu.RUnlock()
+	return n.draining, n.drainReason
+}
+
+// Standby reports whether this node is a standby that has not yet been
+// promoted. Once it finds itself a voter in the configuration it leaves
+// standby for good.
+func (n *Node) Standby() bool {
+	if !n.standby.Load() {
+		return false
+	}
+	voters, err := n.Voters()
+	if err != nil {
+		return true
+	}
+	for _, server := range voters {
+		if string(server.ID) == n.ID() {
+			if n.standby.CompareAndSwap(true, false) {
+				logger.Info("Standby promoted to voter, serving client traffic")
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// InFlight returns the number of proposals awaiting commit on this node.
+func (n *Node) InFlight() int64 {
+	return n.inFlight.Load()
+}
+
+// Barrier blocks until all preceding log entries have been applied to the FSM.
+func (n *Node) Barrier(timeout time.Duration) error {
+	return n.Raft.Barrier(timeout).Error()
+}
+
+// ReadLogs calls fn for every command entry in [from, to] in index order.
+// Configuration changes, no-ops and sidecar metadata are skipped since they
+// are never applied to the backend. ErrLogCompacted is returned if
+// from precedes the oldest entry still held in the log store.
+func (n *Node) ReadLogs(from, to uint64, fn func(*raft.Log) error) error {
+	first, err := n.logStore.FirstIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read first log index: %w", err)
+	}
+	if from < first {
+		return fmt.Errorf("%w: requested %d, oldest available %d", ErrLogCompacted, from, first)
+	}
+
+	for i := from; i <= to; i++ {
+		var entry raft.Log
+		if err := n.logStore.GetLog(i, &entry); err != nil {
+			return fmt.Errorf("failed to read log %d: %w", i, err)
+		}
+		if entry.Type != raft.LogCommand || fsm.IsMeta(&entry) {
+			continue
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appliedIndexer is implemented by state machines that report the index of
+// the last entry they applied. Unlike raft.Raft.AppliedIndex, which moves
+// when entries are handed to the state machine, it moves once they have
+// been applied.
+type appliedIndexer interface {
+	AppliedIndex() uint64
+}
+
+// LastCommandIndex returns the index of the last command entry at or below
+// index, which is the last one the FSM must apply for its state to reflect
+// the log up to index, or floor if there is none above floor.
+// Configuration changes and no-ops are never handed to the FSM, so waiting
+// for their own index to be applied would never end. Compacted entries
+// were applied before the snapshot that replaced them.
+func (n *Node) LastCommandIndex(index, floor uint64) (uint64, error) {
+	first, err := n.logStore.FirstIndex()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read first log index: %w", err)
+	}
+	for i := index; i > floor && i >= first; i-- {
+		var entry raft.Log
+		if err := n.logStore.GetLog(i, &entry); err != nil {
+			return 0, fmt.Errorf("failed to read log %d: %w", i, err)
+		}
+		if entry.Type == raft.LogCommand {
+			return i, nil
+		}
+	}
+	return floor, nil
+}
+
+// Shutdown stops Raft and closes the log store.
+func (n *Node) Shutdown() error {
+	if err := n.Raft.Shutdown().Error(); err != nil {
+		return fmt.Errorf("failed to shut down raft: %w", err)
+	}
+	if closer, ok := n.logStore.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return fmt.Errorf("failed to close log store: %w", err)
+		}
+	}
+	return nil
+}
+
 // IsLeader returns true if this node is currently the leader.
 func (n *Node) IsLeader() bool {
 	return n.Raft.State() == raft.Leader
 }
 
+// SubscribeLeadership returns a channel that receives true when this node
+// becomes leader and false when it loses leadership. Only the latest
+// transition is kept if the subscriber falls behind.
+func (n *Node) SubscribeLeadership() <-chan bool {
+	ch := make(chan bool, 1)
+	n.leaderMu.Lock()
+	n.leaderSubs = append(n.leaderSubs, ch)
+	n.leaderMu.Unlock()
+	return ch
+}
+
+// watchLeadership fans leadership transitions out to subscribers.
+func (n *Node) watchLeadership() {
+	for isLeader := range n.Raft.LeaderCh() {
+		n.leaderMu.Lock()
+		for _, ch := range n.leaderSubs {
+			select {
+			case ch <- isLeader:
+			default:
+				// Replace the stale, unconsumed transition with this one.
+				select {
+				case <-ch:
+				default:
+				}
+				ch <- isLeader
+			}
+		}
+		n.leaderMu.Unlock()
+	}
+}
+
+// VerifyLeader confirms with a quorum of the cluster that this node is
+// still the leader. Unlike IsLeader, the answer cannot be stale during a
+// network partition.
+func (n *Node) VerifyLeader() error {
+	return n.Raft.VerifyLeader().Error()
+}
+
+// ReadIndex returns an index at which a linearizable read may be served
+// once the FSM has applied it. Leadership is confirmed with a quorum. A new
+// leader only learns the true commit index after committing an entry from
+// its own term, so the first read of each term issues a barrier.
+func (n *Node) ReadIndex(timeout time.Duration) (uint64, error) {
+	if !n.IsLeader() {
+		return 0, raft.ErrNotLeader
+	}
+
+	term := n.Raft.CurrentTerm()
+	if n.readTerm.Load() != term {
+		if err := n.Barrier(timeout); err != nil {
+			return 0, err
+		}
+		n.readTerm.Store(term)
+	}
+
+	index := n.Raft.CommitIndex()
+	if err := n.VerifyLeader(); err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+// LeaderID returns the server ID of the current leader.
+func (n *Node) LeaderID() string {
+	_, id := n.Raft.LeaderWithID()
+	return string(id)
+}
+
 // LeaderAddr returns the address of the current leader.
 func (n *Node) LeaderAddr() string {
 	addr, _ := n.Raft.LeaderWithID()
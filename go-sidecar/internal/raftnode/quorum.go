@@ -0,0 +1,87 @@
+package raftnode
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ErrQuorumLoss is returned for membership changes that would leave the
+// cluster without a reachable quorum of voters.
+var ErrQuorumLoss = errors.New("change would leave the cluster without a quorum")
+
+// healthyContact is how recently a follower must have answered the leader
+// to count as reachable when checking a membership change.
+const healthyContact = 10 * time.Second
+
+// CheckRemoval reports whether removing id leaves enough reachable voters
+// to form a quorum of the remaining configuration. Removing a non-voter is
+// always safe. Reachability is only known on the leader, which counts
+// itself and every follower that answered it within the last 10 seconds.
+func (n *Node) CheckRemoval(id string) error {
+	configuration, _, err := n.Configuration()
+	if err != nil {
+		return err
+	}
+	progress := n.PeerProgress()
+
+	remaining, healthy := 0, 0
+	for _, server := range configuration.Servers {
+		if server.Suffrage != raft.Voter {
+			if string(server.ID) == id {
+				return nil
+			}
+			continue
+		}
+		if string(server.ID) == id {
+			continue
+		}
+		remaining++
+		if string(server.ID) == n.ID() {
+			healthy++
+		} else if p, ok := progress[string(server.ID)]; ok && time.Since(p.LastContact) < healthyContact {
+			healthy++
+		}
+	}
+
+	if remaining == 0 {
+		return fmt.Errorf("%w: %s is the last voter", ErrQuorumLoss, id)
+	}
+	if quorum := remaining/2 + 1; healthy < quorum {
+		return fmt.Errorf("%w: after removing %s, %d voters remain and a quorum needs %d, but only %d are reachable",
+			ErrQuorumLoss, id, remaining, quorum, healthy)
+	}
+	return nil
+}
+
+// ReachableVoters returns the number of voters in the configuration and
+// how many of them are reachable from the leader: itself and every follower
+// that answered it within -leader-lease-timeout. That is the window in
+// which the leader must hear from a quorum or step down, so fewer
+// reachable voters than a quorum means it is about to. Only the leader
+// knows; on other nodes reachable is zero.
+func (n *Node) ReachableVoters() (reachable, voters int, err error) {
+	configuration, _, err := n.Configuration()
+	if err != nil {
+		return 0, 0, err
+	}
+	isLeader := n.IsLeader()
+	progress := n.PeerProgress()
+	for _, server := range configuration.Servers {
+		if server.Suffrage != raft.Voter {
+			continue
+		}
+		voters++
+		if !isLeader {
+			continue
+		}
+		if string(server.ID) == n.ID() {
+			reachable++
+		} else if p, ok := progress[string(server.ID)]; ok && time.Since(p.LastContact) < n.config.LeaderLeaseTimeout {
+			reachable++
+		}
+	}
+	return reachable, voters, nil
+}
@@ -0,0 +1,153 @@
+package raftnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/logging"
+)
+
+// peersFile is the name of the recovery file looked for in the data
+// directory at startup.
+const peersFile = "peers.json"
+
+// recoverFromPeersFile applies <data dir>/peers.json, if present, with
+// raft.RecoverCluster, replacing the configuration stored in the log.
+// This is hashicorp/raft's manual recovery path for a cluster that has
+// permanently lost quorum: stop every surviving server, write the same
+// peers.json (in the raft.ReadConfigJSON format) to each of them and start
+// them again. The file is removed once it has been applied.
+func recoverFromPeersFile(dataDir string, raftConfig *raft.Config, stateMachine raft.FSM, store *encryptedStore, snapshots raft.SnapshotStore, trans raft.Transport) error {
+	path := filepath.Join(dataDir, peersFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check for %s: %w", path, err)
+	}
+
+	logger.Warn("Recovering the cluster configuration from file", "path", path)
+	configuration, err := raft.ReadConfigJSON(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := recoverCluster(raftConfig, stateMachine, store, snapshots, trans, configuration); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("recovered cluster but failed to delete %s: %w", path, err)
+	}
+	logger.Warn("Cluster configuration recovered, file deleted", "path", path)
+	return nil
+}
+
+// Recover replaces the configuration stored in dataDir with configuration
+// while the node is stopped, turning the surviving servers of a cluster
+// that lost quorum for good into a working cluster. The log is replayed
+// into stateMachine and compacted. keys decrypt an encrypted log store.
+func Recover(dataDir, nodeID string, stateMachine raft.FSM, configuration raft.Configuration, keys [][]byte) error {
+	raftConfig := raft.DefaultConfig()
+	raftConfig.LocalID = raft.ServerID(nodeID)
+
+	store, err := openLogStore(dataDir, keys, true)
+	if err != nil {
+		return fmt.Errorf("failed to open log store: %w", err)
+	}
+	defer store.Close()
+
+	snapshots, err := raft.NewFileSnapshotStoreWithLogger(dataDir, 2, logging.HCLog("raft").Named("snapshot"))
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot store: %w", err)
+	}
+
+	// The transport is only used to encode server addresses.
+	_, trans := raft.NewInmemTransport("")
+	return recoverCluster(raftConfig, stateMachine, store, snapshots, trans, configuration)
+}
+
+// recoverCluster logs configuration and applies it with
+// raft.RecoverCluster.
+func recoverCluster(raftConfig *raft.Config, stateMachine raft.FSM, store *encryptedStore, snapshots raft.SnapshotStore, trans raft.Transport, configuration raft.Configuration) error {
+	self := false
+	for _, server := range configuration.Servers {
+		logger.Warn("Recovered configuration", "member", server.ID, "address", server.Address, "suffrage", server.Suffrage.String())
+		if server.ID == raftConfig.LocalID {
+			self = true
+		}
+	}
+	if !self {
+		logger.Warn("The recovered configuration does not include this node; it will not take part in the recovered cluster")
+	}
+
+	if err := raft.RecoverCluster(raftConfig, stateMachine, store, store, snapshots, trans, configuration); err != nil {
+		return fmt.Errorf("failed to recover cluster: %w", err)
+	}
+	return nil
+}
+
+// peersFileEntry is one server in peers.json, in the format read by
+// raft.ReadConfigJSON.
+type peersFileEntry struct {
+	ID       raft.ServerID      `json:"id"`
+	Address  raft.ServerAddress `json:"address"`
+	NonVoter bool               `json:"non_voter"`
+}
+
+// ForceRemoveServer schedules the removal of id from this node's
+// configuration without going through the log, for a cluster whose
+// configuration changes can no longer commit. It writes the latest
+// configuration known to this node, minus id, to peers.json, where it is
+// applied by raft.RecoverCluster the next time the sidecar starts. The
+// written configuration is returned.
+//
+// Like any manual recovery this bypasses Raft's safety: it must be done on
+// every surviving server, and only for a server that is never coming back.
+func (n *Node) ForceRemoveServer(id string) (raft.Configuration, error) {
+	current, _, err := n.Configuration()
+	if err != nil {
+		return raft.Configuration{}, err
+	}
+
+	var configuration raft.Configuration
+	found := false
+	for _, server := range current.Servers {
+		if string(server.ID) == id {
+			found = true
+			continue
+		}
+		configuration.Servers = append(configuration.Servers, server)
+	}
+	if !found {
+		return raft.Configuration{}, fmt.Errorf("server %s is not in the configuration", id)
+	}
+
+	entries := make([]peersFileEntry, 0, len(configuration.Servers))
+	for _, server := range configuration.Servers {
+		entries = append(entries, peersFileEntry{
+			ID:       server.ID,
+			Address:  server.Address,
+			NonVoter: server.Suffrage == raft.Nonvoter,
+		})
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return raft.Configuration{}, fmt.Errorf("failed to encode %s: %w", peersFile, err)
+	}
+
+	path := filepath.Join(n.config.DataDir, peersFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return raft.Configuration{}, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return raft.Configuration{}, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	logger.Warn("Force-removing server; the configuration written is applied when the sidecar restarts",
+		"member", id, "path", path, "servers", len(configuration.Servers))
+	return configuration, nil
+}
@@ -0,0 +1,45 @@
+package raftnode
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/config"
+	"my-raft-sidecar/internal/logging"
+)
+
+// applyTunables copies the reloadable Raft settings of cfg into raftConfig.
+func applyTunables(raftConfig *raft.Config, cfg *config.Config) {
+	raftConfig.SnapshotThreshold = cfg.SnapshotThreshold
+	raftConfig.SnapshotInterval = cfg.SnapshotInterval
+	raftConfig.TrailingLogs = cfg.TrailingLogs
+	raftConfig.HeartbeatTimeout = cfg.HeartbeatTimeout
+	raftConfig.ElectionTimeout = cfg.ElectionTimeout
+}
+
+// applyLogLevels sets the level of the log output and, if cfg sets one
+// apart, of the Raft library's.
+func applyLogLevels(cfg *config.Config) error {
+	if err := logging.SetLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+	return logging.SetRaftLevel(cfg.RaftLogLevel)
+}
+
+// Reload applies the snapshot settings, timeouts and log levels of cfg to
+// the running Raft instance without restarting it, so the node keeps its
+// leadership and connections. Invalid settings are rejected as a whole.
+func (n *Node) Reload(cfg *config.Config) error {
+	err := n.Raft.ReloadConfig(raft.ReloadableConfig{
+		TrailingLogs:      cfg.TrailingLogs,
+		SnapshotThreshold: cfg.SnapshotThreshold,
+		SnapshotInterval:  cfg.SnapshotInterval,
+		HeartbeatTimeout:  cfg.HeartbeatTimeout,
+		ElectionTimeout:   cfg.ElectionTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reload raft configuration: %w", err)
+	}
+	return applyLogLevels(cfg)
+}
@@ -0,0 +1,240 @@
+package raftnode
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"my-raft-sidecar/internal/metrics"
+)
+
+// PeerProgress is the leader's view of replication to one follower.
+type PeerProgress struct {
+	// MatchIndex is the highest log index known to be replicated to the
+	// follower in the current term.
+	MatchIndex uint64
+	// NextIndex is the index of the next entry the leader will send, as
+	// implied by the follower's last answer.
+	NextIndex uint64
+	// LastContact is when the follower last answered an AppendEntries RPC.
+	LastContact time.Time
+	// Failures counts AppendEntries RPCs that failed in transport, and
+	// Rejections those the follower refused, in the current term.
+	Failures   uint64
+	Rejections uint64
+	// HeartbeatRTT is the round-trip time of the last heartbeat.
+	HeartbeatRTT time.Duration
+	// Pipelined is set while entries are streamed to the follower over a
+	// pipeline, rather than sent one RPC at a time as the leader does
+	// while probing a follower or after a pipeline failed.
+	Pipelined bool
+}
+
+// trackingTransport wraps the network transport to record the outcome of
+// every AppendEntries RPC this node sends as leader. hashicorp/raft keeps
+// follower progress private, so this is how the node learns each peer's
+// match index, last contact time, failures and append latency.
+type trackingTransport struct {
+	*raft.NetworkTransport
+
+	mu    sync.Mutex
+	term  uint64
+	peers map[raft.ServerID]*PeerProgress
+	// latency holds the duration of the AppendEntries RPCs carrying
+	// entries to each follower, across terms. pipelines counts each
+	// follower's open pipelines.
+	latency   map[raft.ServerID]*metrics.Histogram
+	pipelines map[raft.ServerID]int
+}
+
+func newTrackingTransport(trans *raft.NetworkTransport) *trackingTransport {
+	return &trackingTransport{
+		NetworkTransport: trans,
+		peers:            make(map[raft.ServerID]*PeerProgress),
+		latency:          make(map[raft.ServerID]*metrics.Histogram),
+		pipelines:        make(map[raft.ServerID]int),
+	}
+}
+
+// AppendEntries sends an AppendEntries RPC and records the result.
+func (t *trackingTransport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) error {
+	start := time.Now()
+	if err := t.NetworkTransport.AppendEntries(id, target, args, resp); err != nil {
+		t.fail(id, args)
+		return err
+	}
+	t.record(id, args, resp, time.Since(start))
+	return nil
+}
+
+// AppendEntriesPipeline returns a pipeline that records the result of every
+// request once its response is consumed.
+func (t *trackingTransport) AppendEntriesPipeline(id raft.ServerID, target raft.ServerAddress) (raft.AppendPipeline, error) {
+	pipeline, err := t.NetworkTransport.AppendEntriesPipeline(id, target)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.pipelines[id]++
+	t.mu.Unlock()
+	return newTrackingPipeline(t, id, pipeline), nil
+}
+
+// pipelineClosed records that a pipeline to a follower was closed.
+func (t *trackingTransport) pipelineClosed(id raft.ServerID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pipelines[id]--; t.pipelines[id] <= 0 {
+		delete(t.pipelines, id)
+	}
+}
+
+// peer returns the progress of a follower in the given term, resetting all
+// progress when a new term starts. t.mu must be held.
+func (t *trackingTransport) peer(id raft.ServerID, term uint64) *PeerProgress {
+	if term != t.term {
+		t.term = term
+		t.peers = make(map[raft.ServerID]*PeerProgress)
+	}
+	progress, ok := t.peers[id]
+	if !ok {
+		progress = &PeerProgress{}
+		t.peers[id] = progress
+	}
+	return progress
+}
+
+// record updates the progress of a follower from a completed RPC that took
+// rtt. Heartbeats carry no log position and only refresh the contact time
+// and round-trip time.
+func (t *trackingTransport) record(id raft.ServerID, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse, rtt time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	progress := t.peer(id, args.Term)
+	progress.LastContact = time.Now()
+
+	heartbeat := args.PrevLogEntry == 0 && len(args.Entries) == 0
+	if len(args.Entries) > 0 {
+		t.appendLatency(id).ObserveDuration(rtt)
+	}
+	switch {
+	case heartbeat:
+		progress.HeartbeatRTT = rtt
+	case resp.Success:
+		if match := args.PrevLogEntry + uint64(len(args.Entries)); match > progress.MatchIndex {
+			progress.MatchIndex = match
+		}
+		progress.NextIndex = progress.MatchIndex + 1
+	default:
+		// The leader backs off to just past the follower's last entry.
+		progress.Rejections++
+		progress.NextIndex = max(min(args.PrevLogEntry, resp.LastLog+1), 1)
+	}
+}
+
+// appendLatency returns the append latency histogram of a follower. t.mu
+// must be held.
+func (t *trackingTransport) appendLatency(id raft.ServerID) *metrics.Histogram {
+	h, ok := t.latency[id]
+	if !ok {
+		h = metrics.NewHistogram(metrics.DurationBuckets)
+		t.latency[id] = h
+	}
+	return h
+}
+
+// latencies returns the append latency histogram of every follower that
+// has been sent entries.
+func (t *trackingTransport) latencies() map[string]*metrics.Histogram {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := make(map[string]*metrics.Histogram, len(t.latency))
+	for id, h := range t.latency {
+		result[string(id)] = h
+	}
+	return result
+}
+
+// fail counts an RPC that failed in transport.
+func (t *trackingTransport) fail(id raft.ServerID, args *raft.AppendEntriesRequest) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peer(id, args.Term).Failures++
+}
+
+// progress returns a copy of the recorded progress for the given term.
+func (t *trackingTransport) progress(term uint64) map[string]PeerProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make(map[string]PeerProgress, len(t.peers))
+	if t.term != term {
+		return result
+	}
+	for id, progress := range t.peers {
+		p := *progress
+		p.Pipelined = t.pipelines[id] > 0
+		result[string(id)] = p
+	}
+	return result
+}
+
+// trackingPipeline relays the responses of a pipeline through the
+// transport's recorder.
+type trackingPipeline struct {
+	raft.AppendPipeline
+	trans      *trackingTransport
+	id         raft.ServerID
+	consumerCh chan raft.AppendFuture
+	shutdownCh chan struct{}
+	closeOnce  sync.Once
+}
+
+func newTrackingPipeline(trans *trackingTransport, id raft.ServerID, pipeline raft.AppendPipeline) *trackingPipeline {
+	p := &trackingPipeline{
+		AppendPipeline: pipeline,
+		trans:          trans,
+		id:             id,
+		consumerCh:     make(chan raft.AppendFuture),
+		shutdownCh:     make(chan struct{}),
+	}
+	go p.relay()
+	return p
+}
+
+// Consumer returns the channel of completed requests.
+func (p *trackingPipeline) Consumer() <-chan raft.AppendFuture {
+	return p.consumerCh
+}
+
+// Close closes the underlying pipeline and stops relaying.
+func (p *trackingPipeline) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.shutdownCh)
+		p.trans.pipelineClosed(p.id)
+	})
+	return p.AppendPipeline.Close()
+}
+
+func (p *trackingPipeline) relay() {
+	source := p.AppendPipeline.Consumer()
+	for {
+		select {
+		case future := <-source:
+			if future.Error() == nil {
+				p.trans.record(p.id, future.Request(), future.Response(), time.Since(future.Start()))
+			} else {
+				p.trans.fail(p.id, future.Request())
+			}
+			select {
+			case p.consumerCh <- future:
+			case <-p.shutdownCh:
+				return
+			}
+		case <-p.shutdownCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package raftnode
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// clusterMagic starts the preamble every Raft connection opens with.
+var clusterMagic = []byte("RKV1")
+
+// clusterStreamLayer is a TCP stream layer whose connections open with a
+// preamble carrying the dialer's cluster ID. Incoming connections from a
+// node of another cluster are closed, so that a node holding a stale
+// configuration cannot replicate into, or vote in, a cluster that reused
+// its peers' addresses. Nodes that have no cluster ID yet are accepted.
+//
+// Connections without a preamble are accepted too, and the preamble is only
+// sent if sendPreamble is set, so that a cluster can be upgraded from
+// versions that do not know it one node at a time.
+//
+// With a TLS configuration every connection is wrapped in TLS before the
+// preamble is sent, so that log replication is encrypted in transit.
+type clusterStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	identity  *clusterIdentity
+	serverTLS *tls.Config
+	clientTLS func(host string) *tls.Config
+
+	sendPreamble bool
+}
+
+// newClusterStreamLayer listens on bindAddr. The TLS configuration used to
+// accept connections, and the function returning the one used to dial a
+// host, may be nil.
+func newClusterStreamLayer(bindAddr string, advertise net.Addr, identity *clusterIdentity, serverTLS *tls.Config, clientTLS func(host string) *tls.Config, sendPreamble bool) (*clusterStreamLayer, error) {
+	listener, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterStreamLayer{
+		Listener:     listener,
+		advertise:    advertise,
+		identity:     identity,
+		serverTLS:    serverTLS,
+		clientTLS:    clientTLS,
+		sendPreamble: sendPreamble,
+	}, nil
+}
+
+// Dial opens a connection, completes the TLS handshake if TLS is enabled,
+// and sends the preamble if enabled.
+func (s *clusterStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", string(address), timeout)
+	if err != nil {
+		return nil, err
+	}
+	if s.clientTLS != nil {
+		if conn, err = s.handshake(conn, string(address), timeout); err != nil {
+			return nil, err
+		}
+	}
+	if !s.sendPreamble {
+		return conn, nil
+	}
+
+	id := s.identity.get()
+	preamble := append(append([]byte{}, clusterMagic...), byte(len(id)))
+	preamble = append(preamble, id...)
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(preamble); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Time{})
+	return conn, nil
+}
+
+// handshake wraps conn in a TLS client connection verified against the
+// host part of address. An address without one fails the host name check.
+func (s *clusterStreamLayer) handshake(conn net.Conn, address string, timeout time.Duration) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(address)
+	tlsConn := tls.Client(conn, s.clientTLS(host))
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", address, err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// Accept returns the next connection. The TLS handshake, if any, and the
+// preamble are carried out on the first read, so that a slow peer cannot
+// hold up the accept loop.
+func (s *clusterStreamLayer) Accept() (net.Conn, error) {
+	conn, err := s.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if s.serverTLS != nil {
+		conn = tls.Server(conn, s.serverTLS)
+	}
+	return &clusterConn{Conn: conn, identity: s.identity}, nil
+}
+
+// Addr returns the advertised address.
+func (s *clusterStreamLayer) Addr() net.Addr {
+	if s.advertise != nil {
+		return s.advertise
+	}
+	return s.Listener.Addr()
+}
+
+// clusterConn is an accepted connection whose preamble has not been
+// checked yet.
+type clusterConn struct {
+	net.Conn
+	identity *clusterIdentity
+
+	once sync.Once
+	err  error
+	// pending is the first byte of a connection without a preamble, read
+	// while looking for one and returned by the next Read.
+	pending []byte
+}
+
+func (c *clusterConn) Read(b []byte) (int, error) {
+	c.once.Do(func() {
+		if c.err = c.checkPreamble(); c.err != nil {
+			logger.Warn("Rejected Raft connection", "address", c.RemoteAddr().String(), "error", c.err)
+			c.Conn.Close()
+		}
+	})
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(c.pending) > 0 && len(b) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// checkPreamble reads the preamble and compares the peer's cluster ID with
+// this node's. A connection that does not start with one, whose first byte
+// is a Raft RPC type instead, is let through without a check.
+func (c *clusterConn) checkPreamble() error {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(c.Conn, first); err != nil {
+		return fmt.Errorf("failed to read preamble: %w", err)
+	}
+	if first[0] != clusterMagic[0] {
+		c.pending = first
+		return nil
+	}
+	// The rest of the magic and the length of the cluster ID
+	header := make([]byte, len(clusterMagic))
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return fmt.Errorf("failed to read preamble: %w", err)
+	}
+	if string(header[:len(header)-1]) != string(clusterMagic[1:]) {
+		return errors.New("invalid preamble")
+	}
+	id := make([]byte, header[len(header)-1])
+	if _, err := io.ReadFull(c.Conn, id); err != nil {
+		return fmt.Errorf("failed to read preamble: %w", err)
+	}
+
+	own := c.identity.get()
+	if len(id) > 0 && own != "" && string(id) != own {
+		return fmt.Errorf("%w: %s (this cluster is %s)", ErrClusterMismatch, id, own)
+	}
+	return nil
+}
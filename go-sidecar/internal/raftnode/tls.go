@@ -0,0 +1,73 @@
+package raftnode
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"my-raft-sidecar/internal/config"
+	"my-raft-sidecar/internal/rbac"
+	"my-raft-sidecar/internal/tlsutil"
+)
+
+// ErrPeerNotAllowed is returned when a Raft peer's certificate names none of
+// the allowed identities.
+var ErrPeerNotAllowed = errors.New("peer certificate not allowed")
+
+// transportTLS returns the TLS configuration used to accept Raft
+// connections and a function returning the one used to dial a host, or
+// nils if source is nil. The certificate is presented
+// both when accepting and when dialing. Peers are verified against the CA
+// bundle, or the system roots without one, and their certificate must be
+// valid for the host part of the address they are dialed at.
+//
+// With mutual TLS, accepted connections must present a certificate signed
+// by the CA as well. With an allowlist, the peer certificate on both sides
+// must also carry one of the allowed identities; the allowlist then takes
+// the place of the host name check, since SPIFFE certificates name a
+// workload rather than a host. Without an allowlist, verifyPeer, if set,
+// takes its place instead and, like an allowlist, implies mutual TLS.
+func transportTLS(cfg *config.Config, source *tlsutil.Source, verifyPeer func(*x509.Certificate) error) (server *tls.Config, client func(host string) *tls.Config, err error) {
+	mutual := cfg.RaftMTLS || len(cfg.RaftAllowedPeers) > 0 || verifyPeer != nil
+	if source == nil {
+		if mutual {
+			return nil, nil, errors.New("mutual TLS on the Raft transport requires a certificate, a key and a CA")
+		}
+		return nil, nil, nil
+	}
+	if mutual && !source.HasCA() {
+		return nil, nil, errors.New("mutual TLS on the Raft transport requires -raft-tls-ca")
+	}
+
+	verify := verifyPeer
+	if len(cfg.RaftAllowedPeers) > 0 {
+		allowed := cfg.RaftAllowedPeers
+		verify = func(cert *x509.Certificate) error { return checkPeerIdentity(cert, allowed) }
+	}
+	client = func(host string) *tls.Config {
+		return source.ClientConfig(host, verify == nil, verify)
+	}
+	return source.ServerConfig(mutual, verify), client, nil
+}
+
+// checkPeerIdentity returns nil if cert carries one of the allowed
+// identities as its common name, a DNS SAN or a URI SAN. An allowed entry
+// ending in * matches any identity with that prefix, such as every SPIFFE
+// ID of a trust domain.
+func checkPeerIdentity(cert *x509.Certificate, allowed []string) error {
+	identities := rbac.CertIdentities(cert)
+	for _, identity := range identities {
+		if slices.ContainsFunc(allowed, func(pattern string) bool {
+			if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+				return strings.HasPrefix(identity, prefix)
+			}
+			return identity == pattern
+		}) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrPeerNotAllowed, strings.Join(identities, ", "))
+}
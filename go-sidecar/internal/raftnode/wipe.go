@@ -0,0 +1,27 @@
+package raftnode
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Wipe deletes the Raft state in dataDir: the log, the snapshots and any
+// pending peers.json, so that the node starts again as an empty server that
+// has never been bootstrapped. The persisted cluster ID and the audit log
+// are kept, so the node can only rejoin the cluster it belonged to. The
+// node must not be running. The backend's keys are not touched; clear them
+// with fsm.CppFSM.Clear before the node rebuilds them from the leader.
+func Wipe(dataDir string) error {
+	for _, name := range []string{"logs.dat", "snapshots", peersFile} {
+		path := filepath.Join(dataDir, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		logger.Warn("Wiping", "path", path)
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to wipe %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Package ratelimit provides token-bucket rate limits shared by all clients
+// and applied to each client separately.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is a sustained rate in requests per second and the burst of
+// requests allowed above it. The zero Limit is unlimited.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled reports whether the limit restricts anything.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0
+}
+
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%g/s (burst %d)", l.Rate, l.Burst)
+}
+
+// ParseLimit parses "<rate>[:<burst>]", where rate is in requests per
+// second. The burst defaults to the rate, rounded up. An empty string is
+// unlimited.
+func ParseLimit(s string) (Limit, error) {
+	if s == "" {
+		return Limit{}, nil
+	}
+	rateText, burstText, hasBurst := strings.Cut(s, ":")
+	rate, err := strconv.ParseFloat(rateText, 64)
+	if err != nil || rate <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: rate must be a positive number", s)
+	}
+	burst := int(rate)
+	if float64(burst) < rate {
+		burst++
+	}
+	if hasBurst {
+		if burst, err = strconv.Atoi(burstText); err != nil || burst < 1 {
+			return Limit{}, fmt.Errorf("invalid rate limit %q: burst must be a positive integer", s)
+		}
+	}
+	return Limit{Rate: rate, Burst: burst}, nil
+}
+
+// bucket holds up to burst tokens, refilled at rate per second.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time since its last use and takes a
+// token if one is available.
+func (b *bucket) take(limit Limit, now time.Time) bool {
+	b.tokens = min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full reports whether the bucket would be full at now, and so can be
+// forgotten.
+func (b *bucket) full(limit Limit, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= float64(limit.Burst)
+}
+
+// Limiter enforces a global limit and a per-client limit. A request must
+// pass both.
+type Limiter struct {
+	global    Limit
+	perClient Limit
+
+	mu        sync.Mutex
+	all       bucket
+	clients   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New returns a limiter enforcing global and perClient. A limit that is
+// not enabled allows every request, as does a nil limiter; the limits can
+// be changed later with SetLimits.
+func New(global, perClient Limit) *Limiter {
+	now := time.Now()
+	return &Limiter{
+		global:    global,
+		perClient: perClient,
+		all:       bucket{tokens: float64(global.Burst), last: now},
+		clients:   make(map[string]*bucket),
+		lastSweep: now,
+	}
+}
+
+// SetLimits replaces the limits of a running limiter. Buckets keep their
+// tokens, capped at the new bursts, so that lowering a limit takes effect
+// at once and raising one does not grant a burst of its own.
+func (l *Limiter) SetLimits(global, perClient Limit) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.global.Enabled() {
+		l.all = bucket{tokens: float64(global.Burst), last: time.Now()}
+	}
+	l.all.tokens = min(l.all.tokens, float64(global.Burst))
+	if !l.perClient.Enabled() || !perClient.Enabled() {
+		clear(l.clients)
+	}
+	for _, b := range l.clients {
+		b.tokens = min(b.tokens, float64(perClient.Burst))
+	}
+	l.global, l.perClient = global, perClient
+}
+
+// Allow reports whether a request from client may proceed, taking a token
+// from the buckets it is subject to if so. Rejected requests take no
+// tokens.
+func (l *Limiter) Allow(client string) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+
+	var b *bucket
+	if l.perClient.Enabled() {
+		b = l.clients[client]
+		if b == nil {
+			b = &bucket{tokens: float64(l.perClient.Burst), last: now}
+			l.clients[client] = b
+		}
+		if !b.take(l.perClient, now) {
+			return false
+		}
+	}
+	if l.global.Enabled() && !l.all.take(l.global, now) {
+		if b != nil {
+			b.tokens++
+		}
+		return false
+	}
+	return true
+}
+
+// sweep forgets, once a minute, the clients whose bucket has refilled, so
+// that the map does not grow with every address ever seen.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.clients {
+		if b.full(l.perClient, now) {
+			delete(l.clients, client)
+		}
+	}
+}
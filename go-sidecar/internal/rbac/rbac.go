@@ -0,0 +1,154 @@
+// Package rbac maps authenticated callers to roles.
+//
+// Roles are ordered: a writer may do everything a reader may, and an admin
+// everything a writer may. Readers may read data and cluster state,
+// writers may also propose commands, and only admins may change the
+// membership or carry out other operational actions.
+//
+// Callers holding the management API's bearer token are always admins: the
+// token is a single shared secret that names no identity to grant a role.
+// Management callers that need less are told apart by client certificate.
+package rbac
+
+import (
+	"bufio"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Role is a caller's level of access.
+type Role int
+
+// Roles, from least to most privileged.
+const (
+	None Role = iota
+	Reader
+	Writer
+	Admin
+)
+
+func (r Role) String() string {
+	switch r {
+	case Reader:
+		return "reader"
+	case Writer:
+		return "writer"
+	case Admin:
+		return "admin"
+	}
+	return "none"
+}
+
+// ParseRole parses a role name.
+func ParseRole(name string) (Role, error) {
+	switch strings.ToLower(name) {
+	case "reader":
+		return Reader, nil
+	case "writer":
+		return Writer, nil
+	case "admin":
+		return Admin, nil
+	}
+	return None, fmt.Errorf("unknown role %q", name)
+}
+
+// rule grants role to identities matching pattern.
+type rule struct {
+	role    Role
+	pattern string
+}
+
+func (r rule) matches(identity string) bool {
+	if prefix, ok := strings.CutSuffix(r.pattern, "*"); ok {
+		return strings.HasPrefix(identity, prefix)
+	}
+	return identity == r.pattern
+}
+
+// Policy assigns roles to identities: API key names, JWT subjects and the
+// common name and SANs of client certificates.
+type Policy struct {
+	rules []rule
+	// claim is the JWT claim that may list the caller's roles directly.
+	claim string
+}
+
+// Load reads a policy file with one "<role> <identity>" rule per line. An
+// identity ending in * matches by prefix. Blank lines and lines starting
+// with # are ignored. JWTs may also carry their roles in claim, as a
+// string or a list of strings.
+func Load(path, claim string) (*Policy, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RBAC policy: %w", err)
+	}
+	defer file.Close()
+
+	policy := &Policy{claim: claim}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<role> <identity>\"", path, line)
+		}
+		role, err := ParseRole(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		policy.rules = append(policy.rules, rule{role: role, pattern: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read RBAC policy: %w", err)
+	}
+	return policy, nil
+}
+
+// RoleOf returns the highest role granted to any of identities or listed
+// in claims.
+func (p *Policy) RoleOf(identities []string, claims map[string]any) Role {
+	role := None
+	for _, identity := range identities {
+		for _, r := range p.rules {
+			if r.role > role && r.matches(identity) {
+				role = r.role
+			}
+		}
+	}
+
+	var names []string
+	switch value := claims[p.claim].(type) {
+	case string:
+		names = strings.Fields(value)
+	case []any:
+		for _, v := range value {
+			if name, ok := v.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	for _, name := range names {
+		if r, err := ParseRole(name); err == nil && r > role {
+			role = r
+		}
+	}
+	return role
+}
+
+// CertIdentities returns the identities a certificate carries: its common
+// name, DNS SANs and URI SANs (such as SPIFFE IDs).
+func CertIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
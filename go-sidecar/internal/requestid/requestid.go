@@ -0,0 +1,98 @@
+// Package requestid identifies requests, so that the log lines of a single
+// write can be found across nodes and in the C++ backend's logs.
+//
+// A request ID is taken from the caller, as x-request-id gRPC metadata or an
+// X-Request-ID HTTP header, or generated. It is carried in the extensions
+// of the log entry a proposal is committed as, and handed to the backend in
+// the Command and as x-request-id metadata when the entry is applied.
+package requestid
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// MetadataKey is the gRPC metadata key carrying a request ID, and Header
+// the HTTP header.
+const (
+	MetadataKey = "x-request-id"
+	Header      = "X-Request-ID"
+)
+
+// maxLen is the length of the longest request ID accepted from a caller.
+const maxLen = 128
+
+// extensionPrefix starts the extensions of a log entry carrying a request
+// ID. It is followed by the ID, a NUL and the entry's other extensions.
+var extensionPrefix = []byte("raftkv-rid\x00")
+
+// New returns a random request ID.
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether id may be used as a request ID: up to 128 letters,
+// digits, and '.', '_', ':' or '-'. Other IDs are replaced by new ones.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '.', c == '_', c == ':', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// OrNew returns id if it is valid, and a new request ID otherwise.
+func OrNew(id string) string {
+	if Valid(id) {
+		return id
+	}
+	return New()
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the request ID id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, or "".
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Extension returns the log entry extensions carrying the request ID id
+// ahead of the entry's other extensions, rest.
+func Extension(id string, rest []byte) []byte {
+	ext := make([]byte, 0, len(extensionPrefix)+len(id)+1+len(rest))
+	ext = append(ext, extensionPrefix...)
+	ext = append(ext, id...)
+	ext = append(ext, 0)
+	return append(ext, rest...)
+}
+
+// Split returns the request ID in the extensions of a log entry, or "" if
+// it has none, and the entry's other extensions.
+func Split(extensions []byte) (id string, rest []byte) {
+	after, ok := bytes.CutPrefix(extensions, extensionPrefix)
+	if !ok {
+		return "", extensions
+	}
+	idBytes, rest, ok := bytes.Cut(after, []byte{0})
+	if !ok {
+		return "", extensions
+	}
+	return string(idBytes), rest
+}
@@ -0,0 +1,308 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/jointoken"
+	"my-raft-sidecar/internal/raftnode"
+	"my-raft-sidecar/internal/requestid"
+	"my-raft-sidecar/internal/tlsutil"
+	"my-raft-sidecar/internal/unixsock"
+	pb "my-raft-sidecar/pb"
+)
+
+// adminServer implements the Admin service on top of the RaftNode server.
+// Join may be sent to any member; other membership changes must be sent to
+// the leader and followers reject them with FailedPrecondition and a leader
+// hint.
+type adminServer struct {
+	pb.UnimplementedAdminServer
+	*Server
+}
+
+// Join adds the calling node to the cluster. Unlike AddVoter it may be sent
+// to any member: followers forward it to the leader.
+func (a *adminServer) Join(ctx context.Context, req *pb.JoinRequest) (*pb.AdminResponse, error) {
+	if err := rateLimit(ctx, a.opts.JoinLimiter, "Join"); err != nil {
+		return nil, err
+	}
+	takeOver, err := jointoken.Authorize(a.opts.ClusterToken, req.ClusterToken, req.Id, req.Voter)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if req.Id == "" || req.RaftAddr == "" {
+		return nil, status.Error(codes.InvalidArgument, "id and raft_addr are required")
+	}
+
+	if !a.node.IsLeader() {
+		if isForwarded(ctx) {
+			return nil, a.notLeader(ctx)
+		}
+		addr := a.leaderSidecarAddr()
+		if addr == "" {
+			return nil, status.Error(codes.Unavailable, "no known leader")
+		}
+		client, err := a.peers.adminClient(addr)
+		if err != nil {
+			return nil, err
+		}
+		return client.Join(a.forwardContext(ctx), req)
+	}
+
+	if err := a.node.CheckClusterID(req.ClusterId); err != nil {
+		logger.Warn("Rejected join request", "member", req.Id, "error", err)
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	logger.Info("Received join request", "member", req.Id, "address", req.RaftAddr, "voter", req.Voter)
+	meta := &fsm.PeerMeta{
+		NodeID:      req.Id,
+		SidecarAddr: req.SidecarAddr,
+		MgmtAddr:    req.MgmtAddr,
+		Priority:    int(req.Priority),
+		ReadOnly:    req.ReadOnly,
+		Standby:     req.Standby,
+		Zone:        req.Zone,
+		Rack:        req.Rack,
+	}
+	join := a.node.JoinAt
+	if !takeOver {
+		join = a.node.JoinNewAt
+	}
+	err = join(req.RaftAddr, req.Voter, meta, req.ExpectedEpoch)
+	entry := a.auditEntry(ctx, audit.OpJoin, req.Id, req.RaftAddr, map[string]string{
+		"voter":    strconv.FormatBool(req.Voter),
+		"priority": strconv.Itoa(int(req.Priority)),
+		"readOnly": strconv.FormatBool(req.ReadOnly),
+		"standby":  strconv.FormatBool(req.Standby),
+		"zone":     req.Zone,
+		"rack":     req.Rack,
+	})
+	if entry.Caller.Subject == "" && req.ClusterToken != "" {
+		entry.Caller.Subject = "join token"
+		if req.ClusterToken == a.opts.ClusterToken {
+			entry.Caller.Subject = "cluster token"
+		}
+	}
+	a.node.AuditLog().Record(entry, err)
+	if err != nil {
+		return nil, a.membershipError(ctx, err)
+	}
+	return a.changed(), nil
+}
+
+// AddVoter adds a voting member.
+func (a *adminServer) AddVoter(ctx context.Context, req *pb.AddServerRequest) (*pb.AdminResponse, error) {
+	return a.addServer(ctx, req, true)
+}
+
+// AddNonvoter adds a member that replicates the log without voting.
+func (a *adminServer) AddNonvoter(ctx context.Context, req *pb.AddServerRequest) (*pb.AdminResponse, error) {
+	return a.addServer(ctx, req, false)
+}
+
+func (a *adminServer) addServer(ctx context.Context, req *pb.AddServerRequest, voter bool) (*pb.AdminResponse, error) {
+	if req.Id == "" || req.RaftAddr == "" {
+		return nil, status.Error(codes.InvalidArgument, "id and raft_addr are required")
+	}
+	if !a.node.IsLeader() {
+		return nil, a.notLeader(ctx)
+	}
+
+	logger.Info("Adding server", "member", req.Id, "address", req.RaftAddr, "voter", voter)
+	meta := &fsm.PeerMeta{
+		NodeID:      req.Id,
+		SidecarAddr: req.SidecarAddr,
+		MgmtAddr:    req.MgmtAddr,
+		Priority:    int(req.Priority),
+		ReadOnly:    req.ReadOnly,
+	}
+	op := audit.OpAddNonvoter
+	if voter {
+		op = audit.OpAddVoter
+	}
+	err := a.node.JoinAt(req.RaftAddr, voter, meta, req.ExpectedEpoch)
+	a.node.AuditLog().Record(a.auditEntry(ctx, op, req.Id, req.RaftAddr, map[string]string{
+		"priority": strconv.Itoa(int(req.Priority)),
+		"readOnly": strconv.FormatBool(req.ReadOnly),
+	}), err)
+	if err != nil {
+		return nil, a.membershipError(ctx, err)
+	}
+	return a.changed(), nil
+}
+
+// Remove removes a member from the configuration.
+func (a *adminServer) Remove(ctx context.Context, req *pb.RemoveServerRequest) (*pb.AdminResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	if !a.node.IsLeader() {
+		return nil, a.notLeader(ctx)
+	}
+
+	member, err := a.node.HasServer(req.Id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read configuration: %v", err)
+	}
+	if !member {
+		return nil, status.Errorf(codes.NotFound, "unknown server %q", req.Id)
+	}
+	if !req.Force {
+		if err := a.node.CheckRemoval(req.Id); err != nil {
+			logger.Warn("Refusing to remove server", "member", req.Id, "error", err)
+			return nil, status.Errorf(codes.FailedPrecondition, "%v; set force to remove anyway", err)
+		}
+	}
+
+	logger.Info("Removing server", "member", req.Id)
+	err = a.node.RemoveServerAt(req.Id, req.ExpectedEpoch)
+	a.node.AuditLog().Record(a.auditEntry(ctx, audit.OpRemove, req.Id, "", map[string]string{
+		"force": strconv.FormatBool(req.Force),
+	}), err)
+	if err != nil {
+		return nil, a.membershipError(ctx, err)
+	}
+	return a.changed(), nil
+}
+
+// TransferLeadership hands leadership to the requested voter, or to the
+// most up-to-date one if none is given.
+func (a *adminServer) TransferLeadership(ctx context.Context, req *pb.TransferLeadershipRequest) (*pb.AdminResponse, error) {
+	if !a.node.IsLeader() {
+		return nil, a.notLeader(ctx)
+	}
+
+	if req.Id == "" {
+		err := a.node.TransferLeadership()
+		a.node.AuditLog().Record(a.auditEntry(ctx, audit.OpTransferLeadership, "", "", nil), err)
+		if err != nil {
+			return nil, a.membershipError(ctx, err)
+		}
+		return &pb.AdminResponse{}, nil
+	}
+
+	voters, err := a.node.Voters()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read configuration: %v", err)
+	}
+	for _, server := range voters {
+		if string(server.ID) == req.Id {
+			err := a.node.TransferLeadershipTo(req.Id, string(server.Address))
+			a.node.AuditLog().Record(a.auditEntry(ctx, audit.OpTransferLeadership, req.Id, string(server.Address), nil), err)
+			if err != nil {
+				return nil, a.membershipError(ctx, err)
+			}
+			return &pb.AdminResponse{}, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "unknown voter %q", req.Id)
+}
+
+// GetConfiguration returns the current configuration. On the leader every
+// follower also reports its replication progress.
+func (a *adminServer) GetConfiguration(ctx context.Context, req *pb.GetConfigurationRequest) (*pb.GetConfigurationResponse, error) {
+	configuration, index, err := a.node.Configuration()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read configuration: %v", err)
+	}
+
+	leaderID := a.node.LeaderID()
+	progress := a.node.PeerProgress()
+	resp := &pb.GetConfigurationResponse{Index: index, LeaderId: leaderID}
+	for _, server := range configuration.Servers {
+		info := &pb.ServerInfo{
+			Id:       string(server.ID),
+			Address:  string(server.Address),
+			Suffrage: server.Suffrage.String(),
+			Leader:   string(server.ID) == leaderID,
+		}
+		if meta, ok := a.fsm.Peer(info.Id); ok {
+			info.Zone, info.Rack, info.SidecarAddr = meta.Zone, meta.Rack, meta.SidecarAddr
+		}
+		if p, ok := progress[info.Id]; ok {
+			info.MatchIndex = p.MatchIndex
+			info.LastContactMs = time.Since(p.LastContact).Milliseconds()
+		}
+		resp.Servers = append(resp.Servers, info)
+	}
+	return resp, nil
+}
+
+// changed answers a successful membership change with the new epoch.
+func (a *adminServer) changed() *pb.AdminResponse {
+	epoch, _ := a.node.Epoch()
+	return &pb.AdminResponse{Epoch: epoch}
+}
+
+// membershipError maps a failed configuration change to a gRPC status.
+func (a *adminServer) membershipError(ctx context.Context, err error) error {
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+		return a.notLeader(ctx)
+	}
+	if errors.Is(err, raftnode.ErrEpochMismatch) {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	if errors.Is(err, raftnode.ErrMemberExists) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Errorf(codes.Unavailable, "configuration change failed: %v", err)
+}
+
+// initiator identifies the caller of an admin RPC for the audit log. Joins
+// forwarded by a follower are attributed to that follower.
+func initiator(ctx context.Context) string {
+	caller := "unknown"
+	if p, ok := peer.FromContext(ctx); ok {
+		caller = "grpc:" + p.Addr.String()
+		if unixsock.IsUnix(p.Addr) && p.LocalAddr != nil {
+			caller = "grpc:unix:" + p.LocalAddr.String()
+		}
+	}
+	if identity, ok := IdentityFromContext(ctx); ok && identity.Subject != "" {
+		caller += " as " + identity.Subject
+	}
+	if fromPeer(ctx) {
+		caller += " (forwarded)"
+	}
+	return caller
+}
+
+// auditEntry returns an audit entry for op on target made by the caller of
+// an admin RPC, with the request's params.
+func (s *Server) auditEntry(ctx context.Context, op, target, address string, params map[string]string) audit.Entry {
+	return audit.Entry{
+		Op:        op,
+		Target:    target,
+		Address:   address,
+		Initiator: initiator(ctx),
+		Caller:    s.auditCaller(ctx),
+		Params:    params,
+		RequestID: requestid.FromContext(ctx),
+	}
+}
+
+// auditCaller describes the client of a call for the audit logs.
+func (s *Server) auditCaller(ctx context.Context) *audit.Caller {
+	caller := &audit.Caller{SourceIP: clientIP(ctx)}
+	if identity, ok := IdentityFromContext(ctx); ok {
+		caller.Subject = identity.Subject
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			caller.CertCN = tlsutil.ClientCommonName(s.opts.TLS, &info.State)
+		}
+	}
+	return caller
+}
@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/status"
+
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/requestid"
+	pb "my-raft-sidecar/pb"
+)
+
+// auditPropose records a Propose call in ProposeAudit if it is sampled.
+func (s *Server) auditPropose(ctx context.Context, cmd *pb.Command, resp *pb.ProposeResponse, err error, forwarded bool, latency time.Duration) {
+	if err == nil && !resp.Success {
+		err = errors.New(resp.Error)
+	}
+	if !s.opts.ProposeAudit.Sample(err != nil) {
+		return
+	}
+
+	sum := sha256.Sum256(cmd.Data)
+	entry := audit.ProposeEntry{
+		Caller:    s.auditCaller(ctx),
+		RequestID: requestid.FromContext(ctx),
+		Size:      len(cmd.Data),
+		DedupKey:  hex.EncodeToString(sum[:]),
+		Forwarded: forwarded,
+		Latency:   latency.String(),
+	}
+	if err == nil {
+		entry.Term, entry.Index = resp.Term, resp.FencingToken
+	} else if st, ok := status.FromError(err); ok {
+		entry.Code = st.Code().String()
+		err = errors.New(st.Message())
+	}
+	s.opts.ProposeAudit.Record(entry, err)
+}
@@ -0,0 +1,240 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"my-raft-sidecar/internal/jwt"
+	"my-raft-sidecar/internal/rbac"
+	pb "my-raft-sidecar/pb"
+)
+
+// Metadata keys carrying client credentials. Either key accepts an API key;
+// authorization also accepts a JWT.
+const (
+	authorizationKey = "authorization"
+	apiKeyKey        = "x-api-key"
+)
+
+// AuthConfig configures client authentication on the gRPC server.
+type AuthConfig struct {
+	// APIKeysFile lists accepted API keys, one per line, each optionally
+	// followed by whitespace and a name identifying its holder. Blank
+	// lines and lines starting with # are ignored.
+	APIKeysFile string
+	// JWTKeyFile holds the key JWTs are verified with: a PEM public key or
+	// an HS256 secret (see jwt.NewVerifier).
+	JWTKeyFile string
+	// JWTIssuer and JWTAudience, if set, must match the token's iss and
+	// aud claims.
+	JWTIssuer   string
+	JWTAudience string
+	// Exempt lists full method names ("/package.Service/Method") that
+	// need no credentials, such as the gRPC health checks.
+	Exempt []string
+	// Policy, if set, grants authenticated callers a role that must be at
+	// least the one each method requires (see methodRoles).
+	Policy *rbac.Policy
+}
+
+// methodRoles is the role each method requires under an RBAC policy.
+// Methods not listed, including any added later, require admin.
+var methodRoles = map[string]rbac.Role{
+	pb.RaftNode_Propose_FullMethodName:       rbac.Writer,
+	pb.RaftNode_Status_FullMethodName:        rbac.Reader,
+	pb.RaftNode_Scan_FullMethodName:          rbac.Reader,
+	pb.RaftNode_Watch_FullMethodName:         rbac.Reader,
+	pb.RaftNode_Read_FullMethodName:          rbac.Reader,
+	pb.RaftNode_GetLeader_FullMethodName:     rbac.Reader,
+	pb.RaftNode_GetFence_FullMethodName:      rbac.Reader,
+	pb.Admin_GetConfiguration_FullMethodName: rbac.Reader,
+}
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	// Subject is the API key's name or the JWT's sub claim.
+	Subject string
+	// Claims are the JWT's claims, or nil for an API key.
+	Claims *jwt.Claims
+	// Role is the caller's role under the RBAC policy, if there is one.
+	Role rbac.Role
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the caller authenticated by the server's
+// interceptors, if any.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
+
+// Authenticator validates the credentials of incoming gRPC calls.
+type Authenticator struct {
+	apiKeys  map[string]string // key -> name
+	verifier *jwt.Verifier
+	exempt   map[string]bool
+	policy   *rbac.Policy
+}
+
+// NewAuthenticator loads the API keys and JWT key named in config.
+func NewAuthenticator(config *AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		apiKeys: make(map[string]string),
+		exempt:  make(map[string]bool),
+		policy:  config.Policy,
+	}
+	for _, method := range config.Exempt {
+		a.exempt[method] = true
+	}
+
+	if config.APIKeysFile != "" {
+		if err := a.loadAPIKeys(config.APIKeysFile); err != nil {
+			return nil, err
+		}
+	}
+	if config.JWTKeyFile != "" {
+		key, err := os.ReadFile(config.JWTKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT key: %w", err)
+		}
+		if a.verifier, err = jwt.NewVerifier(key, config.JWTIssuer, config.JWTAudience); err != nil {
+			return nil, fmt.Errorf("invalid JWT key: %w", err)
+		}
+	}
+	if len(a.apiKeys) == 0 && a.verifier == nil {
+		return nil, errors.New("authentication requires API keys or a JWT key")
+	}
+	return a, nil
+}
+
+// loadAPIKeys reads the API keys file.
+func (a *Authenticator) loadAPIKeys(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read API keys: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		name := "api-key"
+		if len(fields) > 1 {
+			name = fields[1]
+		}
+		a.apiKeys[fields[0]] = name
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read API keys: %w", err)
+	}
+	return nil
+}
+
+// authenticate returns the caller of method, or an Unauthenticated error,
+// or PermissionDenied if the caller's role does not allow the method.
+// Exempt methods return a nil identity.
+func (a *Authenticator) authenticate(ctx context.Context, method string) (*Identity, error) {
+	if a.exempt[method] {
+		return nil, nil
+	}
+	identity, err := a.identify(ctx)
+	if err != nil || a.policy == nil {
+		return identity, err
+	}
+
+	var claims map[string]any
+	if identity.Claims != nil {
+		claims = identity.Claims.Raw
+	}
+	identity.Role = a.policy.RoleOf([]string{identity.Subject}, claims)
+	required, ok := methodRoles[method]
+	if !ok {
+		required = rbac.Admin
+	}
+	if identity.Role < required {
+		return nil, status.Errorf(codes.PermissionDenied, "%s requires the %s role, %q has %s", method, required, identity.Subject, identity.Role)
+	}
+	return identity, nil
+}
+
+// identify returns the caller presenting the request's credentials.
+func (a *Authenticator) identify(ctx context.Context) (*Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	credential := ""
+	if values := md.Get(apiKeyKey); len(values) > 0 {
+		credential = values[0]
+	} else if values := md.Get(authorizationKey); len(values) > 0 {
+		credential, _ = strings.CutPrefix(values[0], "Bearer ")
+	}
+	if credential == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing credentials")
+	}
+
+	for key, name := range a.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(credential), []byte(key)) == 1 {
+			return &Identity{Subject: name}, nil
+		}
+	}
+	if a.verifier != nil && jwt.LooksLikeToken(credential) {
+		claims, err := a.verifier.Verify(credential)
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+		}
+		return &Identity{Subject: claims.Subject, Claims: claims}, nil
+	}
+	return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+}
+
+// UnaryInterceptor authenticates unary calls.
+func (a *Authenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		identity, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			logger.Warn("Rejected request", "method", info.FullMethod, "initiator", initiator(ctx), "error", err)
+			return nil, err
+		}
+		if identity != nil {
+			ctx = context.WithValue(ctx, identityKey{}, identity)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor authenticates streaming calls.
+func (a *Authenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		identity, err := a.authenticate(stream.Context(), info.FullMethod)
+		if err != nil {
+			logger.Warn("Rejected request", "method", info.FullMethod, "initiator", initiator(stream.Context()), "error", err)
+			return err
+		}
+		if identity != nil {
+			stream = &identityStream{ServerStream: stream, ctx: context.WithValue(stream.Context(), identityKey{}, identity)}
+		}
+		return handler(srv, stream)
+	}
+}
+
+// identityStream carries the caller's identity in its context.
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
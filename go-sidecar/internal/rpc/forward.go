@@ -0,0 +1,254 @@
+package rpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"my-raft-sidecar/internal/requestid"
+	pb "my-raft-sidecar/pb"
+)
+
+// forwardedKey marks requests proxied from another sidecar so that a
+// request is never forwarded more than once, even if nodes disagree on
+// who the leader is. Its value is the proof returned by forwardProof, or
+// "1" without a cluster token.
+const forwardedKey = "x-raftkv-forwarded"
+
+// forwardProof returns the value of forwardedKey that proves a request was
+// forwarded by a member: a MAC derived from the cluster token, which
+// clients do not hold. It is empty without a cluster token, in which case
+// no forwarded request is trusted.
+func forwardProof(clusterToken string) string {
+	if clusterToken == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(clusterToken))
+	mac.Write([]byte("raftkv forwarded request"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// peerForwardKey is the context key of calls forwarded by a member.
+type peerForwardKey struct{}
+
+// peerForwardInterceptor marks calls carrying the forwarding proof as
+// forwarded by a member (see fromPeer). The marker of any other call only
+// stops it from being forwarded again.
+func peerForwardInterceptor(proof string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if proof != "" {
+			md, _ := metadata.FromIncomingContext(ctx)
+			if values := md.Get(forwardedKey); len(values) == 1 && hmac.Equal([]byte(values[0]), []byte(proof)) {
+				ctx = context.WithValue(ctx, peerForwardKey{}, true)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// fromPeer reports whether the request was forwarded by another member,
+// as proven with the cluster token. Such requests were rate limited and
+// audited by the node the client called, and carry the origin node it
+// recorded.
+func fromPeer(ctx context.Context) bool {
+	forwarded, _ := ctx.Value(peerForwardKey{}).(bool)
+	return forwarded
+}
+
+// Trailer metadata keys carrying the current leader on NotLeader errors.
+const (
+	leaderIDKey   = "x-raftkv-leader-id"
+	leaderAddrKey = "x-raftkv-leader-addr"
+)
+
+// notLeader returns a FailedPrecondition error for a request that must be
+// served by the leader. The leader's node ID and sidecar address are
+// attached as trailer metadata so clients can retry against it directly.
+func (s *Server) notLeader(ctx context.Context) error {
+	leaderID, leaderAddr := s.leaderHint(ctx)
+	return status.Errorf(codes.FailedPrecondition,
+		"node is not the leader (leader: %q at %q)", leaderID, leaderAddr)
+}
+
+// leaderHint returns the current leader's node ID and sidecar address and
+// records them as trailer metadata on the call.
+func (s *Server) leaderHint(ctx context.Context) (leaderID, leaderAddr string) {
+	leaderID, leaderAddr = s.node.LeaderID(), s.leaderSidecarAddr()
+	grpc.SetTrailer(ctx, metadata.Pairs(leaderIDKey, leaderID, leaderAddrKey, leaderAddr))
+	return leaderID, leaderAddr
+}
+
+// forwardRead proxies a linearizable read to the leader's sidecar.
+func (s *Server) forwardRead(ctx context.Context, req *pb.ReadRequest) (*pb.ReadResponse, error) {
+	if !s.opts.ProxyReads || isForwarded(ctx) {
+		return nil, s.notLeader(ctx)
+	}
+
+	client, err := s.leaderClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Read(s.forwardContext(ctx), req)
+}
+
+// forwardPropose proxies a proposal to the leader's sidecar. The request is
+// marked as forwarded so the receiving node applies it locally (or fails)
+// rather than forwarding it again. Aborted and DeadlineExceeded errors are
+// passed on as they are, so that the client can tell them apart, and a
+// leader that has stepped down is reported with this node's leader hint.
+func (s *Server) forwardPropose(ctx context.Context, cmd *pb.Command) (*pb.ProposeResponse, error) {
+	client, err := s.leaderClient()
+	if err != nil {
+		return &pb.ProposeResponse{Success: false, Error: err.Error()}, nil
+	}
+
+	resp, err := client.Propose(s.forwardContext(ctx), cmd)
+	switch status.Code(err) {
+	case codes.Aborted, codes.DeadlineExceeded:
+		return nil, err
+	case codes.FailedPrecondition:
+		return nil, s.notLeader(ctx)
+	}
+	if err != nil {
+		return &pb.ProposeResponse{
+			Success: false,
+			Error:   fmt.Sprintf("failed to forward proposal to leader: %v", err),
+		}, nil
+	}
+	return resp, nil
+}
+
+// leaderClient returns a client for the current leader's sidecar.
+func (s *Server) leaderClient() (pb.RaftNodeClient, error) {
+	addr := s.leaderSidecarAddr()
+	if addr == "" {
+		return nil, status.Error(codes.Unavailable, "no known leader")
+	}
+	return s.peers.client(addr)
+}
+
+// leaderSidecarAddr returns the leader's sidecar address from the
+// replicated peer metadata. For leaders that have not published metadata
+// it is derived from the Raft address, assuming every node serves gRPC on
+// the same port.
+func (s *Server) leaderSidecarAddr() string {
+	if meta, ok := s.fsm.Peer(s.node.LeaderID()); ok && meta.SidecarAddr != "" {
+		return meta.SidecarAddr
+	}
+
+	raftAddr := s.node.LeaderAddr()
+	if raftAddr == "" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(raftAddr)
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, s.opts.PeerPort)
+}
+
+// isForwarded reports whether the request claims to have been proxied by
+// another sidecar. Any client can claim it, so it only stops the request
+// from being forwarded again; see fromPeer for forwards that are trusted.
+func isForwarded(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	return ok && len(md.Get(forwardedKey)) > 0
+}
+
+// forwardContext marks an outgoing request as proxied, with the forwarding
+// proof if there is a cluster token. The caller's credentials and the
+// request ID are passed on, so that the leader authenticates the original
+// client and logs the same request ID.
+func (s *Server) forwardContext(ctx context.Context) context.Context {
+	marker := s.forwardProof
+	if marker == "" {
+		marker = "1"
+	}
+	pairs := []string{forwardedKey, marker}
+	if id := requestid.FromContext(ctx); id != "" {
+		pairs = append(pairs, requestid.MetadataKey, id)
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, key := range []string{authorizationKey, apiKeyKey} {
+			for _, value := range md.Get(key) {
+				pairs = append(pairs, key, value)
+			}
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
+
+// peerPool caches client connections to other sidecars, which are made
+// over TLS if tlsConfig is set.
+type peerPool struct {
+	tlsConfig func(host string) *tls.Config
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func newPeerPool(tlsConfig func(host string) *tls.Config) *peerPool {
+	return &peerPool{tlsConfig: tlsConfig, conns: make(map[string]*grpc.ClientConn)}
+}
+
+// client returns a RaftNode client for addr, dialing it on first use.
+func (p *peerPool) client(addr string) (pb.RaftNodeClient, error) {
+	conn, err := p.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	return pb.NewRaftNodeClient(conn), nil
+}
+
+// adminClient returns an Admin client for addr, dialing it on first use.
+func (p *peerPool) adminClient(addr string) (pb.AdminClient, error) {
+	conn, err := p.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	return pb.NewAdminClient(conn), nil
+}
+
+func (p *peerPool) conn(addr string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conn, ok := p.conns[addr]
+	if !ok {
+		creds := insecure.NewCredentials()
+		if p.tlsConfig != nil {
+			host, _, _ := net.SplitHostPort(addr)
+			creds = credentials.NewTLS(p.tlsConfig(host))
+		}
+		var err error
+		conn, err = grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to connect to leader at %s: %v", addr, err)
+		}
+		p.conns[addr] = conn
+	}
+	return conn, nil
+}
+
+// Close closes all cached connections.
+func (p *peerPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, addr)
+	}
+}
@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"my-raft-sidecar/internal/ratelimit"
+	"my-raft-sidecar/internal/unixsock"
+)
+
+// rateLimit returns a ResourceExhausted error if limiter rejects the
+// caller of method. Requests forwarded by a member were limited by the node
+// the client called, so they pass unchecked; otherwise every proposal
+// forwarded by a follower would count against that follower's address.
+func rateLimit(ctx context.Context, limiter *ratelimit.Limiter, method string) error {
+	if limiter == nil || fromPeer(ctx) {
+		return nil
+	}
+	if limiter.Allow(clientKey(ctx)) {
+		return nil
+	}
+	logger.Warn("Rate limited request", "method", method, "initiator", initiator(ctx))
+	return status.Errorf(codes.ResourceExhausted, "%s rate limit exceeded, retry later", method)
+}
+
+// clientKey identifies the caller for per-client limits: its authenticated
+// identity if there is one, otherwise its IP address.
+func clientKey(ctx context.Context) string {
+	if identity, ok := IdentityFromContext(ctx); ok && identity.Subject != "" {
+		return "subject:" + identity.Subject
+	}
+	return clientIP(ctx)
+}
+
+// clientIP returns the address a request came from, without the port, or
+// "" if it came in on the Unix domain socket.
+func clientIP(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if unixsock.IsUnix(p.Addr) {
+			return ""
+		}
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"my-raft-sidecar/internal/requestid"
+)
+
+// requestIDInterceptor gives every unary call a request ID: the one the
+// client sent as x-request-id metadata, or a new one. It is returned to the
+// client as header metadata and carried in the call's context (see
+// requestid.FromContext).
+func requestIDInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestid.MetadataKey); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	id = requestid.OrNew(id)
+	grpc.SetHeader(ctx, metadata.Pairs(requestid.MetadataKey, id))
+
+	resp, err := handler(requestid.NewContext(ctx, id), req)
+	if err != nil {
+		logger.Debug("Call failed", "method", info.FullMethod, "request_id", id, "error", err)
+	}
+	return resp, err
+}
@@ -3,56 +3,471 @@ package rpc
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"time"
 
+	"github.com/hashicorp/raft"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
+	"my-raft-sidecar/internal/acl"
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/logging"
 	"my-raft-sidecar/internal/raftnode"
+	"my-raft-sidecar/internal/ratelimit"
+	"my-raft-sidecar/internal/requestid"
+	"my-raft-sidecar/internal/signing"
+	"my-raft-sidecar/internal/tracing"
+	"my-raft-sidecar/internal/unixsock"
 	pb "my-raft-sidecar/pb"
 )
 
+// logger logs with component=rpc.
+var logger = logging.Component("rpc")
+
 // Server represents the gRPC server for Raft operations.
 type Server struct {
 	pb.UnimplementedRaftNodeServer
 	node       *raftnode.Node
+	fsm        *fsm.CppFSM
+	opts       *Options
+	peers      *peerPool
 	grpcServer *grpc.Server
 	listener   net.Listener
+	// socketServer serves the Unix socket in the clear when grpcServer
+	// serves TLS; otherwise grpcServer serves both.
+	socketServer *grpc.Server
+	// forwardProof marks the requests this node forwards to the leader as
+	// coming from a member (see forwardProof).
+	forwardProof string
+}
+
+// Options contains optional parameters for the gRPC server.
+type Options struct {
+	// ProxyReads forwards linearizable reads received by a follower to the
+	// leader instead of rejecting them.
+	ProxyReads bool
+	// BindAddr is the IP address to listen on; all interfaces if empty.
+	BindAddr string
+	// SocketPath, if set, is a Unix domain socket served as well.
+	SocketPath string
+	// PeerPort is the sidecar gRPC port of the other nodes, used together
+	// with the leader's Raft host to reach the leader's sidecar.
+	PeerPort string
+	// ReadTimeout bounds the leadership checks of a linearizable read, and
+	// its wait for the backend to catch up.
+	ReadTimeout time.Duration
+	// ProposeTimeout bounds how long a proposal waits to be enqueued in
+	// the Raft log, or the caller's deadline if that is sooner.
+	ProposeTimeout time.Duration
+	// ForwardProposals forwards proposals received by a follower to the
+	// leader instead of failing them with ErrNotLeader.
+	ForwardProposals bool
+	// ReadOnly rejects proposals; the node only serves reads and watches.
+	ReadOnly bool
+	// ClusterToken, if set, must be presented by nodes joining through the
+	// Admin service, unless they present a join token minted with it.
+	ClusterToken string
+	// Auth, if set, authenticates every call (see Authenticator).
+	Auth *Authenticator
+	// ProposeLimiter and JoinLimiter, if set, rate limit Propose and
+	// Admin.Join calls from clients (see rateLimit).
+	ProposeLimiter *ratelimit.Limiter
+	JoinLimiter    *ratelimit.Limiter
+	// ACL, if set, restricts the commands each authenticated caller may
+	// propose.
+	ACL *acl.List
+	// Signatures, if set, checks the signature of proposed commands.
+	Signatures *signing.Verifier
+	// TraceEntries records the trace context of each proposal in its log
+	// entry (see tracing).
+	TraceEntries bool
+	// ProposeAudit, if set, records a sample of Propose calls.
+	ProposeAudit *audit.ProposeLog
+	// TLS, if set, is served on the TCP listener; the Unix socket is only
+	// reachable locally and stays in the clear. PeerTLS, if set, returns
+	// the configuration used to reach the sidecar of the leader at host.
+	TLS     *tls.Config
+	PeerTLS func(host string) *tls.Config
 }
 
+// DefaultOptions returns sensible default options.
+func DefaultOptions() *Options {
+	return &Options{
+		ProxyReads:       true,
+		ForwardProposals: true,
+		PeerPort:         "50052",
+		ReadTimeout:      5 * time.Second,
+		ProposeTimeout:   5 * time.Second,
+	}
+}
+
+// errStandby rejects client requests sent to a standby that has not been
+// promoted yet.
+var errStandby = status.Error(codes.Unavailable, "node is a standby")
+
 // NewServer creates a new gRPC server for the Raft node.
-func NewServer(node *raftnode.Node) *Server {
-	return &Server{
-		node:       node,
-		grpcServer: grpc.NewServer(),
+func NewServer(node *raftnode.Node, stateMachine *fsm.CppFSM, opts *Options) *Server {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	// Request IDs are assigned first, so that rejected calls have one too
+	proof := forwardProof(opts.ClusterToken)
+	serverOpts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(requestIDInterceptor, peerForwardInterceptor(proof))}
+	if opts.Auth != nil {
+		serverOpts = append(serverOpts,
+			grpc.ChainUnaryInterceptor(opts.Auth.UnaryInterceptor()),
+			grpc.ChainStreamInterceptor(opts.Auth.StreamInterceptor()),
+		)
+	}
+	s := &Server{
+		node:         node,
+		fsm:          stateMachine,
+		opts:         opts,
+		peers:        newPeerPool(opts.PeerTLS),
+		forwardProof: proof,
 	}
+	if opts.TLS != nil {
+		s.grpcServer = grpc.NewServer(append(serverOpts, grpc.Creds(credentials.NewTLS(opts.TLS)))...)
+		s.socketServer = grpc.NewServer(serverOpts...)
+	} else {
+		s.grpcServer = grpc.NewServer(serverOpts...)
+		s.socketServer = s.grpcServer
+	}
+	return s
 }
 
-// Propose handles client proposals to the Raft cluster.
+// Propose handles client proposals to the Raft cluster. A follower
+// forwards the proposal to the leader unless forwarding is disabled.
+// Proposals over the rate limit fail with ResourceExhausted, and those the
+// ACL does not allow with PermissionDenied. Proposals that could not be
+// enqueued within the timeout fail with Aborted: they never reached the log,
+// so they are safe to retry once the leader has caught up. The call's
+// request ID, and its trace context if TraceEntries is set, are recorded in
+// the log entry and handed to the backend with it. Calls from clients are
+// recorded in ProposeAudit, if set.
 func (s *Server) Propose(ctx context.Context, cmd *pb.Command) (*pb.ProposeResponse, error) {
-	if err := s.node.Apply(cmd.Data, 5*time.Second); err != nil {
+	// Forwarded proposals are recorded by the node the client called
+	if s.opts.ProposeAudit == nil || fromPeer(ctx) {
+		return s.propose(ctx, cmd, nil)
+	}
+	start := time.Now()
+	var forwarded bool
+	resp, err := s.propose(ctx, cmd, &forwarded)
+	s.auditPropose(ctx, cmd, resp, err, forwarded, time.Since(start))
+	return resp, err
+}
+
+// propose implements Propose, setting *forwarded, if forwarded is not nil,
+// when it forwards the proposal to the leader.
+func (s *Server) propose(ctx context.Context, cmd *pb.Command, forwarded *bool) (*pb.ProposeResponse, error) {
+	requestID := requestid.FromContext(ctx)
+	var trace tracing.Context
+	if s.opts.TraceEntries {
+		trace = tracing.FromIncoming(ctx, s.node.ID(), fromPeer(ctx))
+		if !fromPeer(ctx) {
+			grpc.SetHeader(ctx, metadata.Pairs(tracing.TraceparentKey, trace.Traceparent()))
+		}
+	}
+	if err := rateLimit(ctx, s.opts.ProposeLimiter, "Propose"); err != nil {
+		return nil, err
+	}
+	if err := s.opts.Signatures.Verify(cmd.KeyId, cmd.Signature, cmd.Data); err != nil {
+		logger.Warn("Rejected proposal", "initiator", initiator(ctx), "request_id", requestID, "error", err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if s.opts.ACL != nil {
+		subject := ""
+		if identity, ok := IdentityFromContext(ctx); ok {
+			subject = identity.Subject
+		}
+		if err := s.opts.ACL.Check(subject, cmd.Data); err != nil {
+			logger.Warn("Rejected proposal", "initiator", initiator(ctx), "request_id", requestID, "error", err)
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+	if s.node.Standby() {
+		return nil, errStandby
+	}
+	if s.opts.ReadOnly {
+		return nil, status.Error(codes.FailedPrecondition, "node is a read-only replica")
+	}
+	if draining, reason := s.node.Draining(); draining {
+		return nil, status.Errorf(codes.Unavailable, "node is draining: %s", reason)
+	}
+	if !s.node.IsLeader() && s.opts.ForwardProposals && !isForwarded(ctx) {
+		if forwarded != nil {
+			*forwarded = true
+		}
+		return s.forwardPropose(tracing.OutgoingContext(ctx, trace), cmd)
+	}
+	var extensions []byte
+	if len(cmd.Signature) > 0 {
+		extensions = signing.Extension(cmd.KeyId, cmd.Signature)
+	}
+	if !trace.IsZero() {
+		extensions = tracing.Extension(trace, extensions)
+	}
+	if requestID != "" {
+		extensions = requestid.Extension(requestID, extensions)
+	}
+	timeout := s.opts.ProposeTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+	}
+	if timeout <= 0 {
+		return nil, status.Error(codes.DeadlineExceeded, "deadline exceeded before the proposal was enqueued")
+	}
+	fence, err := s.node.Apply(cmd.Data, extensions, timeout)
+	if errors.Is(err, raft.ErrEnqueueTimeout) {
+		if ctx.Err() != nil {
+			return nil, status.Error(codes.DeadlineExceeded, "deadline exceeded before the proposal was enqueued")
+		}
+		return nil, status.Errorf(codes.Aborted, "proposal not enqueued within %s: %v", timeout, err)
+	}
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+		logger.Debug("Proposal failed", "request_id", requestID, "error", err)
+		return nil, s.notLeader(ctx)
+	}
+	if err != nil {
+		logger.Debug("Proposal failed", "request_id", requestID, "error", err)
 		return &pb.ProposeResponse{
 			Success: false,
 			Error:   err.Error(),
 		}, nil
 	}
-	return &pb.ProposeResponse{Success: true}, nil
+	logger.Debug("Proposal committed", "request_id", requestID, "trace_id", trace.TraceID, "term", fence.Term, "index", fence.Token)
+	return &pb.ProposeResponse{
+		Success:      true,
+		Term:         fence.Term,
+		FencingToken: fence.Token,
+	}, nil
 }
 
-// Start starts the gRPC server on the specified port.
-func (s *Server) Start(port string) error {
-	addr := ":" + port
-	lis, err := net.Listen("tcp", addr)
+// Status reports whether this node is the leader. When req.Verify is set,
+// leadership is confirmed with a quorum before answering, and Verified is
+// set only if it was.
+func (s *Server) Status(ctx context.Context, req *pb.StatusRequest) (*pb.StatusResponse, error) {
+	isLeader := s.node.IsLeader()
+	verified := false
+	if req.Verify && isLeader {
+		if err := s.node.VerifyLeader(); err != nil {
+			logger.Warn("Leadership verification failed", "error", err)
+			isLeader = false
+		} else {
+			verified = true
+		}
+	}
+	return &pb.StatusResponse{
+		IsLeader:   isLeader,
+		LeaderAddr: s.node.LeaderAddr(),
+		Verified:   verified,
+	}, nil
+}
+
+// Scan streams a key range from the backend. Every key/value reflects the
+// same applied log index: applies are paused while the range is copied
+// from the backend, but not while it is sent, so a slow client does not
+// stall the node. On the leader a barrier is issued first so that the scan
+// includes every entry committed before the call.
+func (s *Server) Scan(req *pb.ScanRequest, stream pb.RaftNode_ScanServer) error {
+	if s.node.Standby() {
+		return errStandby
+	}
+	if s.node.IsLeader() {
+		if err := s.node.Barrier(5 * time.Second); err != nil {
+			return status.Errorf(codes.Unavailable, "barrier failed: %v", err)
+		}
+	}
+
+	index, kvs, err := s.fsm.Scan(stream.Context(), req)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		return status.Errorf(codes.Unavailable, "backend scan failed: %v", err)
+	}
+	for _, kv := range kvs {
+		kv.Index = index
+		if err := stream.Send(kv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch streams every applied command to the caller. If req.StartIndex is
+// set, entries from that index onwards are first replayed from the Raft log.
+// A watcher that falls too far behind is disconnected with ResourceExhausted
+// and may resume from the last index it received.
+func (s *Server) Watch(req *pb.WatchRequest, stream pb.RaftNode_WatchServer) error {
+	if s.node.Standby() {
+		return errStandby
+	}
+	sub, appliedIndex := s.fsm.Subscribe()
+	defer sub.Cancel()
+
+	if req.StartIndex > 0 && req.StartIndex <= appliedIndex {
+		err := s.node.ReadLogs(req.StartIndex, appliedIndex, func(l *raft.Log) error {
+			return stream.Send(&pb.WatchEvent{Index: l.Index, Term: l.Term, Data: l.Data})
+		})
+		if errors.Is(err, raftnode.ErrLogCompacted) {
+			return status.Error(codes.OutOfRange, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case entry, ok := <-sub.C:
+			if !ok {
+				if sub.Dropped() {
+					return status.Error(codes.ResourceExhausted, "watcher fell too far behind")
+				}
+				return nil
+			}
+			if entry.Index < req.StartIndex {
+				continue
+			}
+			if err := stream.Send(&pb.WatchEvent{
+				Index: entry.Index,
+				Term:  entry.Term,
+				Data:  entry.Data,
+			}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Read looks up a key. Stale reads are served from local state. A
+// linearizable read is served by the leader once every entry up to its
+// read index has been applied; a follower proxies it to the leader unless
+// proxying is disabled.
+func (s *Server) Read(ctx context.Context, req *pb.ReadRequest) (*pb.ReadResponse, error) {
+	if s.node.Standby() {
+		return nil, errStandby
+	}
+	if req.Linearizable {
+		if !s.node.IsLeader() {
+			return s.forwardRead(ctx, req)
+		}
+
+		index, err := s.node.ReadIndex(s.opts.ReadTimeout)
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return nil, s.notLeader(ctx)
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to confirm leadership: %v", err)
+		}
+		// The read index may point at an entry the FSM never sees, such as
+		// a leader no-op or a configuration change, so the read waits for
+		// the last command at or below it to be applied.
+		if applied := s.fsm.AppliedIndex(); applied < index {
+			target, err := s.node.LastCommandIndex(index, applied)
+			if err != nil {
+				return nil, status.Errorf(codes.Unavailable, "failed to find the last command before the read index: %v", err)
+			}
+			waitCtx, cancel := context.WithTimeout(ctx, s.opts.ReadTimeout)
+			defer cancel()
+			if err := s.fsm.WaitApplied(waitCtx, target); err != nil {
+				return nil, status.FromContextError(err).Err()
+			}
+		}
+	}
+
+	resp, err := s.fsm.Read(ctx, req)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "backend read failed: %v", err)
+	}
+	return resp, nil
+}
+
+// GetLeader reports the current leader and the addresses to reach it.
+func (s *Server) GetLeader(ctx context.Context, req *pb.GetLeaderRequest) (*pb.GetLeaderResponse, error) {
+	leaderID := s.node.LeaderID()
+	if leaderID == "" {
+		return &pb.GetLeaderResponse{}, nil
+	}
+	return &pb.GetLeaderResponse{
+		LeaderId:    leaderID,
+		RaftAddr:    s.node.LeaderAddr(),
+		SidecarAddr: s.leaderSidecarAddr(),
+	}, nil
+}
+
+// GetFence returns the leader's current fence. Downstream systems that
+// remember the highest fence they have seen can reject work tagged with a
+// lower one, such as writes still in flight from a deposed leader.
+func (s *Server) GetFence(ctx context.Context, req *pb.GetFenceRequest) (*pb.GetFenceResponse, error) {
+	if !s.node.IsLeader() {
+		return nil, s.notLeader(ctx)
+	}
+
+	fence, err := s.node.CurrentFence()
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+		return nil, s.notLeader(ctx)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to confirm leadership: %v", err)
+	}
+	return &pb.GetFenceResponse{Term: fence.Term, FencingToken: fence.Token}, nil
+}
+
+// Start starts the gRPC server on the specified port, and on SocketPath if
+// set. An empty port serves the API only on SocketPath.
+func (s *Server) Start(port string) error {
+	addr := "0.0.0.0:" + port
+	if s.opts.BindAddr != "" {
+		addr = net.JoinHostPort(s.opts.BindAddr, port)
+	}
+	var lis net.Listener
+	if port != "" {
+		var err error
+		if lis, err = net.Listen("tcp", addr); err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		s.listener = lis
+	}
+	var socket net.Listener
+	if s.opts.SocketPath != "" {
+		var err error
+		if socket, err = unixsock.Listen(s.opts.SocketPath); err != nil {
+			if lis != nil {
+				lis.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", s.opts.SocketPath, err)
+		}
 	}
-	s.listener = lis
 
 	pb.RegisterRaftNodeServer(s.grpcServer, s)
+	pb.RegisterAdminServer(s.grpcServer, &adminServer{Server: s})
+	if s.socketServer != s.grpcServer {
+		pb.RegisterRaftNodeServer(s.socketServer, s)
+		pb.RegisterAdminServer(s.socketServer, &adminServer{Server: s})
+	}
 
-	log.Printf("gRPC server listening on %s", addr)
+	if lis == nil {
+		logger.Info("gRPC server listening", "socket", s.opts.SocketPath)
+		return s.socketServer.Serve(socket)
+	}
+	if socket != nil {
+		logger.Info("gRPC server listening", "socket", s.opts.SocketPath)
+		go func() {
+			if err := s.socketServer.Serve(socket); err != nil {
+				logger.Error("gRPC server failed", "socket", s.opts.SocketPath, "error", err)
+			}
+		}()
+	}
+	logger.Info("gRPC server listening", "address", addr, "tls", s.opts.TLS != nil)
 	return s.grpcServer.Serve(lis)
 }
 
@@ -61,4 +476,8 @@ func (s *Server) Stop() {
 	if s.grpcServer != nil {
 		s.grpcServer.GracefulStop()
 	}
+	if s.socketServer != nil && s.socketServer != s.grpcServer {
+		s.socketServer.GracefulStop()
+	}
+	s.peers.Close()
 }
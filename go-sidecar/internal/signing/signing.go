@@ -0,0 +1,238 @@
+// Package signing verifies the HMAC signatures clients attach to commands.
+//
+// A client signs a command's data with a key shared with the sidecars. The
+// sidecar checks the signature before proposing the command and stores it
+// in the log entry's extensions, so that every node checks it again before
+// applying the entry. A client library that does not hold a key cannot
+// change the state, whichever node it reaches or however its command
+// enters the log.
+//
+// Nodes must reach the same verdict on every entry, so they check it
+// against a KeySet replicated through the log rather than against the keys
+// they happen to hold: the set names the accepted key IDs with a
+// fingerprint of each key. A node holding a key under the same ID but with
+// another fingerprint, or missing a key an entry is signed with, cannot
+// reach that verdict and must stop.
+package signing
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrUnsigned is returned for commands without a signature when
+	// signatures are required.
+	ErrUnsigned = errors.New("command is not signed")
+	// ErrInvalidSignature is returned for signatures that do not match.
+	ErrInvalidSignature = errors.New("invalid command signature")
+	// ErrKeyUnavailable is returned for entries signed with a key of the
+	// replicated KeySet that this node does not hold, or holds with
+	// another fingerprint.
+	ErrKeyUnavailable = errors.New("signing key not held by this node")
+)
+
+// extensionPrefix starts the extensions of a signed log entry. It is
+// followed by the key ID, a NUL and the signature.
+var extensionPrefix = []byte("raftkv-sig\x00")
+
+// minKeySize is the shortest key accepted, in bytes.
+const minKeySize = 16
+
+// fingerprintLabel is signed with a key to derive its fingerprint, which
+// identifies the key without revealing it.
+var fingerprintLabel = []byte("raftkv-signing-key-fingerprint")
+
+// Sign returns the signature of data under key: its HMAC-SHA256.
+func Sign(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Extension returns the log entry extensions carrying a signature.
+func Extension(keyID string, signature []byte) []byte {
+	ext := make([]byte, 0, len(extensionPrefix)+len(keyID)+1+len(signature))
+	ext = append(ext, extensionPrefix...)
+	ext = append(ext, keyID...)
+	ext = append(ext, 0)
+	return append(ext, signature...)
+}
+
+// Verifier checks command signatures against a set of keys. A nil
+// *Verifier accepts every command.
+type Verifier struct {
+	keys map[string][]byte
+	// AllowUnsigned accepts commands without a signature, while clients
+	// are being changed to sign them. Signed commands are still checked.
+	AllowUnsigned bool
+}
+
+// Load reads signing keys from path, one "<key id> <base64 key>" pair per
+// line. Several keys allow them to be rotated: clients move to a new key
+// while the old one is still accepted. Blank lines and lines starting with
+// # are ignored.
+func Load(path string) (*Verifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing keys: %w", err)
+	}
+	defer clear(data)
+
+	v := &Verifier{keys: make(map[string][]byte)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<key id> <base64 key>\"", path, line)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid key: %w", path, line, err)
+		}
+		if len(key) < minKeySize {
+			return nil, fmt.Errorf("%s:%d: key is %d bytes, want at least %d", path, line, len(key), minKeySize)
+		}
+		if _, ok := v.keys[fields[0]]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate key ID %q", path, line, fields[0])
+		}
+		v.keys[fields[0]] = key
+	}
+	if len(v.keys) == 0 {
+		return nil, fmt.Errorf("signing key file %s has no keys", path)
+	}
+	return v, nil
+}
+
+// Verify checks that signature is the signature of data under the key
+// named keyID. An empty signature is accepted only with AllowUnsigned.
+func (v *Verifier) Verify(keyID string, signature, data []byte) error {
+	if v == nil {
+		return nil
+	}
+	if len(signature) == 0 {
+		if v.AllowUnsigned {
+			return nil
+		}
+		return ErrUnsigned
+	}
+	key, ok := v.keys[keyID]
+	if !ok {
+		return fmt.Errorf("%w: unknown key ID %q", ErrInvalidSignature, keyID)
+	}
+	if !hmac.Equal(signature, Sign(key, data)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// fingerprint returns the fingerprint of key.
+func fingerprint(key []byte) string {
+	return hex.EncodeToString(Sign(key, fingerprintLabel)[:16])
+}
+
+// KeySet is the replicated description of the keys every node checks
+// signatures against. It holds no key material.
+type KeySet struct {
+	// Keys maps each accepted key ID to the fingerprint of its key.
+	Keys map[string]string `json:"keys"`
+	// AllowUnsigned accepts entries without a signature.
+	AllowUnsigned bool `json:"allow_unsigned,omitempty"`
+}
+
+// KeySet returns the set describing v's keys, or nil for a nil *Verifier.
+func (v *Verifier) KeySet() *KeySet {
+	if v == nil {
+		return nil
+	}
+	set := &KeySet{Keys: make(map[string]string, len(v.keys)), AllowUnsigned: v.AllowUnsigned}
+	for id, key := range v.keys {
+		set.Keys[id] = fingerprint(key)
+	}
+	return set
+}
+
+// Empty reports whether s names no keys, in which case entries are not
+// checked at all.
+func (s *KeySet) Empty() bool {
+	return s == nil || len(s.Keys) == 0
+}
+
+// Equal reports whether s and other accept the same entries.
+func (s *KeySet) Equal(other *KeySet) bool {
+	if s.Empty() || other.Empty() {
+		return s.Empty() == other.Empty()
+	}
+	if s.AllowUnsigned != other.AllowUnsigned || len(s.Keys) != len(other.Keys) {
+		return false
+	}
+	for id, fp := range s.Keys {
+		if other.Keys[id] != fp {
+			return false
+		}
+	}
+	return true
+}
+
+// Check returns an error if v holds a key named in set with another
+// fingerprint. Keys missing from v, or from set, are not an error: they
+// are checked when an entry signed with them is applied.
+func (v *Verifier) Check(set *KeySet) error {
+	if v == nil || set.Empty() {
+		return nil
+	}
+	for id, fp := range set.Keys {
+		if key, ok := v.keys[id]; ok && fingerprint(key) != fp {
+			return fmt.Errorf("key %q differs from the replicated key with fingerprint %s", id, fp)
+		}
+	}
+	return nil
+}
+
+// VerifyEntry checks the signature in the extensions of a log entry
+// against its data, accepting what set accepts: the verdict is the same on
+// every node that returns ErrUnsigned, ErrInvalidSignature or nil. Entries
+// without a signature are unsigned. ErrKeyUnavailable means that v cannot
+// check the entry; the node must not apply it, nor skip it.
+func (v *Verifier) VerifyEntry(set *KeySet, extensions, data []byte) error {
+	if set.Empty() {
+		return nil
+	}
+	rest, ok := bytes.CutPrefix(extensions, extensionPrefix)
+	if !ok {
+		if set.AllowUnsigned {
+			return nil
+		}
+		return ErrUnsigned
+	}
+	keyID, signature, ok := bytes.Cut(rest, []byte{0})
+	if !ok || len(signature) == 0 {
+		return fmt.Errorf("%w: malformed log entry extensions", ErrInvalidSignature)
+	}
+	fp, ok := set.Keys[string(keyID)]
+	if !ok {
+		return fmt.Errorf("%w: unknown key ID %q", ErrInvalidSignature, keyID)
+	}
+	var key []byte
+	if v != nil {
+		key = v.keys[string(keyID)]
+	}
+	if key == nil || fingerprint(key) != fp {
+		return fmt.Errorf("%w: %q", ErrKeyUnavailable, keyID)
+	}
+	if !hmac.Equal(signature, Sign(key, data)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
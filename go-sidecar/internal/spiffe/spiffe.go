@@ -0,0 +1,289 @@
+// Package spiffe fetches X.509 SVIDs from the SPIFFE Workload API.
+//
+// The Workload API is a gRPC service served on a local socket by the SPIRE
+// agent (or any other implementation). Its messages are decoded directly
+// from the wire format, so no generated code is needed for the few fields
+// the sidecar uses.
+package spiffe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"my-raft-sidecar/internal/logging"
+	"my-raft-sidecar/internal/tlsutil"
+)
+
+// logger logs with component=spiffe.
+var logger = logging.Component("spiffe")
+
+// fetchX509SVID is the Workload API's streaming method that sends the
+// workload's SVIDs and trust bundle, and sends them again on rotation.
+const fetchX509SVID = "/SpiffeWorkloadAPI/FetchX509SVID"
+
+// ErrNotInTrustDomain is returned for peers whose certificate carries no
+// SPIFFE ID of this workload's trust domain.
+var ErrNotInTrustDomain = errors.New("peer is not in the trust domain")
+
+// SVID is an X.509 SVID with its trust bundle.
+type SVID struct {
+	ID          string
+	Certificate *tls.Certificate
+	Bundle      *x509.CertPool
+}
+
+// Watcher keeps TLS sources supplied with the workload's current SVID.
+type Watcher struct {
+	socket  string
+	sources []*tlsutil.Source
+
+	mu    sync.RWMutex
+	svid  *SVID
+	ready chan struct{}
+	once  sync.Once
+}
+
+// NewWatcher returns a watcher for the Workload API at socket, an address
+// such as unix:///run/spire/sockets/agent.sock or a plain socket path, that
+// updates sources.
+func NewWatcher(socket string, sources ...*tlsutil.Source) *Watcher {
+	if !strings.Contains(socket, "://") {
+		socket = "unix://" + socket
+	}
+	return &Watcher{
+		socket:  socket,
+		sources: sources,
+		ready:   make(chan struct{}),
+	}
+}
+
+// Start fetches SVIDs until ctx is done, reconnecting when the stream
+// breaks, and waits up to timeout for the first one.
+func (w *Watcher) Start(ctx context.Context, timeout time.Duration) error {
+	go w.run(ctx)
+	select {
+	case <-w.ready:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("no SVID received from %s within %s", w.socket, timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SVID returns the current SVID, or nil before the first one.
+func (w *Watcher) SVID() *SVID {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.svid
+}
+
+// TrustDomainVerifier returns a peer check accepting certificates with a
+// SPIFFE ID in the trust domain of this workload's SVID, for use in place
+// of the host name check.
+func (w *Watcher) TrustDomainVerifier() func(*x509.Certificate) error {
+	return func(cert *x509.Certificate) error {
+		svid := w.SVID()
+		if svid == nil {
+			return errors.New("no SVID yet")
+		}
+		own, _ := url.Parse(svid.ID)
+		for _, uri := range cert.URIs {
+			if uri.Scheme == "spiffe" && own != nil && uri.Host == own.Host {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w %s", ErrNotInTrustDomain, own.Host)
+	}
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := w.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warn("SPIFFE Workload API stream failed, retrying", "retry_in", backoff.String(), "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// stream reads SVID updates until the stream fails.
+func (w *Watcher) stream(ctx context.Context) error {
+	conn, err := grpc.NewClient(w.socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The Workload API rejects calls without this header.
+	ctx = metadata.AppendToOutgoingContext(ctx, "workload.spiffe.io", "true")
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, fetchX509SVID, grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		return err
+	}
+	// X509SVIDRequest has no fields.
+	if err := stream.SendMsg([]byte{}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		var msg []byte
+		if err := stream.RecvMsg(&msg); err != nil {
+			return err
+		}
+		svid, err := parseResponse(msg)
+		if err != nil {
+			logger.Warn("Ignoring malformed SVID update", "error", err)
+			continue
+		}
+		w.update(svid)
+	}
+}
+
+// update installs svid in every source.
+func (w *Watcher) update(svid *SVID) {
+	w.mu.Lock()
+	previous := w.svid
+	w.svid = svid
+	w.mu.Unlock()
+
+	for _, source := range w.sources {
+		source.Update(svid.Certificate, svid.Bundle)
+	}
+	if previous == nil {
+		logger.Info("Received SVID", "spiffe_id", svid.ID, "expires", svid.Certificate.Leaf.NotAfter.Format(time.RFC3339))
+	} else {
+		logger.Info("Rotated SVID", "spiffe_id", svid.ID, "expires", svid.Certificate.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	w.once.Do(func() { close(w.ready) })
+}
+
+// parseResponse decodes the first SVID of an X509SVIDResponse:
+//
+//	message X509SVIDResponse { repeated X509SVID svids = 1; ... }
+//	message X509SVID {
+//	  string spiffe_id = 1;
+//	  bytes x509_svid = 2;      // ASN.1 DER certificate chain
+//	  bytes x509_svid_key = 3;  // PKCS#8 DER private key
+//	  bytes bundle = 4;         // ASN.1 DER CA certificates
+//	}
+func parseResponse(msg []byte) (*SVID, error) {
+	fields, err := parseFields(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields[1]) == 0 {
+		return nil, errors.New("response has no SVIDs")
+	}
+	svidFields, err := parseFields(fields[1][0])
+	if err != nil {
+		return nil, err
+	}
+	first := func(n protowire.Number) []byte {
+		if values := svidFields[n]; len(values) > 0 {
+			return values[0]
+		}
+		return nil
+	}
+
+	id := string(first(1))
+	if !strings.HasPrefix(id, "spiffe://") {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q", id)
+	}
+	chain, err := x509.ParseCertificates(first(2))
+	if err != nil || len(chain) == 0 {
+		return nil, fmt.Errorf("invalid SVID certificate: %v", err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(first(3))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SVID key: %w", err)
+	}
+	cas, err := x509.ParseCertificates(first(4))
+	if err != nil || len(cas) == 0 {
+		return nil, fmt.Errorf("invalid trust bundle: %v", err)
+	}
+
+	cert := &tls.Certificate{PrivateKey: key, Leaf: chain[0]}
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	bundle := x509.NewCertPool()
+	for _, ca := range cas {
+		bundle.AddCert(ca)
+	}
+	return &SVID{ID: id, Certificate: cert, Bundle: bundle}, nil
+}
+
+// parseFields returns the length-delimited fields of a message by number,
+// skipping fields of other wire types.
+func parseFields(msg []byte) (map[protowire.Number][][]byte, error) {
+	fields := make(map[protowire.Number][][]byte)
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		if typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(msg)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			fields[num] = append(fields[num], value)
+			msg = msg[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, msg)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+	}
+	return fields, nil
+}
+
+// rawCodec passes already encoded messages through.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec: unexpected %T", v)
+	}
+	return b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawCodec: unexpected %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
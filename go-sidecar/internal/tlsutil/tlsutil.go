@@ -0,0 +1,296 @@
+// Package tlsutil loads the TLS material of the sidecar's listeners and of
+// the clients that talk to them, and reloads it when it is rotated.
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"my-raft-sidecar/internal/logging"
+)
+
+// logger logs with component=tlsutil.
+var logger = logging.Component("tlsutil")
+
+// Files names the PEM files of a TLS configuration.
+type Files struct {
+	// Cert and Key are the certificate presented by this node and its
+	// private key.
+	Cert string
+	Key  string
+	// CA is the bundle that peer certificates are verified against, both
+	// the servers this node dials and, with client authentication, the
+	// clients it accepts. The system roots are used if empty.
+	CA string
+}
+
+// Enabled reports whether any file is set.
+func (f Files) Enabled() bool {
+	return f.Cert != "" || f.Key != "" || f.CA != ""
+}
+
+// Source holds the certificate and CA bundle loaded from Files, or set by
+// a provider such as the SPIFFE Workload API. The configurations it returns
+// always use the latest material, so rotated files take effect on the next
+// handshake once they have been reloaded, without restarting listeners or
+// dropping established connections.
+type Source struct {
+	name  string
+	files Files
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes []time.Time
+}
+
+// NewSource loads files. name identifies the source in logs.
+func NewSource(name string, files Files) (*Source, error) {
+	if files.Cert == "" || files.Key == "" {
+		return nil, errors.New("TLS requires both a certificate and a key")
+	}
+	s := &Source{name: name, files: files}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewProvidedSource returns a source without files, whose material is set
+// with Update. Handshakes fail until it has been set.
+func NewProvidedSource(name string) *Source {
+	return &Source{name: name}
+}
+
+// Update replaces the certificate and CA bundle.
+func (s *Source) Update(cert *tls.Certificate, pool *x509.CertPool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cert, s.pool = cert, pool
+}
+
+// HasCA reports whether peers are verified against a CA bundle rather
+// than the system roots.
+func (s *Source) HasCA() bool {
+	return s.files.CA != "" || s.roots() != nil
+}
+
+// Reload reads the files again. On error the previous material is kept.
+// Provided sources have nothing to reload.
+func (s *Source) Reload() error {
+	if s.files.Cert == "" {
+		return nil
+	}
+	modTimes := s.fileModTimes()
+	cert, err := tls.LoadX509KeyPair(s.files.Cert, s.files.Key)
+	if err != nil {
+		return fmt.Errorf("failed to load %s TLS certificate: %w", s.name, err)
+	}
+	var pool *x509.CertPool
+	if s.files.CA != "" {
+		if pool, err = LoadCA(s.files.CA); err != nil {
+			return fmt.Errorf("failed to load %s TLS CA: %w", s.name, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cert, s.pool, s.modTimes = &cert, pool, modTimes
+	return nil
+}
+
+// Watch reloads the files every interval if any of them has changed, until
+// ctx is done.
+func (s *Source) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !s.changed() {
+					continue
+				}
+				if err := s.Reload(); err != nil {
+					logger.Error("Failed to reload rotated TLS material, keeping the previous one", "listener", s.name, "error", err)
+					continue
+				}
+				logger.Info("Reloaded rotated TLS material", "listener", s.name)
+			}
+		}
+	}()
+}
+
+// changed reports whether any file was modified since the last reload.
+func (s *Source) changed() bool {
+	if s.files.Cert == "" {
+		return false
+	}
+	current := s.fileModTimes()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := range current {
+		if !current[i].Equal(s.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Source) fileModTimes() []time.Time {
+	var modTimes []time.Time
+	for _, path := range []string{s.files.Cert, s.files.Key, s.files.CA} {
+		var modTime time.Time
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
+		}
+		modTimes = append(modTimes, modTime)
+	}
+	return modTimes
+}
+
+func (s *Source) certificate() *tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert
+}
+
+func (s *Source) roots() *x509.CertPool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pool
+}
+
+// ServerConfig returns a configuration for accepting connections. With
+// clientAuth, clients must present a certificate that chains to the CA
+// bundle and, if verify is set, that verify accepts.
+func (s *Source) ServerConfig(clientAuth bool, verify func(*x509.Certificate) error) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.certificate(), nil
+		},
+	}
+	if clientAuth {
+		// The chain is verified against the current bundle below.
+		config.ClientAuth = tls.RequireAnyClientCert
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return s.verifyPeer(state, "", verify)
+		}
+	}
+	return config
+}
+
+// ClientCommonName returns the common name of the certificate a client
+// presented on a connection accepted with config, or "" if there is none.
+// Certificates are only taken from configurations that require them, as
+// ServerConfig's do: the chain is then verified by VerifyConnection before
+// the handshake completes, though not recorded in VerifiedChains.
+func ClientCommonName(config *tls.Config, state *tls.ConnectionState) string {
+	if config == nil || state == nil || config.ClientAuth < tls.RequireAnyClientCert || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.CommonName
+}
+
+// ClientConfig returns a configuration for dialing the server at host,
+// presenting this node's certificate. The server must present a
+// certificate that chains to the CA bundle, valid for host if
+// verifyHostname is set, and, if verify is set, that verify accepts. The
+// host is bound into the configuration, as the connection state carries no
+// server name when an IP address is dialed, so a configuration must be
+// built for each host; with verifyHostname, handshakes fail if host is
+// empty.
+func (s *Source) ClientConfig(host string, verifyHostname bool, verify func(*x509.Certificate) error) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: host,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return s.certificate(), nil
+		},
+		// The chain is verified against the current bundle below.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if !verifyHostname {
+				return s.verifyPeer(state, "", verify)
+			}
+			if host == "" {
+				return errors.New("no host to verify the server certificate against")
+			}
+			return s.verifyPeer(state, host, verify)
+		},
+	}
+}
+
+// DialTLSContext returns a function, for http.Transport.DialTLSContext,
+// that dials addr and completes a TLS handshake with ClientConfig for its
+// host.
+func (s *Source) DialTLSContext(verifyHostname bool, verify func(*x509.Certificate) error) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, s.ClientConfig(host, verifyHostname, verify))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// verifyPeer checks the peer's chain against the current CA bundle (the
+// system roots without one) and, if host is set, that the certificate is
+// valid for it.
+func (s *Source) verifyPeer(state tls.ConnectionState, host string, verify func(*x509.Certificate) error) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("peer presented no certificate")
+	}
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	// The same certificate is commonly used for both ends of a
+	// connection, so any extended key usage is accepted.
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         s.roots(),
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return err
+	}
+	if verify != nil {
+		return verify(leaf)
+	}
+	return nil
+}
+
+// LoadCA reads a PEM CA bundle.
+func LoadCA(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,140 @@
+// Package tracing carries the trace context of a proposal in its Raft log
+// entry, so that the entry can be correlated with the client request that
+// created it on whichever node applies it.
+//
+// Trace and span IDs follow W3C Trace Context: they are taken from the
+// traceparent metadata of the client's call, or generated. The origin node
+// is the node the client called, which may have forwarded the proposal to
+// the leader.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// TraceparentKey is the gRPC metadata key of a W3C traceparent, and
+// OriginKey the key of the origin node on proposals forwarded to the
+// leader.
+const (
+	TraceparentKey = "traceparent"
+	OriginKey      = "x-raftkv-origin-node"
+)
+
+// extensionPrefix starts the extensions of a log entry carrying a trace
+// context. It is followed by the trace ID, span ID and origin node, each
+// ended by a NUL, and the entry's other extensions.
+var extensionPrefix = []byte("raftkv-trace\x00")
+
+// Context is the trace context of a proposal.
+type Context struct {
+	// TraceID is 32 and SpanID 16 lowercase hex digits. SpanID is the
+	// span of the client call that made the proposal.
+	TraceID string
+	SpanID  string
+	// Origin is the ID of the node the client called.
+	Origin string
+}
+
+// IsZero reports whether c carries no trace context.
+func (c Context) IsZero() bool {
+	return c.TraceID == ""
+}
+
+// Traceparent returns c as a W3C traceparent, sampled.
+func (c Context) Traceparent() string {
+	return "00-" + c.TraceID + "-" + c.SpanID + "-01"
+}
+
+// FromIncoming returns the trace context of a client call: the trace and
+// span in its traceparent metadata, or new ones if it has none. The origin
+// is the OriginKey metadata of a forwarded call, or node otherwise.
+func FromIncoming(ctx context.Context, node string, forwarded bool) Context {
+	c := Context{Origin: node}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(TraceparentKey); len(values) > 0 {
+		c.TraceID, c.SpanID, _ = parseTraceparent(values[0])
+	}
+	if c.TraceID == "" {
+		c.TraceID, c.SpanID = randomHex(16), randomHex(8)
+	}
+	if values := md.Get(OriginKey); forwarded && len(values) > 0 && values[0] != "" {
+		c.Origin = values[0]
+	}
+	return c
+}
+
+// OutgoingContext returns a copy of ctx whose outgoing metadata carries c,
+// for a proposal forwarded to the leader. A zero c leaves ctx unchanged.
+func OutgoingContext(ctx context.Context, c Context) context.Context {
+	if c.IsZero() {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, TraceparentKey, c.Traceparent(), OriginKey, c.Origin)
+}
+
+// parseTraceparent returns the trace and span IDs of a version 00 W3C
+// traceparent, or ok false if it is malformed or all zeros.
+func parseTraceparent(value string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || parts[0] != "00" || !isHex(parts[1], 32) || !isHex(parts[2], 16) {
+		return "", "", false
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// isHex reports whether s is n lowercase hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Extension returns the log entry extensions carrying c ahead of the
+// entry's other extensions, rest.
+func Extension(c Context, rest []byte) []byte {
+	ext := make([]byte, 0, len(extensionPrefix)+len(c.TraceID)+len(c.SpanID)+len(c.Origin)+3+len(rest))
+	ext = append(ext, extensionPrefix...)
+	for _, field := range []string{c.TraceID, c.SpanID, c.Origin} {
+		ext = append(ext, field...)
+		ext = append(ext, 0)
+	}
+	return append(ext, rest...)
+}
+
+// Split returns the trace context in the extensions of a log entry, zero if
+// it has none, and the entry's other extensions.
+func Split(extensions []byte) (c Context, rest []byte) {
+	after, ok := bytes.CutPrefix(extensions, extensionPrefix)
+	if !ok {
+		return Context{}, extensions
+	}
+	var fields [3][]byte
+	for i := range fields {
+		if fields[i], after, ok = bytes.Cut(after, []byte{0}); !ok {
+			return Context{}, extensions
+		}
+	}
+	return Context{TraceID: string(fields[0]), SpanID: string(fields[1]), Origin: string(fields[2])}, after
+}
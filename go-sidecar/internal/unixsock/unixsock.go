@@ -0,0 +1,51 @@
+// Package unixsock listens on Unix domain sockets, for the sidecar APIs
+// served to clients on the same host without TCP.
+package unixsock
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Mode is the permission of the socket files: the owner and group of the
+// sidecar may connect.
+const Mode = 0o660
+
+// Listen listens on the Unix domain socket at path, replacing a socket
+// left behind by a previous run. A socket that accepts connections belongs
+// to a running process and is not replaced; any other file at path is an
+// error. The socket file is removed when the listener is closed.
+func Listen(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, Mode); err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return lis, nil
+}
+
+// IsUnix reports whether addr is a Unix domain socket address.
+func IsUnix(addr net.Addr) bool {
+	return addr != nil && addr.Network() == "unix"
+}
@@ -0,0 +1,215 @@
+// Package vault issues the sidecar's TLS certificates from a HashiCorp Vault
+// PKI secrets engine and renews them before they expire.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"my-raft-sidecar/internal/logging"
+	"my-raft-sidecar/internal/tlsutil"
+)
+
+// logger logs with component=vault.
+var logger = logging.Component("vault")
+
+// PKIConfig configures certificate issuance.
+type PKIConfig struct {
+	// Addr is Vault's address, such as https://vault:8200.
+	Addr string
+	// Token authenticates to Vault. It needs update capability on the
+	// role's issue path.
+	Token string
+	// CACert, if set, is the PEM bundle Vault's own certificate is verified
+	// against instead of the system roots.
+	CACert string
+	// Mount and Role name the PKI secrets engine and the role certificates
+	// are issued under: POST /v1/<Mount>/issue/<Role>.
+	Mount string
+	Role  string
+	// CommonName and AltNames (host names or IP addresses) are requested
+	// in the certificate. The role must allow them.
+	CommonName string
+	AltNames   []string
+	// TTL is the requested lifetime; the role's default if zero.
+	TTL time.Duration
+	// RenewAt is the fraction of the lifetime after which the certificate
+	// is renewed.
+	RenewAt float64
+	// RetryInterval is the delay between failed attempts.
+	RetryInterval time.Duration
+}
+
+// DefaultPKIConfig returns default issuance settings for addr, mount and
+// role.
+func DefaultPKIConfig(addr, mount, role string) *PKIConfig {
+	return &PKIConfig{
+		Addr:          addr,
+		Mount:         mount,
+		Role:          role,
+		TTL:           24 * time.Hour,
+		RenewAt:       2.0 / 3,
+		RetryInterval: 30 * time.Second,
+	}
+}
+
+// Issuer keeps TLS sources supplied with a certificate issued by Vault.
+type Issuer struct {
+	config  *PKIConfig
+	sources []*tlsutil.Source
+	client  *http.Client
+}
+
+// NewIssuer returns an issuer that installs its certificates in sources.
+func NewIssuer(config *PKIConfig, sources ...*tlsutil.Source) (*Issuer, error) {
+	if config.Addr == "" || config.Token == "" || config.Role == "" {
+		return nil, errors.New("Vault PKI requires an address, a token and a role")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.CACert != "" {
+		pool, err := tlsutil.LoadCA(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	}
+	return &Issuer{
+		config:  config,
+		sources: sources,
+		client:  &http.Client{Timeout: 30 * time.Second, Transport: transport},
+	}, nil
+}
+
+// Start issues the first certificate, failing if Vault cannot issue one,
+// then renews it in the background until ctx is done.
+func (i *Issuer) Start(ctx context.Context) error {
+	expiry, err := i.issue(ctx)
+	if err != nil {
+		return err
+	}
+	go i.renew(ctx, expiry)
+	return nil
+}
+
+// renew issues a new certificate once RenewAt of the current one's
+// lifetime has passed, retrying until it succeeds. The current certificate
+// stays in use meanwhile.
+func (i *Issuer) renew(ctx context.Context, expiry time.Time) {
+	issued := time.Now()
+	for {
+		delay := time.Duration(float64(expiry.Sub(issued)) * i.config.RenewAt)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		for {
+			next, err := i.issue(ctx)
+			if err == nil {
+				issued, expiry = time.Now(), next
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("Failed to renew certificate from Vault", "expires", expiry.Format(time.RFC3339), "retry_in", i.config.RetryInterval.String(), "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(i.config.RetryInterval):
+			}
+		}
+	}
+}
+
+// issueResponse is the data of a PKI issue response.
+type issueResponse struct {
+	Data struct {
+		Certificate string   `json:"certificate"`
+		PrivateKey  string   `json:"private_key"`
+		IssuingCA   string   `json:"issuing_ca"`
+		CAChain     []string `json:"ca_chain"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// issue requests a certificate and installs it, returning its expiry.
+func (i *Issuer) issue(ctx context.Context) (time.Time, error) {
+	var hosts, ips []string
+	for _, name := range i.config.AltNames {
+		if net.ParseIP(name) != nil {
+			ips = append(ips, name)
+		} else {
+			hosts = append(hosts, name)
+		}
+	}
+	request := map[string]string{
+		"common_name": i.config.CommonName,
+		"alt_names":   strings.Join(hosts, ","),
+		"ip_sans":     strings.Join(ips, ","),
+	}
+	if i.config.TTL > 0 {
+		request["ttl"] = i.config.TTL.String()
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/issue/%s", strings.TrimSuffix(i.config.Addr, "/"), i.config.Mount, i.config.Role)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return time.Time{}, err
+	}
+	req.Header.Set("X-Vault-Token", i.config.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to reach Vault: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read Vault response: %w", err)
+	}
+
+	var issued issueResponse
+	if err := json.Unmarshal(data, &issued); err != nil && resp.StatusCode == http.StatusOK {
+		return time.Time{}, fmt.Errorf("invalid Vault response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("Vault returned status %d: %s", resp.StatusCode, strings.Join(issued.Errors, "; "))
+	}
+
+	// The chain, leaf first, is presented to peers; the CAs verify them.
+	chain := issued.Data.Certificate
+	for _, ca := range issued.Data.CAChain {
+		chain += "\n" + ca
+	}
+	cert, err := tls.X509KeyPair([]byte(chain), []byte(issued.Data.PrivateKey))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid certificate from Vault: %w", err)
+	}
+	pool := x509.NewCertPool()
+	for _, ca := range append([]string{issued.Data.IssuingCA}, issued.Data.CAChain...) {
+		pool.AppendCertsFromPEM([]byte(ca))
+	}
+
+	for _, source := range i.sources {
+		source.Update(&cert, pool)
+	}
+	logger.Info("Issued certificate by Vault", "common_name", i.config.CommonName, "role", i.config.Role,
+		"serial", fmt.Sprintf("%x", cert.Leaf.SerialNumber), "expires", cert.Leaf.NotAfter.Format(time.RFC3339))
+	return cert.Leaf.NotAfter, nil
+}
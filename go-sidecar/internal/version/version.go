@@ -0,0 +1,70 @@
+// Package version holds the sidecar's build version.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/hashicorp/raft"
+)
+
+// Version, Commit and BuildDate describe the sidecar binary, and are set
+// at build time with
+//
+//	go build -ldflags "-X my-raft-sidecar/internal/version.Version=v1.2.3
+//	  -X my-raft-sidecar/internal/version.Commit=$(git rev-parse HEAD)
+//	  -X my-raft-sidecar/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Commit and BuildDate default to the VCS information Go stamps into
+// binaries built from a repository.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Info is the version and build information of the sidecar, as reported
+// by -version and GET /version.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	// RaftProtocol is the Raft protocol version the node speaks.
+	RaftProtocol int `json:"raft_protocol"`
+}
+
+// Get returns the version and build information of the running binary.
+func Get() Info {
+	info := Info{
+		Version:      Version,
+		Commit:       Commit,
+		BuildDate:    BuildDate,
+		GoVersion:    runtime.Version(),
+		RaftProtocol: int(raft.ProtocolVersionMax),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// String formats the information on one line.
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s, Raft protocol %d)",
+		i.Version, i.Commit, i.BuildDate, i.GoVersion, i.RaftProtocol)
+}
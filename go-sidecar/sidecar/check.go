@@ -0,0 +1,177 @@
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"my-raft-sidecar/internal/acl"
+	"my-raft-sidecar/internal/cluster"
+	"my-raft-sidecar/internal/management"
+	"my-raft-sidecar/internal/rbac"
+	"my-raft-sidecar/internal/rpc"
+	"my-raft-sidecar/internal/signing"
+	"my-raft-sidecar/internal/tlsutil"
+	"my-raft-sidecar/internal/vault"
+)
+
+// resolveTimeout bounds each host name lookup in Check.
+const resolveTimeout = 5 * time.Second
+
+// Check validates the configuration as Run does and loads everything Run
+// loads before starting Raft: secrets, TLS material, tokens, keys,
+// policies and the command ACL. It also resolves the host names of the
+// backend, of the nodes to join and of the statsd server. It reports every problem it finds.
+// Check starts nothing and contacts neither the backend, the other nodes,
+// Vault nor the SPIFFE Workload API, so it suits CI and deployment
+// pre-flight checks; the data directory is created if it is missing.
+func (s *Sidecar) Check() error {
+	cfg := s.cfg
+	if cfg.TemporaryDataDir() {
+		defer os.RemoveAll(cfg.DataDir)
+	}
+	if err := cfg.LoadSecrets(); err != nil {
+		return fmt.Errorf("failed to load secrets: %w", err)
+	}
+	cfg.DetectAdvertise()
+
+	var problems []error
+	fail := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+	if err := cfg.Validate(); err != nil {
+		problems = append(problems, err)
+	}
+	if _, err := management.ParseNetworks(cfg.MgmtAllowedCIDRs); err != nil {
+		fail("-mgmt-allowed-cidrs: %v", err)
+	}
+	if _, err := parseLimits(cfg); err != nil {
+		problems = append(problems, err)
+	}
+
+	// TLS material
+	if cfg.VaultPKIRole != "" {
+		token, err := cfg.VaultToken()
+		if err != nil {
+			fail("failed to load Vault token: %v", err)
+		} else {
+			pki := vault.DefaultPKIConfig(cfg.VaultAddr, cfg.VaultPKIMount, cfg.VaultPKIRole)
+			pki.Token = token
+			pki.CACert = cfg.VaultCACert
+			if _, err := vault.NewIssuer(pki); err != nil {
+				fail("failed to configure Vault PKI: %v", err)
+			}
+		}
+	}
+	if cfg.SPIFFESocket == "" && cfg.VaultPKIRole == "" {
+		if files := (tlsutil.Files{Cert: cfg.RaftTLSCert, Key: cfg.RaftTLSKey, CA: cfg.RaftTLSCA}); files.Enabled() {
+			if _, err := tlsutil.NewSource("Raft transport", files); err != nil {
+				fail("failed to load Raft TLS material: %v", err)
+			}
+		}
+		if files := (tlsutil.Files{Cert: cfg.MgmtTLSCert, Key: cfg.MgmtTLSKey, CA: cfg.MgmtTLSCA}); files.Enabled() {
+			if _, err := tlsutil.NewSource("management API", files); err != nil {
+				fail("failed to load management API TLS material: %v", err)
+			}
+		}
+	}
+
+	// Tokens, keys and policies
+	if _, err := cfg.MgmtToken(); err != nil {
+		fail("failed to load management token: %v", err)
+	}
+	if _, err := cfg.EncryptionKeys(); err != nil {
+		fail("failed to load encryption keys: %v", err)
+	}
+	var policy *rbac.Policy
+	if cfg.RBACPolicy != "" {
+		var err error
+		if policy, err = rbac.Load(cfg.RBACPolicy, cfg.RBACJWTClaim); err != nil {
+			fail("failed to load RBAC policy: %v", err)
+		}
+	}
+	if cfg.CommandACL != "" {
+		if _, err := acl.Load(cfg.CommandACL); err != nil {
+			fail("failed to load command ACL: %v", err)
+		}
+	}
+	if cfg.CommandSigningKeys != "" {
+		if _, err := signing.Load(cfg.CommandSigningKeys); err != nil {
+			fail("failed to load command signing keys: %v", err)
+		}
+	}
+	if cfg.GRPCAPIKeysFile != "" || cfg.GRPCJWTKeyFile != "" {
+		if _, err := rpc.NewAuthenticator(&rpc.AuthConfig{
+			APIKeysFile: cfg.GRPCAPIKeysFile,
+			JWTKeyFile:  cfg.GRPCJWTKeyFile,
+			JWTIssuer:   cfg.GRPCJWTIssuer,
+			JWTAudience: cfg.GRPCJWTAudience,
+			Exempt:      cfg.GRPCAuthExempt,
+			Policy:      policy,
+		}); err != nil {
+			fail("failed to configure gRPC authentication: %v", err)
+		}
+	}
+	if cfg.Discovery != "" {
+		mgmtPort := cfg.MgmtPort
+		if !cfg.MgmtListens() {
+			mgmtPort = ""
+		}
+		if _, err := cluster.ParseDiscoverer(cfg.Discovery, mgmtPort); err != nil {
+			fail("invalid discovery configuration: %v", err)
+		}
+	}
+
+	// Addresses of the backend and of the nodes to join
+	lookup := func(flag, addr string) {
+		if addr == "" {
+			return
+		}
+		if err := resolve(addr); err != nil {
+			fail("%s %s: %v", flag, addr, err)
+		}
+	}
+	if !cfg.Dev {
+		lookup("-app", cfg.AppAddr)
+	}
+	lookup("-join", cfg.JoinAddr)
+	lookup("-join-rpc", cfg.JoinRPCAddr)
+	lookup("-statsd-addr", cfg.StatsdAddr)
+	for _, addr := range cfg.RetryJoin {
+		lookup("-retry-join", addr)
+	}
+	for _, peer := range cfg.Peers {
+		if _, addr, ok := strings.Cut(peer, "="); ok {
+			peer = addr
+		}
+		lookup("-peers", peer)
+	}
+	return errors.Join(problems...)
+}
+
+// resolve looks up the host of addr, a host:port address, a URL or a gRPC
+// target such as dns:///host:port. Unix socket targets are not looked up.
+func resolve(addr string) error {
+	if strings.HasPrefix(addr, "unix:") || strings.HasPrefix(addr, "unix-abstract:") {
+		return nil
+	}
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = strings.TrimLeft(addr[i+3:], "/")
+	}
+	addr, _, _ = strings.Cut(addr, "/")
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	_, err = net.DefaultResolver.LookupHost(ctx, host)
+	return err
+}
@@ -0,0 +1,742 @@
+// Package sidecar runs the Raft sidecar in the calling process, for Go
+// services that embed it instead of running the sidecar binary:
+//
+//	cfg := sidecar.DefaultConfig()
+//	cfg.NodeID = "node1"
+//	cfg.AppAddr = "localhost:50051"
+//	cfg.Bootstrap = true
+//	err := sidecar.New(cfg).Run(ctx)
+//
+// The sidecar sets the TLS configuration and bearer token used to reach
+// other members' management APIs for the whole process, so a process runs
+// at most one.
+package sidecar
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"my-raft-sidecar/internal/acl"
+	"my-raft-sidecar/internal/audit"
+	"my-raft-sidecar/internal/backend"
+	"my-raft-sidecar/internal/cdc"
+	"my-raft-sidecar/internal/cluster"
+	"my-raft-sidecar/internal/config"
+	"my-raft-sidecar/internal/events"
+	"my-raft-sidecar/internal/fsm"
+	"my-raft-sidecar/internal/logging"
+	"my-raft-sidecar/internal/management"
+	"my-raft-sidecar/internal/memkv"
+	"my-raft-sidecar/internal/metrics"
+	"my-raft-sidecar/internal/raftnode"
+	"my-raft-sidecar/internal/ratelimit"
+	"my-raft-sidecar/internal/rbac"
+	"my-raft-sidecar/internal/rpc"
+	"my-raft-sidecar/internal/signing"
+	"my-raft-sidecar/internal/spiffe"
+	"my-raft-sidecar/internal/tlsutil"
+	"my-raft-sidecar/internal/vault"
+)
+
+// logger logs with component=sidecar.
+var logger = logging.Component("sidecar")
+
+// Config is the configuration of a sidecar. Its fields match the flags of
+// the sidecar binary, described in the README.
+type Config = config.Config
+
+// DefaultConfig returns a Config holding the default of every flag.
+func DefaultConfig() *Config {
+	return config.Default()
+}
+
+// Sidecar is a Raft node serving the sidecar gRPC API and the management
+// API, and replicating commands to a StateMachine backend.
+type Sidecar struct {
+	cfg *Config
+
+	mu     sync.Mutex
+	reload func() ([]string, error)
+}
+
+// New returns a sidecar running with cfg, which it takes ownership of.
+func New(cfg *Config) *Sidecar {
+	return &Sidecar{cfg: cfg}
+}
+
+// Reload applies the changed reloadable settings and reloads the TLS
+// material, as SIGHUP does to the sidecar binary, and returns the changed
+// settings that only take effect on restart. Only a Config parsed from
+// flags can be reloaded.
+func (s *Sidecar) Reload() ([]string, error) {
+	s.mu.Lock()
+	reload := s.reload
+	s.mu.Unlock()
+	if reload == nil {
+		return nil, errors.New("the sidecar is not running")
+	}
+	return reload()
+}
+
+// Run starts the sidecar and serves until ctx is done or the gRPC server
+// fails. It then leaves the cluster if LeaveOnShutdown is set, and stops.
+// Run validates the configuration and loads its secrets first; it does not
+// handle signals.
+func (s *Sidecar) Run(ctx context.Context) error {
+	cfg := s.cfg
+	if cfg.TemporaryDataDir() {
+		defer os.RemoveAll(cfg.DataDir)
+	}
+	if err := cfg.LoadSecrets(); err != nil {
+		return fmt.Errorf("failed to load secrets: %w", err)
+	}
+	cfg.DetectAdvertise()
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	mgmtNetworks, err := management.ParseNetworks(cfg.MgmtAllowedCIDRs)
+	if err != nil {
+		return fmt.Errorf("-mgmt-allowed-cidrs: %w", err)
+	}
+
+	// Keep the background work running after ctx is done until the node
+	// has left the cluster
+	done := ctx.Done()
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+
+	// Rate limit proposals and joins received by this node
+	limits, err := parseLimits(cfg)
+	if err != nil {
+		return err
+	}
+	proposeLimiter := ratelimit.New(limits["propose-rate-limit"], limits["propose-client-rate-limit"])
+	joinLimiter := ratelimit.New(limits["join-rate-limit"], limits["join-client-rate-limit"])
+	logLimits(limits)
+
+	// Load the TLS material of the Raft transport and the management API;
+	// it is reloaded when the files are rotated or on SIGHUP. The gRPC API,
+	// and with SPIFFE the backend, only use TLS with a provided identity
+	var mgmtTLS, grpcTLS *tls.Config
+	var grpcPeerTLS, backendTLS func(host string) *tls.Config
+	var tlsSources []*tlsutil.Source
+	var raftTLS *tlsutil.Source
+	var raftVerify func(*x509.Certificate) error
+	if cfg.SPIFFESocket != "" {
+		// Every listener and the backend dial use the workload's SVID,
+		// rotated by the Workload API, and accept peers of its trust
+		// domain; the Raft transport requires peer certificates and the
+		// management API with -mgmt-mtls
+		raftTLS = tlsutil.NewProvidedSource("Raft transport")
+		source := tlsutil.NewProvidedSource("management API")
+		grpcSource := tlsutil.NewProvidedSource("gRPC API")
+		watcher := spiffe.NewWatcher(cfg.SPIFFESocket, raftTLS, source, grpcSource)
+		if err := watcher.Start(ctx, 30*time.Second); err != nil {
+			return fmt.Errorf("failed to fetch SVID from the SPIFFE Workload API: %w", err)
+		}
+		raftVerify = watcher.TrustDomainVerifier()
+		cluster.SetManagementTLSDialer(source.DialTLSContext(false, raftVerify))
+		mgmtTLS = source.ServerConfig(cfg.MgmtMTLS, raftVerify)
+		grpcTLS = grpcSource.ServerConfig(false, nil)
+		grpcPeerTLS = func(host string) *tls.Config { return grpcSource.ClientConfig(host, false, raftVerify) }
+		backendTLS = grpcPeerTLS
+	} else if cfg.VaultPKIRole != "" {
+		// Every listener presents a certificate issued by Vault for the
+		// advertised host and renewed before it expires
+		raftTLS = tlsutil.NewProvidedSource("Raft transport")
+		source := tlsutil.NewProvidedSource("management API")
+		grpcSource := tlsutil.NewProvidedSource("gRPC API")
+		token, err := cfg.VaultToken()
+		if err != nil {
+			return fmt.Errorf("failed to load Vault token: %w", err)
+		}
+		pki := vault.DefaultPKIConfig(cfg.VaultAddr, cfg.VaultPKIMount, cfg.VaultPKIRole)
+		pki.Token = token
+		pki.CACert = cfg.VaultCACert
+		pki.TTL = cfg.VaultCertTTL
+		pki.CommonName = cfg.NodeID
+		if cfg.RaftAdvertise != "" {
+			if net.ParseIP(cfg.RaftAdvertise) == nil {
+				pki.CommonName = cfg.RaftAdvertise
+			}
+			pki.AltNames = append(pki.AltNames, cfg.RaftAdvertise)
+		}
+		pki.AltNames = append(pki.AltNames, cfg.VaultAltNames...)
+		issuer, err := vault.NewIssuer(pki, raftTLS, source, grpcSource)
+		if err != nil {
+			return fmt.Errorf("failed to configure Vault PKI: %w", err)
+		}
+		if err := issuer.Start(ctx); err != nil {
+			return fmt.Errorf("failed to issue certificate from Vault: %w", err)
+		}
+		cluster.SetManagementTLSDialer(source.DialTLSContext(true, nil))
+		mgmtTLS = source.ServerConfig(cfg.MgmtMTLS, nil)
+		grpcTLS = grpcSource.ServerConfig(false, nil)
+		grpcPeerTLS = func(host string) *tls.Config { return grpcSource.ClientConfig(host, true, nil) }
+	} else if files := (tlsutil.Files{Cert: cfg.RaftTLSCert, Key: cfg.RaftTLSKey, CA: cfg.RaftTLSCA}); files.Enabled() {
+		var err error
+		if raftTLS, err = tlsutil.NewSource("Raft transport", files); err != nil {
+			return fmt.Errorf("failed to load Raft TLS material: %w", err)
+		}
+		tlsSources = append(tlsSources, raftTLS)
+	}
+
+	// Serve the management API over HTTPS and reach other members' with
+	// the same certificate
+	if files := (tlsutil.Files{Cert: cfg.MgmtTLSCert, Key: cfg.MgmtTLSKey, CA: cfg.MgmtTLSCA}); cfg.SPIFFESocket == "" && cfg.VaultPKIRole == "" && (files.Enabled() || cfg.MgmtMTLS) {
+		source, err := tlsutil.NewSource("management API", files)
+		if err != nil {
+			return fmt.Errorf("failed to load management API TLS material: %w", err)
+		}
+		tlsSources = append(tlsSources, source)
+		cluster.SetManagementTLSDialer(source.DialTLSContext(true, nil))
+		mgmtTLS = source.ServerConfig(cfg.MgmtMTLS, nil)
+	}
+
+	// Require a bearer token on the management API and present it to other
+	// members
+	mgmtToken, err := cfg.MgmtToken()
+	if err != nil {
+		return fmt.Errorf("failed to load management token: %w", err)
+	}
+	cluster.SetManagementToken(mgmtToken)
+
+	// Restrict both APIs by role; a policy only applies to an API whose
+	// callers are authenticated
+	var policy *rbac.Policy
+	if cfg.RBACPolicy != "" {
+		if policy, err = rbac.Load(cfg.RBACPolicy, cfg.RBACJWTClaim); err != nil {
+			return fmt.Errorf("failed to load RBAC policy: %w", err)
+		}
+		if cfg.GRPCAPIKeysFile == "" && cfg.GRPCJWTKeyFile == "" {
+			logger.Warn("The RBAC policy does not apply to the gRPC API, which does not authenticate callers")
+		}
+		if mgmtToken == "" && !mgmtClientAuth(mgmtTLS) {
+			logger.Warn("The RBAC policy does not apply to the management API, which does not authenticate callers")
+		}
+	}
+
+	// Restrict the commands each client may propose
+	var commandACL *acl.List
+	if cfg.CommandACL != "" {
+		if commandACL, err = acl.Load(cfg.CommandACL); err != nil {
+			return fmt.Errorf("failed to load command ACL: %w", err)
+		}
+		if cfg.GRPCAPIKeysFile == "" && cfg.GRPCJWTKeyFile == "" {
+			logger.Warn("The gRPC API does not authenticate callers, so only the command ACL's * rules apply")
+		}
+	}
+
+	// Check command signatures before proposing and applying them
+	var signatures *signing.Verifier
+	if cfg.CommandSigningKeys != "" {
+		if signatures, err = signing.Load(cfg.CommandSigningKeys); err != nil {
+			return fmt.Errorf("failed to load command signing keys: %w", err)
+		}
+		signatures.AllowUnsigned = cfg.AllowUnsignedCommands
+		logger.Info("Command signatures required", "unsigned_allowed", cfg.AllowUnsignedCommands)
+	}
+
+	// Start from an empty Raft state if asked to; the node is removed from
+	// the cluster and joins again further down
+	if cfg.WipeAndRejoin {
+		logger.Warn("-wipe-and-rejoin: deleting the Raft state", "data_dir", cfg.DataDir)
+		if err := raftnode.Wipe(cfg.DataDir); err != nil {
+			return fmt.Errorf("failed to wipe Raft state: %w", err)
+		}
+	}
+
+	// In development mode, serve the built-in key-value backend instead of
+	// connecting to the C++ app, and drop the temporary data directory on
+	// exit
+	if cfg.Dev {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return fmt.Errorf("failed to start the built-in backend: %w", err)
+		}
+		defer lis.Close()
+		go memkv.New().Serve(lis)
+		cfg.AppAddr = lis.Addr().String()
+	}
+
+	// Connect to C++ backend
+	backendConfig := backend.DefaultConnectionConfig(cfg.AppAddr)
+	if backendTLS != nil && !cfg.Dev {
+		host, _, _ := net.SplitHostPort(cfg.AppAddr)
+		backendConfig.TLS = backendTLS(host)
+	}
+	backendClient, err := backend.Connect(backendConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect to backend: %w", err)
+	}
+	defer backendClient.Close()
+
+	// Create FSM
+	stateMachineClient := fsm.NewStateMachineClient(backendClient.StateMachineClient)
+	raftFSM := fsm.NewCppFSM(stateMachineClient)
+	raftFSM.VerifySignatures(signatures)
+	raftFSM.WarnSlowApplies(cfg.SlowApplyThreshold)
+
+	// A wiped node rebuilds the backend from the leader's snapshot and log,
+	// so the keys it held before are deleted first
+	if cfg.WipeAndRejoin {
+		deleted, err := raftFSM.Clear()
+		if err != nil {
+			return fmt.Errorf("failed to clear backend: %w", err)
+		}
+		logger.Warn("-wipe-and-rejoin: deleted the backend's keys", "keys", deleted)
+	}
+
+	// Record privileged operations made through this node
+	auditLog, err := audit.Open(filepath.Join(cfg.DataDir, "audit.log"))
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer auditLog.Close()
+	logger.Info("Audit log opened", "head", auditLog.Head())
+
+	// Record Propose calls from clients if asked to
+	var proposeAudit *audit.ProposeLog
+	if cfg.ProposeAuditLog != "" {
+		proposeAudit, err = audit.OpenProposeLog(cfg.ProposeAuditLog, cfg.ProposeAuditSampleRate)
+		if err != nil {
+			return err
+		}
+		defer proposeAudit.Close()
+		proposeAudit.Start(ctx)
+		logger.Info("Propose audit log opened", "path", cfg.ProposeAuditLog, "sample_rate", cfg.ProposeAuditSampleRate)
+	}
+
+	// Collect the Raft library's telemetry for /metrics, and send it to
+	// statsd if asked to
+	var statsd *metrics.Statsd
+	if cfg.StatsdAddr != "" {
+		statsd, err = metrics.NewStatsd(metrics.StatsdConfig{
+			Addr:     cfg.StatsdAddr,
+			Format:   cfg.StatsdFormat,
+			Tags:     cfg.StatsdTags,
+			Interval: cfg.StatsdInterval,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to configure statsd: %w", err)
+		}
+	}
+	telemetry := metrics.NewTelemetry()
+	if err := telemetry.Install(statsd); err != nil {
+		return fmt.Errorf("failed to configure Raft telemetry: %w", err)
+	}
+
+	// Create Raft node
+	nodeOpts := raftnode.DefaultOptions()
+	nodeOpts.AuditLog = auditLog
+	nodeOpts.Standby = cfg.Standby
+	nodeOpts.InMemory = cfg.Dev
+	nodeOpts.TLS = raftTLS
+	nodeOpts.VerifyPeer = raftVerify
+	if nodeOpts.EncryptionKeys, err = cfg.EncryptionKeys(); err != nil {
+		return fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+	node, err := raftnode.New(cfg, raftFSM, nodeOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create Raft node: %w", err)
+	}
+
+	// Stop the node and the servers started below when Run returns
+	var mgmtServer *management.Server
+	var grpcServer *rpc.Server
+	defer func() {
+		cancel()
+		if err := node.Shutdown(); err != nil {
+			logger.Error("Raft shutdown failed", "error", err)
+		}
+		if mgmtServer != nil {
+			mgmtServer.Stop(context.Background())
+		}
+		if grpcServer != nil {
+			grpcServer.Stop()
+		}
+	}()
+
+	// Bootstrap if requested, alone or with the static peer list
+	if cfg.Bootstrap || len(cfg.Peers) > 0 {
+		if err := node.Bootstrap(); err != nil {
+			logger.Warn("Bootstrap failed (may already be bootstrapped)", "error", err)
+		}
+	}
+
+	// Start change-data-capture export if requested
+	if cfg.CDCBackend != "" {
+		cdcConfig := &cdc.Config{
+			Backend:       cfg.CDCBackend,
+			URL:           cfg.CDCURL,
+			Topic:         cfg.CDCTopic,
+			DataDir:       cfg.DataDir,
+			RetryInterval: 2 * time.Second,
+		}
+		publisher, err := cdc.NewPublisher(cdcConfig)
+		if err != nil {
+			return fmt.Errorf("failed to configure CDC: %w", err)
+		}
+		exporter, err := cdc.NewExporter(cdcConfig, node, raftFSM, publisher)
+		if err != nil {
+			return fmt.Errorf("failed to create CDC exporter: %w", err)
+		}
+		exporter.StartAsync(ctx)
+	}
+
+	// Pick up rotated certificates without a restart
+	for _, source := range tlsSources {
+		if cfg.TLSReloadInterval > 0 {
+			source.Watch(ctx, cfg.TLSReloadInterval)
+		}
+	}
+
+	// Apply changed tunables and reload TLS material on SIGHUP or POST
+	// /reload, and change tunables through PATCH /config, without
+	// restarting. current is the running configuration.
+	var reloadMu sync.Mutex
+	current := cfg
+	apply := func(next *config.Config, restart []string) error {
+		limits, err := parseLimits(next)
+		if err != nil {
+			return err
+		}
+		if err := node.Reload(next); err != nil {
+			return err
+		}
+		proposeLimiter.SetLimits(limits["propose-rate-limit"], limits["propose-client-rate-limit"])
+		joinLimiter.SetLimits(limits["join-rate-limit"], limits["join-client-rate-limit"])
+		current = next
+		logger.Info("Reloaded configuration", "log_level", next.LogLevel, "raft_log_level", next.RaftLogLevel,
+			"snapshot_threshold", next.SnapshotThreshold, "snapshot_interval", next.SnapshotInterval.String(), "trailing_logs", next.TrailingLogs,
+			"heartbeat_timeout", next.HeartbeatTimeout.String(), "election_timeout", next.ElectionTimeout.String())
+		logLimits(limits)
+		if len(restart) > 0 {
+			logger.Warn("Changed settings only take effect after a restart", "settings", restart)
+		}
+		return nil
+	}
+	reload := func() ([]string, error) {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		next, restart, err := current.Reload()
+		if err != nil {
+			return nil, err
+		}
+		if err := apply(next, restart); err != nil {
+			return nil, err
+		}
+		for _, source := range tlsSources {
+			if err := source.Reload(); err != nil {
+				logger.Error("Failed to reload TLS material, keeping the previous one", "error", err)
+			}
+		}
+		return restart, nil
+	}
+	patchConfig := func(changes map[string]*string) ([]string, error) {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		next, restart, err := current.Patch(changes)
+		if err != nil {
+			return nil, err
+		}
+		// Save first, so that an applied change always survives a restart.
+		if err := next.SaveOverrides(); err != nil {
+			return nil, err
+		}
+		if err := apply(next, restart); err != nil {
+			if err := current.SaveOverrides(); err != nil {
+				logger.Error("Failed to restore the saved runtime settings", "error", err)
+			}
+			return nil, err
+		}
+		return restart, nil
+	}
+	runningConfig := func() *config.Settings {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		return current.Settings()
+	}
+	effectiveConfig := func() *config.Dump {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		return current.Dump()
+	}
+	s.mu.Lock()
+	s.reload = reload
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.reload = nil
+		s.mu.Unlock()
+	}()
+
+	// Let the backend know when it may run leader-only work
+	backendClient.WatchLeadership(node.SubscribeLeadership(), node.Raft.CurrentTerm)
+
+	// Tell external systems when this node gains or loses leadership
+	if len(cfg.LeaderWebhooks) > 0 {
+		cluster.NewLeaderWebhooks(node, cfg.LeaderWebhooks).Start(ctx)
+	}
+
+	// Probe backend health and step down if it stays unhealthy
+	health := backendClient.NewHealthChecker(cfg.HealthInterval)
+	health.Start(ctx)
+	if cfg.StepDownAfter > 0 {
+		cluster.NewStepDownMonitor(node, health, cfg.StepDownAfter).Start(ctx)
+	}
+
+	// Replicate this node's endpoints whenever it becomes leader
+	self := &fsm.PeerMeta{
+		NodeID:      cfg.NodeID,
+		SidecarAddr: cfg.SidecarAdvertiseAddr(),
+		MgmtAddr:    cfg.MgmtAdvertiseAddr(),
+		Priority:    cfg.Priority,
+		ReadOnly:    cfg.ReadOnly,
+		Standby:     cfg.Standby,
+		Zone:        cfg.Zone,
+		Rack:        cfg.Rack,
+	}
+	cluster.NewAnnouncer(node, raftFSM, self).Start()
+
+	// Move leadership to higher-priority voters once they are ready
+	cluster.NewPriorityMonitor(node, raftFSM, cfg.Priority, cfg.Zone).Start(ctx)
+
+	// Promote learners to voters once they have caught up
+	if cfg.AutoPromote {
+		cluster.NewPromoter(node, raftFSM).Start(ctx)
+	}
+
+	// Remove servers that have been unreachable for too long
+	if cfg.ReapDeadServers {
+		cluster.NewReaper(node, cfg.ReapAfter).Start(ctx)
+	}
+
+	// Flag members that disagree with the configuration
+	drift := cluster.NewDriftDetector(node, raftFSM)
+	drift.Start(ctx)
+
+	// Publish leader elections, membership changes, snapshots and backend
+	// health transitions on /events
+	clusterEvents := events.NewBus()
+	cluster.NewEventWatcher(node, health, clusterEvents).Start(ctx)
+
+	// Alert when there is no leader or the leader loses its quorum
+	quorum := cluster.NewQuorumMonitor(node, cfg.LeaderlessAlertAfter, cfg.ClusterAlertWebhooks)
+	quorum.Start(ctx)
+
+	// Export metrics on the management API
+	registry := metrics.NewRegistry()
+	registry.Register(node)
+	registry.Register(raftFSM)
+	registry.Register(drift)
+	registry.Register(quorum)
+	registry.Register(telemetry)
+
+	// Push the metrics to statsd as well
+	if statsd != nil {
+		statsd.Start(ctx, registry)
+	}
+
+	// Start management server
+	mgmtOpts := management.DefaultOptions()
+	mgmtOpts.TLS = mgmtTLS
+	mgmtOpts.AuthToken = mgmtToken
+	if mgmtToken != "" || mgmtClientAuth(mgmtTLS) {
+		mgmtOpts.Policy = policy
+	}
+	mgmtOpts.ClusterToken = cfg.ClusterToken
+	mgmtOpts.JoinLimiter = joinLimiter
+	mgmtOpts.BindAddr = cfg.MgmtBind
+	mgmtOpts.SocketPath = cfg.MgmtSocket
+	mgmtOpts.AllowedNetworks = mgmtNetworks
+	mgmtOpts.Metrics = registry
+	mgmtOpts.Drift = drift
+	mgmtOpts.Events = clusterEvents
+	mgmtOpts.Quorum = quorum
+	if cfg.FromFlags() {
+		mgmtOpts.Reload = reload
+		mgmtOpts.Config = runningConfig
+		mgmtOpts.PatchConfig = patchConfig
+		mgmtOpts.DebugConfig = effectiveConfig
+	}
+	mgmtPort := cfg.MgmtPort
+	if !cfg.MgmtListens() {
+		mgmtPort = ""
+	}
+	mgmtServer = management.NewServer(node, raftFSM, health, mgmtPort, mgmtOpts)
+	mgmtServer.Start()
+
+	// Join cluster if requested
+	joinConfig := cluster.DefaultJoinConfig(
+		cfg.JoinAddr,
+		cfg.NodeID,
+		cfg.AdvertiseAddr(),
+	)
+	joinConfig.SidecarAddr = cfg.SidecarAdvertiseAddr()
+	joinConfig.MgmtAddr = cfg.MgmtAdvertiseAddr()
+	joinConfig.Voter = !cfg.ReadOnly && !cfg.Nonvoter && !cfg.Standby && !cfg.WipeAndRejoin
+	joinConfig.RemoveFirst = cfg.WipeAndRejoin
+	joinConfig.Standby = cfg.Standby
+	joinConfig.Zone = cfg.Zone
+	joinConfig.Rack = cfg.Rack
+	joinConfig.Priority = cfg.Priority
+	joinConfig.ReadOnly = cfg.ReadOnly
+	joinConfig.LeaderRPCAddr = cfg.JoinRPCAddr
+	joinConfig.RPCTLS = grpcPeerTLS
+	joinConfig.ClusterToken = cfg.ClusterToken
+	if cfg.JoinToken != "" {
+		joinConfig.ClusterToken = cfg.JoinToken
+	}
+	joinConfig.ClusterID = node.ClusterID()
+	joinConfig.MaxElapsedTime = cfg.JoinMaxElapsed
+	joinConfig.ExitOnFailure = cfg.JoinExitOnFailure
+	if cfg.JoinAddr != "" || cfg.JoinRPCAddr != "" {
+		joiner := cluster.NewJoiner(joinConfig)
+		joiner.JoinAsync()
+	}
+
+	// Form or join a cluster through discovered peers
+	if cfg.Discovery != "" || len(cfg.RetryJoin) > 0 {
+		var discoverer cluster.Discoverer = cluster.StaticDiscoverer(cfg.RetryJoin)
+		if cfg.Discovery != "" {
+			if discoverer, err = cluster.ParseDiscoverer(cfg.Discovery, mgmtPort); err != nil {
+				return fmt.Errorf("invalid discovery configuration: %w", err)
+			}
+		}
+		if registrar, ok := discoverer.(cluster.Registrar); ok {
+			registrar.StartRegistration(ctx, node, self)
+		}
+		cluster.NewDiscovery(node, &cluster.DiscoveryConfig{
+			Discoverer:      discoverer,
+			BootstrapExpect: cfg.BootstrapExpect,
+			Join:            joinConfig,
+			Interval:        2 * time.Second,
+		}).Start(ctx)
+	}
+
+	// Start gRPC server
+	rpcOpts := rpc.DefaultOptions()
+	rpcOpts.ProxyReads = cfg.ProxyReads
+	rpcOpts.BindAddr = cfg.SidecarBind
+	rpcOpts.SocketPath = cfg.SidecarSocket
+	// Without a -srv listener, the other nodes are assumed to serve on the
+	// default port
+	sidecarPort := ""
+	if cfg.SidecarListens() {
+		sidecarPort = cfg.SidecarPort
+		rpcOpts.PeerPort = cfg.SidecarPort
+	}
+	rpcOpts.ForwardProposals = cfg.ForwardProposals
+	rpcOpts.ProposeTimeout = cfg.ProposeTimeout
+	rpcOpts.ReadOnly = cfg.ReadOnly
+	rpcOpts.ClusterToken = cfg.ClusterToken
+	rpcOpts.ProposeLimiter = proposeLimiter
+	rpcOpts.JoinLimiter = joinLimiter
+	rpcOpts.ACL = commandACL
+	rpcOpts.Signatures = signatures
+	rpcOpts.TraceEntries = cfg.TraceLogEntries
+	rpcOpts.ProposeAudit = proposeAudit
+	rpcOpts.TLS = grpcTLS
+	rpcOpts.PeerTLS = grpcPeerTLS
+	if cfg.GRPCAPIKeysFile != "" || cfg.GRPCJWTKeyFile != "" {
+		rpcOpts.Auth, err = rpc.NewAuthenticator(&rpc.AuthConfig{
+			APIKeysFile: cfg.GRPCAPIKeysFile,
+			JWTKeyFile:  cfg.GRPCJWTKeyFile,
+			JWTIssuer:   cfg.GRPCJWTIssuer,
+			JWTAudience: cfg.GRPCJWTAudience,
+			Exempt:      cfg.GRPCAuthExempt,
+			Policy:      policy,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to configure gRPC authentication: %w", err)
+		}
+	}
+	grpcServer = rpc.NewServer(node, raftFSM, rpcOpts)
+
+	// Log startup info
+	logger.Info("Go Sidecar running",
+		"raft_bind", cfg.BindAddr(),
+		"raft_advertise", cfg.AdvertiseAddr(),
+		"grpc_bind", cfg.SidecarBindAddr(),
+		"grpc_advertise", cfg.SidecarAdvertiseAddr(),
+		"mgmt_bind", cfg.MgmtBindAddr(),
+		"mgmt_advertise", cfg.MgmtAdvertiseAddr(),
+	)
+
+	if cfg.Dev {
+		logger.Info("Development mode: a single in-memory node whose data is lost on exit",
+			"grpc_api", cfg.SidecarAdvertiseAddr(),
+			"management_api", cluster.ManagementURL(cfg.MgmtAdvertiseAddr(), ""),
+			"raft", cfg.AdvertiseAddr(),
+			"backend", "built-in, "+cfg.AppAddr,
+			"data_dir", cfg.DataDir)
+	}
+
+	// Serve until ctx is done or the server fails
+	served := make(chan error, 1)
+	go func() { served <- grpcServer.Start(sidecarPort) }()
+	select {
+	case err := <-served:
+		return fmt.Errorf("gRPC server failed: %w", err)
+	case <-done:
+	}
+
+	logger.Info("Shutting down")
+	if cfg.LeaveOnShutdown {
+		// Over TLS the certificate is issued for the advertised address
+		localMgmtAddr := "127.0.0.1:" + cfg.MgmtPort
+		if mgmtTLS != nil {
+			localMgmtAddr = cfg.MgmtAdvertiseAddr()
+		} else if cfg.MgmtBind != "" {
+			localMgmtAddr = cfg.MgmtBindAddr()
+		}
+		leaver := cluster.NewLeaver(node, cluster.DefaultLeaveConfig(localMgmtAddr, cfg.NodeID))
+		if err := leaver.Leave(); err != nil {
+			logger.Error("Failed to leave cluster", "error", err)
+		}
+	}
+	return nil
+}
+
+// parseLimits parses the rate limit flags of cfg by flag name.
+func parseLimits(cfg *config.Config) (map[string]ratelimit.Limit, error) {
+	limits := make(map[string]ratelimit.Limit)
+	for name, spec := range map[string]string{
+		"propose-rate-limit":        cfg.ProposeRateLimit,
+		"propose-client-rate-limit": cfg.ProposeClientRateLimit,
+		"join-rate-limit":           cfg.JoinRateLimit,
+		"join-client-rate-limit":    cfg.JoinClientRateLimit,
+	} {
+		limit, err := ratelimit.ParseLimit(spec)
+		if err != nil {
+			return nil, fmt.Errorf("-%s: %w", name, err)
+		}
+		limits[name] = limit
+	}
+	return limits, nil
+}
+
+// logLimits logs the rate limits in force, if any.
+func logLimits(limits map[string]ratelimit.Limit) {
+	if limits["propose-rate-limit"].Enabled() || limits["propose-client-rate-limit"].Enabled() {
+		logger.Info("Rate limiting proposals", "overall", limits["propose-rate-limit"].String(), "per_client", limits["propose-client-rate-limit"].String())
+	}
+	if limits["join-rate-limit"].Enabled() || limits["join-client-rate-limit"].Enabled() {
+		logger.Info("Rate limiting join requests", "overall", limits["join-rate-limit"].String(), "per_client", limits["join-client-rate-limit"].String())
+	}
+}
+
+// mgmtClientAuth reports whether the management API requires client
+// certificates.
+func mgmtClientAuth(config *tls.Config) bool {
+	return config != nil && config.ClientAuth != tls.NoClientCert
+}